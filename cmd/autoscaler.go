@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/flanksource/karina/pkg/provision"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var autoscalerOpts provision.AutoscalerOptions
+var Autoscaler = &cobra.Command{
+	Use:   "autoscaler",
+	Short: "Run a controller that adds and removes workers in node pools with a maxCount",
+	Args:  cobra.MinimumNArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		autoscaler := provision.NewAutoscaler(getPlatform(cmd), autoscalerOpts)
+		if err := autoscaler.Run(make(chan bool)); err != nil {
+			log.Fatalf("Failed to run autoscaler: %v", err)
+		}
+	},
+}
+
+func init() {
+	Autoscaler.Flags().DurationVar(&autoscalerOpts.Interval, "interval", 30*time.Second, "Interval between checks for unschedulable pods and underutilised nodes")
+	Autoscaler.Flags().DurationVar(&autoscalerOpts.ScaleUpCooldown, "scale-up-cooldown", 2*time.Minute, "Minimum time between scaling up the same pool")
+	Autoscaler.Flags().DurationVar(&autoscalerOpts.ScaleDownCooldown, "scale-down-cooldown", 10*time.Minute, "Minimum time after a pool was scaled up or down before it is scaled down")
+	Autoscaler.Flags().DurationVar(&autoscalerOpts.UnneededTime, "unneeded-time", 10*time.Minute, "How long a node needs to be underutilised before it is removed")
+	Autoscaler.Flags().Float64Var(&autoscalerOpts.Utilisation, "utilisation-threshold", 0.5, "Fraction of allocatable cpu and memory requested below which a node is underutilised")
+	Autoscaler.Flags().DurationVar(&autoscalerOpts.BurninPeriod, "burnin-period", time.Minute*3, "Period to burn-in new nodes before scheduling workloads on")
+	Autoscaler.Flags().DurationVar(&autoscalerOpts.Drain.Timeout, "drain-timeout", time.Minute*10, "How long to retry evicting pods blocked by PodDisruptionBudgets before giving up on removing a node")
+	autoscalerOpts.Drain.OnTimeout = provision.DrainSkip
+}
@@ -1,9 +1,14 @@
 package cmd
 
 import (
+	"crypto/x509"
+	"encoding/json"
 	"fmt"
+	"os"
+	"time"
 
 	"github.com/flanksource/karina/pkg/ca"
+	"github.com/flanksource/karina/pkg/provision"
 	"github.com/flanksource/karina/pkg/types"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -24,6 +29,19 @@ var generateCA = &cobra.Command{
 		privateKeyPath, _ := cmd.Flags().GetString("private-key-path")
 		password, _ := cmd.Flags().GetString("password")
 		expiry, _ := cmd.Flags().GetInt("expiry")
+		if intermediate, _ := cmd.Flags().GetBool("intermediate"); intermediate {
+			rootCert, _ := cmd.Flags().GetString("root-cert")
+			rootPrivateKey, _ := cmd.Flags().GetString("root-private-key")
+			rootPassword, _ := cmd.Flags().GetString("root-password")
+			if rootCert == "" || rootPrivateKey == "" {
+				log.Fatalf("--root-cert and --root-private-key are required with --intermediate")
+			}
+			root := &types.CA{Cert: rootCert, PrivateKey: rootPrivateKey, Password: rootPassword}
+			if err := ca.GenerateIntermediateCA(name, certPath, privateKeyPath, password, expiry, root); err != nil {
+				log.Fatalf("Failed to generate certificate, %s", err)
+			}
+			return
+		}
 		if err := ca.GenerateCA(name, certPath, privateKeyPath, password, expiry); err != nil {
 			log.Fatalf("Failed to generate certificate, %s", err)
 		}
@@ -64,17 +82,163 @@ var validateCA = &cobra.Command{
 	},
 }
 
+var reportCA = &cobra.Command{
+	Use:   "report",
+	Short: "Report the expiry of every certificate in the cluster",
+	Long:  "Report the issuer, SANs and expiry of every TLS secret, cert-manager Certificate, webhook caBundle and kubeadm certificate, exiting with 1 if any expire within --warn-days",
+	Args:  cobra.MinimumNArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		platform := getPlatform(cmd)
+		client, err := platform.GetClientset()
+		if err != nil {
+			log.Fatalf("Failed to get clientset: %v", err)
+		}
+		dynamicClient, err := platform.GetDynamicClient()
+		if err != nil {
+			log.Fatalf("Failed to get dynamic client: %v", err)
+		}
+
+		certs, err := ca.FromSecrets(client)
+		if err != nil {
+			log.Fatalf("Failed to read secrets: %v", err)
+		}
+		if certificates, err := ca.FromCertificates(dynamicClient, certs); err != nil {
+			log.Warnf("Skipping cert-manager certificates: %v", err)
+		} else {
+			certs = append(certs, certificates...)
+		}
+		webhooks, err := ca.FromWebhooks(client)
+		if err != nil {
+			log.Fatalf("Failed to read webhooks: %v", err)
+		}
+		certs = append(certs, webhooks...)
+		if skip, _ := cmd.Flags().GetBool("skip-masters"); !skip {
+			timeout, _ := cmd.Flags().GetDuration("timeout")
+			masters, err := ca.FromMasters(client, func(node, command string) (string, error) {
+				return platform.Executef(node, timeout, command)
+			})
+			if err != nil {
+				log.Fatalf("Failed to read kubeadm certificates: %v", err)
+			}
+			certs = append(certs, masters...)
+		}
+
+		roots := map[string]*x509.Certificate{}
+		if platform.CA != nil {
+			if cert, err := ca.ReadCert(platform.CA); err != nil {
+				log.Warnf("Unable to read ca: %v", err)
+			} else {
+				roots["ca"] = cert
+			}
+		}
+		if platform.IngressCA != nil {
+			if cert, err := ca.ReadCert(platform.IngressCA); err != nil {
+				log.Warnf("Unable to read ingressCA: %v", err)
+			} else {
+				roots["ingressCA"] = cert
+			}
+		}
+		ca.Verify(certs, roots)
+		ca.SortCerts(certs)
+
+		if output, _ := cmd.Flags().GetString("output"); output == "json" {
+			data, _ := json.MarshalIndent(certs, "", "  ")
+			fmt.Println(string(data))
+		} else {
+			ca.PrintCerts(os.Stdout, certs)
+		}
+		warnDays, _ := cmd.Flags().GetInt("warn-days")
+		if expiring := ca.Expiring(certs, warnDays); len(expiring) > 0 {
+			log.Errorf("%d certificates expire within %d days or could not be read", len(expiring), warnDays)
+			os.Exit(1)
+		}
+	},
+}
+
+var rotateCA = &cobra.Command{
+	Use:   "rotate",
+	Short: "Rotate the cluster CA or ingress CA",
+	Long: "Rotate the cluster CA (--ca) or ingress CA (--ingress-ca) to a CA created with karina ca generate, in stages: " +
+		"trust distributes bundles trusting both CAs, reissue re-issues certificates signed by the old CA and finalize removes the old CA. " +
+		"Progress is saved in the cluster so that a failed rotation can be resumed with --resume or reverted with --rollback",
+	Args: cobra.MinimumNArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		platform := getPlatform(cmd)
+		if status, _ := cmd.Flags().GetBool("status"); status {
+			client, err := platform.GetClientset()
+			if err != nil {
+				log.Fatalf("Failed to get clientset: %v", err)
+			}
+			rotation, err := provision.GetRotation(client)
+			if err != nil {
+				log.Fatalf("%v", err)
+			}
+			if rotation == nil {
+				fmt.Println("No CA rotation has been started")
+				return
+			}
+			rotation.Print(os.Stdout)
+			return
+		}
+
+		opts := provision.RotateOptions{}
+		if clusterCA, _ := cmd.Flags().GetBool("ca"); clusterCA {
+			opts.CA = provision.ClusterCA
+		}
+		if ingressCA, _ := cmd.Flags().GetBool("ingress-ca"); ingressCA {
+			if opts.CA != "" {
+				log.Fatalf("Only one of --ca or --ingress-ca can be rotated at a time")
+			}
+			opts.CA = provision.IngressCA
+		}
+		certPath, _ := cmd.Flags().GetString("cert")
+		privateKeyPath, _ := cmd.Flags().GetString("private-key")
+		password, _ := cmd.Flags().GetString("password")
+		if certPath != "" {
+			opts.New = &types.CA{Cert: certPath, PrivateKey: privateKeyPath, Password: password}
+		}
+		until, _ := cmd.Flags().GetString("until")
+		opts.Until = provision.RotationStage(until)
+		opts.Bundle, _ = cmd.Flags().GetString("bundle")
+		opts.Timeout, _ = cmd.Flags().GetDuration("timeout")
+		opts.Resume, _ = cmd.Flags().GetBool("resume")
+		opts.Rollback, _ = cmd.Flags().GetBool("rollback")
+		if err := provision.RotateCA(platform, opts); err != nil {
+			log.Fatalf("Failed to rotate %s: %v", opts.CA, err)
+		}
+	},
+}
+
 func init() {
-	CA.AddCommand(generateCA, validateCA, decrypt)
+	CA.AddCommand(generateCA, validateCA, decrypt, reportCA, rotateCA)
 	generateCA.Flags().String("name", "", "certificate name")
 	generateCA.Flags().String("cert-path", "", "path to certificate file")
 	generateCA.Flags().String("private-key-path", "", "path to private key file")
 	generateCA.Flags().String("password", "", "certificate password")
 	generateCA.Flags().Int("expiry", 1, "certificate expiration in years")
+	generateCA.Flags().Bool("intermediate", false, "generate an intermediate CA signed by the root CA in --root-cert")
+	generateCA.Flags().String("root-cert", "", "path to the root CA certificate file")
+	generateCA.Flags().String("root-private-key", "", "path to the root CA private key file")
+	generateCA.Flags().String("root-password", "", "root CA private key password")
 	validateCA.Flags().String("cert-path", "", "path to certificate file")
 	validateCA.Flags().String("private-key-path", "", "path to private key file")
 	validateCA.Flags().String("password", "", "certificate password")
 	decrypt.Flags().String("key", "", "path to private key file")
 	decrypt.Flags().String("password", "", "certificate password")
 	decrypt.Flags().String("cert", "", "path to certificate file")
+	reportCA.Flags().StringP("output", "o", "table", "Output format: table or json")
+	reportCA.Flags().Int("warn-days", 30, "Exit with 1 if any certificate expires within this many days")
+	reportCA.Flags().Bool("skip-masters", false, "Do not read the kubeadm certificates on the masters")
+	reportCA.Flags().Duration("timeout", 2*time.Minute, "Timeout for reading the kubeadm certificates on each master")
+	rotateCA.Flags().Bool("ca", false, "Rotate the cluster CA")
+	rotateCA.Flags().Bool("ingress-ca", false, "Rotate the ingress CA")
+	rotateCA.Flags().String("cert", "", "path to the certificate of the new CA")
+	rotateCA.Flags().String("private-key", "", "path to the private key of the new CA")
+	rotateCA.Flags().String("password", "", "password of the new CA private key")
+	rotateCA.Flags().String("until", "", "Stop after this stage: trust, reissue or finalize")
+	rotateCA.Flags().String("bundle", "", "Write the trusted CA bundle to this file after each stage, for use as trustedCA")
+	rotateCA.Flags().Duration("timeout", 5*time.Minute, "Timeout for each restart and re-issued certificate")
+	rotateCA.Flags().Bool("resume", false, "Resume a running or failed rotation")
+	rotateCA.Flags().Bool("rollback", false, "Revert the last rotation, restoring every changed bundle")
+	rotateCA.Flags().Bool("status", false, "Print the progress of the last rotation")
 }
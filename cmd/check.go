@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/flanksource/karina/pkg/deprecations"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var Check = &cobra.Command{
+	Use:   "check",
+	Short: "Check the cluster and manifests before changing them",
+}
+
+var checkAPIs = &cobra.Command{
+	Use:   "apis",
+	Short: "Report objects and manifests that use API versions removed in the target kubernetes version",
+	Long:  "Report objects and manifests that use API versions removed in the target kubernetes version, exiting with 1 if any are found",
+	Args:  cobra.MinimumNArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		platform := getPlatform(cmd)
+		target, _ := cmd.Flags().GetString("target")
+		if target == "" {
+			target = platform.Kubernetes.Version
+		}
+		version, err := deprecations.ParseVersion(target)
+		if err != nil {
+			log.Fatalf("Invalid --target: %v", err)
+		}
+
+		findings := []deprecations.Finding{}
+		if skip, _ := cmd.Flags().GetBool("skip-cluster"); !skip {
+			client, err := platform.GetClientset()
+			if err != nil {
+				log.Fatalf("Failed to get clientset: %v", err)
+			}
+			dynamicClient, err := platform.GetDynamicClient()
+			if err != nil {
+				log.Fatalf("Failed to get dynamic client: %v", err)
+			}
+			live, err := deprecations.ScanCluster(client.Discovery(), dynamicClient, version)
+			if err != nil {
+				log.Fatalf("Failed to scan cluster: %v", err)
+			}
+			findings = append(findings, live...)
+		}
+		if skip, _ := cmd.Flags().GetBool("skip-manifests"); !skip {
+			manifests, err := deprecations.ScanManifests(platform, version)
+			if err != nil {
+				log.Fatalf("Failed to scan manifests: %v", err)
+			}
+			findings = append(findings, manifests...)
+		}
+
+		if output, _ := cmd.Flags().GetString("output"); output == "json" {
+			data, _ := json.MarshalIndent(findings, "", "  ")
+			fmt.Println(string(data))
+		} else if len(findings) > 0 {
+			deprecations.PrintFindings(os.Stdout, findings)
+		} else {
+			fmt.Printf("No API versions removed in %s are in use\n", target)
+		}
+		if len(findings) > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	checkAPIs.Flags().String("target", "", "Kubernetes version to check against, defaults to kubernetes.version")
+	checkAPIs.Flags().StringP("output", "o", "table", "Output format: table or json")
+	checkAPIs.Flags().Bool("skip-cluster", false, "Do not scan the objects in the cluster")
+	checkAPIs.Flags().Bool("skip-manifests", false, "Do not scan the karina manifests")
+	Check.AddCommand(checkAPIs)
+}
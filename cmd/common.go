@@ -15,6 +15,7 @@ import (
 	"github.com/flanksource/commons/logger"
 	"github.com/flanksource/commons/lookup"
 	"github.com/flanksource/commons/text"
+	"github.com/flanksource/karina/pkg/config"
 	"github.com/flanksource/karina/pkg/constants"
 	"github.com/flanksource/karina/pkg/phases/harbor"
 	"github.com/flanksource/karina/pkg/platform"
@@ -27,6 +28,9 @@ import (
 	yaml "gopkg.in/flanksource/yaml.v3"
 )
 
+// configProvenance records the source of each config value when set, see config explain
+var configProvenance *config.Provenance
+
 type configMerger struct {
 	ReadFunction func(path string) ([]byte, error)
 }
@@ -85,6 +89,8 @@ func getConfig(cmd *cobra.Command) types.PlatformConfig {
 	inCluster, _ := cmd.Flags().GetBool("in-cluster")
 	skipDecryption, _ := cmd.Flags().GetBool("skip-decrypt")
 	prune, _ := cmd.Flags().GetBool("prune")
+	overrideFreeze, _ := cmd.Flags().GetString("override-freeze")
+	waitForWindow, _ := cmd.Flags().GetDuration("wait-for-window")
 
 	base := types.PlatformConfig{
 		E2E:             e2e,
@@ -93,6 +99,8 @@ func getConfig(cmd *cobra.Command) types.PlatformConfig {
 		SkipDecrypt:     skipDecryption,
 		InClusterConfig: inCluster,
 		Prune:           prune,
+		OverrideFreeze:  overrideFreeze,
+		WaitForWindow:   waitForWindow,
 	}
 	return NewConfigFromBase(base, paths, extras)
 }
@@ -129,6 +137,7 @@ func NewConfigFromBase(base types.PlatformConfig, paths []string, extras []strin
 		defaultConfig.LocalPath.Disabled = true
 		defaultConfig.Calico.Disabled = true
 	}
+	configProvenance.Add(config.Defaults, config.Fill, defaultConfig)
 	if err := mergo.Merge(&base, defaultConfig); err != nil {
 		log.Fatalf("Failed to merge default config, %v", err)
 	}
@@ -151,7 +160,8 @@ func NewConfigFromBase(base types.PlatformConfig, paths []string, extras []strin
 		key := strings.Split(extra, "=")[0]
 		val := extra[len(key)+1:]
 
-		log.Debugf("Looking up %s to set it to: %s", key, val)
+		log.Debugf("Looking up %s to set it to: %s", key, config.MaskSecret(key, val))
+		configProvenance.AddValue("--extra "+key, config.Override, key, val)
 		if err := lookup.Set(&base, key, val); err != nil {
 			log.Fatalf("failed to set key %s: %v", key, err)
 		}
@@ -209,6 +219,8 @@ func mergeConfigBytes(base *types.PlatformConfig, data []byte, path string) erro
 		}
 	}
 
+	configProvenance.Add(path, config.Fill, cfg)
+
 	for node, vm := range cfg.Nodes {
 		if baseNode, ok := base.Nodes[node]; ok {
 			if err := mergo.Merge(&baseNode, vm); err != nil {
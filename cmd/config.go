@@ -1,9 +1,20 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
 
+	"github.com/flanksource/karina/pkg/config"
+	"github.com/flanksource/karina/pkg/phases/order"
+	"github.com/flanksource/karina/pkg/types"
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	yaml "gopkg.in/flanksource/yaml.v3"
 )
 
 var Config = &cobra.Command{
@@ -14,14 +25,272 @@ var Config = &cobra.Command{
 var validateConfig = &cobra.Command{
 	Use:   "validate",
 	Short: "Validate config",
+	Long:  "Validate config files against the config schema, failing on unknown keys, wrong types and invalid values",
 	Args:  cobra.MinimumNArgs(0),
 	Run: func(cmd *cobra.Command, args []string) {
+		errs, err := config.ValidateFiles(config.GenerateSchema(), getConfigPaths(cmd)...)
+		if err != nil {
+			log.Fatalf("Failed to validate config: %v", err)
+		}
+		exitOnValidationErrors(errs)
+
 		p := getPlatform(cmd)
-		config := p.String()
-		fmt.Printf("Generated config is:\n%s\n", config)
+		exitOnValidationErrors(config.ValidateSemantics(p.PlatformConfig))
+		fmt.Printf("Generated config is:\n%s\n", p.String())
+	},
+}
+
+var configSchema = &cobra.Command{
+	Use:   "schema",
+	Short: "Print the JSON schema for config files",
+	Long:  "Print the JSON schema for config files, the schema can be used by editors for validation and autocomplete",
+	Args:  cobra.MinimumNArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		data, err := config.GenerateSchema().ToJSON()
+		if err != nil {
+			log.Fatalf("Failed to generate schema: %v", err)
+		}
+		fmt.Println(string(data))
+	},
+}
+
+var lintConfig = &cobra.Command{
+	Use:   "lint",
+	Short: "Check config for combinations of values that will not work together",
+	Args:  cobra.MinimumNArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		paths := getConfigPaths(cmd)
+		suppress, _ := cmd.Flags().GetStringSlice("suppress")
+		output, _ := cmd.Flags().GetString("output")
+		live, _ := cmd.Flags().GetBool("live")
+
+		configProvenance = &config.Provenance{}
+		p := getPlatform(cmd)
+		ctx := config.LintContext{Config: p.PlatformConfig}
+		if live {
+			client, err := p.GetClientset()
+			if err != nil {
+				log.Fatalf("Failed to connect to cluster: %v", err)
+			}
+			version, err := client.Discovery().ServerVersion()
+			if err != nil {
+				log.Fatalf("Failed to get cluster version: %v", err)
+			}
+			ctx.ServerVersion = version.GitVersion
+		}
+
+		findings := config.Locate(config.Lint(ctx, suppress), configProvenance, paths[0])
+		switch output {
+		case "sarif":
+			data, err := config.ToSARIF(findings)
+			if err != nil {
+				log.Fatalf("Failed to generate SARIF: %v", err)
+			}
+			fmt.Println(string(data))
+		case "text":
+			config.PrintFindings(os.Stdout, findings)
+		default:
+			log.Fatalf("Unknown output format: %s", output)
+		}
+		if config.HasErrors(findings) {
+			os.Exit(1)
+		}
+	},
+}
+
+var explainConfig = &cobra.Command{
+	Use:     "explain <path>",
+	Short:   "Show the effective value of a config path and each file, default or flag that set it",
+	Example: "karina config explain monitoring.prometheus.version -c karina.yml",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		configProvenance = &config.Provenance{}
+		effective := config.Flatten(getConfig(cmd))
+
+		paths := config.Paths(effective, args[0])
+		for _, layer := range configProvenance.Layers {
+			for _, path := range config.Paths(layer.Values, args[0]) {
+				if _, ok := effective[path]; !ok {
+					paths = append(paths, path)
+				}
+			}
+		}
+		if len(paths) == 0 {
+			log.Fatalf("%s is not set", args[0])
+		}
+		sort.Strings(paths)
+
+		w := tabwriter.NewWriter(os.Stdout, 3, 2, 3, ' ', 0)
+		seen := map[string]bool{}
+		for _, path := range paths {
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+			contributions, source := configProvenance.Trace(path, effective[path])
+			if source == "" {
+				source = "unset"
+			}
+			_, _ = fmt.Fprintf(w, "%s = %s\t(%s)\n", path, formatValue(config.MaskSecret(path, effective[path])), source)
+			for _, c := range contributions {
+				_, _ = fmt.Fprintf(w, "  %s\t%s\t%s\n", c.Status, c.Source, formatValue(c.Value))
+			}
+		}
+		_ = w.Flush()
+	},
+}
+
+var dumpConfig = &cobra.Command{
+	Use:   "dump",
+	Short: "Print the effective config after merging all files, defaults and flags",
+	Args:  cobra.MinimumNArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		annotate, _ := cmd.Flags().GetBool("annotate")
+		if !annotate {
+			data, _ := yaml.Marshal(getConfig(cmd))
+			fmt.Println(string(data))
+			return
+		}
+		configProvenance = &config.Provenance{}
+		data, err := configProvenance.Annotate(getConfig(cmd))
+		if err != nil {
+			log.Fatalf("Failed to annotate config: %v", err)
+		}
+		fmt.Println(data)
 	},
 }
 
+var diffConfig = &cobra.Command{
+	Use:     "diff",
+	Short:   "Compare two fully resolved configs and show the phases affected by each change",
+	Example: "karina config diff -c stage.yml --c2 prod.yml",
+	Run: func(cmd *cobra.Command, args []string) {
+		to, _ := cmd.Flags().GetStringArray("c2")
+		if len(to) == 0 {
+			log.Fatalf("Must specify a config to compare against with --c2")
+		}
+		secrets, _ := cmd.Flags().GetString("secrets")
+		versionsOnly, _ := cmd.Flags().GetBool("versions-only")
+		output, _ := cmd.Flags().GetString("output")
+		skipDecrypt, _ := cmd.Flags().GetBool("skip-decrypt")
+		switch config.SecretMode(secrets) {
+		case config.ShowSecrets, config.MaskSecrets, config.IgnoreSecrets:
+		default:
+			log.Fatalf("Invalid --secrets %s, must be one of mask, ignore or show", secrets)
+		}
+
+		// both configs change into the directory of their first file while loading
+		fromConfig := NewConfigFromBase(types.PlatformConfig{SkipDecrypt: skipDecrypt}, absolutePaths(getConfigPaths(cmd)), nil)
+		toConfig := NewConfigFromBase(types.PlatformConfig{SkipDecrypt: skipDecrypt}, absolutePaths(to), nil)
+
+		diffs := config.Diff(fromConfig, toConfig, config.DiffOptions{
+			Secrets:      config.SecretMode(secrets),
+			VersionsOnly: versionsOnly,
+		})
+
+		type change struct {
+			config.FieldDiff
+			Phases []string `json:"phases,omitempty"`
+			Global bool     `json:"global,omitempty"`
+		}
+		var changes []change
+		affected := map[string]bool{}
+		global := false
+		for _, diff := range diffs {
+			phases, isGlobal := order.PhasesForConfig(diff.Path)
+			for _, phase := range phases {
+				affected[phase] = true
+			}
+			global = global || isGlobal
+			changes = append(changes, change{FieldDiff: diff, Phases: phases, Global: isGlobal})
+		}
+
+		if output == "json" {
+			data, _ := json.MarshalIndent(changes, "", "  ")
+			fmt.Println(string(data))
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 3, 2, 3, ' ', 0)
+		for _, c := range changes {
+			prefix := "~"
+			if c.From == nil {
+				prefix = "+"
+			} else if c.To == nil {
+				prefix = "-"
+			}
+			phases := strings.Join(c.Phases, ", ")
+			if c.Global {
+				phases = "all phases"
+			}
+			_, _ = fmt.Fprintf(w, "%s %s\t%s => %s\t%s\n", prefix, c.Path, formatValue(c.From), formatValue(c.To), phases)
+		}
+		_ = w.Flush()
+
+		var names []string
+		for phase := range affected {
+			names = append(names, phase)
+		}
+		sort.Strings(names)
+		fmt.Printf("\n%d changes", len(changes))
+		if global {
+			fmt.Printf(", affecting all phases\n")
+		} else {
+			fmt.Printf(", affecting phases: %s\n", strings.Join(names, ", "))
+		}
+	},
+}
+
+func absolutePaths(paths []string) []string {
+	var abs []string
+	for _, path := range paths {
+		p, err := filepath.Abs(path)
+		if err != nil {
+			log.Fatalf("Invalid path %s: %v", path, err)
+		}
+		abs = append(abs, p)
+	}
+	return abs
+}
+
+func formatValue(value interface{}) string {
+	if value == nil {
+		return "<none>"
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
+
+func getConfigPaths(cmd *cobra.Command) []string {
+	paths, _ := cmd.Flags().GetStringArray("config")
+	var files []string
+	for _, path := range paths {
+		files = append(files, strings.Split(path, ",")...)
+	}
+	return files
+}
+
+func exitOnValidationErrors(errs []config.ValidationError) {
+	if len(errs) == 0 {
+		return
+	}
+	for _, err := range errs {
+		log.Errorf("%v", err)
+	}
+	log.Errorf("Config is invalid: %d errors", len(errs))
+	os.Exit(1)
+}
+
 func init() {
-	Config.AddCommand(validateConfig)
+	lintConfig.Flags().StringSlice("suppress", []string{}, "Rule IDs or names to skip")
+	lintConfig.Flags().StringP("output", "o", "text", "Output format (text, sarif)")
+	lintConfig.Flags().Bool("live", false, "Compare against the running cluster, e.g. to detect kubernetes downgrades")
+	dumpConfig.Flags().Bool("annotate", false, "Add a comment to each value with the file, default or flag that set it")
+	diffConfig.Flags().StringArray("c2", []string{}, "Path to the config file(s) to compare against")
+	diffConfig.Flags().String("secrets", string(config.MaskSecrets), "How to show values that look like secrets (mask, ignore, show)")
+	diffConfig.Flags().Bool("versions-only", false, "Only compare the versions map and version fields")
+	diffConfig.Flags().StringP("output", "o", "text", "Output format (text, json)")
+	Config.AddCommand(validateConfig, configSchema, lintConfig, explainConfig, dumpConfig, diffConfig)
 }
@@ -1,58 +1,46 @@
 package cmd
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"path"
+	"text/tabwriter"
+	"time"
 
 	log "github.com/flanksource/commons/logger"
 	"github.com/flanksource/karina/pkg/phases/order"
-	"github.com/pkg/errors"
+	"github.com/flanksource/karina/pkg/plan"
+	"github.com/flanksource/karina/pkg/platform"
 	"github.com/spf13/cobra"
 )
 
 var deployExclude []string
+var deployConcurrency int
+var deployDiff bool
+var deployReport, deployReportFormat string
 var Deploy = &cobra.Command{
 	Use: "deploy",
 }
 
 func init() {
 	var PhasesCmd = &cobra.Command{
-		Use: "phases",
+		Use:   "phases",
+		Short: "Deploy the selected phases in dependency order",
 		Run: func(cmd *cobra.Command, args []string) {
-			phases := order.GetAllPhases()
 			p := getPlatform(cmd)
 			if _, err := p.GetClientset(); err != nil {
 				log.Fatalf("Failed to connect to platform, aborting deployment: %s", err)
 				os.Exit(1)
 			}
-			// we track the failure status, and continue on failure to allow degraded operations
-			failed := false
-			// first deploy strictly ordered phases, these phases are often dependencies for other phases
-			for _, name := range order.PhaseOrder {
-				flag, _ := cmd.Flags().GetBool(name)
-				if !flag {
-					continue
+			var names []string
+			for name := range order.GetAllPhases() {
+				if flag, _ := cmd.Flags().GetBool(name); flag {
+					names = append(names, name)
 				}
-				if err := phases[name](p); err != nil {
-					log.Errorf("Failed to deploy %s: %v", name, errors.WithStack(err))
-					failed = true
-				}
-				// remove the phase from the map so it isn't run again
-				delete(phases, name)
-			}
-			for name, fn := range phases {
-				flag, _ := cmd.Flags().GetBool(name)
-				if !flag {
-					continue
-				}
-
-				if err := fn(p); err != nil {
-					log.Errorf("Failed to deploy %s: %v", name, errors.WithStack(err))
-					failed = true
-				}
-			}
-			if failed {
-				os.Exit(1)
 			}
+			deployPhases(p, names)
 		},
 	}
 
@@ -80,44 +68,142 @@ func init() {
 		Args:  cobra.MinimumNArgs(0),
 		Run: func(cmd *cobra.Command, args []string) {
 			p := getPlatform(cmd)
-			phases := order.GetPhases()
-			all := order.GetAllPhases()
-			// we track the failure status, and continue on failure to allow degraded operations
-			failed := false
-
-			for _, phase := range order.BootstrapPhases {
-				if sliceContains(deployExclude, phase) {
-					p.Tracef("Skipping excluded phase %s", phase)
-					continue
-				}
-				p.Tracef("Deploying %s", phase)
-				if err := all[phase](p); err != nil {
-					log.Errorf("Failed to deploy %s: %v", phase, err)
-					failed = true
-				}
-			}
+			deployPhases(p, allPhases(p))
+		},
+	}
 
-			for name, fn := range phases {
-				if sliceContains(deployExclude, name) {
-					p.Tracef("Skipping excluded phase %s", name)
-					continue
-				}
-				p.Tracef("Deploying %s", name)
-				if err := fn(p); err != nil {
-					log.Errorf("Failed to deploy %s: %v", name, err)
-					failed = true
-				}
+	var planCmd = &cobra.Command{
+		Use:   "plan [phases...]",
+		Short: "Show the objects that would be created, updated or pruned without applying them",
+		Long:  "Performs a dry-run of the given phases (or all phases if none are given) and compares the result with the live objects in the cluster",
+		Run: func(cmd *cobra.Command, args []string) {
+			p := getPlatform(cmd)
+			names := args
+			if len(names) == 0 {
+				names = allPhases(p)
 			}
-			if failed {
-				os.Exit(1)
+			output, _ := cmd.Flags().GetString("output")
+			if err := printPlan(p, names, output); err != nil {
+				log.Fatalf("Failed to generate plan: %v", err)
 			}
 		},
 	}
+	planCmd.Flags().StringP("output", "o", "text", "Output format (text, json)")
+	planCmd.Flags().StringSliceVar(&deployExclude, "exclude", []string{}, "A list of phases to exclude from the plan")
+	Deploy.AddCommand(planCmd)
 
 	all.Flags().StringSliceVar(&deployExclude, "exclude", []string{}, "A list of phases to exclude from deployment")
+	all.Flags().IntVar(&deployConcurrency, "concurrency", 1, "Number of independent phases to deploy in parallel")
+	PhasesCmd.Flags().IntVar(&deployConcurrency, "concurrency", 1, "Number of independent phases to deploy in parallel")
+	all.Flags().BoolVar(&deployDiff, "diff", false, "Print the changes that will be made before deploying")
+	PhasesCmd.Flags().BoolVar(&deployDiff, "diff", false, "Print the changes that will be made before deploying")
+	for _, cmd := range []*cobra.Command{all, PhasesCmd} {
+		cmd.Flags().StringVar(&deployReport, "report", "", "Path to write a report of each phase's deployment to")
+		cmd.Flags().StringVar(&deployReportFormat, "report-format", "json", "Format of the --report file (json, junit)")
+	}
 	Deploy.AddCommand(all)
 }
 
+// deployPhases deploys phases in dependency order, exiting with a non-zero code if any phase
+// fails or is skipped because of a failed dependency
+func deployPhases(p *platform.Platform, names []string) {
+	if deployReport != "" && deployReportFormat != "json" && deployReportFormat != "junit" {
+		log.Fatalf("Unknown report format: %s", deployReportFormat)
+	}
+	if deployDiff {
+		if err := printPlan(p, names, "text"); err != nil {
+			log.Fatalf("Failed to generate plan: %v", err)
+		}
+	}
+	results, err := order.Deploy(p, names, deployConcurrency)
+	if err != nil {
+		log.Fatalf("Failed to order phases: %v", err)
+	}
+	printDeploySummary(results)
+	if deployReport != "" {
+		if err := writeDeployReport(order.NewReport(p.Name, results)); err != nil {
+			log.Errorf("Failed to write report: %v", err)
+		}
+	}
+	if results.HasFailures() {
+		os.Exit(1)
+	}
+}
+
+// allPhases returns the bootstrap and addon phases that are not excluded with --exclude
+func allPhases(p *platform.Platform) []string {
+	names := append([]string{}, order.BootstrapPhases...)
+	for name := range order.GetPhases() {
+		names = append(names, name)
+	}
+	var selected []string
+	for _, name := range names {
+		if sliceContains(deployExclude, name) {
+			p.Tracef("Skipping excluded phase %s", name)
+			continue
+		}
+		selected = append(selected, name)
+	}
+	return selected
+}
+
+func printPlan(p *platform.Platform, names []string, output string) error {
+	result, err := plan.Generate(p, names)
+	if err != nil {
+		return err
+	}
+	switch output {
+	case "json":
+		data, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	case "text":
+		result.Print(os.Stdout)
+	default:
+		return fmt.Errorf("unknown output format: %s", output)
+	}
+	return nil
+}
+
+func writeDeployReport(report order.Report) error {
+	var data []byte
+	switch deployReportFormat {
+	case "json":
+		json, err := report.ToJSON()
+		if err != nil {
+			return err
+		}
+		data = json
+	case "junit":
+		xml, err := report.ToJUnit()
+		if err != nil {
+			return err
+		}
+		data = []byte(xml)
+	default:
+		return fmt.Errorf("unknown report format: %s", deployReportFormat)
+	}
+	if err := os.MkdirAll(path.Dir(deployReport), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(deployReport, data, 0644)
+}
+
+func printDeploySummary(results order.Results) {
+	w := tabwriter.NewWriter(os.Stdout, 3, 2, 3, ' ', 0)
+	_, _ = fmt.Fprintf(w, "PHASE\tSTATUS\tDURATION\tERROR\n")
+	for _, result := range results {
+		msg := ""
+		if result.Error != nil {
+			msg = result.Error.Error()
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", result.Name, result.Status, result.Duration().Round(time.Millisecond), msg)
+	}
+	_ = w.Flush()
+}
+
 func sliceContains(slice []string, s string) bool {
 	for _, v := range slice {
 		if v == s {
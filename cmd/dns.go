@@ -3,7 +3,10 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strings"
 
+	dnsclient "github.com/flanksource/karina/pkg/client/dns"
+	"github.com/flanksource/karina/pkg/platform"
 	"github.com/spf13/cobra"
 )
 
@@ -11,7 +14,7 @@ var DNS = &cobra.Command{
 	Use: "dns",
 }
 
-var domain string
+var domain, recordType string
 
 func init() {
 	append := &cobra.Command{
@@ -19,7 +22,7 @@ func init() {
 		Args: cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			dns := getPlatform(cmd).GetDNSClient()
-			if err := dns.Append(domain, args...); err != nil {
+			if err := dns.Append(domain, recordType, args...); err != nil {
 				fmt.Println(err)
 				os.Exit(1)
 			}
@@ -31,7 +34,7 @@ func init() {
 		Args: cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			dns := getPlatform(cmd).GetDNSClient()
-			if err := dns.Update(domain, args...); err != nil {
+			if err := dns.Update(domain, recordType, args...); err != nil {
 				fmt.Println(err)
 				os.Exit(1)
 			}
@@ -43,7 +46,7 @@ func init() {
 		Args: cobra.MaximumNArgs(0),
 		Run: func(cmd *cobra.Command, args []string) {
 			dns := getPlatform(cmd).GetDNSClient()
-			list, err := dns.Get(domain)
+			list, err := dns.Get(domain, recordType)
 			if err != nil {
 				fmt.Println(err)
 				os.Exit(1)
@@ -59,14 +62,33 @@ func init() {
 		Args: cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			dns := getPlatform(cmd).GetDNSClient()
-			if err := dns.Delete(domain, args...); err != nil {
+			if err := dns.Delete(domain, recordType, args...); err != nil {
 				fmt.Println(err)
 				os.Exit(1)
 			}
 		},
 	}
-	DNS.AddCommand(append, update, delete, get)
+	var adopt bool
+	reconcile := &cobra.Command{
+		Use:   "reconcile",
+		Short: "Add and remove the records of k8s-api.<domain> and *.<domain> to match the ready masters and workers",
+		Args:  cobra.MaximumNArgs(0),
+		// --domain is not used
+		PersistentPreRun: GlobalPreRun,
+		Run: func(cmd *cobra.Command, args []string) {
+			platform := getPlatform(cmd)
+			changes, err := platform.ReconcileDNS(adopt)
+			printDNSChanges(changes, platform.Name, platform.DryRun)
+			if err != nil {
+				platform.Fatalf("Failed to reconcile DNS: %v", err)
+			}
+		},
+	}
+	reconcile.Flags().BoolVar(&adopt, "adopt", false, "Take ownership of names that have records but no ownership record, e.g. created before ownership was tracked")
+
+	DNS.AddCommand(append, update, delete, get, reconcile)
 	DNS.PersistentFlags().StringVar(&domain, "domain", "", "")
+	DNS.PersistentFlags().StringVar(&recordType, "type", dnsclient.A, "Record type, one of "+strings.Join(dnsclient.RecordTypes(), ", "))
 	DNS.PersistentPreRun = func(cmd *cobra.Command, args []string) {
 		if domain == "" {
 			fmt.Println("Must specify a --domain")
@@ -75,3 +97,38 @@ func init() {
 	}
 	_ = DNS.MarkFlagRequired("domain")
 }
+
+func printDNSChanges(changes []platform.DNSChange, owner string, dryRun bool) {
+	pending := false
+	for _, change := range changes {
+		if change.IsEmpty() {
+			continue
+		}
+		pending = true
+		if change.Skipped != "" {
+			fmt.Printf("! %s skipped, %s\n", change.Domain, change.Skipped)
+			for _, ip := range change.Add {
+				fmt.Printf("!   missing %s\n", ip)
+			}
+			for _, ip := range change.Remove {
+				fmt.Printf("!   stale %s\n", ip)
+			}
+			continue
+		}
+		if change.Claim {
+			fmt.Printf("+ %s TXT \"%s\"\n", change.Domain, dnsclient.OwnerRecord(owner))
+		}
+		for _, ip := range change.Add {
+			fmt.Printf("+ %s A %s\n", change.Domain, ip)
+		}
+		for _, ip := range change.Remove {
+			fmt.Printf("- %s A %s\n", change.Domain, ip)
+		}
+	}
+	switch {
+	case !pending:
+		fmt.Println("DNS records are up to date")
+	case dryRun:
+		fmt.Println("Not applied, --dry-run is set")
+	}
+}
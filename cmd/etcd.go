@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/flanksource/karina/pkg/etcd"
+	"github.com/flanksource/karina/pkg/provision"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var Etcd = &cobra.Command{
+	Use:   "etcd",
+	Short: "Commands for managing the etcd cluster running on the masters",
+}
+
+var etcdSnapshotOpts etcd.SnapshotOptions
+var etcdSnapshot = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Save a snapshot of etcd to a local file or S3",
+	Args:  cobra.MinimumNArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		location, err := etcd.Snapshot(getPlatform(cmd), etcdSnapshotOpts)
+		if err != nil {
+			log.Fatalf("Failed to take etcd snapshot: %v", err)
+		}
+		fmt.Println(location)
+	},
+}
+
+var etcdRestore = &cobra.Command{
+	Use:   "restore <s3://bucket/key>",
+	Short: "Rebuild a control plane that has lost all masters from an etcd snapshot",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		urlExpiry, _ := cmd.Flags().GetDuration("url-expiry")
+		if err := provision.RestoreControlPlane(getPlatform(cmd), args[0], urlExpiry); err != nil {
+			log.Fatalf("Failed to restore etcd: %v", err)
+		}
+	},
+}
+
+var etcdMembers = &cobra.Command{
+	Use:   "members",
+	Short: "List the etcd members with their status",
+	Args:  cobra.MinimumNArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		members, err := etcd.GetMembers(getPlatform(cmd))
+		if err != nil {
+			log.Fatalf("Failed to list etcd members: %v", err)
+		}
+		if output, _ := cmd.Flags().GetString("output"); output == "json" {
+			data, _ := json.MarshalIndent(members, "", "  ")
+			fmt.Println(string(data))
+			return
+		}
+		etcd.PrintMembers(os.Stdout, members)
+	},
+}
+
+var etcdDefrag = &cobra.Command{
+	Use:   "defrag [node...]",
+	Short: "Defragment etcd members one at a time to reclaim disk space, defaults to all members",
+	Args:  cobra.MinimumNArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := etcd.Defrag(getPlatform(cmd), args); err != nil {
+			log.Fatalf("Failed to defragment etcd: %v", err)
+		}
+	},
+}
+
+var etcdRemoveMember = &cobra.Command{
+	Use:   "remove-member <name|id>",
+	Short: "Remove an unhealthy etcd member, e.g. of a master that was lost without being terminated",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		force, _ := cmd.Flags().GetBool("force")
+		if err := etcd.RemoveMember(getPlatform(cmd), args[0], force); err != nil {
+			log.Fatalf("Failed to remove etcd member: %v", err)
+		}
+	},
+}
+
+func init() {
+	etcdSnapshot.Flags().StringVar(&etcdSnapshotOpts.Node, "node", "", "Master to take the snapshot from, defaults to the first master")
+	etcdSnapshot.Flags().StringVarP(&etcdSnapshotOpts.Destination, "output", "o", "", "Local file or s3://bucket/key to save the snapshot to, uses the s3 connection, a trailing / adds a timestamped file name")
+	etcdRestore.Flags().Duration("url-expiry", provision.DefaultSnapshotURLExpiry, "How long the presigned snapshot URL passed to the new master is valid for, it must cover cloning and booting the VM")
+	etcdMembers.Flags().StringP("output", "o", "table", "Output format: table or json")
+	etcdRemoveMember.Flags().Bool("force", false, "Remove the member even if it is healthy")
+	Etcd.AddCommand(etcdSnapshot, etcdRestore, etcdMembers, etcdDefrag, etcdRemoveMember)
+}
@@ -1,6 +1,18 @@
 package cmd
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/flanksource/karina/pkg/machineimages"
+	"github.com/flanksource/karina/pkg/platform"
+	"github.com/flanksource/karina/pkg/provision"
+	"github.com/flanksource/konfigadm/pkg/build"
+	konfigadm "github.com/flanksource/konfigadm/pkg/types"
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
@@ -10,29 +22,183 @@ var MachineImages = &cobra.Command{
 	Short:   "Commands for working with machine images",
 }
 
+func loadCatalog(platform *platform.Platform) *machineimages.Catalog {
+	catalog, err := machineimages.Load(platform)
+	if err != nil {
+		log.Fatalf("Failed to load machine image catalogue: %v", err)
+	}
+	return catalog
+}
+
+// register adds an image to the catalogue, hashing the konfigadm specs it was built from
+func register(platform *platform.Platform, image machineimages.Image, specs []string) {
+	var data []byte
+	for _, spec := range specs {
+		content, err := ioutil.ReadFile(spec)
+		if err != nil {
+			log.Fatalf("Failed to read %s: %v", spec, err)
+		}
+		data = append(data, content...)
+	}
+	if len(data) > 0 {
+		image.KonfigadmHash = machineimages.HashKonfigadm(data)
+	}
+	catalog := loadCatalog(platform)
+	catalog.Add(image)
+	if err := catalog.Save(platform); err != nil {
+		log.Fatalf("Failed to save machine image catalogue: %v", err)
+	}
+	log.Infof("Registered %s (%s, %s)", image.Name, image.Kubernetes, image.OS)
+}
+
 func init() {
-	MachineImages.AddCommand(&cobra.Command{
+	list := &cobra.Command{
 		Use:   "list",
-		Short: "List all machine images currently uploaded",
+		Short: "List all machine images in the catalogue and the number of machines using them",
 		Args:  cobra.MinimumNArgs(0),
 		Run: func(cmd *cobra.Command, args []string) {
+			platform := getPlatform(cmd)
+			catalog := loadCatalog(platform)
+			images := catalog.Images
+			if s, _ := cmd.Flags().GetString("selector"); s != "" {
+				selector, err := machineimages.ParseSelector(s)
+				if err != nil {
+					log.Fatalf("Invalid --selector: %v", err)
+				}
+				image, err := selector.Select(images)
+				if err != nil {
+					log.Fatalf("%v", err)
+				}
+				images = []machineimages.Image{*image}
+			}
+			if output, _ := cmd.Flags().GetString("output"); output == "json" {
+				data, _ := json.MarshalIndent(images, "", "  ")
+				fmt.Println(string(data))
+				return
+			}
+			usage := map[string]int{}
+			if err := provision.WithCluster(platform); err != nil {
+				log.Warnf("Unable to count machines using each image: %v", err)
+			} else if usage, err = provision.TemplateUsage(platform); err != nil {
+				log.Warnf("Unable to count machines using each image: %v", err)
+			}
+			machineimages.Print(os.Stdout, images, usage)
+		},
+	}
+	list.Flags().String("selector", "", "Show the image a template selector resolves to, e.g. k8s=1.20,os=ubuntu,latest")
+	list.Flags().StringP("output", "o", "table", "Output format: table or json")
+	MachineImages.AddCommand(list)
 
+	var specs []string
+	var name, osName, kubernetes string
+	var upload bool
+	buildCmd := &cobra.Command{
+		Use:   "build <image>",
+		Short: "Builds a new machine-image by applying konfigadm specs to a base image, and records it in the catalogue",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			platform := getPlatform(cmd)
+			cfg, err := konfigadm.NewConfig(specs...).Build()
+			if err != nil {
+				log.Fatalf("Failed to load konfigadm specs: %v", err)
+			}
+			version := kubernetes
+			if version == "" && cfg.Kubernetes != nil {
+				version = cfg.Kubernetes.Version
+			}
+			if version == "" {
+				log.Fatalf("--k8s is required when the konfigadm specs do not specify a kubernetes version")
+			}
+			build.Qemu{}.Build(args[0], cfg)
+			register(platform, machineimages.Image{Name: name, Kubernetes: version, OS: osName, Built: time.Now(), Pending: true, Path: args[0]}, specs)
+			if !upload {
+				log.Infof("%s is not used by template selectors until it is uploaded with karina machine-images upload %s", name, name)
+				return
+			}
+			if err := provision.UploadTemplate(platform, name, args[0]); err != nil {
+				log.Fatalf("Failed to upload %s: %v", name, err)
+			}
 		},
-	})
-	MachineImages.AddCommand(&cobra.Command{
-		Use:   "build",
-		Short: "Builds a new machine-image",
-		Args:  cobra.MinimumNArgs(0),
+	}
+	buildCmd.Flags().StringArrayVarP(&specs, "konfigadm", "k", []string{}, "One or more konfigadm specs to apply")
+	buildCmd.Flags().StringVar(&name, "name", "", "Name of the template the image will be uploaded as")
+	buildCmd.Flags().StringVar(&osName, "os", "", "Distribution and version of the base image, e.g. ubuntu20.04")
+	buildCmd.Flags().StringVar(&kubernetes, "k8s", "", "Kubernetes version installed, defaults to the version in the konfigadm specs")
+	buildCmd.Flags().BoolVar(&upload, "upload", false, "Upload the image to the provider of the cluster once built")
+	_ = buildCmd.MarkFlagRequired("name")
+	_ = buildCmd.MarkFlagRequired("os")
+	MachineImages.AddCommand(buildCmd)
+
+	var registerSpecs []string
+	var registerOS, registerKubernetes string
+	var built time.Duration
+	registerCmd := &cobra.Command{
+		Use:   "register <name>",
+		Short: "Records an existing template in the catalogue",
+		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
+			image := machineimages.Image{Name: args[0], Kubernetes: registerKubernetes, OS: registerOS, Built: time.Now().Add(-built)}
+			register(getPlatform(cmd), image, registerSpecs)
+		},
+	}
+	registerCmd.Flags().StringArrayVarP(&registerSpecs, "konfigadm", "k", []string{}, "The konfigadm specs the template was built from")
+	registerCmd.Flags().StringVar(&registerOS, "os", "", "Distribution and version of the template, e.g. ubuntu20.04")
+	registerCmd.Flags().StringVar(&registerKubernetes, "k8s", "", "Kubernetes version installed in the template")
+	registerCmd.Flags().DurationVar(&built, "age", 0, "How long ago the template was built")
+	_ = registerCmd.MarkFlagRequired("os")
+	_ = registerCmd.MarkFlagRequired("k8s")
+	MachineImages.AddCommand(registerCmd)
 
+	MachineImages.AddCommand(&cobra.Command{
+		Use:   "remove <name>",
+		Short: "Removes an image from the catalogue without deleting the template",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			platform := getPlatform(cmd)
+			catalog := loadCatalog(platform)
+			if !catalog.Remove(args[0]) {
+				log.Fatalf("%s is not in the catalogue", args[0])
+			}
+			if err := catalog.Save(platform); err != nil {
+				log.Fatalf("Failed to save machine image catalogue: %v", err)
+			}
 		},
 	})
+
 	MachineImages.AddCommand(&cobra.Command{
-		Use:   "upload",
-		Short: "Uploads a new machine image",
+		Use:   "gc",
+		Short: "Deletes templates that are not used by any machine or pool, keeping the newest machineImages.keep of each OS and kubernetes version",
 		Args:  cobra.MinimumNArgs(0),
 		Run: func(cmd *cobra.Command, args []string) {
-
+			platform := getPlatform(cmd)
+			images, err := provision.GarbageCollectTemplates(platform)
+			if len(images) == 0 && err == nil {
+				fmt.Println("No unused templates")
+			} else if len(images) > 0 {
+				if platform.DryRun {
+					fmt.Println("Templates that would be deleted:")
+				} else {
+					fmt.Println("Deleted templates:")
+				}
+				machineimages.Print(os.Stdout, images, nil)
+			}
+			if err != nil {
+				log.Fatalf("Failed to garbage collect templates: %v", err)
+			}
 		},
 	})
+
+	var uploadImage string
+	uploadCmd := &cobra.Command{
+		Use:   "upload <name>",
+		Short: "Uploads a built machine image to the provider of the cluster, after which template selectors can resolve to it",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := provision.UploadTemplate(getPlatform(cmd), args[0], uploadImage); err != nil {
+				log.Fatalf("Failed to upload %s: %v", args[0], err)
+			}
+		},
+	}
+	uploadCmd.Flags().StringVar(&uploadImage, "image", "", "The image file to upload, defaults to the file the image was built into")
+	MachineImages.AddCommand(uploadCmd)
 }
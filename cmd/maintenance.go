@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/flanksource/karina/pkg/maintenance"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var Maintenance = &cobra.Command{
+	Use:   "maintenance",
+	Short: "Commands for maintenance windows and change freezes",
+}
+
+var maintenanceStatus = &cobra.Command{
+	Use:   "status",
+	Short: "Show whether disruptive operations are currently allowed and the recorded freeze overrides",
+	Args:  cobra.MinimumNArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfig(cmd)
+		calendar, err := maintenance.New(config.Maintenance)
+		if err != nil {
+			log.Fatalf("Invalid maintenance config: %v", err)
+		}
+		if err := calendar.Check(time.Now()); err != nil {
+			fmt.Printf("Disruptive operations are not allowed: %v\n", err)
+		} else {
+			fmt.Println("Disruptive operations are allowed")
+		}
+
+		client, err := getPlatform(cmd).GetClientset()
+		if err != nil {
+			log.Fatalf("Failed to get clientset: %v", err)
+		}
+		overrides, err := maintenance.GetOverrides(client)
+		if err != nil {
+			log.Fatalf("Failed to get overrides: %v", err)
+		}
+		if len(overrides) == 0 {
+			return
+		}
+		fmt.Println()
+		w := tabwriter.NewWriter(os.Stdout, 3, 2, 3, ' ', 0)
+		fmt.Fprintf(w, "TIME\tUSER\tOPERATION\tREASON\tREFUSED\n")
+		for _, override := range overrides {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", override.Time.Format(time.RFC3339), override.User, override.Operation, override.Reason, override.Refused)
+		}
+		_ = w.Flush()
+	},
+}
+
+func init() {
+	Maintenance.AddCommand(maintenanceStatus)
+}
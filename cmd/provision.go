@@ -7,6 +7,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 
+	dnsclient "github.com/flanksource/karina/pkg/client/dns"
 	"github.com/flanksource/karina/pkg/provision"
 	"github.com/flanksource/karina/pkg/provision/vmware"
 )
@@ -16,12 +17,13 @@ var Provision = &cobra.Command{
 	Use:   "provision",
 	Short: "Commands for provisioning clusters and VMs",
 }
-var vsphereCluster = &cobra.Command{
-	Use:   "vsphere-cluster",
-	Short: "Provision a new vsphere cluster",
-	Args:  cobra.MinimumNArgs(0),
+var vmCluster = &cobra.Command{
+	Use:     "cluster",
+	Aliases: []string{"vsphere-cluster"},
+	Short:   "Provision a new cluster using the VM provider in master.provider (vsphere, libvirt)",
+	Args:    cobra.MinimumNArgs(0),
 	Run: func(cmd *cobra.Command, args []string) {
-		if err := provision.VsphereCluster(getPlatform(cmd), burninPeriod); err != nil {
+		if err := provision.VMCluster(getPlatform(cmd), burninPeriod); err != nil {
 			log.Fatalf("Failed to provision cluster, %s", err)
 		}
 	},
@@ -57,7 +59,9 @@ var vm = &cobra.Command{
 		platform := getPlatform(cmd)
 		//copy master config
 		vm := platform.Master
-		vmware.LoadGovcEnvVars(*platform.Vsphere, &vm)
+		if platform.Vsphere != nil {
+			vmware.LoadGovcEnvVars(*platform.Vsphere, &vm)
+		}
 		vm.MemoryGB = int64(mem)
 		vm.DiskGB = disk
 		vm.CPUs = int32(cpu)
@@ -79,7 +83,7 @@ var vm = &cobra.Command{
 			log.Fatalf("Failed to provision vm, %s", err)
 		}
 		if dns != "" {
-			if err := platform.GetDNSClient().Append(dns, vm.IP); err != nil {
+			if err := platform.GetDNSClient().Append(dns, dnsclient.A, vm.IP); err != nil {
 				log.Fatalf("Failed to update DNS %s => %s: %v", dns, vm.IP, err)
 			}
 		}
@@ -88,8 +92,8 @@ var vm = &cobra.Command{
 }
 
 func init() {
-	vsphereCluster.Flags().DurationVar(&burninPeriod, "burnin-period", time.Minute*3, "Period to burn-in new nodes before scheduling workloads on")
-	Provision.AddCommand(vsphereCluster, kindCluster, vm)
+	vmCluster.Flags().DurationVar(&burninPeriod, "burnin-period", time.Minute*3, "Period to burn-in new nodes before scheduling workloads on")
+	Provision.AddCommand(vmCluster, kindCluster, vm)
 	vm.Flags().String("name", "", "Name of vm")
 	vm.Flags().String("dns", "", "DNS entry to add")
 	vm.Flags().String("template", "", "template to use")
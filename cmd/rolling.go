@@ -1,10 +1,12 @@
 package cmd
 
 import (
+	"fmt"
 	"os"
 	"time"
 
 	"github.com/flanksource/karina/pkg/provision"
+	"github.com/flanksource/karina/pkg/types"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -14,22 +16,46 @@ var Rolling = &cobra.Command{
 }
 
 var rollingOpts provision.RollingOptions
+var rollingHealthGates []string
+
+// parseHealthGates parses the values of the --health-gate flag
+func parseHealthGates(flags []string) []types.HealthGate {
+	var gates []types.HealthGate
+	for _, s := range flags {
+		gate, err := provision.ParseHealthGate(s)
+		if err != nil {
+			log.Fatalf("Invalid --health-gate %s: %v", s, err)
+		}
+		gates = append(gates, gate)
+	}
+	return gates
+}
+
 var RollingRestart = &cobra.Command{
 	Use:   "restart",
 	Short: "Rolling restart of all nodes",
 	Args:  cobra.MinimumNArgs(0),
 	Run: func(cmd *cobra.Command, args []string) {
+		rollingOpts.HealthGates = parseHealthGates(rollingHealthGates)
 		if err := provision.RollingRestart(getPlatform(cmd), rollingOpts); err != nil {
 			log.Fatalf("Failed to restart nodes, %s", err)
 		}
 	},
 }
 
+var rollingAbort bool
 var RollingUpdate = &cobra.Command{
 	Use:   "update",
 	Short: "Rolling update of all nodes",
 	Args:  cobra.MinimumNArgs(0),
 	Run: func(cmd *cobra.Command, args []string) {
+		if rollingAbort {
+			if err := provision.AbortRollout(getPlatform(cmd)); err != nil {
+				log.Fatalf("Failed to abort rollout: %s", err)
+			}
+			return
+		}
+		rollingOpts.HealthGates = parseHealthGates(rollingHealthGates)
 		if err := provision.RollingUpdate(getPlatform(cmd), rollingOpts); err != nil {
 			log.Fatalf("Failed to update nodes %s", err)
 			os.Exit(1)
@@ -37,6 +63,27 @@ var RollingUpdate = &cobra.Command{
 	},
 }
 
+var RollingStatus = &cobra.Command{
+	Use:   "status",
+	Short: "Show the progress of the last rolling update",
+	Args:  cobra.MinimumNArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		client, err := getPlatform(cmd).GetClientset()
+		if err != nil {
+			log.Fatalf("Failed to get clientset: %v", err)
+		}
+		rollout, err := provision.GetRollout(client)
+		if err != nil {
+			log.Fatalf("Failed to get rollout: %v", err)
+		}
+		if rollout == nil {
+			fmt.Println("No rollouts found")
+			return
+		}
+		rollout.Print(os.Stdout)
+	},
+}
+
 func init() {
 	rollingOpts = provision.RollingOptions{}
 	RollingUpdate.Flags().DurationVar(&rollingOpts.MinAge, "min-age", time.Hour*24*7, "Minimum age of nodes to roll")
@@ -45,9 +92,18 @@ func init() {
 	Rolling.PersistentFlags().IntVar(&rollingOpts.Max, "max", 100, "Max number of nodes to roll")
 	RollingUpdate.PersistentFlags().IntVar(&rollingOpts.MaxSurge, "max-surge", 3, "Max number of nodes surge to, the higher the number the faster the rollout, but the more capacity that will be used ")
 	RollingUpdate.PersistentFlags().IntVar(&rollingOpts.HealthTolerance, "health-tolerance", 1, "Max number of failing pods to tolerate")
-	Rolling.PersistentFlags().BoolVar(&rollingOpts.MigrateLocalVolumes, "migrate-local-volumes", true, "Delete and recreate local PVC's")
+	// --migrate-local-volumes never had any effect, it is kept so that existing scripts do not fail
+	Rolling.PersistentFlags().Bool("migrate-local-volumes", true, "Delete and recreate local PVC's")
+	Rolling.PersistentFlags().MarkDeprecated("migrate-local-volumes", "it has no effect and will be removed in a future release") // nolint: errcheck
 	Rolling.PersistentFlags().BoolVar(&rollingOpts.Force, "force", false, "ignore errors and continue with the rolling action regardless of health")
 	Rolling.PersistentFlags().BoolVar(&rollingOpts.Masters, "masters", true, "include master nodes")
 	Rolling.PersistentFlags().BoolVar(&rollingOpts.Workers, "workers", true, "include worker nodes")
-	Rolling.AddCommand(RollingRestart, RollingUpdate)
+	Rolling.PersistentFlags().StringSliceVar(&rollingOpts.Pools, "pool", []string{}, "Only roll workers in these node pools, masters are skipped when set")
+	RollingUpdate.PersistentFlags().StringToIntVar(&rollingOpts.PoolMaxSurge, "pool-max-surge", map[string]int{}, "Max surge for individual node pools, e.g. gpu=1,general=5")
+	Rolling.PersistentFlags().DurationVar(&rollingOpts.Drain.Timeout, "drain-timeout", time.Minute*10, "How long to retry evicting pods blocked by PodDisruptionBudgets when draining a node")
+	Rolling.PersistentFlags().StringVar((*string)(&rollingOpts.Drain.OnTimeout), "drain-timeout-policy", string(provision.DrainAbort), "What to do with pods that cannot be evicted within the drain timeout: skip the node, force delete the pods or abort")
+	Rolling.PersistentFlags().StringArrayVar(&rollingHealthGates, "health-gate", []string{}, "Additional gate to check after each batch in the form type[:arg], e.g. alerts:warning, etcd, canary:app=web or 'prometheus:sum(kube_job_status_failed)'")
+	RollingUpdate.Flags().BoolVar(&rollingOpts.Resume, "resume", false, "Resume the last interrupted or failed rollout using its original options")
+	RollingUpdate.Flags().BoolVar(&rollingAbort, "abort", false, "Abort the last rollout, removing surge nodes and uncordoning nodes being replaced")
+	Rolling.AddCommand(RollingRestart, RollingUpdate, RollingStatus)
 }
@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"time"
+
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 
@@ -18,12 +20,14 @@ var Terminate = &cobra.Command{
 	},
 }
 
+var terminateDrain provision.DrainOptions
+
 var TerminateNodes = &cobra.Command{
 	Use:   "terminate-node [nodes]",
-	Short: "Cordon and terminate the specified nodes",
+	Short: "Drain and terminate the specified nodes",
 	Args:  cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		if err := provision.TerminateNodes(getPlatform(cmd), args); err != nil {
+		if err := provision.TerminateNodes(getPlatform(cmd), args, terminateDrain); err != nil {
 			log.Fatalf("Failed terminate nodes %s", err)
 		}
 	},
@@ -38,3 +42,8 @@ var TerminateOrphans = &cobra.Command{
 		}
 	},
 }
+
+func init() {
+	TerminateNodes.Flags().DurationVar(&terminateDrain.Timeout, "drain-timeout", time.Minute*10, "How long to retry evicting pods blocked by PodDisruptionBudgets when draining a node")
+	TerminateNodes.Flags().StringVar((*string)(&terminateDrain.OnTimeout), "drain-timeout-policy", string(provision.DrainAbort), "What to do with pods that cannot be evicted within the drain timeout: skip the node, force delete the pods or abort")
+}
@@ -1,18 +1,36 @@
 package cmd
 
 import (
+	"time"
+
 	"github.com/flanksource/commons/logger"
 	"github.com/flanksource/karina/pkg/provision"
 	"github.com/spf13/cobra"
 )
 
+var upgradeOpts provision.UpgradeOptions
+var upgradeHealthGates []string
 var Upgrade = &cobra.Command{
 	Use:   "upgrade",
-	Short: "Upgrade the kubernetes control plane",
+	Short: "Upgrade the kubernetes control plane and workers to the configured version",
 	Args:  cobra.MinimumNArgs(0),
 	Run: func(cmd *cobra.Command, args []string) {
-		if err := provision.Upgrade(getPlatform(cmd)); err != nil {
+		upgradeOpts.HealthGates = parseHealthGates(upgradeHealthGates)
+		if err := provision.Upgrade(getPlatform(cmd), upgradeOpts); err != nil {
 			logger.Fatalf("Failed to upgrade cluster, %s", err)
 		}
 	},
 }
+
+func init() {
+	Upgrade.Flags().StringVar((*string)(&upgradeOpts.Workers), "workers", string(provision.UpgradeAuto), "How to upgrade workers: in-place, replace, auto (replace pools with a new template, in-place otherwise) or none")
+	Upgrade.Flags().StringSliceVar(&upgradeOpts.Pools, "pool", []string{}, "Only upgrade workers in these node pools")
+	Upgrade.Flags().DurationVar(&upgradeOpts.Timeout, "timeout", time.Minute*5, "How long to wait for upgraded nodes to become ready")
+	Upgrade.Flags().DurationVar(&upgradeOpts.BurninPeriod, "burnin-period", time.Minute*3, "Period to burn-in replacement nodes before scheduling workloads on")
+	Upgrade.Flags().IntVar(&upgradeOpts.MaxSurge, "max-surge", 3, "Max number of workers to replace at a time in pools that are replaced")
+	Upgrade.Flags().IntVar(&upgradeOpts.HealthTolerance, "health-tolerance", 1, "Max number of failing pods to tolerate when replacing workers")
+	Upgrade.Flags().StringArrayVar(&upgradeHealthGates, "health-gate", []string{}, "Additional gate to check after each batch of replaced workers in the form type[:arg], e.g. alerts:warning, etcd, canary:app=web or 'prometheus:sum(kube_job_status_failed)'")
+	Upgrade.Flags().DurationVar(&upgradeOpts.Drain.Timeout, "drain-timeout", time.Minute*10, "How long to retry evicting pods blocked by PodDisruptionBudgets when draining a node")
+	Upgrade.Flags().StringVar((*string)(&upgradeOpts.Drain.OnTimeout), "drain-timeout-policy", string(provision.DrainAbort), "What to do with pods that cannot be evicted within the drain timeout: skip the node, force delete the pods or abort")
+	Upgrade.Flags().BoolVar(&upgradeOpts.IgnoreDeprecations, "ignore-deprecations", false, "Continue the upgrade when APIs removed in the new version are still in use")
+}
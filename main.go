@@ -27,9 +27,11 @@ func main() {
 		cmd.Access,
 		cmd.APIDocs,
 		cmd.Apply,
+		cmd.Autoscaler,
 		cmd.Backup,
 		cmd.BurninController,
 		cmd.CA,
+		cmd.Check,
 		cmd.Terminate,
 		cmd.Cleanup,
 		cmd.Config,
@@ -39,12 +41,14 @@ func main() {
 		cmd.DB,
 		cmd.Deploy,
 		cmd.DNS,
+		cmd.Etcd,
 		cmd.Exec,
 		cmd.ExecNode,
 		cmd.Harbor,
 		cmd.Images,
 		cmd.Logs,
 		cmd.MachineImages,
+		cmd.Maintenance,
 		cmd.Namespace,
 		cmd.Node,
 		cmd.NSX,
@@ -113,6 +117,8 @@ func main() {
 	root.PersistentFlags().Bool("dry-run", false, "Don't apply any changes, print what would have been done")
 	root.PersistentFlags().Bool("trace", false, "Print out generated specs and configs")
 	root.PersistentFlags().Bool("in-cluster", false, "Use in cluster kubernetes config")
+	root.PersistentFlags().String("override-freeze", "", "Run disruptive operations outside of maintenance windows or during a freeze, the reason is recorded in the cluster")
+	root.PersistentFlags().Duration("wait-for-window", 0, "Wait up to this long for the next maintenance window instead of refusing disruptive operations")
 	root.SetUsageTemplate(root.UsageTemplate() + fmt.Sprintf("\nversion: %s\n ", version))
 
 	if err := root.Execute(); err != nil {
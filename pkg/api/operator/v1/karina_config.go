@@ -13,6 +13,9 @@ type KarinaConfigSpec struct {
 	TemplateFrom map[string]TemplateSource `json:"templateFrom,omitempty"`
 	Image        string                    `json:"image,omitempty"`
 	Version      string                    `json:"version,omitempty"`
+	// OverrideFreeze is the reason for deploying outside of a maintenance window or during a freeze, it allows a
+	// single deploy and is cleared by the operator once used
+	OverrideFreeze string `json:"overrideFreeze,omitempty"`
 }
 
 // KarinaConfigStatus defines the observed state of KarinaConfig
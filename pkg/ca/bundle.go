@@ -0,0 +1,88 @@
+package ca
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"time"
+
+	"github.com/flanksource/commons/certs"
+	"github.com/pkg/errors"
+)
+
+// EncodeCert returns the PEM encoding of a certificate
+func EncodeCert(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
+
+// Contains returns true if a PEM bundle contains cert
+func Contains(bundle []byte, cert *x509.Certificate) bool {
+	for {
+		var block *pem.Block
+		block, bundle = pem.Decode(bundle)
+		if block == nil {
+			return false
+		}
+		if block.Type == "CERTIFICATE" && bytes.Equal(block.Bytes, cert.Raw) {
+			return true
+		}
+	}
+}
+
+// AppendCert adds cert to the end of a PEM bundle, unless it is already in the bundle
+func AppendCert(bundle []byte, cert *x509.Certificate) []byte {
+	if Contains(bundle, cert) {
+		return bundle
+	}
+	out := append([]byte{}, bundle...)
+	if len(out) > 0 && !bytes.HasSuffix(out, []byte("\n")) {
+		out = append(out, '\n')
+	}
+	return append(out, EncodeCert(cert)...)
+}
+
+// RemoveCert removes cert from a PEM bundle, keeping the other blocks in order
+func RemoveCert(bundle []byte, cert *x509.Certificate) []byte {
+	var out []byte
+	for {
+		var block *pem.Block
+		block, bundle = pem.Decode(bundle)
+		if block == nil {
+			return out
+		}
+		if block.Type == "CERTIFICATE" && bytes.Equal(block.Bytes, cert.Raw) {
+			continue
+		}
+		out = append(out, pem.EncodeToMemory(block)...)
+	}
+}
+
+// ReplaceCert replaces the first certificate in a PEM bundle with cert, removing any other copy of cert
+func ReplaceCert(bundle []byte, cert *x509.Certificate) ([]byte, error) {
+	chain, err := ParseCertificates(bundle)
+	if err != nil {
+		return nil, err
+	}
+	return append(EncodeCert(cert), RemoveCert(RemoveCert(bundle, chain[0]), cert)...), nil
+}
+
+// CrossSign signs the public key and subject of an existing CA with another CA, certificates issued by the
+// existing CA then also chain to the signing CA
+func CrossSign(signer certs.CertificateAuthority, ca *x509.Certificate, expiry time.Duration) (*x509.Certificate, error) {
+	template := &x509.Certificate{
+		Subject:               ca.Subject,
+		PublicKey:             ca.PublicKey,
+		SubjectKeyId:          ca.SubjectKeyId,
+		KeyUsage:              ca.KeyUsage,
+		ExtKeyUsage:           ca.ExtKeyUsage,
+		IsCA:                  ca.IsCA,
+		BasicConstraintsValid: ca.BasicConstraintsValid,
+		MaxPathLen:            ca.MaxPathLen,
+		MaxPathLenZero:        ca.MaxPathLenZero,
+	}
+	signed, err := signer.Sign(template, expiry)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to cross sign %s", ca.Subject)
+	}
+	return signed, nil
+}
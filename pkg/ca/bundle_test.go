@@ -0,0 +1,47 @@
+package ca
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestBundle(t *testing.T) {
+	g := NewWithT(t)
+	old := newCA(g, "old-ca", nil)
+	new := newCA(g, "new-ca", nil)
+
+	bundle := old.EncodedCertificate()
+	g.Expect(Contains(bundle, old.X509)).To(BeTrue())
+	g.Expect(Contains(bundle, new.X509)).To(BeFalse())
+
+	both := AppendCert(bundle, new.X509)
+	g.Expect(Contains(both, new.X509)).To(BeTrue())
+	g.Expect(string(AppendCert(both, new.X509))).To(Equal(string(both)))
+	chain, err := ParseCertificates(both)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(chain).To(HaveLen(2))
+
+	g.Expect(string(RemoveCert(both, old.X509))).To(Equal(string(new.EncodedCertificate())))
+	replaced, err := ReplaceCert(both, new.X509)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(string(replaced)).To(Equal(string(new.EncodedCertificate())))
+}
+
+func TestCrossSign(t *testing.T) {
+	g := NewWithT(t)
+	old := newCA(g, "old-ca", nil)
+	new := newCA(g, "new-ca", nil)
+	intermediate := newCA(g, "kubernetes-ca", old)
+	leaf, err := intermediate.SignCertificate(newCA(g, "leaf", nil), 1)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	signed, err := CrossSign(new, intermediate.X509, 24*time.Hour)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(signed.Subject.CommonName).To(Equal("kubernetes-ca"))
+	g.Expect(ChainsTo([]*x509.Certificate{leaf.X509, intermediate.X509}, old.X509)).To(BeTrue())
+	g.Expect(ChainsTo([]*x509.Certificate{leaf.X509, signed}, new.X509)).To(BeTrue())
+	g.Expect(ChainsTo([]*x509.Certificate{leaf.X509, signed}, old.X509)).To(BeFalse())
+}
@@ -1,6 +1,7 @@
 package ca
 
 import (
+	"crypto/x509"
 	"fmt"
 	"strings"
 
@@ -11,8 +12,11 @@ import (
 )
 
 // ReadCA opens the CA stored in the file ca.Cert using the private key in ca.PrivateKey
-// with key password ca.Password.
+// with key password ca.Password. Any certificates following the CA in ca.Cert are its issuers.
 func ReadCA(ca *types.CA) (*certs.Certificate, error) {
+	if ca.PKCS11 != nil || ca.Vault != nil {
+		return nil, fmt.Errorf("the private key of %s is held in an HSM or vault and cannot be read", ca.Cert)
+	}
 	var cert, privateKey string
 	if strings.HasPrefix(ca.Cert, "-----BEGIN CERTIFICATE-----") {
 		cert = ca.Cert
@@ -36,8 +40,38 @@ func ReadCA(ca *types.CA) (*certs.Certificate, error) {
 		return nil, fmt.Errorf("unable to read private key %s", ca.PrivateKey)
 	}
 
+	var decoded *certs.Certificate
+	var err error
 	if ca.Password == "" {
-		return certs.DecodeCertificate([]byte(cert), []byte(privateKey))
+		decoded, err = certs.DecodeCertificate([]byte(cert), []byte(privateKey))
+	} else {
+		decoded, err = certs.DecryptCertificate([]byte(cert), []byte(privateKey), []byte(ca.Password))
+	}
+	if err != nil {
+		return nil, err
+	}
+	chain, err := ParseCertificates([]byte(cert))
+	if err != nil {
+		return nil, err
+	}
+	for _, issuer := range chain[1:] {
+		decoded.Chain = append(decoded.Chain, &certs.Certificate{X509: issuer})
+	}
+	return decoded, nil
+}
+
+// ReadCert reads the certificate of a CA without its private key, ca.Cert is either a PEM encoded certificate or a path
+func ReadCert(ca *types.CA) (*x509.Certificate, error) {
+	cert := ca.Cert
+	if !strings.HasPrefix(ca.Cert, "-----BEGIN CERTIFICATE-----") {
+		cert = files.SafeRead(ca.Cert)
+	}
+	if cert == "" {
+		return nil, fmt.Errorf("unable to read certificate %s", ca.Cert)
+	}
+	chain, err := ParseCertificates([]byte(cert))
+	if err != nil {
+		return nil, err
 	}
-	return certs.DecryptCertificate([]byte(cert), []byte(privateKey), []byte(ca.Password))
+	return chain[0], nil
 }
@@ -4,34 +4,57 @@ import (
 	"crypto/rand"
 	"crypto/x509"
 	"encoding/pem"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 
 	"github.com/flanksource/commons/certs"
+	"github.com/flanksource/karina/pkg/types"
 	"github.com/pkg/errors"
 )
 
 // GenerateCA generates a new CA certificate
 func GenerateCA(name, certPath, privateKeyPath, password string, expiryYears int) error {
-	if err := ensureDir(certPath, 0700); err != nil {
-		return errors.Wrapf(err, "failed to create directories for certificate path: %s", certPath)
-	}
-	if err := ensureDir(privateKeyPath, 0700); err != nil {
-		return errors.Wrapf(err, "failed to create directories for certificate private key path: %s", privateKeyPath)
+	ca := certs.NewCertificateBuilder(name).CA().Certificate
+	signedCA, err := ca.SignCertificate(ca, expiryYears)
+	if err != nil {
+		return errors.Wrap(err, "failed to sign certificate")
 	}
+	return writeCA(signedCA, signedCA.EncodedCertificate(), certPath, privateKeyPath, password)
+}
 
+// GenerateIntermediateCA generates a new CA certificate signed by root, so that the root key can be kept offline.
+// The certificate file contains the intermediate followed by the root
+func GenerateIntermediateCA(name, certPath, privateKeyPath, password string, expiryYears int, root *types.CA) error {
+	rootCA, err := ReadAuthority(root)
+	if err != nil {
+		return errors.Wrap(err, "failed to read root CA")
+	}
 	ca := certs.NewCertificateBuilder(name).CA().Certificate
-	signedCA, err := ca.SignCertificate(ca, expiryYears)
+	signedCA, err := rootCA.SignCertificate(ca, expiryYears)
 	if err != nil {
 		return errors.Wrap(err, "failed to sign certificate")
 	}
+	if !ChainsTo([]*x509.Certificate{signedCA.X509}, Certificate(rootCA)) {
+		return fmt.Errorf("the signed certificate does not chain to %s", Certificate(rootCA).Subject)
+	}
+	return writeCA(signedCA, append(signedCA.EncodedCertificate(), EncodeChain(rootCA)...), certPath, privateKeyPath, password)
+}
+
+func writeCA(ca *certs.Certificate, chain []byte, certPath, privateKeyPath, password string) error {
+	if err := ensureDir(certPath, 0700); err != nil {
+		return errors.Wrapf(err, "failed to create directories for certificate path: %s", certPath)
+	}
+	if err := ensureDir(privateKeyPath, 0700); err != nil {
+		return errors.Wrapf(err, "failed to create directories for certificate private key path: %s", privateKeyPath)
+	}
 
-	if err := ioutil.WriteFile(certPath, signedCA.EncodedCertificate(), 0600); err != nil {
+	if err := ioutil.WriteFile(certPath, chain, 0600); err != nil {
 		return errors.Wrap(err, "failed to write certificate file")
 	}
 
-	encryptedPrivateKey := signedCA.EncodedPrivateKey()
+	encryptedPrivateKey := ca.EncodedPrivateKey()
 
 	if password != "" {
 		pk := x509.MarshalPKCS1PrivateKey(ca.PrivateKey)
@@ -0,0 +1,98 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/flanksource/karina/pkg/types"
+	"github.com/pkg/errors"
+)
+
+// digestInfoPrefixes are the ASN.1 DigestInfo headers prepended to a digest before PKCS#1 v1.5 padding,
+// the RSA-PKCS mechanism pads and signs its input as is
+var digestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA1:   {0x30, 0x21, 0x30, 0x09, 0x06, 0x05, 0x2b, 0x0e, 0x03, 0x02, 0x1a, 0x05, 0x00, 0x04, 0x14},
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// pinEnv is the environment variable the PIN is passed to pkcs11-tool in, so that it is not visible in the
+// command line of the process
+const pinEnv = "KARINA_PKCS11_PIN"
+
+// pkcs11Tool runs pkcs11-tool from OpenSC with env added to the environment, it is a variable so that tests can
+// replace the HSM
+var pkcs11Tool = func(env []string, args ...string) ([]byte, error) {
+	cmd := exec.Command("pkcs11-tool", args...)
+	cmd.Env = append(os.Environ(), env...)
+	return cmd.CombinedOutput()
+}
+
+// pkcs11Key is a crypto.Signer for a private key in an HSM, signing through its PKCS#11 module with pkcs11-tool
+type pkcs11Key struct {
+	config types.PKCS11
+	public crypto.PublicKey
+}
+
+func (k *pkcs11Key) Public() crypto.PublicKey {
+	return k.public
+}
+
+func (k *pkcs11Key) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	args := []string{"--module", k.config.Module, "--sign"}
+	input := digest
+	switch k.public.(type) {
+	case *rsa.PublicKey:
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			return nil, fmt.Errorf("RSA-PSS signatures are not supported")
+		}
+		prefix, ok := digestInfoPrefixes[opts.HashFunc()]
+		if !ok {
+			return nil, fmt.Errorf("unsupported hash %v", opts.HashFunc())
+		}
+		input = append(append([]byte{}, prefix...), digest...)
+		args = append(args, "--mechanism", "RSA-PKCS")
+	case *ecdsa.PublicKey:
+		// openssl format is the ASN.1 encoding expected in certificates, rather than r || s
+		args = append(args, "--mechanism", "ECDSA", "--signature-format", "openssl")
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", k.public)
+	}
+	if k.config.TokenLabel != "" {
+		args = append(args, "--token-label", k.config.TokenLabel)
+	}
+	if k.config.KeyID != "" {
+		args = append(args, "--id", k.config.KeyID)
+	} else {
+		args = append(args, "--label", k.config.KeyLabel)
+	}
+	var env []string
+	if k.config.Pin != "" {
+		// pkcs11-tool reads the PIN from the environment variable named after env:
+		args = append(args, "--login", "--pin", "env:"+pinEnv)
+		env = append(env, pinEnv+"="+k.config.Pin)
+	}
+
+	dir, err := ioutil.TempDir("", "karina-pkcs11")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+	in, out := filepath.Join(dir, "digest"), filepath.Join(dir, "signature")
+	if err := ioutil.WriteFile(in, input, 0600); err != nil {
+		return nil, err
+	}
+	args = append(args, "--input-file", in, "--output-file", out)
+	if output, err := pkcs11Tool(env, args...); err != nil {
+		return nil, errors.Wrapf(err, "pkcs11-tool failed: %s", output)
+	}
+	return ioutil.ReadFile(out)
+}
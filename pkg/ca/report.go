@@ -0,0 +1,352 @@
+package ca
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/flanksource/karina/pkg/constants"
+	certmanager "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Sources of certificates in the report
+const (
+	SourceSecret      = "secret"
+	SourceCertificate = "certificate"
+	SourceWebhook     = "webhook"
+	SourceKubeadm     = "kubeadm"
+)
+
+// CertificateAnnotation is added by cert-manager to the secrets it issues
+const CertificateAnnotation = "cert-manager.io/certificate-name"
+
+// kubeadmCerts prints every certificate and embedded kubeconfig client certificate on a master, prefixed with its path
+const kubeadmCerts = "grep -r -H --include='*.crt' '' /etc/kubernetes/pki; grep -H client-certificate-data /etc/kubernetes/*.conf"
+
+// Certificates is the cert-manager Certificate resource
+var Certificates = schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "certificates"}
+
+// Cert is a certificate found in the cluster or on a master
+type Cert struct {
+	Source string `json:"source"`
+	// Namespace and Name identify the secret, Certificate or webhook, or the node and file of kubeadm certs
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	// Certificate is the cert-manager Certificate that issued a secret
+	Certificate string    `json:"certificate,omitempty"`
+	Subject     string    `json:"subject,omitempty"`
+	Issuer      string    `json:"issuer,omitempty"`
+	SANs        []string  `json:"sans,omitempty"`
+	NotAfter    time.Time `json:"notAfter,omitempty"`
+	DaysLeft    int       `json:"daysLeft"`
+	// TrustedBy is the configured CA the certificate chains to, e.g. ca or ingressCA
+	TrustedBy string `json:"trustedBy,omitempty"`
+	Error     string `json:"error,omitempty"`
+
+	chain []*x509.Certificate
+}
+
+// ID returns the location of the certificate
+func (c Cert) ID() string {
+	if c.Namespace == "" {
+		return c.Name
+	}
+	return c.Namespace + "/" + c.Name
+}
+
+func newCert(source, namespace, name string, data []byte) Cert {
+	cert := Cert{Source: source, Namespace: namespace, Name: name}
+	chain, err := ParseCertificates(data)
+	if err != nil {
+		cert.Error = err.Error()
+		return cert
+	}
+	cert.chain = chain
+	leaf := chain[0]
+	cert.Subject = leaf.Subject.String()
+	cert.Issuer = leaf.Issuer.String()
+	cert.SANs = append(cert.SANs, leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		cert.SANs = append(cert.SANs, ip.String())
+	}
+	cert.NotAfter = leaf.NotAfter
+	cert.DaysLeft = daysUntil(leaf.NotAfter)
+	return cert
+}
+
+func daysUntil(t time.Time) int {
+	return int(time.Until(t).Hours() / 24)
+}
+
+// ParseCertificates decodes the PEM encoded certificates in data, the first certificate is the leaf
+func ParseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var chain []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid certificate")
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no PEM encoded certificates found")
+	}
+	return chain, nil
+}
+
+// ChainsTo returns true if the leaf of chain is root, or is signed by root directly or through the
+// intermediates in the chain. Expiry is not checked so that expired certificates are still attributed
+func ChainsTo(chain []*x509.Certificate, root *x509.Certificate) bool {
+	cert := chain[0]
+	for i := 0; i <= len(chain); i++ {
+		if bytes.Equal(cert.Raw, root.Raw) || cert.CheckSignatureFrom(root) == nil {
+			return true
+		}
+		var parent *x509.Certificate
+		for _, candidate := range chain {
+			if candidate != cert && cert.CheckSignatureFrom(candidate) == nil {
+				parent = candidate
+				break
+			}
+		}
+		if parent == nil {
+			return false
+		}
+		cert = parent
+	}
+	return false
+}
+
+// FromSecrets returns the certificates in every secret with a tls.crt key
+func FromSecrets(client kubernetes.Interface) ([]Cert, error) {
+	secrets, err := client.CoreV1().Secrets(v1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list secrets")
+	}
+	var found []Cert
+	for _, secret := range secrets.Items {
+		data, ok := secret.Data[v1.TLSCertKey]
+		if !ok {
+			continue
+		}
+		cert := newCert(SourceSecret, secret.Namespace, secret.Name, data)
+		cert.Certificate = secret.Annotations[CertificateAnnotation]
+		found = append(found, cert)
+	}
+	return found, nil
+}
+
+// FromCertificates returns the cert-manager Certificates whose secret has not been issued, issued
+// certificates are reported through their secret
+func FromCertificates(dynamicClient dynamic.Interface, secrets []Cert) ([]Cert, error) {
+	list, err := dynamicClient.Resource(Certificates).Namespace(v1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list certificates")
+	}
+	issued := map[string]bool{}
+	for _, secret := range secrets {
+		issued[secret.ID()] = true
+	}
+	var found []Cert
+	for _, item := range list.Items {
+		var certificate certmanager.Certificate
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.UnstructuredContent(), &certificate); err != nil {
+			return nil, errors.Wrapf(err, "invalid certificate %s/%s", item.GetNamespace(), item.GetName())
+		}
+		if issued[certificate.Namespace+"/"+certificate.Spec.SecretName] {
+			continue
+		}
+		cert := Cert{
+			Source:      SourceCertificate,
+			Namespace:   certificate.Namespace,
+			Name:        certificate.Name,
+			Certificate: certificate.Name,
+			Issuer:      certificate.Spec.IssuerRef.Name,
+			SANs:        append(append([]string{}, certificate.Spec.DNSNames...), certificate.Spec.IPAddresses...),
+			Error:       fmt.Sprintf("secret %s has not been issued", certificate.Spec.SecretName),
+		}
+		if certificate.Status.NotAfter != nil {
+			cert.NotAfter = certificate.Status.NotAfter.Time
+			cert.DaysLeft = daysUntil(cert.NotAfter)
+		}
+		found = append(found, cert)
+	}
+	return found, nil
+}
+
+// FromWebhooks returns the caBundle of every validating and mutating webhook
+func FromWebhooks(client kubernetes.Interface) ([]Cert, error) {
+	var found []Cert
+	add := func(config, webhook string, caBundle []byte) {
+		if len(caBundle) > 0 {
+			found = append(found, newCert(SourceWebhook, "", config+"/"+webhook, caBundle))
+		}
+	}
+	validating, err := client.AdmissionregistrationV1().ValidatingWebhookConfigurations().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list validating webhooks")
+	}
+	for _, config := range validating.Items {
+		for _, webhook := range config.Webhooks {
+			add(config.Name, webhook.Name, webhook.ClientConfig.CABundle)
+		}
+	}
+	mutating, err := client.AdmissionregistrationV1().MutatingWebhookConfigurations().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list mutating webhooks")
+	}
+	for _, config := range mutating.Items {
+		for _, webhook := range config.Webhooks {
+			add(config.Name, webhook.Name, webhook.ClientConfig.CABundle)
+		}
+	}
+	return found, nil
+}
+
+// FromMasters returns the kubeadm control plane, etcd and kubeconfig client certificates on each master,
+// execute runs a shell command on a node
+func FromMasters(client kubernetes.Interface, execute func(node, command string) (string, error)) ([]Cert, error) {
+	nodes, err := client.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{LabelSelector: constants.MasterNodeLabel})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list masters")
+	}
+	var found []Cert
+	for _, node := range nodes.Items {
+		out, err := execute(node.Name, kubeadmCerts)
+		if err != nil {
+			found = append(found, Cert{Source: SourceKubeadm, Namespace: node.Name, Name: "/etc/kubernetes", Error: err.Error()})
+			continue
+		}
+		found = append(found, parseKubeadmCerts(node.Name, out)...)
+	}
+	return found, nil
+}
+
+// parseKubeadmCerts parses the output of kubeadmCerts, lines are either path:<line of PEM> or
+// path:    client-certificate-data: <base64 PEM>
+func parseKubeadmCerts(node, out string) []Cert {
+	files := map[string][]byte{}
+	var paths []string
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	scanner.Buffer(make([]byte, 1024*1024), 10*1024*1024)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], "/") {
+			continue
+		}
+		path, line := parts[0], strings.TrimSpace(parts[1])
+		if _, ok := files[path]; !ok {
+			paths = append(paths, path)
+		}
+		if value := strings.TrimPrefix(line, "client-certificate-data:"); value != line {
+			data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+			if err == nil {
+				files[path] = append(files[path], data...)
+			}
+			continue
+		}
+		files[path] = append(files[path], []byte(line+"\n")...)
+	}
+	var found []Cert
+	for _, path := range paths {
+		found = append(found, newCert(SourceKubeadm, node, path, files[path]))
+	}
+	return found
+}
+
+// Verify records which of the roots each certificate chains to
+func Verify(certs []Cert, roots map[string]*x509.Certificate) {
+	var names []string
+	for name := range roots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i := range certs {
+		if len(certs[i].chain) == 0 {
+			continue
+		}
+		for _, name := range names {
+			if ChainsTo(certs[i].chain, roots[name]) {
+				certs[i].TrustedBy = name
+				break
+			}
+		}
+	}
+}
+
+// Expiring returns the certificates that expire within days, or whose expiry could not be read
+func Expiring(certs []Cert, days int) []Cert {
+	var expiring []Cert
+	for _, cert := range certs {
+		if cert.NotAfter.IsZero() || cert.DaysLeft < days {
+			expiring = append(expiring, cert)
+		}
+	}
+	return expiring
+}
+
+// SortCerts sorts certificates by expiry, soonest first
+func SortCerts(certs []Cert) {
+	sort.SliceStable(certs, func(i, j int) bool {
+		if !certs[i].NotAfter.Equal(certs[j].NotAfter) {
+			return certs[i].NotAfter.Before(certs[j].NotAfter)
+		}
+		return certs[i].ID() < certs[j].ID()
+	})
+}
+
+// PrintCerts writes a table of certificates to w
+func PrintCerts(w io.Writer, certs []Cert) {
+	table := tabwriter.NewWriter(w, 3, 2, 3, ' ', 0)
+	fmt.Fprintf(table, "SOURCE\tNAME\tISSUER\tSANS\tEXPIRES\tDAYS\tTRUSTED BY\tERROR\n")
+	for _, cert := range certs {
+		expires, days := "", ""
+		if !cert.NotAfter.IsZero() {
+			expires, days = cert.NotAfter.Format("2006-01-02"), fmt.Sprintf("%d", cert.DaysLeft)
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", cert.Source, cert.ID(), issuerName(cert.Issuer),
+			truncate(strings.Join(cert.SANs, ","), 60), expires, days, cert.TrustedBy, cert.Error)
+	}
+	_ = table.Flush()
+}
+
+// issuerName returns the common name of an issuer if it has one
+func issuerName(issuer string) string {
+	for _, part := range strings.Split(issuer, ",") {
+		if strings.HasPrefix(part, "CN=") {
+			return strings.TrimPrefix(part, "CN=")
+		}
+	}
+	return issuer
+}
+
+func truncate(s string, length int) string {
+	if len(s) <= length {
+		return s
+	}
+	return s[:length-3] + "..."
+}
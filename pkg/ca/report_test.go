@@ -0,0 +1,102 @@
+package ca
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/flanksource/commons/certs"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newCA(g *WithT, name string, parent *certs.Certificate) *certs.Certificate {
+	ca := certs.NewCertificateBuilder(name).CA().Certificate
+	if parent == nil {
+		parent = ca
+	}
+	signed, err := parent.SignCertificate(ca, 1)
+	g.Expect(err).ToNot(HaveOccurred())
+	return signed
+}
+
+func TestReport(t *testing.T) {
+	g := NewWithT(t)
+	root := newCA(g, "root-ca", nil)
+	intermediate := newCA(g, "intermediate-ca", root)
+	other := newCA(g, "other-ca", nil)
+
+	leaf, err := intermediate.SignCertificate(certs.NewCertificateBuilder("web").AltName("web.example.com").Server().Certificate, 1)
+	g.Expect(err).ToNot(HaveOccurred())
+	bundle := append(leaf.EncodedCertificate(), intermediate.EncodedCertificate()...)
+	untrusted, err := other.SignCertificate(certs.NewCertificateBuilder("db").Server().Certificate, 1)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	client := fake.NewSimpleClientset(
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", Annotations: map[string]string{CertificateAnnotation: "web"}},
+			Data:       map[string][]byte{"tls.crt": bundle},
+		},
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db"},
+			Data:       map[string][]byte{"tls.crt": untrusted.EncodedCertificate()},
+		},
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "broken"},
+			Data:       map[string][]byte{"tls.crt": []byte("not a certificate")},
+		},
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "password"},
+			Data:       map[string][]byte{"password": []byte("secret")},
+		},
+	)
+	found, err := FromSecrets(client)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(found).To(HaveLen(3))
+
+	Verify(found, map[string]*x509.Certificate{"ca": root.X509})
+	SortCerts(found)
+	byName := map[string]Cert{}
+	for _, cert := range found {
+		byName[cert.Name] = cert
+	}
+	g.Expect(byName["web"].TrustedBy).To(Equal("ca"))
+	g.Expect(byName["web"].Certificate).To(Equal("web"))
+	g.Expect(byName["web"].SANs).To(ContainElement("web.example.com"))
+	g.Expect(issuerName(byName["web"].Issuer)).To(Equal("intermediate-ca"))
+	g.Expect(byName["web"].DaysLeft).To(BeNumerically(">", 360))
+	g.Expect(byName["db"].TrustedBy).To(BeEmpty())
+	g.Expect(byName["broken"].Error).ToNot(BeEmpty())
+
+	g.Expect(Expiring(found, 30)).To(HaveLen(1))
+	g.Expect(Expiring(found, 400)).To(HaveLen(3))
+}
+
+func TestParseKubeadmCerts(t *testing.T) {
+	g := NewWithT(t)
+	ca := newCA(g, "kubernetes", nil)
+	client, err := ca.SignCertificate(certs.NewCertificateBuilder("kubernetes-admin").Client().Certificate, 1)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	out := ""
+	for _, line := range strings.Split(strings.TrimSpace(string(ca.EncodedCertificate())), "\n") {
+		out += fmt.Sprintf("/etc/kubernetes/pki/ca.crt:%s\n", line)
+	}
+	out += fmt.Sprintf("/etc/kubernetes/admin.conf:    client-certificate-data: %s\n", base64.StdEncoding.EncodeToString(client.EncodedCertificate()))
+	out += "grep: /etc/kubernetes/pki/missing.crt: No such file or directory\n"
+
+	found := parseKubeadmCerts("master-1", out)
+	g.Expect(found).To(HaveLen(2))
+	g.Expect(found[0].ID()).To(Equal("master-1//etc/kubernetes/pki/ca.crt"))
+	g.Expect(found[0].Error).To(BeEmpty())
+	g.Expect(found[1].Name).To(Equal("/etc/kubernetes/admin.conf"))
+	g.Expect(found[1].Subject).To(ContainSubstring("kubernetes-admin"))
+
+	Verify(found, map[string]*x509.Certificate{"ca": ca.X509})
+	g.Expect(found[0].TrustedBy).To(Equal("ca"))
+	g.Expect(found[1].TrustedBy).To(Equal("ca"))
+}
@@ -0,0 +1,117 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/flanksource/commons/certs"
+	"github.com/flanksource/commons/files"
+	"github.com/flanksource/karina/pkg/types"
+	"github.com/pkg/errors"
+)
+
+// Signer is a CertificateAuthority whose private key is only accessible through a crypto.Signer, e.g. a key
+// held in an HSM or Vault, so that the key is never read into memory
+type Signer struct {
+	X509 *x509.Certificate
+	// Chain is the certificates of the CAs that issued X509, when it is an intermediate
+	Chain []*x509.Certificate
+	Key   crypto.Signer
+}
+
+func (s *Signer) SignCertificate(cert *certs.Certificate, expiryYears int) (*certs.Certificate, error) {
+	if cert.X509.PublicKey == nil && cert.PrivateKey != nil {
+		cert.X509.PublicKey = cert.PrivateKey.Public()
+	}
+	signed, err := s.Sign(cert.X509, time.Hour*24*364*time.Duration(expiryYears))
+	if err != nil {
+		return nil, err
+	}
+	return &certs.Certificate{X509: signed, PrivateKey: cert.PrivateKey}, nil
+}
+
+func (s *Signer) Sign(cert *x509.Certificate, expiry time.Duration) (*x509.Certificate, error) {
+	if cert.SerialNumber == nil {
+		serial, err := rand.Int(rand.Reader, new(big.Int).SetInt64(math.MaxInt64))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to generate random integer for signed certificate")
+		}
+		cert.SerialNumber = serial
+	}
+	// Account for clock skew
+	cert.NotBefore = time.Now().Add(15 * time.Minute * -1).UTC()
+	cert.NotAfter = time.Now().Add(expiry).UTC()
+	if cert.KeyUsage == 0 {
+		cert.KeyUsage = x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature
+	}
+	signed, err := x509.CreateCertificate(rand.Reader, cert, s.X509, cert.PublicKey, s.Key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to sign %s", cert.Subject.CommonName)
+	}
+	return x509.ParseCertificate(signed)
+}
+
+// GetPublicChain returns the issuers of the CA followed by the CA itself
+func (s *Signer) GetPublicChain() []*certs.Certificate {
+	var chain []*certs.Certificate
+	for _, cert := range s.Chain {
+		chain = append(chain, &certs.Certificate{X509: cert})
+	}
+	return append(chain, &certs.Certificate{X509: s.X509})
+}
+
+// ReadAuthority opens the CA stored in ca.Cert, signing with its private key in an HSM when ca.PKCS11 is set,
+// in the Vault transit engine when ca.Vault is set, or otherwise in the file ca.PrivateKey using ReadCA
+func ReadAuthority(ca *types.CA) (certs.CertificateAuthority, error) {
+	if ca.PKCS11 == nil && ca.Vault == nil {
+		return ReadCA(ca)
+	}
+	chain, err := readChain(ca)
+	if err != nil {
+		return nil, err
+	}
+	if ca.PKCS11 != nil {
+		if ca.PKCS11.Module == "" {
+			return nil, fmt.Errorf("pkcs11.module is required")
+		}
+		if ca.PKCS11.KeyLabel == "" && ca.PKCS11.KeyID == "" {
+			return nil, fmt.Errorf("one of pkcs11.keyLabel or pkcs11.keyID is required")
+		}
+		return &Signer{X509: chain[0], Chain: chain[1:], Key: &pkcs11Key{config: *ca.PKCS11, public: chain[0].PublicKey}}, nil
+	}
+	return newVaultAuthority(*ca.Vault, chain)
+}
+
+// readChain reads the certificate of a CA and its issuers, ca.Cert is either PEM encoded certificates or a path
+func readChain(ca *types.CA) ([]*x509.Certificate, error) {
+	cert := ca.Cert
+	if !strings.HasPrefix(ca.Cert, "-----BEGIN CERTIFICATE-----") {
+		cert = files.SafeRead(ca.Cert)
+	}
+	if cert == "" {
+		return nil, fmt.Errorf("unable to read certificate %s", ca.Cert)
+	}
+	return ParseCertificates([]byte(cert))
+}
+
+// Certificate returns the certificate of a CA, without its issuers
+func Certificate(ca certs.CertificateAuthority) *x509.Certificate {
+	chain := ca.GetPublicChain()
+	return chain[len(chain)-1].X509
+}
+
+// EncodeChain returns the PEM encoded certificate of a CA followed by its issuers
+func EncodeChain(ca certs.CertificateAuthority) []byte {
+	var bundle []byte
+	chain := ca.GetPublicChain()
+	for i := len(chain) - 1; i >= 0; i-- {
+		bundle = append(bundle, EncodeCert(chain[i].X509)...)
+	}
+	return bundle
+}
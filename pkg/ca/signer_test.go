@@ -0,0 +1,197 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/flanksource/commons/certs"
+	"github.com/flanksource/karina/pkg/types"
+	. "github.com/onsi/gomega"
+)
+
+func TestGenerateIntermediateCA(t *testing.T) {
+	g := NewWithT(t)
+	dir, err := ioutil.TempDir("", "karina-ca")
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	root := &types.CA{Cert: filepath.Join(dir, "root.crt"), PrivateKey: filepath.Join(dir, "root.key"), Password: "root"}
+	g.Expect(GenerateCA("root-ca", root.Cert, root.PrivateKey, root.Password, 10)).To(Succeed())
+	intermediate := &types.CA{Cert: filepath.Join(dir, "cluster.crt"), PrivateKey: filepath.Join(dir, "cluster.key"), Password: "cluster"}
+	g.Expect(GenerateIntermediateCA("cluster-ca", intermediate.Cert, intermediate.PrivateKey, intermediate.Password, 5, root)).To(Succeed())
+
+	rootCert, err := ReadCert(root)
+	g.Expect(err).ToNot(HaveOccurred())
+	ca, err := ReadCA(intermediate)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ca.X509.Subject.CommonName).To(Equal("cluster-ca"))
+	g.Expect(ca.Chain).To(HaveLen(1))
+	g.Expect(ca.Chain[0].X509.Equal(rootCert)).To(BeTrue())
+	g.Expect(Certificate(ca).Equal(ca.X509)).To(BeTrue())
+
+	chain, err := ParseCertificates(EncodeChain(ca))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(chain).To(HaveLen(2))
+	g.Expect(chain[0].Subject.CommonName).To(Equal("cluster-ca"))
+	g.Expect(chain[1].Subject.CommonName).To(Equal("root-ca"))
+
+	leaf, err := ca.Sign(certs.NewCertificateBuilder("admin").Client().Certificate.X509, time.Hour)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ChainsTo(append([]*x509.Certificate{leaf}, chain...), rootCert)).To(BeTrue())
+}
+
+func TestPKCS11Signer(t *testing.T) {
+	g := NewWithT(t)
+	hsm := newCA(g, "hsm-ca", nil)
+
+	var calls, envs [][]string
+	defer func(tool func(env []string, args ...string) ([]byte, error)) { pkcs11Tool = tool }(pkcs11Tool)
+	pkcs11Tool = func(env []string, args ...string) ([]byte, error) {
+		calls = append(calls, args)
+		envs = append(envs, env)
+		flags := map[string]string{}
+		for i := 0; i < len(args)-1; i++ {
+			flags[args[i]] = args[i+1]
+		}
+		input, err := ioutil.ReadFile(flags["--input-file"])
+		if err != nil {
+			return nil, err
+		}
+		// RSA-PKCS pads and signs the DigestInfo it is given without hashing it
+		signature, err := rsa.SignPKCS1v15(rand.Reader, hsm.PrivateKey, crypto.Hash(0), input)
+		if err != nil {
+			return nil, err
+		}
+		return nil, ioutil.WriteFile(flags["--output-file"], signature, 0600)
+	}
+
+	authority, err := ReadAuthority(&types.CA{
+		Cert:   string(hsm.EncodedCertificate()),
+		PKCS11: &types.PKCS11{Module: "/usr/lib/softhsm/libsofthsm2.so", TokenLabel: "karina", KeyLabel: "cluster-ca", Pin: "1234"},
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	leaf, err := authority.SignCertificate(certs.NewCertificateBuilder("admin").Client().Certificate, 1)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(leaf.X509.CheckSignatureFrom(hsm.X509)).To(Succeed())
+	g.Expect(leaf.PrivateKey).ToNot(BeNil())
+
+	g.Expect(calls).To(HaveLen(1))
+	g.Expect(calls[0]).To(ContainElements("--module", "/usr/lib/softhsm/libsofthsm2.so", "--mechanism", "RSA-PKCS", "--token-label", "karina", "--label", "cluster-ca", "--login", "env:"+pinEnv))
+	// the PIN is passed in the environment, as the command line of a process can be read by other users
+	for _, arg := range calls[0] {
+		g.Expect(arg).ToNot(ContainSubstring("1234"))
+	}
+	g.Expect(envs[0]).To(Equal([]string{pinEnv + "=1234"}))
+
+	_, err = ReadAuthority(&types.CA{Cert: string(hsm.EncodedCertificate()), PKCS11: &types.PKCS11{Module: "libsofthsm2.so"}})
+	g.Expect(err).To(MatchError(ContainSubstring("keyLabel or pkcs11.keyID is required")))
+	_, err = ReadCA(&types.CA{Cert: string(hsm.EncodedCertificate()), PKCS11: &types.PKCS11{Module: "libsofthsm2.so"}})
+	g.Expect(err).To(MatchError(ContainSubstring("cannot be read")))
+}
+
+func TestVaultTransitSigner(t *testing.T) {
+	g := NewWithT(t)
+	vault := newCA(g, "vault-ca", nil)
+
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		var request struct {
+			Input              string `json:"input"`
+			Prehashed          bool   `json:"prehashed"`
+			SignatureAlgorithm string `json:"signature_algorithm"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || !request.Prehashed || request.SignatureAlgorithm != "pkcs1v15" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		digest, _ := base64.StdEncoding.DecodeString(request.Input)
+		signature, err := rsa.SignPKCS1v15(rand.Reader, vault.PrivateKey, crypto.SHA256, digest)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]string{"signature": "vault:v1:" + base64.StdEncoding.EncodeToString(signature)},
+		})
+	}))
+	defer server.Close()
+
+	authority, err := ReadAuthority(&types.CA{
+		Cert:  string(vault.EncodedCertificate()),
+		Vault: &types.VaultSigner{Address: server.URL, Token: "token", Key: "cluster-ca"},
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	leaf, err := authority.Sign(certs.NewCertificateBuilder("admin").Client().Certificate.X509, time.Hour)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(leaf.CheckSignatureFrom(vault.X509)).To(Succeed())
+	g.Expect(path).To(Equal("/v1/transit/sign/cluster-ca/sha2-256"))
+}
+
+// TestSoftHSM signs with a key generated in SoftHSM, when softhsm2-util and pkcs11-tool are installed
+func TestSoftHSM(t *testing.T) {
+	module := os.Getenv("SOFTHSM2_MODULE")
+	if module == "" {
+		module = "/usr/lib/softhsm/libsofthsm2.so"
+	}
+	if _, err := exec.LookPath("softhsm2-util"); err != nil {
+		t.Skip("softhsm2-util is not installed")
+	}
+	if _, err := exec.LookPath("pkcs11-tool"); err != nil {
+		t.Skip("pkcs11-tool is not installed")
+	}
+	if _, err := os.Stat(module); err != nil {
+		t.Skipf("SoftHSM module %s not found, set SOFTHSM2_MODULE", module)
+	}
+	g := NewWithT(t)
+	dir, err := ioutil.TempDir("", "karina-softhsm")
+	g.Expect(err).ToNot(HaveOccurred())
+	defer os.RemoveAll(dir) // nolint: errcheck
+	conf := filepath.Join(dir, "softhsm2.conf")
+	g.Expect(ioutil.WriteFile(conf, []byte("directories.tokendir = "+dir+"\n"), 0600)).To(Succeed())
+	os.Setenv("SOFTHSM2_CONF", conf)   // nolint: errcheck
+	defer os.Unsetenv("SOFTHSM2_CONF") // nolint: errcheck
+
+	run := func(name string, args ...string) []byte {
+		out, err := exec.Command(name, args...).CombinedOutput()
+		g.Expect(err).ToNot(HaveOccurred(), string(out))
+		return out
+	}
+	run("softhsm2-util", "--init-token", "--free", "--label", "karina", "--so-pin", "5678", "--pin", "1234")
+	run("pkcs11-tool", "--module", module, "--token-label", "karina", "--login", "--pin", "1234", "--keypairgen", "--key-type", "rsa:2048", "--label", "cluster-ca")
+	run("pkcs11-tool", "--module", module, "--token-label", "karina", "--read-object", "--type", "pubkey", "--label", "cluster-ca", "--output-file", filepath.Join(dir, "pub.der"))
+	der, err := ioutil.ReadFile(filepath.Join(dir, "pub.der"))
+	g.Expect(err).ToNot(HaveOccurred())
+	public, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		public, err = x509.ParsePKCS1PublicKey(der)
+	}
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// the HSM key self signs the CA certificate
+	config := types.PKCS11{Module: module, TokenLabel: "karina", KeyLabel: "cluster-ca", Pin: "1234"}
+	template := certs.NewCertificateBuilder("hsm-ca").CA().Certificate.X509
+	template.PublicKey = public
+	signer := &Signer{X509: template, Key: &pkcs11Key{config: config, public: public}}
+	caCert, err := signer.Sign(template, time.Hour)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(caCert.CheckSignatureFrom(caCert)).To(Succeed())
+
+	authority, err := ReadAuthority(&types.CA{Cert: string(EncodeCert(caCert)), PKCS11: &config})
+	g.Expect(err).ToNot(HaveOccurred())
+	leaf, err := authority.Sign(certs.NewCertificateBuilder("admin").Client().Certificate.X509, time.Hour)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(leaf.CheckSignatureFrom(caCert)).To(Succeed())
+}
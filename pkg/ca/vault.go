@@ -0,0 +1,92 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/flanksource/commons/certs"
+	"github.com/flanksource/karina/pkg/types"
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+var transitHashes = map[crypto.Hash]string{
+	crypto.SHA1:   "sha1",
+	crypto.SHA256: "sha2-256",
+	crypto.SHA384: "sha2-384",
+	crypto.SHA512: "sha2-512",
+}
+
+// newVaultAuthority returns a CA that signs with a key held by the Vault transit secrets engine
+func newVaultAuthority(config types.VaultSigner, chain []*x509.Certificate) (certs.CertificateAuthority, error) {
+	vaultConfig := api.DefaultConfig()
+	if config.Address != "" {
+		vaultConfig.Address = config.Address
+	}
+	client, err := api.NewClient(vaultConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create vault client")
+	}
+	if config.Token != "" {
+		client.SetToken(config.Token)
+	}
+	mount := config.Mount
+	if mount == "" {
+		mount = "transit"
+	}
+	if config.Key == "" {
+		return nil, fmt.Errorf("vault.key is required")
+	}
+	key := &transitKey{client: client, path: mount + "/sign/" + config.Key, public: chain[0].PublicKey}
+	return &Signer{X509: chain[0], Chain: chain[1:], Key: key}, nil
+}
+
+// transitKey is a crypto.Signer for a key held by the Vault transit secrets engine
+type transitKey struct {
+	client *api.Client
+	path   string
+	public crypto.PublicKey
+}
+
+func (k *transitKey) Public() crypto.PublicKey {
+	return k.public
+}
+
+func (k *transitKey) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	hash, ok := transitHashes[opts.HashFunc()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash %v", opts.HashFunc())
+	}
+	data := map[string]interface{}{
+		"input":                base64.StdEncoding.EncodeToString(digest),
+		"prehashed":            true,
+		"marshaling_algorithm": "asn1",
+	}
+	switch k.public.(type) {
+	case *rsa.PublicKey:
+		data["signature_algorithm"] = "pkcs1v15"
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			data["signature_algorithm"] = "pss"
+		}
+	case *ecdsa.PublicKey:
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", k.public)
+	}
+	secret, err := k.client.Logical().Write(k.path+"/"+hash, data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to sign with %s", k.path)
+	}
+	if secret == nil {
+		return nil, fmt.Errorf("no signature returned by %s", k.path)
+	}
+	signature, _ := secret.Data["signature"].(string)
+	// signatures are prefixed with the key version, e.g. vault:v1:<base64>
+	parts := strings.Split(signature, ":")
+	return base64.StdEncoding.DecodeString(parts[len(parts)-1])
+}
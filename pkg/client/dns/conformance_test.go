@@ -0,0 +1,159 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/flanksource/commons/logger"
+	"github.com/flanksource/karina/pkg/types"
+	. "github.com/onsi/gomega"
+)
+
+// standIn starts a local stand-in for a provider, returning a client for zone and a func to stop the stand-in
+type standIn func(t *testing.T, zone string) (Client, func())
+
+var standIns = map[string]standIn{
+	RFC2136Provider:     newRFC2136StandIn,
+	Route53Provider:     newRoute53StandIn,
+	PowerDNSProvider:    newPowerDNSStandIn,
+	CoreDNSEtcdProvider: newEtcdStandIn,
+}
+
+// TestConformance runs the same tests against every registered provider
+func TestConformance(t *testing.T) {
+	for _, name := range Providers() {
+		standIn, ok := standIns[name]
+		if !ok {
+			t.Errorf("no stand-in for the %s provider", name)
+			continue
+		}
+		t.Run(name, func(t *testing.T) {
+			client, stop := standIn(t, "example.com")
+			defer stop()
+			testConformance(t, client, "example.com")
+		})
+	}
+}
+
+func testConformance(t *testing.T, client Client, zone string) {
+	g := NewWithT(t)
+	www := "www." + zone
+
+	records, err := client.Get(www, A)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(records).To(BeEmpty())
+
+	// append adds to the existing records, without duplicating them
+	g.Expect(client.Append(www, A, "10.0.0.1", "10.0.0.2")).To(Succeed())
+	g.Expect(client.Append(www, A, "10.0.0.2", "10.0.0.3")).To(Succeed())
+	g.Expect(client.Get(www, A)).To(ConsistOf("10.0.0.1", "10.0.0.2", "10.0.0.3"))
+
+	g.Expect(client.Delete(www, A, "10.0.0.2")).To(Succeed())
+	g.Expect(client.Get(www, A)).To(ConsistOf("10.0.0.1", "10.0.0.3"))
+
+	g.Expect(client.Update(www, A, "10.0.0.4")).To(Succeed())
+	g.Expect(client.Get(www, A)).To(ConsistOf("10.0.0.4"))
+
+	// each record type is managed independently
+	txt := `owner="karina" cluster=test`
+	g.Expect(client.Append(www, TXT, txt)).To(Succeed())
+	g.Expect(client.Append(www, AAAA, "fd00::1")).To(Succeed())
+	g.Expect(client.Get(www, TXT)).To(ConsistOf(txt))
+	g.Expect(client.Get(www, AAAA)).To(ConsistOf("fd00::1"))
+	g.Expect(client.Get(www, A)).To(ConsistOf("10.0.0.4"))
+
+	g.Expect(client.Update("alias."+zone, CNAME, www)).To(Succeed())
+	g.Expect(client.Get("alias."+zone, CNAME)).To(ConsistOf(www))
+	g.Expect(client.Get("alias."+zone, A)).To(BeEmpty())
+
+	// records of a subdomain are not records of its parent
+	g.Expect(client.Append("api."+www, A, "10.0.1.1")).To(Succeed())
+	g.Expect(client.Get(www, A)).To(ConsistOf("10.0.0.4"))
+	g.Expect(client.Get("api."+www, A)).To(ConsistOf("10.0.1.1"))
+
+	g.Expect(client.Append("*.apps."+zone, A, "10.0.2.1", "10.0.2.2")).To(Succeed())
+	g.Expect(client.Get("*.apps."+zone, A)).To(ConsistOf("10.0.2.1", "10.0.2.2"))
+
+	// "*" deletes every record of the type
+	g.Expect(client.Delete(www, A, "*")).To(Succeed())
+	g.Expect(client.Get(www, A)).To(BeEmpty())
+	g.Expect(client.Get(www, TXT)).To(ConsistOf(txt))
+	g.Expect(client.Update(www, TXT)).To(Succeed())
+	g.Expect(client.Get(www, TXT)).To(BeEmpty())
+
+	g.Expect(client.Append(www, A, "not-an-ip")).To(MatchError(ContainSubstring("invalid A record")))
+	g.Expect(client.Append(www, AAAA, "10.0.0.1")).To(MatchError(ContainSubstring("invalid AAAA record")))
+	_, err = client.Get(www, "MX")
+	g.Expect(err).To(MatchError(ContainSubstring("unsupported record type MX")))
+}
+
+func TestNewClient(t *testing.T) {
+	g := NewWithT(t)
+	log := logger.StandardLogger()
+
+	client, err := NewClient(types.DynamicDNS{Zone: "example.com", Nameserver: "127.0.0.1:53"}, log)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(client).To(BeAssignableToTypeOf(&DynamicDNSClient{}))
+
+	_, err = NewClient(types.DynamicDNS{Type: "infoblox"}, log)
+	g.Expect(err).To(MatchError("unknown dns provider infoblox, must be one of coredns-etcd, powerdns, rfc2136, route53"))
+	_, err = NewClient(types.DynamicDNS{Type: PowerDNSProvider}, log)
+	g.Expect(err).To(MatchError("dns.powerdns.url is required"))
+
+	RegisterProvider("stub", func(config types.DynamicDNS, log logger.Logger) (Client, error) {
+		return &DummyDNSClient{Logger: log, Zone: config.Zone}, nil
+	})
+	defer delete(providers, "stub")
+	client, err = NewClient(types.DynamicDNS{Type: "stub"}, log)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(client).To(BeAssignableToTypeOf(&DummyDNSClient{}))
+}
+
+func TestTXTQuoting(t *testing.T) {
+	g := NewWithT(t)
+	for _, text := range []string{"plain", `owner="karina"`, `back\slash`, "with spaces"} {
+		g.Expect(unquoteTXT(quoteTXT(text))).To(Equal(text))
+	}
+	g.Expect(unquoteTXT(`"v=spf1 " "-all"`)).To(Equal("v=spf1 -all"))
+}
+
+// TestEtcdLayout checks that records are stored where and how the CoreDNS etcd plugin reads them
+func TestEtcdLayout(t *testing.T) {
+	g := NewWithT(t)
+	kv := &memoryKV{data: map[string]string{}}
+	client := &EtcdClient{Logger: logger.StandardLogger(), KV: kv, Prefix: "/dns/", Zone: "example.com"}
+
+	g.Expect(client.Append("www", A, "10.0.0.1")).To(Succeed())
+	g.Expect(client.Append("www.example.com", TXT, "owner=karina")).To(Succeed())
+	var values []string
+	for key, value := range kv.data {
+		g.Expect(key).To(HavePrefix("/dns/com/example/www/karina-"))
+		values = append(values, value)
+	}
+	g.Expect(values).To(ConsistOf(`{"host":"10.0.0.1","ttl":60}`, `{"text":"owner=karina","ttl":60}`))
+}
+
+func TestRegistry(t *testing.T) {
+	g := NewWithT(t)
+	client, stop := newEtcdStandIn(t, "example.com")
+	defer stop()
+	registry := Registry{Client: client, Owner: "test"}
+
+	g.Expect(registry.GetOwner("www")).To(BeEmpty())
+	g.Expect(registry.Append("www", TXT, "v=spf1 -all")).To(Succeed())
+	g.Expect(registry.Claim("www")).To(Succeed())
+	g.Expect(registry.Claim("www")).To(Succeed())
+	g.Expect(registry.GetOwner("www")).To(Equal("test"))
+	g.Expect(registry.Get("www", TXT)).To(ConsistOf("v=spf1 -all"))
+	g.Expect(registry.Get("_karina-owner.www", TXT)).To(ConsistOf("heritage=karina,karina/owner=test"))
+
+	// wildcards are owned by a record at a name that the wildcard does not cover
+	g.Expect(registry.Claim("*.apps")).To(Succeed())
+	g.Expect(registry.Get("*.apps", TXT)).To(BeEmpty())
+	g.Expect(registry.Get("_karina-owner-wildcard.apps", TXT)).To(ConsistOf("heritage=karina,karina/owner=test"))
+	g.Expect(registry.GetOwner("*.apps")).To(Equal("test"))
+
+	other := Registry{Client: client, Owner: "other"}
+	g.Expect(other.Claim("www")).To(MatchError("www is owned by test"))
+	g.Expect(Registry{Client: client}.Claim("www")).To(MatchError(ContainSubstring("owner is required")))
+	g.Expect(parseOwner("heritage=external-dns,external-dns/owner=test")).To(BeEmpty())
+}
@@ -2,11 +2,13 @@ package dns
 
 import (
 	"fmt"
+	"net"
 	"strings"
 	"time"
 
 	"github.com/flanksource/commons/logger"
 	"github.com/miekg/dns"
+	"github.com/thoas/go-funk"
 )
 
 var tsigAlgs = map[string]string{
@@ -16,11 +18,58 @@ var tsigAlgs = map[string]string{
 	"hmac-sha512": dns.HmacSHA512,
 }
 
+// Record types supported by every provider
+const (
+	A     = "A"
+	AAAA  = "AAAA"
+	CNAME = "CNAME"
+	TXT   = "TXT"
+)
+
+// RecordTypes returns the record types supported by every provider
+func RecordTypes() []string {
+	return []string{A, AAAA, CNAME, TXT}
+}
+
+// Client manages the records of a single zone, records are IP addresses for A and AAAA, a domain name
+// without the trailing dot for CNAME, and unquoted text for TXT
 type Client interface {
-	Append(domain string, records ...string) error
-	Get(domain string) ([]string, error)
-	Update(domain string, records ...string) error
-	Delete(domain string, records ...string) error
+	// Append adds records to the existing records of domain
+	Append(domain, recordType string, records ...string) error
+	// Get returns the records of domain
+	Get(domain, recordType string) ([]string, error)
+	// Update replaces the records of domain
+	Update(domain, recordType string, records ...string) error
+	// Delete removes records from domain, "*" removes all records of recordType
+	Delete(domain, recordType string, records ...string) error
+}
+
+// Validate returns an error if recordType is not supported or a record is not valid for it
+func Validate(recordType string, records ...string) error {
+	if !funk.ContainsString(RecordTypes(), recordType) {
+		return fmt.Errorf("unsupported record type %s, must be one of %s", recordType, strings.Join(RecordTypes(), ", "))
+	}
+	for _, record := range records {
+		if record == "*" {
+			continue
+		}
+		ip := net.ParseIP(record)
+		switch recordType {
+		case A:
+			if ip == nil || ip.To4() == nil {
+				return fmt.Errorf("invalid A record %s, must be an IPv4 address", record)
+			}
+		case AAAA:
+			if ip == nil || ip.To4() != nil {
+				return fmt.Errorf("invalid AAAA record %s, must be an IPv6 address", record)
+			}
+		case CNAME:
+			if _, ok := dns.IsDomainName(record); !ok {
+				return fmt.Errorf("invalid CNAME record %s, must be a domain name", record)
+			}
+		}
+	}
+	return nil
 }
 
 type DynamicDNSClient struct {
@@ -37,14 +86,17 @@ func (client DynamicDNSClient) String() string {
 	return fmt.Sprintf("DNS(%s@%s)", client.Zone, client.Nameserver)
 }
 
-func (client DynamicDNSClient) Append(domain string, records ...string) error {
+func (client DynamicDNSClient) Append(domain, recordType string, records ...string) error {
+	if err := Validate(recordType, records...); err != nil {
+		return err
+	}
 	domain = subdomain(domain, client.Zone)
-	client.Debugf("Appending %s.%s %s", domain, client.Zone, records)
+	client.Debugf("Appending %s.%s %s %s", domain, client.Zone, recordType, records)
 	m := new(dns.Msg)
 	m.SetUpdate(client.Zone + ".")
 
 	for _, record := range records {
-		rr, err := newRR(domain, client.Zone, 60, "A", record)
+		rr, err := newRR(domain, client.Zone, 60, recordType, record)
 		if err != nil {
 			return fmt.Errorf("append: failed to get new RR: %v", err)
 		}
@@ -53,9 +105,12 @@ func (client DynamicDNSClient) Append(domain string, records ...string) error {
 	return client.sendMessage(client.Zone, m)
 }
 
-func (client DynamicDNSClient) Get(domain string) ([]string, error) {
+func (client DynamicDNSClient) Get(domain, recordType string) ([]string, error) {
+	if err := Validate(recordType); err != nil {
+		return nil, err
+	}
 	m := new(dns.Msg)
-	m.SetAxfr(domain)
+	m.SetAxfr(client.Zone + ".")
 	if !client.Insecure {
 		m.SetTsig(client.KeyName, tsigAlgs[client.Algorithm], 300, time.Now().Unix())
 	}
@@ -71,6 +126,7 @@ func (client DynamicDNSClient) Get(domain string) ([]string, error) {
 		return nil, fmt.Errorf("failed to fetch records via AXFR: %v", err)
 	}
 
+	name := fqdn(domain, client.Zone)
 	var records []string
 	for e := range env {
 		if e.Error != nil {
@@ -80,20 +136,19 @@ func (client DynamicDNSClient) Get(domain string) ([]string, error) {
 			return nil, fmt.Errorf("AXFR error: %v", e.Error)
 		}
 		for _, rr := range e.RR {
-			switch rr.Header().Rrtype {
-			case dns.TypeCNAME:
-				records = append(records, rr.(*dns.CNAME).Target)
-
-			case dns.TypeA:
-				records = append(records, rr.(*dns.A).A.String())
-
-			case dns.TypeAAAA:
-				records = append(records, rr.(*dns.AAAA).AAAA.String())
-
-			case dns.TypeTXT:
-				records = append(records, rr.(*dns.TXT).String())
-			default:
-				continue // Unhandled record type
+			if !strings.EqualFold(rr.Header().Name, name) || dns.TypeToString[rr.Header().Rrtype] != recordType {
+				continue
+			}
+			switch rr := rr.(type) {
+			case *dns.CNAME:
+				records = append(records, strings.TrimSuffix(rr.Target, "."))
+			case *dns.A:
+				records = append(records, rr.A.String())
+			case *dns.AAAA:
+				records = append(records, rr.AAAA.String())
+			case *dns.TXT:
+				// the character strings are kept escaped, e.g. \"
+				records = append(records, unquoteTXT(`"`+strings.Join(rr.Txt, `" "`)+`"`))
 			}
 		}
 	}
@@ -101,20 +156,23 @@ func (client DynamicDNSClient) Get(domain string) ([]string, error) {
 	return records, nil
 }
 
-func (client DynamicDNSClient) Update(domain string, records ...string) error {
+func (client DynamicDNSClient) Update(domain, recordType string, records ...string) error {
+	if err := Validate(recordType, records...); err != nil {
+		return err
+	}
 	domain = subdomain(domain, client.Zone)
-	client.Debugf("Updating %s.%s %s", domain, client.Zone, records)
+	client.Debugf("Updating %s.%s %s %s", domain, client.Zone, recordType, records)
 	m := new(dns.Msg)
 	m.SetUpdate(client.Zone + ".")
 
-	rr, err := newRR(domain, client.Zone, 0, "ANY", "")
+	rr, err := newRR(domain, client.Zone, 0, recordType, "")
 	if err != nil {
 		return fmt.Errorf("update: failed to get new RR: %v", err)
 	}
 	m.RemoveRRset([]dns.RR{*rr})
 
 	for _, record := range records {
-		rr, err := newRR(domain, client.Zone, 60, "A", record)
+		rr, err := newRR(domain, client.Zone, 60, recordType, record)
 		if err != nil {
 			return fmt.Errorf("update: failed to get new RR: %v", err)
 		}
@@ -123,22 +181,25 @@ func (client DynamicDNSClient) Update(domain string, records ...string) error {
 	return client.sendMessage(client.Zone, m)
 }
 
-func (client DynamicDNSClient) Delete(domain string, records ...string) error {
+func (client DynamicDNSClient) Delete(domain, recordType string, records ...string) error {
+	if err := Validate(recordType, records...); err != nil {
+		return err
+	}
 	domain = subdomain(domain, client.Zone)
-	client.Debugf("Removing %s.%s %s", domain, client.Zone, records)
+	client.Debugf("Removing %s.%s %s %s", domain, client.Zone, recordType, records)
 
 	m := new(dns.Msg)
 	m.SetUpdate(client.Zone + ".")
 
 	for _, record := range records {
 		if record == "*" {
-			rr, err := newRR(domain, client.Zone, 0, "ANY", "")
+			rr, err := newRR(domain, client.Zone, 0, recordType, "")
 			if err != nil {
 				return fmt.Errorf("delete: failed to get new RR: %v", err)
 			}
 			m.RemoveRRset([]dns.RR{*rr})
 		} else {
-			rr, err := newRR(domain, client.Zone, 0, "A", record)
+			rr, err := newRR(domain, client.Zone, 0, recordType, record)
 			if err != nil {
 				return fmt.Errorf("delete: failed to get new RR: %v", err)
 			}
@@ -169,6 +230,12 @@ func (client DynamicDNSClient) sendMessage(zone string, msg *dns.Msg) error {
 }
 
 func newRR(domain string, zone string, ttl int, resourceType string, record string) (*dns.RR, error) {
+	switch {
+	case resourceType == TXT && record != "":
+		record = quoteTXT(record)
+	case resourceType == CNAME && record != "":
+		record = dns.Fqdn(record)
+	}
 	RR := strings.Trim(fmt.Sprintf("%s.%s %d %s %s", domain, zone, ttl, resourceType, record), " ")
 	rr, err := dns.NewRR(RR)
 	if err != nil {
@@ -180,3 +247,41 @@ func newRR(domain string, zone string, ttl int, resourceType string, record stri
 func subdomain(domain, zone string) string {
 	return strings.ReplaceAll(domain, "."+zone, "")
 }
+
+// fqdn returns domain as a fully qualified name in zone, with the trailing dot
+func fqdn(domain, zone string) string {
+	domain = strings.TrimSuffix(domain, ".")
+	zone = strings.TrimSuffix(zone, ".")
+	if zone != "" && domain != zone && !strings.HasSuffix(domain, "."+zone) {
+		domain += "." + zone
+	}
+	return dns.Fqdn(domain)
+}
+
+// quoteTXT returns text as a quoted character string
+func quoteTXT(text string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(text) + `"`
+}
+
+// unquoteTXT returns the text of one or more quoted character strings, e.g. "v=spf1" "-all"
+func unquoteTXT(text string) string {
+	if !strings.HasPrefix(text, `"`) {
+		return text
+	}
+	var out strings.Builder
+	quoted, escaped := false, false
+	for _, c := range text {
+		switch {
+		case escaped:
+			out.WriteRune(c)
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+			out.WriteRune(c)
+		}
+	}
+	return out.String()
+}
@@ -9,19 +9,19 @@ type DummyDNSClient struct {
 	Zone string
 }
 
-func (d DummyDNSClient) Append(domain string, records ...string) error {
+func (d DummyDNSClient) Append(domain, recordType string, records ...string) error {
 	domain = subdomain(domain, d.Zone)
-	d.Debugf("[DNS Stub] Append %s.%s %v", domain, d.Zone, records)
+	d.Debugf("[DNS Stub] Append %s.%s %s %v", domain, d.Zone, recordType, records)
 	return nil
 }
-func (d DummyDNSClient) Get(domain string) ([]string, error) { return nil, nil }
-func (d DummyDNSClient) Update(domain string, records ...string) error {
+func (d DummyDNSClient) Get(domain, recordType string) ([]string, error) { return nil, nil }
+func (d DummyDNSClient) Update(domain, recordType string, records ...string) error {
 	domain = subdomain(domain, d.Zone)
-	d.Debugf("[DNS Stub] Update %s.%s %v", domain, d.Zone, records)
+	d.Debugf("[DNS Stub] Update %s.%s %s %v", domain, d.Zone, recordType, records)
 	return nil
 }
-func (d DummyDNSClient) Delete(domain string, records ...string) error {
+func (d DummyDNSClient) Delete(domain, recordType string, records ...string) error {
 	domain = subdomain(domain, d.Zone)
-	d.Debugf("[DNS Stub] Delete %s.%s %v", domain, d.Zone, records)
+	d.Debugf("[DNS Stub] Delete %s.%s %s %v", domain, d.Zone, recordType, records)
 	return nil
 }
@@ -0,0 +1,202 @@
+package dns
+
+import (
+	"context"
+	"crypto/sha1" // nolint: gosec
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/flanksource/commons/logger"
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+	"github.com/thoas/go-funk"
+	"go.etcd.io/etcd/clientv3"
+)
+
+const etcdTimeout = 10 * time.Second
+
+// EtcdClient manages records in the etcd backend of the CoreDNS etcd plugin, where the records of
+// www.example.com are stored as JSON under /skydns/com/example/www/<id>
+type EtcdClient struct {
+	logger.Logger
+	KV clientv3.KV
+	// Prefix is the path CoreDNS reads records from, defaults to /skydns
+	Prefix string
+	Zone   string
+}
+
+// skydnsRecord is the JSON format read by the CoreDNS etcd plugin
+type skydnsRecord struct {
+	Host string `json:"host,omitempty"`
+	Text string `json:"text,omitempty"`
+	TTL  uint32 `json:"ttl,omitempty"`
+}
+
+// recordType returns A or AAAA if the host is an IP, CNAME if it is a domain name, and TXT for text
+func (r skydnsRecord) recordType() string {
+	if r.Text != "" {
+		return TXT
+	}
+	ip := net.ParseIP(r.Host)
+	switch {
+	case ip == nil:
+		return CNAME
+	case ip.To4() != nil:
+		return A
+	default:
+		return AAAA
+	}
+}
+
+func (r skydnsRecord) value() string {
+	if r.Text != "" {
+		return r.Text
+	}
+	return strings.TrimSuffix(r.Host, ".")
+}
+
+func (client *EtcdClient) String() string {
+	return fmt.Sprintf("CoreDNS(%s@etcd%s)", client.Zone, client.prefix())
+}
+
+func (client *EtcdClient) Append(domain, recordType string, records ...string) error {
+	if err := Validate(recordType, records...); err != nil {
+		return err
+	}
+	path := client.path(domain)
+	client.Debugf("Appending %s %s %v", path, recordType, records)
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	for _, record := range records {
+		value := skydnsRecord{Host: record, TTL: 60}
+		if recordType == TXT {
+			value = skydnsRecord{Text: record, TTL: 60}
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		// the key is derived from the record so that appending it twice does not duplicate it
+		hash := sha1.Sum([]byte(recordType + " " + record)) // nolint: gosec
+		key := path + "/karina-" + hex.EncodeToString(hash[:4])
+		if _, err := client.KV.Put(ctx, key, string(data)); err != nil {
+			return errors.Wrapf(err, "failed to put %s", key)
+		}
+	}
+	return nil
+}
+
+func (client *EtcdClient) Get(domain, recordType string) ([]string, error) {
+	if err := Validate(recordType); err != nil {
+		return nil, err
+	}
+	existing, err := client.list(domain, recordType)
+	if err != nil {
+		return nil, err
+	}
+	var records []string
+	for _, value := range existing {
+		records = append(records, value)
+	}
+	records = funk.UniqString(records)
+	sort.Strings(records)
+	return records, nil
+}
+
+func (client *EtcdClient) Update(domain, recordType string, records ...string) error {
+	if err := Validate(recordType, records...); err != nil {
+		return err
+	}
+	existing, err := client.list(domain, recordType)
+	if err != nil {
+		return err
+	}
+	var stale []string
+	for key, value := range existing {
+		if !funk.ContainsString(records, value) {
+			stale = append(stale, key)
+		}
+	}
+	if err := client.delete(stale...); err != nil {
+		return err
+	}
+	return client.Append(domain, recordType, records...)
+}
+
+func (client *EtcdClient) Delete(domain, recordType string, records ...string) error {
+	if err := Validate(recordType, records...); err != nil {
+		return err
+	}
+	existing, err := client.list(domain, recordType)
+	if err != nil {
+		return err
+	}
+	var keys []string
+	for key, value := range existing {
+		if funk.ContainsString(records, "*") || funk.ContainsString(records, value) {
+			keys = append(keys, key)
+		}
+	}
+	client.Debugf("Removing %s %s %v", client.path(domain), recordType, records)
+	return client.delete(keys...)
+}
+
+// list returns the values of the records of domain by key, records of subdomains are stored under the same
+// prefix and are skipped
+func (client *EtcdClient) list(domain, recordType string) (map[string]string, error) {
+	path := client.path(domain)
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	resp, err := client.KV.Get(ctx, path, clientv3.WithPrefix())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list %s", path)
+	}
+	records := map[string]string{}
+	for _, kv := range resp.Kvs {
+		key := string(kv.Key)
+		if key != path && (!strings.HasPrefix(key, path+"/") || strings.Contains(key[len(path)+1:], "/")) {
+			continue
+		}
+		var record skydnsRecord
+		if err := json.Unmarshal(kv.Value, &record); err != nil {
+			client.Warnf("Ignoring invalid record %s: %v", key, err)
+			continue
+		}
+		if record.recordType() == recordType {
+			records[key] = record.value()
+		}
+	}
+	return records, nil
+}
+
+func (client *EtcdClient) delete(keys ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	for _, key := range keys {
+		if _, err := client.KV.Delete(ctx, key); err != nil {
+			return errors.Wrapf(err, "failed to delete %s", key)
+		}
+	}
+	return nil
+}
+
+func (client *EtcdClient) prefix() string {
+	if client.Prefix == "" {
+		return "/skydns"
+	}
+	return "/" + strings.Trim(client.Prefix, "/")
+}
+
+// path returns the key of domain, with its labels reversed
+func (client *EtcdClient) path(domain string) string {
+	labels := dns.SplitDomainName(strings.ToLower(fqdn(domain, client.Zone)))
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return client.prefix() + "/" + strings.Join(labels, "/")
+}
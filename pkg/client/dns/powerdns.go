@@ -0,0 +1,176 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/flanksource/commons/logger"
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+	"github.com/thoas/go-funk"
+)
+
+// PowerDNSClient manages records through the PowerDNS authoritative server HTTP API
+type PowerDNSClient struct {
+	logger.Logger
+	// URL of the API, e.g. http://powerdns:8081
+	URL    string
+	APIKey string
+	// Server is the server ID, defaults to localhost
+	Server string
+	Zone   string
+	HTTP   *http.Client
+}
+
+type pdnsRecord struct {
+	Content  string `json:"content"`
+	Disabled bool   `json:"disabled"`
+}
+
+type pdnsRRSet struct {
+	Name       string       `json:"name"`
+	Type       string       `json:"type"`
+	TTL        int          `json:"ttl,omitempty"`
+	ChangeType string       `json:"changetype,omitempty"`
+	Records    []pdnsRecord `json:"records"`
+}
+
+type pdnsZone struct {
+	RRSets []pdnsRRSet `json:"rrsets"`
+}
+
+func (client *PowerDNSClient) String() string {
+	return fmt.Sprintf("PowerDNS(%s@%s)", client.Zone, client.URL)
+}
+
+func (client *PowerDNSClient) Append(domain, recordType string, records ...string) error {
+	existing, err := client.Get(domain, recordType)
+	if err != nil {
+		return err
+	}
+	return client.Update(domain, recordType, funk.UniqString(append(existing, records...))...)
+}
+
+func (client *PowerDNSClient) Get(domain, recordType string) ([]string, error) {
+	if err := Validate(recordType); err != nil {
+		return nil, err
+	}
+	var zone pdnsZone
+	if err := client.do(http.MethodGet, nil, &zone); err != nil {
+		return nil, err
+	}
+	name := fqdn(domain, client.Zone)
+	var records []string
+	for _, set := range zone.RRSets {
+		if !strings.EqualFold(set.Name, name) || set.Type != recordType {
+			continue
+		}
+		for _, record := range set.Records {
+			if record.Disabled {
+				continue
+			}
+			switch recordType {
+			case TXT:
+				records = append(records, unquoteTXT(record.Content))
+			case CNAME:
+				records = append(records, strings.TrimSuffix(record.Content, "."))
+			default:
+				records = append(records, record.Content)
+			}
+		}
+	}
+	client.Tracef("lookup %s %s => %v", name, recordType, records)
+	return records, nil
+}
+
+func (client *PowerDNSClient) Update(domain, recordType string, records ...string) error {
+	if err := Validate(recordType, records...); err != nil {
+		return err
+	}
+	set := pdnsRRSet{
+		Name:       fqdn(domain, client.Zone),
+		Type:       recordType,
+		TTL:        60,
+		ChangeType: "REPLACE",
+		Records:    []pdnsRecord{},
+	}
+	if len(records) == 0 {
+		set.ChangeType = "DELETE"
+	}
+	for _, record := range records {
+		switch recordType {
+		case TXT:
+			record = quoteTXT(record)
+		case CNAME:
+			record = dns.Fqdn(record)
+		}
+		set.Records = append(set.Records, pdnsRecord{Content: record})
+	}
+	client.Debugf("Updating %s %s to %v", set.Name, recordType, records)
+	return client.do(http.MethodPatch, pdnsZone{RRSets: []pdnsRRSet{set}}, nil)
+}
+
+func (client *PowerDNSClient) Delete(domain, recordType string, records ...string) error {
+	if funk.ContainsString(records, "*") {
+		return client.Update(domain, recordType)
+	}
+	existing, err := client.Get(domain, recordType)
+	if err != nil {
+		return err
+	}
+	return client.Update(domain, recordType, funk.SubtractString(existing, records)...)
+}
+
+// do sends a request for the zone, decoding the response into out if it is not nil
+func (client *PowerDNSClient) do(method string, in, out interface{}) error {
+	server := client.Server
+	if server == "" {
+		server = "localhost"
+	}
+	endpoint := fmt.Sprintf("%s/api/v1/servers/%s/zones/%s", strings.TrimSuffix(client.URL, "/"), url.PathEscape(server), url.PathEscape(dns.Fqdn(client.Zone)))
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-API-Key", client.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+	httpClient := client.HTTP
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "failed to %s %s", method, endpoint)
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		var apiError struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &apiError) == nil && apiError.Error != "" {
+			return fmt.Errorf("%s %s: %s", method, endpoint, apiError.Error)
+		}
+		return fmt.Errorf("%s %s: %s", method, endpoint, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
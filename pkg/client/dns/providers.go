@@ -0,0 +1,109 @@
+package dns
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/flanksource/commons/logger"
+	"github.com/flanksource/karina/pkg/types"
+	"go.etcd.io/etcd/clientv3"
+)
+
+const (
+	RFC2136Provider     = "rfc2136"
+	Route53Provider     = "route53"
+	PowerDNSProvider    = "powerdns"
+	CoreDNSEtcdProvider = "coredns-etcd"
+)
+
+// Provider creates the Client for the zone configured in dns
+type Provider func(config types.DynamicDNS, log logger.Logger) (Client, error)
+
+var providers = map[string]Provider{
+	RFC2136Provider: func(config types.DynamicDNS, log logger.Logger) (Client, error) {
+		return &DynamicDNSClient{
+			Logger:     log,
+			Zone:       config.Zone,
+			KeyName:    config.KeyName,
+			Nameserver: config.Nameserver,
+			Key:        config.Key,
+			Algorithm:  config.Algorithm,
+		}, nil
+	},
+	Route53Provider: func(config types.DynamicDNS, log logger.Logger) (Client, error) {
+		client := &Route53Client{
+			Logger:       log,
+			HostedZoneID: config.Zone,
+			AccessKey:    config.AccessKey,
+			SecretKey:    config.SecretKey,
+		}
+		client.Init()
+		return client, nil
+	},
+	PowerDNSProvider: func(config types.DynamicDNS, log logger.Logger) (Client, error) {
+		if config.PowerDNS == nil || config.PowerDNS.URL == "" {
+			return nil, fmt.Errorf("dns.powerdns.url is required")
+		}
+		return &PowerDNSClient{
+			Logger: log,
+			URL:    config.PowerDNS.URL,
+			APIKey: config.PowerDNS.APIKey,
+			Server: config.PowerDNS.Server,
+			Zone:   config.Zone,
+		}, nil
+	},
+	CoreDNSEtcdProvider: func(config types.DynamicDNS, log logger.Logger) (Client, error) {
+		if config.Etcd == nil || len(config.Etcd.Endpoints) == 0 {
+			return nil, fmt.Errorf("dns.etcd.endpoints is required")
+		}
+		client, err := clientv3.New(clientv3.Config{
+			Endpoints:   config.Etcd.Endpoints,
+			DialTimeout: etcdTimeout,
+			Username:    config.Etcd.Username,
+			Password:    config.Etcd.Password,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &EtcdClient{
+			Logger: log,
+			KV:     client,
+			Prefix: config.Etcd.Prefix,
+			Zone:   config.Zone,
+		}, nil
+	},
+}
+
+// RegisterProvider adds or replaces the provider that can be selected with dns.type
+func RegisterProvider(name string, provider Provider) {
+	providers[name] = provider
+}
+
+// Providers returns the names of all registered providers
+func Providers() []string {
+	var names []string
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ProviderName returns the provider selected by dns.type, defaulting to rfc2136
+func ProviderName(config types.DynamicDNS) string {
+	if config.Type == "" {
+		return RFC2136Provider
+	}
+	return config.Type
+}
+
+// NewClient returns the Client of the provider selected by dns.type
+func NewClient(config types.DynamicDNS, log logger.Logger) (Client, error) {
+	name := ProviderName(config)
+	provider, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown dns provider %s, must be one of %s", name, strings.Join(Providers(), ", "))
+	}
+	return provider(config, log)
+}
@@ -0,0 +1,81 @@
+package dns
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	heritage    = "heritage=karina"
+	ownerPrefix = "_karina-owner"
+)
+
+// Registry records which cluster owns a name with a TXT record at a prefixed name, like the external-dns TXT
+// registry, so that a cluster only changes the records it created
+type Registry struct {
+	Client
+	// Owner identifies the cluster, e.g. its name
+	Owner string
+}
+
+// OwnerRecord returns the TXT record that marks a name as owned by owner
+func OwnerRecord(owner string) string {
+	return fmt.Sprintf("%s,karina/owner=%s", heritage, owner)
+}
+
+// OwnerName returns the name of the ownership record of domain. The record is not at domain itself, as a TXT record
+// at a wildcard would answer TXT queries for every otherwise undefined name, e.g. *.k8s is owned by
+// _karina-owner-wildcard.k8s
+func OwnerName(domain string) string {
+	if strings.HasPrefix(domain, "*.") {
+		return ownerPrefix + "-wildcard." + strings.TrimPrefix(domain, "*.")
+	}
+	return ownerPrefix + "." + domain
+}
+
+// parseOwner returns the owner in an ownership record, or "" if record is not one
+func parseOwner(record string) string {
+	fields := strings.Split(record, ",")
+	if fields[0] != heritage {
+		return ""
+	}
+	for _, field := range fields[1:] {
+		if strings.HasPrefix(field, "karina/owner=") {
+			return strings.TrimPrefix(field, "karina/owner=")
+		}
+	}
+	return ""
+}
+
+// GetOwner returns the owner of domain, or "" if it has no ownership record
+func (r Registry) GetOwner(domain string) (string, error) {
+	records, err := r.Get(OwnerName(domain), TXT)
+	if err != nil {
+		return "", err
+	}
+	for _, record := range records {
+		if owner := parseOwner(record); owner != "" {
+			return owner, nil
+		}
+	}
+	return "", nil
+}
+
+// Claim adds an ownership record for domain, failing if it is owned by another cluster
+func (r Registry) Claim(domain string) error {
+	if r.Owner == "" {
+		return fmt.Errorf("an owner is required to claim %s", domain)
+	}
+	owner, err := r.GetOwner(domain)
+	if err != nil {
+		return err
+	}
+	switch owner {
+	case r.Owner:
+		return nil
+	case "":
+		return r.Append(OwnerName(domain), TXT, OwnerRecord(r.Owner))
+	default:
+		return fmt.Errorf("%s is owned by %s", domain, owner)
+	}
+}
@@ -30,10 +30,13 @@ func (r53 *Route53Client) Init() {
 	}
 }
 
-func getResourceRecords(records ...string) []*route53.ResourceRecord {
+func getResourceRecords(recordType string, records ...string) []*route53.ResourceRecord {
 	out := []*route53.ResourceRecord{}
 	for _, record := range records {
 		value := record
+		if recordType == TXT {
+			value = quoteTXT(record)
+		}
 		out = append(out, &route53.ResourceRecord{
 			Value: &value,
 		})
@@ -45,60 +48,89 @@ func normalize(name string) string {
 	return strings.Replace(name, "\\052", "*", 1)
 }
 
-func (r53 *Route53Client) Append(domain string, records ...string) error {
-	existing, err := r53.Get(domain)
+func (r53 *Route53Client) Append(domain, recordType string, records ...string) error {
+	existing, err := r53.Get(domain, recordType)
 	if err != nil {
 		return fmt.Errorf("error getting existing records for domain %s, %v", domain, err)
 	}
-	return r53.Update(domain, append(existing, records...)...)
+	return r53.Update(domain, recordType, funk.UniqString(append(existing, records...))...)
+}
+
+func (r53 *Route53Client) Get(domain, recordType string) ([]string, error) {
+	set, err := r53.getRecordSet(domain, recordType)
+	if err != nil || set == nil {
+		return nil, err
+	}
+
+	var records []string
+	for _, record := range set.ResourceRecords {
+		switch recordType {
+		case TXT:
+			records = append(records, unquoteTXT(*record.Value))
+		case CNAME:
+			records = append(records, strings.TrimSuffix(*record.Value, "."))
+		default:
+			records = append(records, *record.Value)
+		}
+	}
+
+	r53.Tracef("lookup %s %s => %v", domain, recordType, records)
+	return records, nil
 }
 
-func (r53 *Route53Client) Get(domain string) ([]string, error) {
+// getRecordSet returns the record set of domain, or nil if it does not exist
+func (r53 *Route53Client) getRecordSet(domain, recordType string) (*route53.ResourceRecordSet, error) {
+	if err := Validate(recordType); err != nil {
+		return nil, err
+	}
 	if !strings.HasSuffix(domain, ".") {
 		domain += "."
 	}
 
-	a := "A"
 	output, err := r53.svc.ListResourceRecordSets(&route53.ListResourceRecordSetsInput{
-		StartRecordType: &a,
+		StartRecordType: aws.String(recordType),
 		HostedZoneId:    aws.String(r53.HostedZoneID),
 		StartRecordName: aws.String(domain),
 	})
 	if err != nil {
-		return []string{}, fmt.Errorf("error getting records for %s: %v", domain, err)
+		return nil, fmt.Errorf("error getting records for %s: %v", domain, err)
 	}
 
-	var records []string
 	for _, set := range output.ResourceRecordSets {
-		if normalize(*set.Name) != domain || *set.Type != a {
-			continue
-		}
-		for _, record := range set.ResourceRecords {
-			records = append(records, *record.Value)
+		if strings.EqualFold(normalize(*set.Name), domain) && *set.Type == recordType {
+			return set, nil
 		}
 	}
-
-	r53.Tracef("lookup %s => %v", domain, records)
-	return records, nil
+	return nil, nil
 }
 
-func (r53 *Route53Client) Update(domain string, records ...string) error {
-	rr := getResourceRecords(records...)
+func (r53 *Route53Client) Update(domain, recordType string, records ...string) error {
+	if err := Validate(recordType, records...); err != nil {
+		return err
+	}
+	rr := getResourceRecords(recordType, records...)
 	ttl := int64(60)
-	r53.Tracef("Updating %s domain to %v", domain, records)
+	r53.Tracef("Updating %s %s to %v", domain, recordType, records)
+	change := &route53.Change{
+		Action: aws.String("UPSERT"),
+		ResourceRecordSet: &route53.ResourceRecordSet{
+			ResourceRecords: rr,
+			Name:            aws.String(domain),
+			Type:            aws.String(recordType),
+			TTL:             &ttl,
+		},
+	}
+	if len(records) == 0 {
+		// a record set cannot be empty, and can only be deleted with its current values
+		existing, err := r53.getRecordSet(domain, recordType)
+		if err != nil || existing == nil {
+			return err
+		}
+		change = &route53.Change{Action: aws.String("DELETE"), ResourceRecordSet: existing}
+	}
 	input := &route53.ChangeResourceRecordSetsInput{
 		ChangeBatch: &route53.ChangeBatch{
-			Changes: []*route53.Change{
-				{
-					Action: aws.String("UPSERT"),
-					ResourceRecordSet: &route53.ResourceRecordSet{
-						ResourceRecords: rr,
-						Name:            aws.String(domain),
-						Type:            aws.String("A"),
-						TTL:             &ttl,
-					},
-				},
-			},
+			Changes: []*route53.Change{change},
 		},
 		HostedZoneId: aws.String(r53.HostedZoneID),
 	}
@@ -107,10 +139,13 @@ func (r53 *Route53Client) Update(domain string, records ...string) error {
 	return err
 }
 
-func (r53 *Route53Client) Delete(domain string, records ...string) error {
-	existing, err := r53.Get(domain)
+func (r53 *Route53Client) Delete(domain, recordType string, records ...string) error {
+	existing, err := r53.Get(domain, recordType)
 	if err != nil {
 		return fmt.Errorf("error getting existing records for domain %s, %v", domain, err)
 	}
-	return r53.Update(domain, funk.Subtract(existing, records).([]string)...)
+	if funk.ContainsString(records, "*") {
+		return r53.Update(domain, recordType)
+	}
+	return r53.Update(domain, recordType, funk.SubtractString(existing, records)...)
 }
@@ -0,0 +1,337 @@
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/flanksource/commons/logger"
+	"github.com/flanksource/karina/pkg/types"
+	"github.com/miekg/dns"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/mvcc/mvccpb"
+)
+
+// newRFC2136StandIn serves dynamic updates and zone transfers signed with TSIG
+func newRFC2136StandIn(t *testing.T, zone string) (Client, func()) {
+	keyName, secret := "karina.", "c2VjcmV0LWtleS1mb3ItdGVzdHM="
+	zone = dns.Fqdn(zone)
+	var mu sync.Mutex
+	var records []dns.RR
+	handler := func(w dns.ResponseWriter, r *dns.Msg) {
+		mu.Lock()
+		defer mu.Unlock()
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if r.IsTsig() == nil || w.TsigStatus() != nil {
+			m.Rcode = dns.RcodeNotAuth
+			_ = w.WriteMsg(m)
+			return
+		}
+		m.SetTsig(keyName, dns.HmacSHA256, 300, time.Now().Unix())
+		switch {
+		case r.Opcode == dns.OpcodeUpdate:
+			for _, rr := range r.Ns {
+				header := rr.Header()
+				switch header.Class {
+				case dns.ClassANY:
+					records = removeRR(records, func(existing dns.RR) bool {
+						return existing.Header().Name == header.Name && (header.Rrtype == dns.TypeANY || existing.Header().Rrtype == header.Rrtype)
+					})
+				case dns.ClassNONE:
+					header.Class = dns.ClassINET
+					records = removeRR(records, func(existing dns.RR) bool { return dns.IsDuplicate(existing, rr) })
+				default:
+					records = append(removeRR(records, func(existing dns.RR) bool { return dns.IsDuplicate(existing, rr) }), rr)
+				}
+			}
+		case len(r.Question) == 1 && r.Question[0].Qtype == dns.TypeAXFR:
+			soa := &dns.SOA{
+				Hdr: dns.RR_Header{Name: zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 60},
+				Ns:  "ns." + zone, Mbox: "admin." + zone, Serial: 1, Refresh: 60, Retry: 60, Expire: 60, Minttl: 60,
+			}
+			m.Answer = append(append([]dns.RR{soa}, records...), soa)
+		default:
+			m.Rcode = dns.RcodeNotImplemented
+		}
+		_ = w.WriteMsg(m)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{
+		Listener:   listener,
+		Net:        "tcp",
+		TsigSecret: map[string]string{keyName: secret},
+		Handler:    dns.HandlerFunc(handler),
+		// the default only accepts queries and notifies
+		MsgAcceptFunc: func(dns.Header) dns.MsgAcceptAction { return dns.MsgAccept },
+	}
+	go server.ActivateAndServe() // nolint: errcheck
+
+	client, err := NewClient(types.DynamicDNS{
+		Zone:       strings.TrimSuffix(zone, "."),
+		Nameserver: listener.Addr().String(),
+		KeyName:    keyName,
+		Key:        secret,
+		Algorithm:  "hmac-sha256",
+	}, logger.StandardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, func() { _ = server.Shutdown() }
+}
+
+func removeRR(records []dns.RR, remove func(dns.RR) bool) []dns.RR {
+	var out []dns.RR
+	for _, rr := range records {
+		if !remove(rr) {
+			out = append(out, rr)
+		}
+	}
+	return out
+}
+
+// newPowerDNSStandIn serves the zone endpoints of the PowerDNS API, rejecting content that PowerDNS would reject
+func newPowerDNSStandIn(t *testing.T, zone string) (Client, func()) {
+	var mu sync.Mutex
+	rrsets := map[string]pdnsRRSet{}
+	zonePath := "/api/v1/servers/localhost/zones/" + dns.Fqdn(zone)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fail := func(status int, message string) {
+			w.WriteHeader(status)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": message})
+		}
+		if r.Header.Get("X-API-Key") != "api-key" {
+			fail(http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		if r.URL.Path != zonePath {
+			fail(http.StatusNotFound, "Could not find domain")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			var sets []pdnsRRSet
+			for _, set := range rrsets {
+				sets = append(sets, set)
+			}
+			_ = json.NewEncoder(w).Encode(pdnsZone{RRSets: sets})
+		case http.MethodPatch:
+			var patch pdnsZone
+			if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+				fail(http.StatusBadRequest, err.Error())
+				return
+			}
+			for _, set := range patch.RRSets {
+				if !strings.HasSuffix(set.Name, "."+dns.Fqdn(zone)) {
+					fail(http.StatusUnprocessableEntity, "RRset "+set.Name+" is not canonical or not in zone")
+					return
+				}
+				for _, record := range set.Records {
+					if (set.Type == CNAME && !dns.IsFqdn(record.Content)) || (set.Type == TXT && !strings.HasPrefix(record.Content, `"`)) {
+						fail(http.StatusUnprocessableEntity, "Record "+set.Name+"/"+set.Type+" '"+record.Content+"': Parsing record content")
+						return
+					}
+				}
+				key := set.Name + "/" + set.Type
+				switch set.ChangeType {
+				case "REPLACE":
+					set.ChangeType = ""
+					rrsets[key] = set
+				case "DELETE":
+					delete(rrsets, key)
+				default:
+					fail(http.StatusUnprocessableEntity, "Changetype not understood")
+					return
+				}
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			fail(http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	}))
+
+	client, err := NewClient(types.DynamicDNS{
+		Type:     PowerDNSProvider,
+		Zone:     zone,
+		PowerDNS: &types.PowerDNS{URL: server.URL, APIKey: "api-key"},
+	}, logger.StandardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, server.Close
+}
+
+// memoryKV is an in-memory clientv3.KV supporting Get, Put and Delete
+type memoryKV struct {
+	clientv3.KV
+	mu   sync.Mutex
+	data map[string]string
+}
+
+func (kv *memoryKV) keys(op clientv3.Op) []string {
+	var keys []string
+	start, end := string(op.KeyBytes()), string(op.RangeBytes())
+	for key := range kv.data {
+		if key == start || (end != "" && key >= start && key < end) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (kv *memoryKV) Get(_ context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	resp := &clientv3.GetResponse{}
+	for _, key := range kv.keys(clientv3.OpGet(key, opts...)) {
+		resp.Kvs = append(resp.Kvs, &mvccpb.KeyValue{Key: []byte(key), Value: []byte(kv.data[key])})
+	}
+	resp.Count = int64(len(resp.Kvs))
+	return resp, nil
+}
+
+func (kv *memoryKV) Put(_ context.Context, key, val string, _ ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.data[key] = val
+	return &clientv3.PutResponse{}, nil
+}
+
+func (kv *memoryKV) Delete(_ context.Context, key string, opts ...clientv3.OpOption) (*clientv3.DeleteResponse, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	resp := &clientv3.DeleteResponse{}
+	for _, key := range kv.keys(clientv3.OpDelete(key, opts...)) {
+		delete(kv.data, key)
+		resp.Deleted++
+	}
+	return resp, nil
+}
+
+func newEtcdStandIn(t *testing.T, zone string) (Client, func()) {
+	return &EtcdClient{
+		Logger: logger.StandardLogger(),
+		KV:     &memoryKV{data: map[string]string{}},
+		Zone:   zone,
+	}, func() {}
+}
+
+type route53RecordSet struct {
+	Name   string `xml:"Name"`
+	Type   string `xml:"Type"`
+	TTL    int64  `xml:"TTL"`
+	Values []struct {
+		Value string `xml:"Value"`
+	} `xml:"ResourceRecords>ResourceRecord"`
+}
+
+func (set route53RecordSet) values() string {
+	var values []string
+	for _, record := range set.Values {
+		values = append(values, record.Value)
+	}
+	return strings.Join(values, ",")
+}
+
+// newRoute53StandIn serves ListResourceRecordSets and ChangeResourceRecordSets for a single hosted zone
+func newRoute53StandIn(t *testing.T, zone string) (Client, func()) {
+	var mu sync.Mutex
+	sets := map[string]route53RecordSet{}
+	name := func(name string) string {
+		// route53 returns names with the trailing dot and the * of wildcards escaped
+		return strings.Replace(strings.ToLower(dns.Fqdn(name)), "*", "\\052", 1)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fail := func(status int, code, message string) {
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte("<ErrorResponse><Error><Type>Sender</Type><Code>" + code + "</Code><Message>" + message + "</Message></Error></ErrorResponse>"))
+		}
+		if strings.TrimSuffix(r.URL.Path, "/") != "/2013-04-01/hostedzone/Z123/rrset" {
+			fail(http.StatusNotFound, "NoSuchHostedZone", r.URL.Path)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			var list struct {
+				XMLName     xml.Name           `xml:"ListResourceRecordSetsResponse"`
+				Sets        []route53RecordSet `xml:"ResourceRecordSets>ResourceRecordSet"`
+				IsTruncated bool               `xml:"IsTruncated"`
+				MaxItems    string             `xml:"MaxItems"`
+			}
+			var keys []string
+			for key := range sets {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				list.Sets = append(list.Sets, sets[key])
+			}
+			list.MaxItems = "100"
+			_ = xml.NewEncoder(w).Encode(list)
+		case http.MethodPost:
+			var request struct {
+				Changes []struct {
+					Action string           `xml:"Action"`
+					Set    route53RecordSet `xml:"ResourceRecordSet"`
+				} `xml:"ChangeBatch>Changes>Change"`
+			}
+			if err := xml.NewDecoder(r.Body).Decode(&request); err != nil {
+				fail(http.StatusBadRequest, "InvalidInput", err.Error())
+				return
+			}
+			for _, change := range request.Changes {
+				set := change.Set
+				set.Name = name(set.Name)
+				key := set.Name + "/" + set.Type
+				switch change.Action {
+				case "UPSERT":
+					if len(set.Values) == 0 {
+						fail(http.StatusBadRequest, "InvalidInput", "ResourceRecords must not be empty")
+						return
+					}
+					sets[key] = set
+				case "DELETE":
+					existing, ok := sets[key]
+					if !ok || existing.values() != set.values() {
+						fail(http.StatusBadRequest, "InvalidChangeBatch", "values provided do not match the current values")
+						return
+					}
+					delete(sets, key)
+				}
+			}
+			_, _ = w.Write([]byte("<ChangeResourceRecordSetsResponse><ChangeInfo><Id>/change/C1</Id><Status>PENDING</Status><SubmittedAt>2020-01-01T00:00:00Z</SubmittedAt></ChangeInfo></ChangeResourceRecordSetsResponse>"))
+		}
+	}))
+
+	sess, err := session.NewSession(&aws.Config{
+		Endpoint:    aws.String(server.URL),
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("access-key", "secret-key", ""),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &Route53Client{Logger: logger.StandardLogger(), HostedZoneID: "Z123", session: sess, svc: route53.New(sess)}
+	return client, server.Close
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/flanksource/karina/pkg/types"
+)
+
+// SecretMode controls how values that look like secrets are diffed
+type SecretMode string
+
+const (
+	ShowSecrets   SecretMode = "show"
+	MaskSecrets   SecretMode = "mask"
+	IgnoreSecrets SecretMode = "ignore"
+)
+
+const masked = "******"
+
+// secretKey matches keys that are likely to contain secrets, see console.StripSecrets
+var secretKey = regexp.MustCompile(`(?i)(pass|secret|token|privatekey|access_?key|^key$)`)
+
+type DiffOptions struct {
+	Secrets SecretMode
+	// VersionsOnly restricts the diff to the versions map and version fields of each component
+	VersionsOnly bool
+}
+
+// FieldDiff is a single value that differs between two configs, From is nil if the value
+// was added and To is nil if it was removed
+type FieldDiff struct {
+	Path string      `json:"path"`
+	From interface{} `json:"from,omitempty"`
+	To   interface{} `json:"to,omitempty"`
+}
+
+// IsSecret returns true if the last key of path looks like it contains a secret
+func IsSecret(path string) bool {
+	keys := strings.Split(path, ".")
+	return secretKey.MatchString(keys[len(keys)-1])
+}
+
+// IsVersion returns true if path is an entry in the versions map or a version field
+func IsVersion(path string) bool {
+	keys := strings.Split(path, ".")
+	key := keys[len(keys)-1]
+	return keys[0] == "versions" || key == "version" || strings.HasSuffix(key, "Version")
+}
+
+// Diff compares the effective values of two fully resolved configs
+func Diff(from, to types.PlatformConfig, opts DiffOptions) []FieldDiff {
+	fromValues := Flatten(from)
+	toValues := Flatten(to)
+
+	paths := map[string]bool{}
+	for path := range fromValues {
+		paths[path] = true
+	}
+	for path := range toValues {
+		paths[path] = true
+	}
+
+	var diffs []FieldDiff
+	for path := range paths {
+		a, b := fromValues[path], toValues[path]
+		if reflect.DeepEqual(a, b) {
+			continue
+		}
+		if opts.VersionsOnly && !IsVersion(path) {
+			continue
+		}
+		if IsSecret(path) {
+			if opts.Secrets == IgnoreSecrets {
+				continue
+			}
+			if opts.Secrets != ShowSecrets {
+				a, b = mask(a), mask(b)
+			}
+		}
+		diffs = append(diffs, FieldDiff{Path: path, From: a, To: b})
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Path < diffs[j].Path })
+	return diffs
+}
+
+func mask(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	return masked
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/flanksource/karina/pkg/types"
+	. "github.com/onsi/gomega"
+)
+
+func TestDiff(t *testing.T) {
+	from := types.PlatformConfig{
+		Name:       "test",
+		Kubernetes: types.Kubernetes{Version: "v1.19.8"},
+		Versions:   map[string]string{"sealed-secrets": "v0.10.0"},
+		Thanos:     types.Thanos{Bucket: "metrics"},
+	}
+	from.S3.AccessKey, from.S3.SecretKey = "minio", "old-secret"
+	from.Harbor.Version = "v2.0.0"
+	to := types.PlatformConfig{
+		Name:       "test",
+		Kubernetes: types.Kubernetes{Version: "v1.20.1"},
+		Versions:   map[string]string{"sealed-secrets": "v0.10.0", "gitops": "v1.0.0"},
+		Thanos:     types.Thanos{Retention: "30d"},
+	}
+	to.S3.AccessKey, to.S3.SecretKey = "minio", "new-secret"
+	to.Harbor.Version = "v2.1.0"
+
+	tests := []struct {
+		name string
+		opts DiffOptions
+		diff []FieldDiff
+	}{
+		{
+			name: "secrets are masked by default",
+			diff: []FieldDiff{
+				{Path: "harbor.version", From: "v2.0.0", To: "v2.1.0"},
+				{Path: "kubernetes.version", From: "v1.19.8", To: "v1.20.1"},
+				{Path: "s3.secret_key", From: masked, To: masked},
+				{Path: "thanos.bucket", From: "metrics"},
+				{Path: "thanos.retention", To: "30d"},
+				{Path: "versions.gitops", To: "v1.0.0"},
+			},
+		},
+		{
+			name: "shown secrets",
+			opts: DiffOptions{Secrets: ShowSecrets, VersionsOnly: true},
+			diff: []FieldDiff{
+				{Path: "harbor.version", From: "v2.0.0", To: "v2.1.0"},
+				{Path: "kubernetes.version", From: "v1.19.8", To: "v1.20.1"},
+				{Path: "versions.gitops", To: "v1.0.0"},
+			},
+		},
+		{
+			name: "ignored secrets",
+			opts: DiffOptions{Secrets: IgnoreSecrets},
+			diff: []FieldDiff{
+				{Path: "harbor.version", From: "v2.0.0", To: "v2.1.0"},
+				{Path: "kubernetes.version", From: "v1.19.8", To: "v1.20.1"},
+				{Path: "thanos.bucket", From: "metrics"},
+				{Path: "thanos.retention", To: "30d"},
+				{Path: "versions.gitops", To: "v1.0.0"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(Diff(from, to, test.opts)).To(Equal(test.diff))
+		})
+	}
+
+	g := NewWithT(t)
+	diff := Diff(from, to, DiffOptions{Secrets: ShowSecrets})
+	g.Expect(diff).To(ContainElement(FieldDiff{Path: "s3.secret_key", From: "old-secret", To: "new-secret"}))
+	g.Expect(Diff(from, from, DiffOptions{})).To(BeEmpty())
+}
+
+func TestIsSecret(t *testing.T) {
+	g := NewWithT(t)
+	for _, path := range []string{"s3.secret_key", "s3.access_key", "vault.token", "harbor.db.password", "ca.privateKey", "gitops[0].key"} {
+		g.Expect(IsSecret(path)).To(BeTrue(), path)
+	}
+	for _, path := range []string{"s3.bucket", "name", "keycloak.version"} {
+		g.Expect(IsSecret(path)).To(BeFalse(), path)
+	}
+}
@@ -0,0 +1,224 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/blang/semver/v4"
+	"github.com/flanksource/karina/pkg/types"
+)
+
+type Severity string
+
+// Severities use the same names as SARIF levels
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+	SeverityNote    Severity = "note"
+)
+
+// LintContext is the input to every lint rule
+type LintContext struct {
+	Config types.PlatformConfig
+	// ServerVersion is the version of the running cluster, empty if the cluster was not queried
+	ServerVersion string
+}
+
+// Rule is a check for combinations of config values that are valid on their own, but will not work together
+type Rule struct {
+	ID          string
+	Name        string
+	Severity    Severity
+	Description string
+	Fix         string
+	// Check returns the config path and message of each problem found
+	Check func(ctx LintContext) []Finding
+}
+
+type Finding struct {
+	RuleID   string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	Path     string   `json:"path"`
+	Message  string   `json:"message"`
+	Fix      string   `json:"fix"`
+	// Files are the config files that set the values the finding is about, see Locate
+	Files []string `json:"files,omitempty"`
+}
+
+func finding(path, msg string, args ...interface{}) []Finding {
+	return []Finding{{Path: path, Message: fmt.Sprintf(msg, args...)}}
+}
+
+var Rules = []Rule{
+	{
+		ID:          "KL001",
+		Name:        "thanos-requires-s3",
+		Severity:    SeverityError,
+		Description: "Thanos stores metrics in S3 and requires S3 credentials",
+		Fix:         "Set s3.access_key and s3.secret_key, or disable thanos",
+		Check: func(ctx LintContext) []Finding {
+			cfg := ctx.Config
+			if cfg.Thanos.IsDisabled() || (cfg.S3.AccessKey != "" && cfg.S3.SecretKey != "") {
+				return nil
+			}
+			return finding("thanos", "thanos is enabled but s3 credentials are not set")
+		},
+	},
+	{
+		ID:          "KL002",
+		Name:        "harbor-s3-requires-bucket",
+		Severity:    SeverityError,
+		Description: "Harbor needs a bucket to store images in when using S3",
+		Fix:         "Set harbor.bucket, or remove harbor.s3 to use a persistent volume",
+		Check: func(ctx LintContext) []Finding {
+			harbor := ctx.Config.Harbor
+			if harbor.IsDisabled() || harbor.S3 == nil || harbor.Bucket != "" {
+				return nil
+			}
+			return finding("harbor.bucket", "harbor.s3 is set but harbor.bucket is empty")
+		},
+	},
+	{
+		ID:          "KL003",
+		Name:        "certmanager-single-issuer",
+		Severity:    SeverityError,
+		Description: "Only one issuer can be used for signing ingress certificates",
+		Fix:         "Remove either certmanager.vault or certmanager.letsencrypt",
+		Check: func(ctx LintContext) []Finding {
+			cm := ctx.Config.CertManager
+			if cm.Vault == nil || cm.Letsencrypt == nil || cm.Letsencrypt.Disabled {
+				return nil
+			}
+			return finding("certmanager", "both certmanager.vault and certmanager.letsencrypt are set")
+		},
+	},
+	{
+		ID:          "KL004",
+		Name:        "velero-volumes-requires-csi",
+		Severity:    SeverityWarning,
+		Description: "Velero can only backup volumes created by a CSI driver",
+		Fix:         "Enable a CSI driver (vsphere.csiVersion, s3.csiVolumes, nfs or localPath), or set velero.volumes to false",
+		Check: func(ctx LintContext) []Finding {
+			cfg := ctx.Config
+			if cfg.Velero.IsDisabled() || !cfg.Velero.Volumes {
+				return nil
+			}
+			if (cfg.Vsphere != nil && cfg.Vsphere.CSIVersion != "") || cfg.S3.CSIVolumes || cfg.NFS != nil || !cfg.LocalPath.IsDisabled() {
+				return nil
+			}
+			return finding("velero.volumes", "velero.volumes is enabled but no CSI driver is configured")
+		},
+	},
+	{
+		ID:          "KL005",
+		Name:        "nsx-cni-conflict",
+		Severity:    SeverityError,
+		Description: "NSX provides the CNI and cannot be used together with another CNI",
+		Fix:         "Disable calico and antrea, or set nsx.cniDisabled to true",
+		Check: func(ctx LintContext) []Finding {
+			cfg := ctx.Config
+			if cfg.NSX == nil || cfg.NSX.Disabled || cfg.NSX.CNIDisabled {
+				return nil
+			}
+			var cnis []string
+			if !cfg.Calico.IsDisabled() {
+				cnis = append(cnis, "calico")
+			}
+			if !cfg.Antrea.IsDisabled() {
+				cnis = append(cnis, "antrea")
+			}
+			if len(cnis) == 0 {
+				return nil
+			}
+			return finding("nsx", "nsx is enabled together with %s", strings.Join(cnis, " and "))
+		},
+	},
+	{
+		ID:          "KL006",
+		Name:        "kubernetes-downgrade",
+		Severity:    SeverityError,
+		Description: "Kubernetes cannot be downgraded",
+		Fix:         "Set kubernetes.version to the version of the running cluster or higher",
+		Check: func(ctx LintContext) []Finding {
+			if ctx.ServerVersion == "" {
+				return nil
+			}
+			desired, err := semver.ParseTolerant(ctx.Config.Kubernetes.Version)
+			if err != nil {
+				return finding("kubernetes.version", "invalid version %q", ctx.Config.Kubernetes.Version)
+			}
+			running, err := semver.ParseTolerant(ctx.ServerVersion)
+			if err != nil {
+				return nil
+			}
+			// ignore build metadata such as +vmware.1
+			running.Build = nil
+			running.Pre = nil
+			if desired.GTE(running) {
+				return nil
+			}
+			return finding("kubernetes.version", "kubernetes.version %s is lower than the running cluster version %s", ctx.Config.Kubernetes.Version, ctx.ServerVersion)
+		},
+	},
+}
+
+// Lint runs every rule that is not suppressed by ID or name
+func Lint(ctx LintContext, suppress []string) []Finding {
+	var findings []Finding
+	for _, rule := range Rules {
+		if contains(suppress, rule.ID) || contains(suppress, rule.Name) {
+			continue
+		}
+		for _, f := range rule.Check(ctx) {
+			f.RuleID = rule.ID
+			f.Severity = rule.Severity
+			f.Fix = rule.Fix
+			findings = append(findings, f)
+		}
+	}
+	return findings
+}
+
+// Locate sets the files of each finding to the config files that set a value at or under its path,
+// findings about values that were not set by any file (e.g. defaults) are located in fallback
+func Locate(findings []Finding, provenance *Provenance, fallback string) []Finding {
+	for i := range findings {
+		findings[i].Files = provenance.Files(findings[i].Path)
+		if len(findings[i].Files) == 0 && fallback != "" {
+			findings[i].Files = []string{fallback}
+		}
+	}
+	return findings
+}
+
+// HasErrors returns true if any finding has an error severity
+func HasErrors(findings []Finding) bool {
+	for _, f := range findings {
+		if f.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+func PrintFindings(w io.Writer, findings []Finding) {
+	for _, f := range findings {
+		location := f.Path
+		if len(f.Files) > 0 {
+			location += " (" + strings.Join(f.Files, ", ") + ")"
+		}
+		fmt.Fprintf(w, "%s %s %s: %s\n", f.Severity, f.RuleID, location, f.Message)
+		fmt.Fprintf(w, "  fix: %s\n", f.Fix)
+	}
+	fmt.Fprintf(w, "%d findings\n", len(findings))
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,237 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/flanksource/karina/pkg/types"
+	. "github.com/onsi/gomega"
+)
+
+var enabled = types.XDisabled{Version: "v1.0.0"}
+
+func TestLintRules(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        func(cfg *types.PlatformConfig)
+		serverVersion string
+		findings      []string
+	}{
+		{
+			name:   "empty config",
+			config: func(cfg *types.PlatformConfig) {},
+		},
+		{
+			name:     "KL001 thanos without s3 credentials",
+			config:   func(cfg *types.PlatformConfig) { cfg.Thanos.XDisabled = enabled; cfg.S3.AccessKey = "minio" },
+			findings: []string{"KL001 thanos: thanos is enabled but s3 credentials are not set"},
+		},
+		{
+			name: "KL001 thanos with s3 credentials",
+			config: func(cfg *types.PlatformConfig) {
+				cfg.Thanos.XDisabled = enabled
+				cfg.S3.AccessKey, cfg.S3.SecretKey = "minio", "minio123"
+			},
+		},
+		{
+			name:     "KL002 harbor s3 without bucket",
+			config:   func(cfg *types.PlatformConfig) { cfg.Harbor.Version = "v2.1.0"; cfg.Harbor.S3 = &types.S3Connection{} },
+			findings: []string{"KL002 harbor.bucket: harbor.s3 is set but harbor.bucket is empty"},
+		},
+		{
+			name: "KL002 disabled harbor",
+			config: func(cfg *types.PlatformConfig) {
+				cfg.Harbor.Version, cfg.Harbor.Disabled = "v2.1.0", true
+				cfg.Harbor.S3 = &types.S3Connection{}
+			},
+		},
+		{
+			name: "KL003 vault and letsencrypt",
+			config: func(cfg *types.PlatformConfig) {
+				cfg.CertManager.Vault = &types.VaultClient{}
+				cfg.CertManager.Letsencrypt = &types.LetsencryptIssuer{}
+			},
+			findings: []string{"KL003 certmanager: both certmanager.vault and certmanager.letsencrypt are set"},
+		},
+		{
+			name: "KL003 disabled letsencrypt",
+			config: func(cfg *types.PlatformConfig) {
+				cfg.CertManager.Vault = &types.VaultClient{}
+				cfg.CertManager.Letsencrypt = &types.LetsencryptIssuer{XDisabled: types.XDisabled{Disabled: true}}
+			},
+		},
+		{
+			name:     "KL004 velero volumes without csi",
+			config:   func(cfg *types.PlatformConfig) { cfg.Velero.XDisabled = enabled; cfg.Velero.Volumes = true },
+			findings: []string{"KL004 velero.volumes: velero.volumes is enabled but no CSI driver is configured"},
+		},
+		{
+			name: "KL004 velero volumes with vsphere csi",
+			config: func(cfg *types.PlatformConfig) {
+				cfg.Velero.XDisabled, cfg.Velero.Volumes = enabled, true
+				cfg.Vsphere = &types.Vsphere{CSIVersion: "v2.1.0"}
+			},
+		},
+		{
+			name: "KL004 velero volumes with local path",
+			config: func(cfg *types.PlatformConfig) {
+				cfg.Velero.XDisabled, cfg.Velero.Volumes = enabled, true
+				cfg.LocalPath.Disabled = false
+			},
+		},
+		{
+			name: "KL005 nsx with calico and antrea",
+			config: func(cfg *types.PlatformConfig) {
+				cfg.NSX = &types.NSX{}
+				cfg.Calico.XDisabled = enabled
+				cfg.Antrea.XDisabled = enabled
+			},
+			findings: []string{"KL005 nsx: nsx is enabled together with calico and antrea"},
+		},
+		{
+			name: "KL005 nsx without its cni",
+			config: func(cfg *types.PlatformConfig) {
+				cfg.NSX = &types.NSX{CNIDisabled: true}
+				cfg.Calico.XDisabled = enabled
+			},
+		},
+		{
+			name:          "KL006 downgrade",
+			config:        func(cfg *types.PlatformConfig) { cfg.Kubernetes.Version = "v1.19.8" },
+			serverVersion: "v1.20.1+vmware.1",
+			findings:      []string{"KL006 kubernetes.version: kubernetes.version v1.19.8 is lower than the running cluster version v1.20.1+vmware.1"},
+		},
+		{
+			name:          "KL006 same version with build metadata",
+			config:        func(cfg *types.PlatformConfig) { cfg.Kubernetes.Version = "v1.20.1" },
+			serverVersion: "v1.20.1+vmware.1",
+		},
+		{
+			name:          "KL006 invalid version",
+			config:        func(cfg *types.PlatformConfig) { cfg.Kubernetes.Version = "latest" },
+			serverVersion: "v1.20.1",
+			findings:      []string{`KL006 kubernetes.version: invalid version "latest"`},
+		},
+		{
+			name:   "KL006 without a running cluster",
+			config: func(cfg *types.PlatformConfig) { cfg.Kubernetes.Version = "v1.19.8" },
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g := NewWithT(t)
+			// local path is enabled by default, so tests opt in to it
+			cfg := types.PlatformConfig{LocalPath: types.LocalPath{XEnabled: types.XEnabled{Disabled: true}}}
+			test.config(&cfg)
+			var findings []string
+			for _, f := range Lint(LintContext{Config: cfg, ServerVersion: test.serverVersion}, nil) {
+				findings = append(findings, f.RuleID+" "+f.Path+": "+f.Message)
+			}
+			g.Expect(findings).To(Equal(test.findings))
+		})
+	}
+}
+
+func lintConfig() types.PlatformConfig {
+	cfg := types.PlatformConfig{LocalPath: types.LocalPath{XEnabled: types.XEnabled{Disabled: true}}}
+	cfg.Thanos.XDisabled = enabled
+	cfg.Velero.XDisabled, cfg.Velero.Volumes = enabled, true
+	return cfg
+}
+
+func TestLintSuppress(t *testing.T) {
+	g := NewWithT(t)
+	findings := Lint(LintContext{Config: lintConfig()}, nil)
+	g.Expect(findings).To(HaveLen(2))
+	g.Expect(findings[0]).To(Equal(Finding{
+		RuleID:   "KL001",
+		Severity: SeverityError,
+		Path:     "thanos",
+		Message:  "thanos is enabled but s3 credentials are not set",
+		Fix:      "Set s3.access_key and s3.secret_key, or disable thanos",
+	}))
+	g.Expect(HasErrors(findings)).To(BeTrue())
+
+	// rules can be suppressed by either ID or name
+	findings = Lint(LintContext{Config: lintConfig()}, []string{"KL001"})
+	g.Expect(findings).To(HaveLen(1))
+	g.Expect(findings[0].RuleID).To(Equal("KL004"))
+	g.Expect(HasErrors(findings)).To(BeFalse())
+	g.Expect(Lint(LintContext{Config: lintConfig()}, []string{"thanos-requires-s3", "velero-volumes-requires-csi"})).To(BeEmpty())
+}
+
+func TestLocate(t *testing.T) {
+	g := NewWithT(t)
+	p := &Provenance{}
+	p.Add(Defaults, Fill, types.PlatformConfig{Thanos: types.Thanos{Mode: "client"}})
+	p.Add("cluster.yml", Fill, types.PlatformConfig{Thanos: types.Thanos{XDisabled: enabled}})
+	p.Add("thanos.yml", Fill, types.PlatformConfig{Thanos: types.Thanos{Bucket: "metrics"}})
+	p.Add("cluster.yml", Fill, types.PlatformConfig{Thanos: types.Thanos{Retention: "30d"}})
+	p.AddValue("--extra velero.volumes", Override, "velero.volumes", true)
+
+	findings := Locate(Lint(LintContext{Config: lintConfig()}, nil), p, "cluster.yml")
+	// every file that sets a value under the path is included once, values that are only set by defaults
+	// or flags are located in the fallback
+	g.Expect(findings[0].Files).To(Equal([]string{"cluster.yml", "thanos.yml"}))
+	g.Expect(findings[1].Files).To(Equal([]string{"cluster.yml"}))
+
+	var out bytes.Buffer
+	PrintFindings(&out, findings)
+	g.Expect(out.String()).To(Equal(`error KL001 thanos (cluster.yml, thanos.yml): thanos is enabled but s3 credentials are not set
+  fix: Set s3.access_key and s3.secret_key, or disable thanos
+warning KL004 velero.volumes (cluster.yml): velero.volumes is enabled but no CSI driver is configured
+  fix: Enable a CSI driver (vsphere.csiVersion, s3.csiVolumes, nfs or localPath), or set velero.volumes to false
+2 findings
+`))
+}
+
+func TestToSARIF(t *testing.T) {
+	g := NewWithT(t)
+	findings := Lint(LintContext{Config: lintConfig()}, nil)
+	findings[0].Files = []string{"cluster.yml", "thanos.yml"}
+
+	data, err := ToSARIF(findings)
+	g.Expect(err).ToNot(HaveOccurred())
+	var log sarifLog
+	g.Expect(json.Unmarshal(data, &log)).To(Succeed())
+	g.Expect(log.Version).To(Equal("2.1.0"))
+	g.Expect(log.Runs).To(HaveLen(1))
+
+	run := log.Runs[0]
+	g.Expect(run.Tool.Driver.Rules).To(HaveLen(len(Rules)))
+	g.Expect(run.Tool.Driver.Rules[3]).To(Equal(sarifRule{
+		ID:                   "KL004",
+		Name:                 "velero-volumes-requires-csi",
+		ShortDescription:     sarifText{Text: "Velero can only backup volumes created by a CSI driver"},
+		Help:                 sarifText{Text: Rules[3].Fix},
+		DefaultConfiguration: sarifConfiguration{Level: SeverityWarning},
+	}))
+
+	g.Expect(run.Results).To(HaveLen(2))
+	thanos := run.Results[0]
+	g.Expect(thanos.RuleID).To(Equal("KL001"))
+	g.Expect(thanos.Level).To(Equal(SeverityError))
+	g.Expect(thanos.Message.Text).To(Equal("thanos is enabled but s3 credentials are not set. Set s3.access_key and s3.secret_key, or disable thanos"))
+	// findings are located in each file that contributed to them
+	g.Expect(thanos.Locations).To(Equal([]sarifLocation{
+		{
+			PhysicalLocation: &sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: "cluster.yml"}},
+			LogicalLocations: []sarifLogicalLocation{{FullyQualifiedName: "thanos"}},
+		},
+		{
+			PhysicalLocation: &sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: "thanos.yml"}},
+			LogicalLocations: []sarifLogicalLocation{{FullyQualifiedName: "thanos"}},
+		},
+	}))
+	// findings without files only have a logical location
+	g.Expect(run.Results[1].Locations).To(Equal([]sarifLocation{
+		{LogicalLocations: []sarifLogicalLocation{{FullyQualifiedName: "velero.volumes"}}},
+	}))
+	g.Expect(string(data)).ToNot(ContainSubstring(`"physicalLocation": null`))
+
+	// an empty result list is still valid SARIF
+	data, err = ToSARIF(nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(string(data)).To(ContainSubstring(`"results": []`))
+}
@@ -0,0 +1,240 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/flanksource/yaml.v3"
+)
+
+type MergeMode string
+
+const (
+	// Fill layers only set values that have not been set by an earlier layer, this is how
+	// config files and defaults are merged using mergo
+	Fill MergeMode = "fill"
+	// Override layers replace any existing value, e.g. --extra flags
+	Override MergeMode = "override"
+)
+
+// Computed is the source reported for values that were changed while loading the config
+// without being set by any layer, e.g. harbor defaults derived from other values
+const Computed = "computed"
+
+// Defaults is the source of the built-in default values
+const Defaults = "defaults"
+
+// Layer is a single source of config values
+type Layer struct {
+	Source string
+	Mode   MergeMode
+	// Values are the non-empty leaf values set by the layer keyed by their YAML path
+	Values map[string]interface{}
+}
+
+// Provenance records every layer merged into a config, in the order they were merged.
+// A nil Provenance ignores all layers.
+type Provenance struct {
+	Layers []Layer
+}
+
+// Add records every non-empty value in config as being set by source
+func (p *Provenance) Add(source string, mode MergeMode, config interface{}) {
+	if p == nil {
+		return
+	}
+	p.Layers = append(p.Layers, Layer{Source: source, Mode: mode, Values: Flatten(config)})
+}
+
+// AddValue records a single value being set by source
+func (p *Provenance) AddValue(source string, mode MergeMode, path string, value interface{}) {
+	if p == nil {
+		return
+	}
+	p.Layers = append(p.Layers, Layer{Source: source, Mode: mode, Values: map[string]interface{}{path: value}})
+}
+
+// Files returns the config files that set a value at or under path, in the order they were merged
+func (p *Provenance) Files(path string) []string {
+	var files []string
+	for _, layer := range p.Layers {
+		if layer.Mode != Fill || layer.Source == Defaults || contains(files, layer.Source) {
+			continue
+		}
+		if len(Paths(layer.Values, path)) > 0 {
+			files = append(files, layer.Source)
+		}
+	}
+	return files
+}
+
+// Contribution is the value a layer provided for a single path
+type Contribution struct {
+	Source string
+	Value  interface{}
+	// Status is one of set, ignored (a fill layer that was ignored as the value was already set) or override
+	Status string
+}
+
+// Trace returns every layer that provided a value for path, and the source of the effective value,
+// the values of secrets are masked
+func (p *Provenance) Trace(path string, effective interface{}) ([]Contribution, string) {
+	var contributions []Contribution
+	var current interface{}
+	source := ""
+	for _, layer := range p.Layers {
+		value, ok := layer.Values[path]
+		if !ok {
+			continue
+		}
+		contribution := Contribution{Source: layer.Source, Value: value, Status: "set"}
+		switch {
+		case layer.Mode == Override:
+			contribution.Status = "override"
+			current, source = value, layer.Source
+		case source == "":
+			current, source = value, layer.Source
+		default:
+			contribution.Status = "ignored"
+		}
+		contributions = append(contributions, contribution)
+	}
+	if effective != nil && !reflect.DeepEqual(normalizeValue(current), normalizeValue(effective)) {
+		source = Computed
+	}
+	for i := range contributions {
+		contributions[i].Value = MaskSecret(path, contributions[i].Value)
+	}
+	return contributions, source
+}
+
+// MaskSecret returns value masked if path looks like it contains a secret
+func MaskSecret(path string, value interface{}) interface{} {
+	if IsSecret(path) {
+		return mask(value)
+	}
+	return value
+}
+
+// normalizeValue converts --extra values that are always strings for comparison
+func normalizeValue(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	if _, ok := value.(string); ok {
+		return value
+	}
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Slice, reflect.Map:
+		return value
+	}
+	data, _ := yaml.Marshal(value)
+	return strings.TrimSpace(string(data))
+}
+
+// Flatten returns the non-empty leaf values of config keyed by their dotted YAML path,
+// lists are treated as a single value
+func Flatten(config interface{}) map[string]interface{} {
+	out := map[string]interface{}{}
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return out
+	}
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return out
+	}
+	flatten("", values, out)
+	return out
+}
+
+func flatten(prefix string, value interface{}, out map[string]interface{}) {
+	if m, ok := value.(map[string]interface{}); ok {
+		for k, v := range m {
+			path := k
+			if prefix != "" {
+				path = prefix + "." + k
+			}
+			flatten(path, v, out)
+		}
+		return
+	}
+	if value == nil || reflect.ValueOf(value).IsZero() {
+		return
+	}
+	if v := reflect.ValueOf(value); (v.Kind() == reflect.Slice || v.Kind() == reflect.Map) && v.Len() == 0 {
+		return
+	}
+	out[prefix] = value
+}
+
+// Paths returns the paths in values that are equal to or nested under path, sorted
+func Paths(values map[string]interface{}, path string) []string {
+	var paths []string
+	for p := range values {
+		if path == "" || p == path || strings.HasPrefix(p, path+".") {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Annotate returns config as YAML with a comment on each leaf value containing its source,
+// the values of secrets are masked
+func (p *Provenance) Annotate(config interface{}) (string, error) {
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	node := &yaml.Node{}
+	if err := yaml.Unmarshal(data, node); err != nil {
+		return "", err
+	}
+	if len(node.Content) == 0 {
+		return string(data), nil
+	}
+	p.annotate(node.Content[0], "", Flatten(config))
+	data, err = yaml.Marshal(node)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (p *Provenance) annotate(node *yaml.Node, path string, effective map[string]interface{}) {
+	if node.Kind != yaml.MappingNode {
+		value, ok := effective[path]
+		if !ok {
+			return
+		}
+		if _, source := p.Trace(path, value); source != "" {
+			node.LineComment = source
+		}
+		if IsSecret(path) {
+			node.Kind, node.Tag, node.Value, node.Content = yaml.ScalarNode, "!!str", masked, nil
+		}
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		child := node.Content[i].Value
+		if path != "" {
+			child = path + "." + child
+		}
+		value := node.Content[i+1]
+		if value.Kind == yaml.SequenceNode {
+			// comment on the key so that it is not repeated for each item
+			if v, ok := effective[child]; ok {
+				if _, source := p.Trace(child, v); source != "" {
+					node.Content[i].LineComment = source
+				}
+				if IsSecret(child) {
+					node.Content[i+1] = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: masked}
+				}
+			}
+			continue
+		}
+		p.annotate(value, child, effective)
+	}
+}
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/flanksource/karina/pkg/types"
+	. "github.com/onsi/gomega"
+)
+
+func TestProvenanceTrace(t *testing.T) {
+	g := NewWithT(t)
+	p := &Provenance{}
+	p.Add("defaults", Fill, types.PlatformConfig{Name: "default", Domain: "example.com"})
+	p.Add("cluster.yml", Fill, types.PlatformConfig{Name: "test"})
+	p.Add("base.yml", Fill, types.PlatformConfig{Name: "base", DNS: types.DynamicDNS{Zone: "base.example.com"}})
+	p.AddValue("--extra", Override, "name", "override")
+
+	// fill layers are ignored once a value is set, override layers replace it
+	contributions, source := p.Trace("name", "override")
+	g.Expect(source).To(Equal("--extra"))
+	g.Expect(contributions).To(Equal([]Contribution{
+		{Source: "defaults", Value: "default", Status: "set"},
+		{Source: "cluster.yml", Value: "test", Status: "ignored"},
+		{Source: "base.yml", Value: "base", Status: "ignored"},
+		{Source: "--extra", Value: "override", Status: "override"},
+	}))
+
+	// values that differ from every layer were changed while loading the config
+	_, source = p.Trace("domain", "changed.example.com")
+	g.Expect(source).To(Equal(Computed))
+
+	contributions, source = p.Trace("ldap.host", nil)
+	g.Expect(source).To(BeEmpty())
+	g.Expect(contributions).To(BeEmpty())
+}
+
+func TestProvenanceAnnotate(t *testing.T) {
+	g := NewWithT(t)
+	p := &Provenance{}
+	p.Add("cluster.yml", Fill, types.PlatformConfig{Name: "test", DNS: types.DynamicDNS{Zone: "example.com"}})
+	p.AddValue("--extra", Override, "dns.zone", "test.example.com")
+
+	cfg := types.PlatformConfig{Name: "test", DNS: types.DynamicDNS{Zone: "test.example.com"}}
+	annotated, err := p.Annotate(cfg)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(annotated).To(ContainSubstring("name: test # cluster.yml"))
+	g.Expect(annotated).To(ContainSubstring("zone: test.example.com # --extra"))
+}
+
+func TestProvenanceMasksSecrets(t *testing.T) {
+	g := NewWithT(t)
+	cfg := types.PlatformConfig{Name: "test"}
+	cfg.S3.AccessKey = "minio"
+	cfg.S3.SecretKey = "hunter2"
+
+	p := &Provenance{}
+	p.Add("cluster.yml", Fill, cfg)
+	p.AddValue("--extra s3.secret_key", Override, "s3.secret_key", "hunter2")
+
+	contributions, source := p.Trace("s3.secret_key", "hunter2")
+	g.Expect(source).To(Equal("--extra s3.secret_key"))
+	g.Expect(contributions).To(Equal([]Contribution{
+		{Source: "cluster.yml", Value: masked, Status: "set"},
+		{Source: "--extra s3.secret_key", Value: masked, Status: "override"},
+	}))
+
+	annotated, err := p.Annotate(cfg)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(annotated).NotTo(ContainSubstring("hunter2"))
+	g.Expect(annotated).To(ContainSubstring("secret_key: '" + masked + "' # --extra s3.secret_key"))
+	g.Expect(annotated).To(ContainSubstring("name: test # cluster.yml"))
+}
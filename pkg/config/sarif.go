@@ -0,0 +1,111 @@
+package config
+
+import (
+	"encoding/json"
+)
+
+const sarifSchema = "https://raw.githubusercontent.com/oasis-tcs/sarif-spec/master/Schemata/sarif-schema-2.1.0.json"
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifText struct {
+	Text string `json:"text"`
+}
+
+type sarifRule struct {
+	ID                   string             `json:"id"`
+	Name                 string             `json:"name"`
+	ShortDescription     sarifText          `json:"shortDescription"`
+	Help                 sarifText          `json:"help"`
+	DefaultConfiguration sarifConfiguration `json:"defaultConfiguration"`
+}
+
+type sarifConfiguration struct {
+	Level Severity `json:"level"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     Severity        `json:"level"`
+	Message   sarifText       `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation *sarifPhysicalLocation `json:"physicalLocation,omitempty"`
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifLogicalLocation struct {
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+}
+
+// ToSARIF returns findings as a SARIF 2.1.0 log, lint rules run against the merged config so findings
+// are located in each of their files using their config path
+func ToSARIF(findings []Finding) ([]byte, error) {
+	driver := sarifDriver{
+		Name:           "karina",
+		InformationURI: "https://github.com/flanksource/karina",
+	}
+	for _, rule := range Rules {
+		driver.Rules = append(driver.Rules, sarifRule{
+			ID:                   rule.ID,
+			Name:                 rule.Name,
+			ShortDescription:     sarifText{Text: rule.Description},
+			Help:                 sarifText{Text: rule.Fix},
+			DefaultConfiguration: sarifConfiguration{Level: rule.Severity},
+		})
+	}
+	results := []sarifResult{}
+	for _, f := range findings {
+		logical := []sarifLogicalLocation{{FullyQualifiedName: f.Path}}
+		locations := []sarifLocation{}
+		for _, file := range f.Files {
+			locations = append(locations, sarifLocation{
+				PhysicalLocation: &sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: file}},
+				LogicalLocations: logical,
+			})
+		}
+		if len(locations) == 0 {
+			locations = append(locations, sarifLocation{LogicalLocations: logical})
+		}
+		results = append(results, sarifResult{
+			RuleID:    f.RuleID,
+			Level:     f.Severity,
+			Message:   sarifText{Text: f.Message + ". " + f.Fix},
+			Locations: locations,
+		})
+	}
+	return json.MarshalIndent(sarifLog{
+		Schema:  sarifSchema,
+		Version: "2.1.0",
+		Runs:    []sarifRun{{Tool: sarifTool{Driver: driver}, Results: results}},
+	}, "", "  ")
+}
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/flanksource/karina/pkg/types"
+)
+
+const SchemaVersion = "http://json-schema.org/draft-07/schema#"
+
+// Schema is the subset of JSON Schema (draft-07) needed to describe PlatformConfig
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties interface{}        `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Definitions          map[string]*Schema `json:"definitions,omitempty"`
+}
+
+// enums lists the allowed values of string fields, keyed by <type>.<yaml field>
+var enums = map[string][]interface{}{
+	"types.HealthGate.type": {"prometheus", "canary", "alerts", "etcd"},
+	"types.Thanos.mode":     {"client", "observability"},
+	"types.VM.provider":     {"vsphere", "libvirt", "fake"},
+}
+
+// scalars are structs that are marshalled as strings
+var scalars = map[string]bool{
+	"time.Time":         true,
+	"v1.Time":           true,
+	"v1.Duration":       true,
+	"resource.Quantity": true,
+}
+
+// GenerateSchema returns a JSON schema for PlatformConfig, every named struct is added
+// to definitions and referenced with $ref.
+func GenerateSchema() *Schema {
+	g := generator{definitions: map[string]*Schema{}}
+	schema := g.schemaFor(reflect.TypeOf(types.PlatformConfig{}))
+	return &Schema{
+		Schema:      SchemaVersion,
+		Title:       "karina PlatformConfig",
+		Ref:         schema.Ref,
+		Definitions: g.definitions,
+	}
+}
+
+func (s *Schema) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(s, "", "  ")
+}
+
+type generator struct {
+	definitions map[string]*Schema
+}
+
+func definitionName(t reflect.Type) string {
+	return strings.ReplaceAll(t.String(), "*", "")
+}
+
+func (g *generator) schemaFor(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string"}
+		}
+		return &Schema{Type: "array", Items: g.schemaFor(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schemaFor(t.Elem())}
+	case reflect.Struct:
+		name := definitionName(t)
+		if scalars[name] {
+			return &Schema{Type: "string"}
+		}
+		if _, ok := g.definitions[name]; !ok {
+			// register before recursing so that self-referencing types terminate
+			definition := &Schema{Type: "object", Properties: map[string]*Schema{}, AdditionalProperties: false}
+			g.definitions[name] = definition
+			g.addProperties(definition, t)
+		}
+		return &Schema{Ref: "#/definitions/" + name}
+	}
+	// interfaces and anything else can hold any value
+	return &Schema{}
+}
+
+func (g *generator) addProperties(definition *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		name, inline := fieldName(field)
+		if name == "-" {
+			continue
+		}
+		if inline {
+			embedded := field.Type
+			for embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				g.addProperties(definition, embedded)
+				continue
+			}
+			if embedded.Kind() == reflect.Map {
+				definition.AdditionalProperties = g.schemaFor(embedded.Elem())
+				continue
+			}
+		}
+		schema := g.schemaFor(field.Type)
+		if enum, ok := enums[definitionName(t)+"."+name]; ok {
+			schema.Enum = enum
+		}
+		definition.Properties[name] = schema
+	}
+}
+
+// fieldName returns the YAML key of a struct field and whether it is inlined, following the
+// same rules as gopkg.in/yaml.v3
+func fieldName(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("yaml")
+	if tag == "" && !strings.Contains(string(field.Tag), ":") {
+		tag = string(field.Tag)
+	}
+	parts := strings.Split(tag, ",")
+	inline := false
+	for _, flag := range parts[1:] {
+		if flag == "inline" {
+			inline = true
+		}
+	}
+	if parts[0] != "" {
+		return parts[0], inline
+	}
+	return strings.ToLower(field.Name), inline
+}
@@ -0,0 +1,251 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/flanksource/karina/pkg/types"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/flanksource/yaml.v3"
+)
+
+// ValidationError is a single problem found in a config file
+type ValidationError struct {
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (e ValidationError) Error() string {
+	if e.File == "" {
+		return fmt.Sprintf("%s: %s", e.Path, e.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s: %s", e.File, e.Line, e.Path, e.Message)
+}
+
+// ValidateFiles validates each config file and any files it imports using importConfigs
+// or configFrom.file against the schema. SOPS encrypted files are not validated.
+func ValidateFiles(schema *Schema, paths ...string) ([]ValidationError, error) {
+	var result []ValidationError
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read config file %s", path)
+		}
+		node := &yaml.Node{}
+		if err := yaml.Unmarshal(data, node); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %s", path)
+		}
+		result = append(result, schema.Validate(path, node)...)
+
+		var imports struct {
+			ImportConfigs []string                `yaml:"importConfigs"`
+			ConfigFrom    []types.ConfigDirective `yaml:"configFrom"`
+		}
+		if err := node.Decode(&imports); err != nil {
+			// type errors in importConfigs/configFrom have already been reported
+			continue
+		}
+		var children []string
+		for _, config := range imports.ImportConfigs {
+			children = append(children, filepath.Join(filepath.Dir(path), config))
+		}
+		for _, config := range imports.ConfigFrom {
+			if config.FilePath != "" {
+				children = append(children, filepath.Join(filepath.Dir(path), config.FilePath))
+			}
+		}
+		errs, err := ValidateFiles(schema, children...)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, errs...)
+	}
+	return result, nil
+}
+
+// Validate checks a YAML document against the schema, returning every unknown key and type mismatch
+func (s *Schema) Validate(file string, node *yaml.Node) []ValidationError {
+	v := validator{root: s, file: file}
+	if node.Kind == yaml.DocumentNode {
+		if len(node.Content) == 0 {
+			return nil
+		}
+		node = node.Content[0]
+	}
+	v.validate(s, node, "")
+	return v.errors
+}
+
+type validator struct {
+	root   *Schema
+	file   string
+	errors []ValidationError
+}
+
+func (v *validator) errorf(node *yaml.Node, path, msg string, args ...interface{}) {
+	if path == "" {
+		path = "."
+	}
+	v.errors = append(v.errors, ValidationError{
+		File:    v.file,
+		Line:    node.Line,
+		Path:    path,
+		Message: fmt.Sprintf(msg, args...),
+	})
+}
+
+func (v *validator) resolve(schema *Schema) *Schema {
+	for schema.Ref != "" {
+		schema = v.root.Definitions[strings.TrimPrefix(schema.Ref, "#/definitions/")]
+	}
+	return schema
+}
+
+func (v *validator) validate(schema *Schema, node *yaml.Node, path string) {
+	schema = v.resolve(schema)
+	for node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Tag == "!!null" || node.Tag == "!!env" || node.Tag == "!!template" {
+		// templated values are only known once the config is loaded
+		return
+	}
+
+	switch schema.Type {
+	case "object":
+		if node.Kind != yaml.MappingNode {
+			v.errorf(node, path, "expected an object, got %s", describe(node))
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Value == "<<" {
+				v.validate(schema, value, path)
+				continue
+			}
+			child := key.Value
+			if path != "" {
+				child = path + "." + key.Value
+			}
+			if property, ok := schema.Properties[key.Value]; ok {
+				v.validate(property, value, child)
+			} else if additional, ok := schema.AdditionalProperties.(*Schema); ok {
+				v.validate(additional, value, child)
+			} else {
+				v.errorf(key, child, "unknown key")
+			}
+		}
+	case "array":
+		if node.Kind != yaml.SequenceNode {
+			v.errorf(node, path, "expected a list, got %s", describe(node))
+			return
+		}
+		for i, item := range node.Content {
+			v.validate(schema.Items, item, fmt.Sprintf("%s[%d]", path, i))
+		}
+	case "string":
+		// numbers are commonly used for versions and ports, but booleans are likely a mistake
+		if node.Kind != yaml.ScalarNode || node.Tag == "!!bool" {
+			v.errorf(node, path, "expected a string, got %s", describe(node))
+			return
+		}
+		if len(schema.Enum) > 0 && !inEnum(schema.Enum, node.Value) {
+			v.errorf(node, path, "must be one of %v, got %q", schema.Enum, node.Value)
+		}
+	case "integer":
+		if node.Kind != yaml.ScalarNode || node.Tag != "!!int" {
+			v.errorf(node, path, "expected an integer, got %s", describe(node))
+		}
+	case "number":
+		if node.Kind != yaml.ScalarNode || (node.Tag != "!!int" && node.Tag != "!!float") {
+			v.errorf(node, path, "expected a number, got %s", describe(node))
+		}
+	case "boolean":
+		if node.Kind != yaml.ScalarNode || node.Tag != "!!bool" {
+			v.errorf(node, path, "expected a boolean, got %s", describe(node))
+		}
+	}
+}
+
+func inEnum(enum []interface{}, value string) bool {
+	for _, e := range enum {
+		if fmt.Sprintf("%v", e) == value {
+			return true
+		}
+	}
+	return false
+}
+
+func describe(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "an object"
+	case yaml.SequenceNode:
+		return "a list"
+	}
+	return fmt.Sprintf("%s %q", strings.TrimPrefix(node.Tag, "!!"), node.Value)
+}
+
+// ValidateSemantics checks the merged config for values that are well-typed but invalid
+func ValidateSemantics(cfg types.PlatformConfig) []ValidationError {
+	var result []ValidationError
+
+	if !cfg.Thanos.IsDisabled() && cfg.Thanos.Mode != "client" && cfg.Thanos.Mode != "observability" {
+		result = append(result, ValidationError{Path: "thanos.mode", Message: fmt.Sprintf("must be either client or observability, got %q", cfg.Thanos.Mode)})
+	}
+
+	for i, filebeat := range cfg.Filebeat {
+		if filebeat.Disabled {
+			continue
+		}
+		if filebeat.Elasticsearch == nil && filebeat.Logstash == nil {
+			result = append(result, ValidationError{
+				Path:    fmt.Sprintf("filebeat[%d]", i),
+				Message: fmt.Sprintf("%s must set either elasticsearch or logstash", filebeat.Name),
+			})
+		}
+	}
+
+	var pools []string
+	for name := range cfg.Nodes {
+		pools = append(pools, name)
+	}
+	sort.Strings(pools)
+	for _, name := range pools {
+		pool := cfg.Nodes[name]
+		if pool.IsAutoscaled() && pool.MinCount > pool.MaxCount {
+			result = append(result, ValidationError{
+				Path:    fmt.Sprintf("nodes.%s.minCount", name),
+				Message: fmt.Sprintf("minCount %d is greater than maxCount %d", pool.MinCount, pool.MaxCount),
+			})
+		}
+	}
+
+	subnets := map[string]*net.IPNet{}
+	for _, subnet := range []struct{ path, cidr string }{{"podSubnet", cfg.PodSubnet}, {"serviceSubnet", cfg.ServiceSubnet}} {
+		if subnet.cidr == "" {
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(subnet.cidr)
+		if err != nil {
+			result = append(result, ValidationError{Path: subnet.path, Message: fmt.Sprintf("invalid CIDR %q", subnet.cidr)})
+			continue
+		}
+		subnets[subnet.path] = ipnet
+	}
+	if pods, services := subnets["podSubnet"], subnets["serviceSubnet"]; pods != nil && services != nil {
+		if pods.Contains(services.IP) || services.Contains(pods.IP) {
+			result = append(result, ValidationError{
+				Path:    "serviceSubnet",
+				Message: fmt.Sprintf("%s overlaps with podSubnet %s", cfg.ServiceSubnet, cfg.PodSubnet),
+			})
+		}
+	}
+	return result
+}
@@ -0,0 +1,149 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/flanksource/karina/pkg/types"
+	. "github.com/onsi/gomega"
+)
+
+// writeFiles writes each file into a temporary directory and returns the directory
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "karina-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestValidateFiles(t *testing.T) {
+	g := NewWithT(t)
+	dir := writeFiles(t, map[string]string{
+		"cluster.yml": `name: test
+importConfigs:
+  - harbor.yml
+configFrom:
+  - file: thanos.yml
+unknown: true
+master:
+  count: three
+  provider: aws
+kubernetes:
+  version: v1.20.1
+  kubeletExtraArgs:
+    v: "4"
+versions: [1, 2]
+`,
+		"harbor.yml": `harbor:
+  disabled: "yes"
+  version: true
+  replicas: !!env REPLICAS
+`,
+		"thanos.yml": `thanos:
+  mode: sidecar
+  bucket:
+    name: metrics
+`,
+	})
+	cluster := filepath.Join(dir, "cluster.yml")
+	harbor := filepath.Join(dir, "harbor.yml")
+	thanos := filepath.Join(dir, "thanos.yml")
+
+	errs, err := ValidateFiles(GenerateSchema(), cluster)
+	g.Expect(err).ToNot(HaveOccurred())
+	var messages []string
+	for _, e := range errs {
+		messages = append(messages, e.Error())
+	}
+	// imported files are validated after the file that imports them, with their own line numbers
+	g.Expect(messages).To(Equal([]string{
+		cluster + `:6: unknown: unknown key`,
+		cluster + `:8: master.count: expected an integer, got str "three"`,
+		cluster + `:9: master.provider: must be one of [vsphere libvirt fake], got "aws"`,
+		cluster + `:14: versions: expected an object, got a list`,
+		harbor + `:2: harbor.disabled: expected a boolean, got str "yes"`,
+		harbor + `:3: harbor.version: expected a string, got bool "true"`,
+		thanos + `:2: thanos.mode: must be one of [client observability], got "sidecar"`,
+		thanos + `:4: thanos.bucket: expected a string, got an object`,
+	}))
+}
+
+func TestValidateFilesMissingImport(t *testing.T) {
+	g := NewWithT(t)
+	dir := writeFiles(t, map[string]string{"cluster.yml": "importConfigs: [missing.yml]\n"})
+	_, err := ValidateFiles(GenerateSchema(), filepath.Join(dir, "cluster.yml"))
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(err.Error()).To(ContainSubstring("failed to read config file " + filepath.Join(dir, "missing.yml")))
+}
+
+func TestValidateSemantics(t *testing.T) {
+	tests := []struct {
+		name   string
+		config types.PlatformConfig
+		errors []string
+	}{
+		{
+			name:   "valid",
+			config: types.PlatformConfig{PodSubnet: "100.200.0.0/16", ServiceSubnet: "100.100.0.0/16"},
+		},
+		{
+			name:   "thanos mode",
+			config: types.PlatformConfig{Thanos: types.Thanos{XDisabled: types.XDisabled{Version: "v0.17.0"}, Mode: "sidecar"}},
+			errors: []string{`thanos.mode: must be either client or observability, got "sidecar"`},
+		},
+		{
+			name:   "disabled thanos is not validated",
+			config: types.PlatformConfig{Thanos: types.Thanos{Mode: "sidecar"}},
+		},
+		{
+			name: "filebeat output",
+			config: types.PlatformConfig{Filebeat: []types.Filebeat{
+				{Name: "infra", Elasticsearch: &types.Connection{URL: "elastic"}},
+				{Name: "apps"},
+				{Name: "disabled", XDisabled: types.XDisabled{Disabled: true}},
+			}},
+			errors: []string{"filebeat[1]: apps must set either elasticsearch or logstash"},
+		},
+		{
+			name: "autoscaled pools",
+			config: types.PlatformConfig{Nodes: map[string]types.VM{
+				"b":     {MinCount: 5, MaxCount: 3},
+				"a":     {MinCount: 2, MaxCount: 1},
+				"fixed": {MinCount: 5},
+			}},
+			errors: []string{
+				"nodes.a.minCount: minCount 2 is greater than maxCount 1",
+				"nodes.b.minCount: minCount 5 is greater than maxCount 3",
+			},
+		},
+		{
+			name:   "invalid CIDR",
+			config: types.PlatformConfig{PodSubnet: "100.200.0.0", ServiceSubnet: "100.100.0.0/16"},
+			errors: []string{`podSubnet: invalid CIDR "100.200.0.0"`},
+		},
+		{
+			name:   "overlapping subnets",
+			config: types.PlatformConfig{PodSubnet: "10.0.0.0/8", ServiceSubnet: "10.96.0.0/12"},
+			errors: []string{"serviceSubnet: 10.96.0.0/12 overlaps with podSubnet 10.0.0.0/8"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g := NewWithT(t)
+			var messages []string
+			for _, e := range ValidateSemantics(test.config) {
+				messages = append(messages, e.Error())
+			}
+			g.Expect(messages).To(Equal(test.errors))
+		})
+	}
+}
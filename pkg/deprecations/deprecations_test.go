@@ -0,0 +1,173 @@
+package deprecations
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	fakedynamic "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestFind(t *testing.T) {
+	g := NewWithT(t)
+	v121, _ := ParseVersion("v1.21.2")
+	v122, _ := ParseVersion("v1.22.0-rc.1")
+
+	g.Expect(Find("networking.k8s.io/v1beta1", "Ingress", v121)).To(BeNil())
+	removal := Find("networking.k8s.io/v1beta1", "Ingress", v122)
+	g.Expect(removal).ToNot(BeNil())
+	g.Expect(removal.Replacement).To(Equal("networking.k8s.io/v1"))
+	g.Expect(Find("extensions/v1beta1", "Deployment", v121)).ToNot(BeNil())
+	g.Expect(Find("apps/v1", "Deployment", v122)).To(BeNil())
+
+	g.Expect(FindResource("batch", "v1beta1", "cronjobs", v122)).To(BeNil())
+	g.Expect(FindResource("rbac.authorization.k8s.io", "v1beta1", "roles", v122)).ToNot(BeNil())
+}
+
+const metrics = `# HELP apiserver_requested_deprecated_apis [ALPHA] Gauge of deprecated APIs that have been requested, broken out by API group, version, resource, subresource, and removed_release.
+# TYPE apiserver_requested_deprecated_apis gauge
+apiserver_requested_deprecated_apis{group="batch",removed_release="1.25",resource="cronjobs",subresource="",version="v1beta1"} 1
+apiserver_requested_deprecated_apis{group="extensions",removed_release="1.22",resource="ingresses",subresource="",version="v1beta1"} 1
+apiserver_requested_deprecated_apis{group="example.com",removed_release="1.22",resource="widgets",subresource="status",version="v1alpha1"} 1
+apiserver_requested_deprecated_apis{group="policy",removed_release="",resource="podsecuritypolicies",subresource="",version="v1beta1"} 1
+# HELP apiserver_request_total Counter of apiserver requests
+# TYPE apiserver_request_total counter
+apiserver_request_total{code="200",verb="GET"} 10
+`
+
+func TestParseRequested(t *testing.T) {
+	g := NewWithT(t)
+	target, _ := ParseVersion("v1.22.1")
+	requests, err := parseRequested(strings.NewReader(metrics), target)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(requests).To(HaveLen(2))
+	g.Expect(requests[0].APIVersion()).To(Equal("example.com/v1alpha1"))
+	g.Expect(requests[0].Subresource).To(Equal("status"))
+	g.Expect(requests[1].APIVersion()).To(Equal("extensions/v1beta1"))
+	g.Expect(requests[1].Replacement).To(Equal("networking.k8s.io/v1"))
+
+	target, _ = ParseVersion("v1.25")
+	requests, err = parseRequested(strings.NewReader(metrics), target)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(requests).To(HaveLen(4))
+
+	requests, err = parseRequested(strings.NewReader("# no metrics\n"), target)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(requests).To(BeEmpty())
+}
+
+const manifest = `# a comment
+apiVersion: networking.k8s.io/v1beta1
+kind: Ingress
+metadata:
+  name: "dashboard"
+  namespace: kube-system
+  labels:
+    name: ignored
+spec:
+  rules: []
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: current
+---
+apiVersion: {{ .apiVersion }}
+kind: Deployment
+metadata:
+  name: templated
+---
+apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+`
+
+func TestScanYAML(t *testing.T) {
+	g := NewWithT(t)
+	target, _ := ParseVersion("v1.22.0")
+	findings := ScanYAML("nginx.yaml", manifest, target)
+	g.Expect(findings).To(HaveLen(2))
+	g.Expect(findings[0]).To(Equal(Finding{
+		APIVersion:  "networking.k8s.io/v1beta1",
+		Kind:        "Ingress",
+		Namespace:   "kube-system",
+		Name:        "dashboard",
+		File:        "nginx.yaml",
+		RemovedIn:   "v1.22",
+		Replacement: "networking.k8s.io/v1",
+	}))
+	g.Expect(findings[1].Name).To(Equal("widgets.example.com"))
+
+	target, _ = ParseVersion("v1.21.0")
+	g.Expect(ScanYAML("nginx.yaml", manifest, target)).To(BeEmpty())
+}
+
+func ingress(name string, managedFields []metav1.ManagedFieldsEntry, annotations map[string]string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("networking.k8s.io/v1")
+	obj.SetKind("Ingress")
+	obj.SetNamespace("default")
+	obj.SetName(name)
+	obj.SetManagedFields(managedFields)
+	obj.SetAnnotations(annotations)
+	return obj
+}
+
+func TestScanObject(t *testing.T) {
+	g := NewWithT(t)
+	target, _ := ParseVersion("v1.22.0")
+	obj := ingress("web", []metav1.ManagedFieldsEntry{
+		{Manager: "helm", APIVersion: "extensions/v1beta1"},
+		{Manager: "nginx-ingress-controller", APIVersion: "networking.k8s.io/v1"},
+	}, map[string]string{
+		helmReleaseAnnotation: "web",
+		lastAppliedAnnotation: `{"apiVersion":"networking.k8s.io/v1beta1","kind":"Ingress"}`,
+	})
+	findings := ScanObject(obj, "Ingress", target)
+	g.Expect(findings).To(HaveLen(2))
+	g.Expect(findings[0].APIVersion).To(Equal("extensions/v1beta1"))
+	g.Expect(findings[0].Manager).To(Equal("helm (helm release web)"))
+	g.Expect(findings[1].APIVersion).To(Equal("networking.k8s.io/v1beta1"))
+	g.Expect(findings[1].Manager).To(Equal("kubectl apply"))
+}
+
+// preferredDiscovery returns the fake resources as the preferred resources
+type preferredDiscovery struct {
+	*fakediscovery.FakeDiscovery
+}
+
+func (d preferredDiscovery) ServerPreferredResources() ([]*metav1.APIResourceList, error) {
+	return d.Resources, nil
+}
+
+func TestScanCluster(t *testing.T) {
+	g := NewWithT(t)
+	target, _ := ParseVersion("v1.22.0")
+	ingresses := schema.GroupVersionResource{Group: "networking.k8s.io", Version: "v1", Resource: "ingresses"}
+	dynamicClient := fakedynamic.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{ingresses: "IngressList"},
+		ingress("old", []metav1.ManagedFieldsEntry{{Manager: "kubectl", APIVersion: "extensions/v1beta1"}}, nil),
+		ingress("new", []metav1.ManagedFieldsEntry{{Manager: "kubectl", APIVersion: "networking.k8s.io/v1"}}, nil),
+	)
+	client := preferredDiscovery{&fakediscovery.FakeDiscovery{Fake: &k8stesting.Fake{Resources: []*metav1.APIResourceList{{
+		GroupVersion: "networking.k8s.io/v1",
+		APIResources: []metav1.APIResource{
+			{Name: "ingresses", Kind: "Ingress", Namespaced: true, Verbs: []string{"list"}},
+			{Name: "ingresses/status", Kind: "Ingress", Namespaced: true, Verbs: []string{"get"}},
+		},
+	}}}}}
+
+	findings, err := ScanCluster(client, dynamicClient, target)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(findings).To(HaveLen(1))
+	g.Expect(findings[0].Name).To(Equal("old"))
+	g.Expect(findings[0].Namespace).To(Equal("default"))
+	g.Expect(findings[0].Replacement).To(Equal("networking.k8s.io/v1"))
+}
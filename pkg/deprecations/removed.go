@@ -0,0 +1,131 @@
+package deprecations
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/blang/semver/v4"
+)
+
+// Removal is a served API version that is removed in a Kubernetes release
+type Removal struct {
+	Group    string `json:"group"`
+	Version  string `json:"version"`
+	Kind     string `json:"kind"`
+	Resource string `json:"resource"`
+	// RemovedIn is the first release that no longer serves the API version
+	RemovedIn string `json:"removedIn"`
+	// Replacement is the apiVersion to migrate to, or empty if the API is removed without a replacement
+	Replacement string `json:"replacement,omitempty"`
+}
+
+// APIVersion returns the group/version of the removed API
+func (r Removal) APIVersion() string {
+	if r.Group == "" {
+		return r.Version
+	}
+	return r.Group + "/" + r.Version
+}
+
+func (r Removal) String() string {
+	replacement := r.Replacement
+	if replacement == "" {
+		replacement = "no replacement"
+	}
+	return fmt.Sprintf("%s %s is removed in %s, use %s", r.APIVersion(), r.Kind, r.RemovedIn, replacement)
+}
+
+// Removals are the API versions removed since the API machinery deprecation policy was introduced
+var Removals = []Removal{
+	{"extensions", "v1beta1", "DaemonSet", "daemonsets", "v1.16", "apps/v1"},
+	{"extensions", "v1beta1", "Deployment", "deployments", "v1.16", "apps/v1"},
+	{"extensions", "v1beta1", "ReplicaSet", "replicasets", "v1.16", "apps/v1"},
+	{"extensions", "v1beta1", "NetworkPolicy", "networkpolicies", "v1.16", "networking.k8s.io/v1"},
+	{"extensions", "v1beta1", "PodSecurityPolicy", "podsecuritypolicies", "v1.16", "policy/v1beta1"},
+	{"apps", "v1beta1", "Deployment", "deployments", "v1.16", "apps/v1"},
+	{"apps", "v1beta1", "StatefulSet", "statefulsets", "v1.16", "apps/v1"},
+	{"apps", "v1beta2", "DaemonSet", "daemonsets", "v1.16", "apps/v1"},
+	{"apps", "v1beta2", "Deployment", "deployments", "v1.16", "apps/v1"},
+	{"apps", "v1beta2", "ReplicaSet", "replicasets", "v1.16", "apps/v1"},
+	{"apps", "v1beta2", "StatefulSet", "statefulsets", "v1.16", "apps/v1"},
+	{"extensions", "v1beta1", "Ingress", "ingresses", "v1.22", "networking.k8s.io/v1"},
+	{"networking.k8s.io", "v1beta1", "Ingress", "ingresses", "v1.22", "networking.k8s.io/v1"},
+	{"networking.k8s.io", "v1beta1", "IngressClass", "ingressclasses", "v1.22", "networking.k8s.io/v1"},
+	{"apiextensions.k8s.io", "v1beta1", "CustomResourceDefinition", "customresourcedefinitions", "v1.22", "apiextensions.k8s.io/v1"},
+	{"admissionregistration.k8s.io", "v1beta1", "MutatingWebhookConfiguration", "mutatingwebhookconfigurations", "v1.22", "admissionregistration.k8s.io/v1"},
+	{"admissionregistration.k8s.io", "v1beta1", "ValidatingWebhookConfiguration", "validatingwebhookconfigurations", "v1.22", "admissionregistration.k8s.io/v1"},
+	{"apiregistration.k8s.io", "v1beta1", "APIService", "apiservices", "v1.22", "apiregistration.k8s.io/v1"},
+	{"authentication.k8s.io", "v1beta1", "TokenReview", "tokenreviews", "v1.22", "authentication.k8s.io/v1"},
+	{"authorization.k8s.io", "v1beta1", "SubjectAccessReview", "subjectaccessreviews", "v1.22", "authorization.k8s.io/v1"},
+	{"authorization.k8s.io", "v1beta1", "LocalSubjectAccessReview", "localsubjectaccessreviews", "v1.22", "authorization.k8s.io/v1"},
+	{"authorization.k8s.io", "v1beta1", "SelfSubjectAccessReview", "selfsubjectaccessreviews", "v1.22", "authorization.k8s.io/v1"},
+	{"certificates.k8s.io", "v1beta1", "CertificateSigningRequest", "certificatesigningrequests", "v1.22", "certificates.k8s.io/v1"},
+	{"coordination.k8s.io", "v1beta1", "Lease", "leases", "v1.22", "coordination.k8s.io/v1"},
+	{"rbac.authorization.k8s.io", "v1beta1", "ClusterRole", "clusterroles", "v1.22", "rbac.authorization.k8s.io/v1"},
+	{"rbac.authorization.k8s.io", "v1beta1", "ClusterRoleBinding", "clusterrolebindings", "v1.22", "rbac.authorization.k8s.io/v1"},
+	{"rbac.authorization.k8s.io", "v1beta1", "Role", "roles", "v1.22", "rbac.authorization.k8s.io/v1"},
+	{"rbac.authorization.k8s.io", "v1beta1", "RoleBinding", "rolebindings", "v1.22", "rbac.authorization.k8s.io/v1"},
+	{"scheduling.k8s.io", "v1beta1", "PriorityClass", "priorityclasses", "v1.22", "scheduling.k8s.io/v1"},
+	{"storage.k8s.io", "v1beta1", "CSIDriver", "csidrivers", "v1.22", "storage.k8s.io/v1"},
+	{"storage.k8s.io", "v1beta1", "CSINode", "csinodes", "v1.22", "storage.k8s.io/v1"},
+	{"storage.k8s.io", "v1beta1", "StorageClass", "storageclasses", "v1.22", "storage.k8s.io/v1"},
+	{"storage.k8s.io", "v1beta1", "VolumeAttachment", "volumeattachments", "v1.22", "storage.k8s.io/v1"},
+	{"batch", "v1beta1", "CronJob", "cronjobs", "v1.25", "batch/v1"},
+	{"discovery.k8s.io", "v1beta1", "EndpointSlice", "endpointslices", "v1.25", "discovery.k8s.io/v1"},
+	{"events.k8s.io", "v1beta1", "Event", "events", "v1.25", "events.k8s.io/v1"},
+	{"autoscaling", "v2beta1", "HorizontalPodAutoscaler", "horizontalpodautoscalers", "v1.25", "autoscaling/v2"},
+	{"policy", "v1beta1", "PodDisruptionBudget", "poddisruptionbudgets", "v1.25", "policy/v1"},
+	{"policy", "v1beta1", "PodSecurityPolicy", "podsecuritypolicies", "v1.25", ""},
+	{"node.k8s.io", "v1beta1", "RuntimeClass", "runtimeclasses", "v1.25", "node.k8s.io/v1"},
+	{"autoscaling", "v2beta2", "HorizontalPodAutoscaler", "horizontalpodautoscalers", "v1.26", "autoscaling/v2"},
+	{"flowcontrol.apiserver.k8s.io", "v1beta1", "FlowSchema", "flowschemas", "v1.26", "flowcontrol.apiserver.k8s.io/v1beta3"},
+	{"flowcontrol.apiserver.k8s.io", "v1beta1", "PriorityLevelConfiguration", "prioritylevelconfigurations", "v1.26", "flowcontrol.apiserver.k8s.io/v1beta3"},
+	{"storage.k8s.io", "v1beta1", "CSIStorageCapacity", "csistoragecapacities", "v1.27", "storage.k8s.io/v1"},
+}
+
+// ParseVersion parses a Kubernetes version such as v1.20.4 or 1.20, ignoring pre-release and build metadata
+func ParseVersion(version string) (semver.Version, error) {
+	v, err := semver.ParseTolerant(version)
+	if err != nil {
+		return v, fmt.Errorf("invalid kubernetes version %q: %v", version, err)
+	}
+	v.Pre = nil
+	v.Build = nil
+	return v, nil
+}
+
+// RemovedBy returns true if the removal takes effect in or before the target version
+func (r Removal) RemovedBy(target semver.Version) bool {
+	removedIn, err := ParseVersion(r.RemovedIn)
+	if err != nil {
+		return false
+	}
+	return removedBy(removedIn, target)
+}
+
+// removedBy compares the major and minor versions, patch releases never remove APIs
+func removedBy(removedIn, target semver.Version) bool {
+	return target.Major > removedIn.Major || (target.Major == removedIn.Major && target.Minor >= removedIn.Minor)
+}
+
+// Find returns the removal of the kind in apiVersion that takes effect in or before target, or nil
+func Find(apiVersion, kind string, target semver.Version) *Removal {
+	for _, r := range Removals {
+		if r.APIVersion() == apiVersion && strings.EqualFold(r.Kind, kind) && r.RemovedBy(target) {
+			removal := r
+			return &removal
+		}
+	}
+	return nil
+}
+
+// FindResource returns the removal of the resource in group/version that takes effect in or before target, or nil
+func FindResource(group, version, resource string, target semver.Version) *Removal {
+	for _, r := range Removals {
+		if r.Group == group && r.Version == version && r.Resource == resource && r.RemovedBy(target) {
+			removal := r
+			return &removal
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package deprecations
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/blang/semver/v4"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/expfmt"
+	"k8s.io/client-go/kubernetes"
+)
+
+// requestedMetric is set by the API server (v1.19+) for each deprecated API that has been requested since it started
+const requestedMetric = "apiserver_requested_deprecated_apis"
+
+// Request is a deprecated API that clients have requested from the API server
+type Request struct {
+	Group       string `json:"group"`
+	Version     string `json:"version"`
+	Resource    string `json:"resource"`
+	Subresource string `json:"subresource,omitempty"`
+	// RemovedIn is the release the API server reports the API is removed in
+	RemovedIn   string `json:"removedIn"`
+	Replacement string `json:"replacement,omitempty"`
+}
+
+// APIVersion returns the group/version of the requested API
+func (r Request) APIVersion() string {
+	if r.Group == "" {
+		return r.Version
+	}
+	return r.Group + "/" + r.Version
+}
+
+// Requested returns the deprecated APIs requested from the API server that are removed in or before target,
+// the metric only covers the API server instance that serves the request and is reset when it restarts
+func Requested(client kubernetes.Interface, target semver.Version) ([]Request, error) {
+	data, err := client.Discovery().RESTClient().Get().AbsPath("/metrics").DoRaw(context.TODO())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get API server metrics")
+	}
+	return parseRequested(bytes.NewReader(data), target)
+}
+
+func parseRequested(reader io.Reader, target semver.Version) ([]Request, error) {
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse API server metrics")
+	}
+	family, ok := families[requestedMetric]
+	if !ok {
+		return nil, nil
+	}
+	var requests []Request
+	for _, metric := range family.Metric {
+		request := Request{}
+		for _, label := range metric.Label {
+			switch label.GetName() {
+			case "group":
+				request.Group = label.GetValue()
+			case "version":
+				request.Version = label.GetValue()
+			case "resource":
+				request.Resource = label.GetValue()
+			case "subresource":
+				request.Subresource = label.GetValue()
+			case "removed_release":
+				request.RemovedIn = label.GetValue()
+			}
+		}
+		if metric.Gauge != nil && metric.Gauge.GetValue() == 0 {
+			continue
+		}
+		removal := FindResource(request.Group, request.Version, request.Resource, target)
+		if removal != nil {
+			request.RemovedIn = removal.RemovedIn
+			request.Replacement = removal.Replacement
+		} else if request.RemovedIn == "" {
+			continue
+		} else if removedIn, err := ParseVersion(request.RemovedIn); err != nil || !removedBy(removedIn, target) {
+			continue
+		}
+		requests = append(requests, request)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		if requests[i].APIVersion() != requests[j].APIVersion() {
+			return requests[i].APIVersion() < requests[j].APIVersion()
+		}
+		return requests[i].Resource < requests[j].Resource
+	})
+	return requests, nil
+}
+
+// PrintRequested writes a table of requested APIs to w
+func PrintRequested(w io.Writer, requests []Request) {
+	table := tabwriter.NewWriter(w, 3, 2, 3, ' ', 0)
+	fmt.Fprintf(table, "API VERSION\tRESOURCE\tREMOVED IN\tREPLACEMENT\n")
+	for _, r := range requests {
+		resource := r.Resource
+		if r.Subresource != "" {
+			resource += "/" + r.Subresource
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", r.APIVersion(), resource, r.RemovedIn, r.Replacement)
+	}
+	_ = table.Flush()
+}
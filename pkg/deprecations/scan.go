@@ -0,0 +1,287 @@
+package deprecations
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/blang/semver/v4"
+	"github.com/flanksource/karina/manifests"
+	"github.com/flanksource/karina/pkg/platform"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+	helmReleaseAnnotation = "meta.helm.sh/release-name"
+)
+
+// Finding is an object, or a manifest for one, that uses an API version removed in the target version
+type Finding struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+	// File is the manifest under manifests/ the object is defined in
+	File string `json:"file,omitempty"`
+	// Manager is the field manager, kubectl apply or helm release that used the removed API version
+	Manager     string `json:"manager,omitempty"`
+	RemovedIn   string `json:"removedIn"`
+	Replacement string `json:"replacement,omitempty"`
+}
+
+// Source returns where the removed API version was found
+func (f Finding) Source() string {
+	if f.File != "" {
+		return f.File
+	}
+	return f.Manager
+}
+
+func newFinding(apiVersion, kind, namespace, name string, removal *Removal) Finding {
+	return Finding{
+		APIVersion:  apiVersion,
+		Kind:        kind,
+		Namespace:   namespace,
+		Name:        name,
+		RemovedIn:   removal.RemovedIn,
+		Replacement: removal.Replacement,
+	}
+}
+
+// ScanObject returns the removed API versions that an object was last written with, according to its
+// managed fields and the last configuration applied by kubectl
+func ScanObject(obj metav1.Object, kind string, target semver.Version) []Finding {
+	var findings []Finding
+	helm := obj.GetAnnotations()[helmReleaseAnnotation]
+	for _, field := range obj.GetManagedFields() {
+		if removal := Find(field.APIVersion, kind, target); removal != nil {
+			finding := newFinding(field.APIVersion, kind, obj.GetNamespace(), obj.GetName(), removal)
+			finding.Manager = field.Manager
+			if helm != "" {
+				finding.Manager = fmt.Sprintf("%s (helm release %s)", field.Manager, helm)
+			}
+			findings = append(findings, finding)
+		}
+	}
+	if applied, ok := obj.GetAnnotations()[lastAppliedAnnotation]; ok {
+		var typeMeta metav1.TypeMeta
+		if err := json.Unmarshal([]byte(applied), &typeMeta); err == nil {
+			if removal := Find(typeMeta.APIVersion, kind, target); removal != nil {
+				finding := newFinding(typeMeta.APIVersion, kind, obj.GetNamespace(), obj.GetName(), removal)
+				finding.Manager = "kubectl apply"
+				findings = append(findings, finding)
+			}
+		}
+	}
+	return dedupe(findings)
+}
+
+// ScanCluster lists the objects of every kind with an API version removed in target, using discovery
+// to find a version of the kind that is still served
+func ScanCluster(client discovery.DiscoveryInterface, dynamicClient dynamic.Interface, target semver.Version) ([]Finding, error) {
+	resources, err := client.ServerPreferredResources()
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return nil, errors.Wrap(err, "failed to discover API resources")
+	}
+	// the preferred version of each group/resource
+	preferred := map[string]schema.GroupVersionResource{}
+	for _, list := range resources {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, resource := range list.APIResources {
+			if strings.Contains(resource.Name, "/") || !contains(resource.Verbs, "list") {
+				continue
+			}
+			preferred[gv.Group+"/"+resource.Name] = gv.WithResource(resource.Name)
+		}
+	}
+
+	var findings []Finding
+	listed := map[schema.GroupVersionResource]bool{}
+	for _, removal := range Removals {
+		if !removal.RemovedBy(target) {
+			continue
+		}
+		// objects written with a removed version can be read through any version of the kind
+		groups := []string{removal.Group}
+		if replacement, err := schema.ParseGroupVersion(removal.Replacement); err == nil && removal.Replacement != "" {
+			groups = []string{replacement.Group, removal.Group}
+		}
+		for _, group := range groups {
+			gvr, ok := preferred[group+"/"+removal.Resource]
+			if !ok {
+				continue
+			}
+			if !listed[gvr] {
+				listed[gvr] = true
+				list, err := dynamicClient.Resource(gvr).List(context.TODO(), metav1.ListOptions{})
+				if err != nil {
+					return nil, errors.Wrapf(err, "failed to list %s", gvr)
+				}
+				for i := range list.Items {
+					findings = append(findings, ScanObject(&list.Items[i], removal.Kind, target)...)
+				}
+			}
+			break
+		}
+	}
+	findings = dedupe(findings)
+	sortFindings(findings)
+	return findings, nil
+}
+
+// ScanManifests templates every manifest under manifests/ and returns the objects using removed API versions,
+// manifests that cannot be templated (e.g. without access to the cluster) are scanned as is
+func ScanManifests(platform *platform.Platform, target semver.Version) ([]Finding, error) {
+	var findings []Finding
+	untemplated := 0
+	err := fs.WalkDir(manifests.EmbeddedContent, ".", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || !isYAML(path) {
+			return err
+		}
+		content, err := platform.Template(path, "manifests")
+		if err != nil {
+			platform.Debugf("Scanning %s without templating: %v", path, err)
+			untemplated++
+			if content, err = platform.GetResourceByName(path, "manifests"); err != nil {
+				return err
+			}
+		}
+		findings = append(findings, ScanYAML(path, content, target)...)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to scan manifests")
+	}
+	if untemplated > 0 {
+		platform.Warnf("%d manifests could not be templated and were scanned as is", untemplated)
+	}
+	sortFindings(findings)
+	return findings, nil
+}
+
+func isYAML(path string) bool {
+	path = strings.TrimSuffix(path, ".raw")
+	return strings.HasSuffix(path, ".yaml") || strings.HasSuffix(path, ".yml")
+}
+
+// ScanYAML returns the documents in a multi-document YAML file that use removed API versions. Only the
+// top level apiVersion, kind and metadata fields are read so that files that still contain template
+// directives can be scanned
+func ScanYAML(file, content string, target semver.Version) []Finding {
+	var findings []Finding
+	var apiVersion, kind, namespace, name string
+	inMetadata := false
+	flush := func() {
+		if removal := Find(apiVersion, kind, target); removal != nil {
+			finding := newFinding(apiVersion, kind, namespace, name, removal)
+			finding.File = file
+			findings = append(findings, finding)
+		}
+		apiVersion, kind, namespace, name = "", "", "", ""
+		inMetadata = false
+	}
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	scanner.Buffer(make([]byte, 1024*1024), 10*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if strings.HasPrefix(line, "---") {
+			flush()
+			continue
+		}
+		if line == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		if !strings.HasPrefix(line, " ") {
+			inMetadata = line == "metadata:"
+			if value, ok := field(line, "apiVersion"); ok {
+				apiVersion = value
+			} else if value, ok := field(line, "kind"); ok {
+				kind = value
+			}
+			continue
+		}
+		if inMetadata && strings.HasPrefix(line, "  ") && !strings.HasPrefix(line, "   ") {
+			if value, ok := field(line[2:], "name"); ok {
+				name = value
+			} else if value, ok := field(line[2:], "namespace"); ok {
+				namespace = value
+			}
+		}
+	}
+	flush()
+	return findings
+}
+
+// field returns the value of key: value
+func field(line, key string) (string, bool) {
+	if !strings.HasPrefix(line, key+":") {
+		return "", false
+	}
+	return strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, key+":")), `"'`), true
+}
+
+func dedupe(findings []Finding) []Finding {
+	seen := map[Finding]bool{}
+	var unique []Finding
+	for _, finding := range findings {
+		if !seen[finding] {
+			seen[finding] = true
+			unique = append(unique, finding)
+		}
+	}
+	return unique
+}
+
+func sortFindings(findings []Finding) {
+	sort.SliceStable(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// PrintFindings writes a table of findings to w
+func PrintFindings(w io.Writer, findings []Finding) {
+	table := tabwriter.NewWriter(w, 3, 2, 3, ' ', 0)
+	fmt.Fprintf(table, "KIND\tNAME\tAPI VERSION\tREPLACEMENT\tREMOVED IN\tSOURCE\n")
+	for _, f := range findings {
+		name := f.Name
+		if f.Namespace != "" {
+			name = f.Namespace + "/" + f.Name
+		}
+		replacement := f.Replacement
+		if replacement == "" {
+			replacement = "none"
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n", f.Kind, name, f.APIVersion, replacement, f.RemovedIn, f.Source())
+	}
+	_ = table.Flush()
+}
@@ -0,0 +1,54 @@
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/flanksource/karina/pkg/phases/kubeadm"
+	"github.com/flanksource/karina/pkg/platform"
+	kommonsetcd "github.com/flanksource/kommons/etcd"
+	"github.com/pkg/errors"
+	"go.etcd.io/etcd/clientv3"
+)
+
+// withClient calls fn with a client for the etcd member running on a master node, using the etcd CA
+// uploaded by kubeadm.UploadEtcdCerts, if node is empty the first master is used
+func withClient(platform *platform.Platform, node string, fn func(client *kommonsetcd.Client) error) error {
+	if node == "" {
+		master, err := platform.GetMasterNode()
+		if err != nil {
+			return errors.Wrap(err, "failed to get master node")
+		}
+		node = master
+	}
+	ca, err := kubeadm.UploadEtcdCerts(platform)
+	if err != nil {
+		return errors.Wrap(err, "failed to get etcd certs")
+	}
+	generator, err := platform.GetEtcdClientGenerator(ca)
+	if err != nil {
+		return errors.Wrap(err, "failed to get etcd client generator")
+	}
+	ctx, cancel := timeout()
+	defer cancel()
+	client, err := generator.ForNode(ctx, node)
+	if err != nil {
+		return errors.Wrapf(err, "failed to connect to etcd on %s", node)
+	}
+	defer client.Close() // nolint: errcheck
+	return fn(client)
+}
+
+// unwrap returns the underlying etcd client for the maintenance calls that kommons does not wrap
+func unwrap(client *kommonsetcd.Client) (*clientv3.Client, error) {
+	adapter, ok := client.EtcdClient.(*kommonsetcd.EtcdBackoffAdapter)
+	if !ok {
+		return nil, fmt.Errorf("unsupported etcd client %T", client.EtcdClient)
+	}
+	return adapter.EtcdClient, nil
+}
+
+func timeout() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 30*time.Second)
+}
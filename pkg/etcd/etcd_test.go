@@ -0,0 +1,36 @@
+package etcd
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestFormatBytes(t *testing.T) {
+	g := NewWithT(t)
+	g.Expect(formatBytes(0)).To(Equal(""))
+	g.Expect(formatBytes(512)).To(Equal("512B"))
+	g.Expect(formatBytes(2048)).To(Equal("2.0KiB"))
+	g.Expect(formatBytes(150 * 1024 * 1024)).To(Equal("150.0MiB"))
+}
+
+func TestMemberHexID(t *testing.T) {
+	g := NewWithT(t)
+	g.Expect(Member{ID: 0x8e9e05c52164694d}.HexID()).To(Equal("8e9e05c52164694d"))
+}
+
+func TestSelectMembers(t *testing.T) {
+	g := NewWithT(t)
+	members := []Member{{ID: 1, Name: "master-a"}, {ID: 2, Name: "master-b"}, {ID: 3}}
+
+	all, err := selectMembers(members, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(all).To(Equal(map[string]bool{"master-a": true, "master-b": true}))
+
+	selected, err := selectMembers(members, []string{"master-b"})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(selected).To(Equal(map[string]bool{"master-b": true}))
+
+	_, err = selectMembers(members, []string{"master-b", "master-c"})
+	g.Expect(err).To(MatchError("no etcd member named master-c"))
+}
@@ -0,0 +1,236 @@
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/flanksource/karina/pkg/platform"
+	kommonsetcd "github.com/flanksource/kommons/etcd"
+	"github.com/pkg/errors"
+)
+
+// Member is the membership and status of an etcd member, kubeadm names members after their node
+type Member struct {
+	ID         uint64   `json:"id"`
+	Name       string   `json:"name"`
+	PeerURLs   []string `json:"peerURLs"`
+	ClientURLs []string `json:"clientURLs"`
+	IsLearner  bool     `json:"isLearner,omitempty"`
+	IsLeader   bool     `json:"isLeader,omitempty"`
+	Version    string   `json:"version,omitempty"`
+	// DBSize is the allocated size of the database in bytes, and DBSizeInUse the logical size
+	DBSize      int64    `json:"dbSize,omitempty"`
+	DBSizeInUse int64    `json:"dbSizeInUse,omitempty"`
+	Alarms      []string `json:"alarms,omitempty"`
+	// Error is set if the status of the member could not be retrieved
+	Error string `json:"error,omitempty"`
+}
+
+// HexID returns the ID in the hexadecimal form used by etcdctl
+func (m Member) HexID() string {
+	return strconv.FormatUint(m.ID, 16)
+}
+
+// GetMembers returns the members of the etcd cluster with the status of each member that runs on a master node
+func GetMembers(platform *platform.Platform) ([]Member, error) {
+	var members []Member
+	if err := withClient(platform, "", func(client *kommonsetcd.Client) error {
+		ctx, cancel := timeout()
+		defer cancel()
+		list, err := client.Members(ctx)
+		if err != nil {
+			return err
+		}
+		for _, m := range list {
+			member := Member{ID: m.ID, Name: m.Name, PeerURLs: m.PeerURLs, ClientURLs: m.ClientURLs, IsLearner: m.IsLearner}
+			for _, alarm := range m.Alarms {
+				if alarm != kommonsetcd.AlarmOk {
+					member.Alarms = append(member.Alarms, string(alarm))
+				}
+			}
+			members = append(members, member)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	masters, err := platform.GetMasterNodes()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get master nodes")
+	}
+	isMaster := map[string]bool{}
+	for _, master := range masters {
+		isMaster[master] = true
+	}
+	for i := range members {
+		member := &members[i]
+		if member.Name == "" {
+			member.Error = "not started"
+			continue
+		}
+		if !isMaster[member.Name] {
+			member.Error = "no master node"
+			continue
+		}
+		if err := withClient(platform, member.Name, func(client *kommonsetcd.Client) error {
+			ctx, cancel := timeout()
+			defer cancel()
+			status, err := client.EtcdClient.Status(ctx, client.Endpoint)
+			if err != nil {
+				return err
+			}
+			member.Version = status.Version
+			member.DBSize = status.DbSize
+			member.DBSizeInUse = status.DbSizeInUse
+			member.IsLeader = client.IsLeader
+			return nil
+		}); err != nil {
+			member.Error = err.Error()
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+	return members, nil
+}
+
+// PrintMembers writes a table of members to w
+func PrintMembers(w io.Writer, members []Member) {
+	table := tabwriter.NewWriter(w, 3, 2, 3, ' ', 0)
+	fmt.Fprintf(table, "ID\tNAME\tLEADER\tLEARNER\tVERSION\tDB SIZE\tIN USE\tALARMS\tPEER URLS\tERROR\n")
+	for _, m := range members {
+		fmt.Fprintf(table, "%s\t%s\t%v\t%v\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			m.HexID(), m.Name, m.IsLeader, m.IsLearner, m.Version, formatBytes(m.DBSize), formatBytes(m.DBSizeInUse),
+			strings.Join(m.Alarms, ","), strings.Join(m.PeerURLs, ","), m.Error)
+	}
+	_ = table.Flush()
+}
+
+// RemoveMember removes a member by name or hexadecimal ID, refusing to remove the last member or a member
+// that is still healthy unless force is true
+func RemoveMember(platform *platform.Platform, nameOrID string, force bool) error {
+	members, err := GetMembers(platform)
+	if err != nil {
+		return err
+	}
+	var target *Member
+	for i, m := range members {
+		if m.Name == nameOrID || m.HexID() == nameOrID {
+			target = &members[i]
+		}
+	}
+	if target == nil {
+		return fmt.Errorf("no etcd member named %s", nameOrID)
+	}
+	if len(members) == 1 {
+		return fmt.Errorf("refusing to remove %s, it is the only etcd member", target.Name)
+	}
+	if target.Error == "" && !force {
+		return fmt.Errorf("refusing to remove %s as it is healthy, terminate the master instead or use --force", target.Name)
+	}
+
+	// connect through any other healthy member
+	via := ""
+	for _, m := range members {
+		if m.ID != target.ID && m.Error == "" {
+			via = m.Name
+			break
+		}
+	}
+	if via == "" {
+		return fmt.Errorf("no healthy etcd members to remove %s through", target.Name)
+	}
+	platform.Infof("Removing etcd member %s (%s) via %s", target.Name, target.HexID(), via)
+	return withClient(platform, via, func(client *kommonsetcd.Client) error {
+		ctx, cancel := timeout()
+		defer cancel()
+		return client.RemoveMember(ctx, target.ID)
+	})
+}
+
+// Defrag defragments the members on the given master nodes one at a time, or all members if nodes is empty,
+// the leader is defragmented last
+func Defrag(platform *platform.Platform, nodes []string) error {
+	members, err := GetMembers(platform)
+	if err != nil {
+		return err
+	}
+	selected, err := selectMembers(members, nodes)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(members, func(i, j int) bool { return !members[i].IsLeader && members[j].IsLeader })
+	for _, m := range members {
+		if !selected[m.Name] {
+			continue
+		}
+		if m.Error != "" {
+			return fmt.Errorf("cannot defragment %s: %s", m.Name, m.Error)
+		}
+		if err := withClient(platform, m.Name, func(client *kommonsetcd.Client) error {
+			raw, err := unwrap(client)
+			if err != nil {
+				return err
+			}
+			platform.Infof("[%s] defragmenting, %s allocated, %s in use", m.Name, formatBytes(m.DBSize), formatBytes(m.DBSizeInUse))
+			// defragmenting blocks the member and can take a while for large databases
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+			defer cancel()
+			if _, err := raw.Defragment(ctx, client.Endpoint); err != nil {
+				return errors.Wrapf(err, "failed to defragment %s", m.Name)
+			}
+			status, err := raw.Status(ctx, client.Endpoint)
+			if err != nil {
+				return err
+			}
+			platform.Infof("[%s] defragmented, %s allocated", m.Name, formatBytes(status.DbSize))
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selectMembers returns the names of the members to operate on, all members if nodes is empty,
+// or an error if a node is not an etcd member
+func selectMembers(members []Member, nodes []string) (map[string]bool, error) {
+	names := map[string]bool{}
+	for _, m := range members {
+		if m.Name != "" {
+			names[m.Name] = true
+		}
+	}
+	if len(nodes) == 0 {
+		return names, nil
+	}
+	selected := map[string]bool{}
+	for _, node := range nodes {
+		if !names[node] {
+			return nil, fmt.Errorf("no etcd member named %s", node)
+		}
+		selected[node] = true
+	}
+	return selected, nil
+}
+
+func formatBytes(b int64) string {
+	if b == 0 {
+		return ""
+	}
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%dB", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,105 @@
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/flanksource/karina/pkg/platform"
+	"github.com/flanksource/karina/pkg/s3url"
+	kommonsetcd "github.com/flanksource/kommons/etcd"
+	minio "github.com/minio/minio-go/v6"
+	"github.com/pkg/errors"
+)
+
+type SnapshotOptions struct {
+	// Node is the master to take the snapshot from, defaults to the first master
+	Node string
+	// Destination is a local file, or s3://bucket/key to upload to the S3 connection in s3,
+	// destinations ending in / are suffixed with a timestamped file name
+	Destination string
+}
+
+// Snapshot streams a snapshot of etcd to a local file or S3 and returns where it was written to
+func Snapshot(platform *platform.Platform, opts SnapshotOptions) (string, error) {
+	destination := opts.Destination
+	if destination == "" || strings.HasSuffix(destination, "/") {
+		destination += fmt.Sprintf("etcd-%s-%s.db", platform.Name, time.Now().UTC().Format("20060102-150405"))
+	}
+	err := withClient(platform, opts.Node, func(client *kommonsetcd.Client) error {
+		raw, err := unwrap(client)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+		defer cancel()
+		reader, err := raw.Snapshot(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to take etcd snapshot")
+		}
+		defer reader.Close() // nolint: errcheck
+
+		if bucket, key, ok := s3url.Parse(destination); ok {
+			return upload(platform, bucket, key, reader)
+		}
+		file, err := os.Create(destination)
+		if err != nil {
+			return err
+		}
+		size, err := io.Copy(file, reader)
+		if err != nil {
+			file.Close() // nolint: errcheck
+			return errors.Wrapf(err, "failed to write snapshot to %s", destination)
+		}
+		platform.Infof("Wrote %s snapshot to %s", formatBytes(size), destination)
+		return file.Close()
+	})
+	if err != nil {
+		return "", err
+	}
+	return destination, nil
+}
+
+func upload(platform *platform.Platform, bucket, key string, reader io.Reader) error {
+	if err := platform.GetOrCreateBucket(bucket); err != nil {
+		return err
+	}
+	s3, err := platform.GetS3Client()
+	if err != nil {
+		return errors.Wrap(err, "failed to get S3 client")
+	}
+	size, err := s3.PutObject(bucket, key, reader, -1, minio.PutObjectOptions{ContentType: "application/octet-stream"})
+	if err != nil {
+		return errors.Wrapf(err, "failed to upload snapshot to s3://%s/%s", bucket, key)
+	}
+	platform.Infof("Uploaded %s snapshot to s3://%s/%s", formatBytes(size), bucket, key)
+	return nil
+}
+
+// SnapshotURL returns a URL that a new master can download a snapshot from, snapshots in S3 are
+// presigned for expiry
+func SnapshotURL(platform *platform.Platform, location string, expiry time.Duration) (string, error) {
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		return location, nil
+	}
+	bucket, key, ok := s3url.Parse(location)
+	if !ok {
+		return "", fmt.Errorf("snapshot %s must be an s3:// or http(s):// URL that a new master can download from", location)
+	}
+	s3, err := platform.GetS3Client()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get S3 client")
+	}
+	if _, err := s3.StatObject(bucket, key, minio.StatObjectOptions{}); err != nil {
+		return "", errors.Wrapf(err, "failed to find snapshot %s", location)
+	}
+	presigned, err := s3.PresignedGetObject(bucket, key, expiry, url.Values{})
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to presign %s", location)
+	}
+	return presigned.String(), nil
+}
@@ -0,0 +1,160 @@
+package machineimages
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/flanksource/karina/pkg/platform"
+	"github.com/flanksource/karina/pkg/s3url"
+	minio "github.com/minio/minio-go/v6"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Image is a machine image that VM's can be cloned from, the name is the name of the vSphere template,
+// content library item or libvirt volume
+type Image struct {
+	Name string `yaml:"name" json:"name"`
+	// Kubernetes is the version of the kubernetes packages installed in the image, e.g. v1.20.4
+	Kubernetes string `yaml:"kubernetes" json:"kubernetes"`
+	// OS is the distribution and version, e.g. ubuntu20.04
+	OS string `yaml:"os" json:"os"`
+	// KonfigadmHash is a hash of the konfigadm spec the image was built from
+	KonfigadmHash string    `yaml:"konfigadmHash,omitempty" json:"konfigadmHash,omitempty"`
+	Built         time.Time `yaml:"built" json:"built"`
+	// Pending images have been built but not uploaded to any provider yet, selectors never resolve to them
+	Pending bool `yaml:"pending,omitempty" json:"pending,omitempty"`
+	// Path is the file a pending image was built into
+	Path string `yaml:"path,omitempty" json:"path,omitempty"`
+}
+
+// Catalog is the list of machine images that have been built, stored in the location configured in machineImages.catalog
+type Catalog struct {
+	Images []Image `yaml:"images" json:"images"`
+
+	location string
+}
+
+// Load reads the catalogue, a catalogue that does not exist yet is empty
+func Load(platform *platform.Platform) (*Catalog, error) {
+	if platform.MachineImages == nil || platform.MachineImages.Catalog == "" {
+		return nil, fmt.Errorf("machineImages.catalog is not configured")
+	}
+	catalog := &Catalog{location: platform.MachineImages.Catalog}
+	data, err := read(platform, catalog.location)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read machine image catalogue %s", catalog.location)
+	}
+	if err := yaml.Unmarshal(data, catalog); err != nil {
+		return nil, errors.Wrapf(err, "invalid machine image catalogue %s", catalog.location)
+	}
+	return catalog, nil
+}
+
+// Save writes the catalogue back to where it was loaded from
+func (c *Catalog) Save(platform *platform.Platform) error {
+	sort.Slice(c.Images, func(i, j int) bool { return c.Images[i].Built.Before(c.Images[j].Built) })
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if bucket, key, ok := s3url.Parse(c.location); ok {
+		s3, err := platform.GetS3Client()
+		if err != nil {
+			return errors.Wrap(err, "failed to get S3 client")
+		}
+		if err := platform.GetOrCreateBucket(bucket); err != nil {
+			return err
+		}
+		if _, err := s3.PutObject(bucket, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{ContentType: "application/yaml"}); err != nil {
+			return errors.Wrapf(err, "failed to write machine image catalogue %s", c.location)
+		}
+		return nil
+	}
+	return ioutil.WriteFile(c.location, data, 0644)
+}
+
+func read(platform *platform.Platform, location string) ([]byte, error) {
+	bucket, key, ok := s3url.Parse(location)
+	if !ok {
+		data, err := ioutil.ReadFile(location)
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return data, err
+	}
+	s3, err := platform.GetS3Client()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get S3 client")
+	}
+	if _, err := s3.StatObject(bucket, key, minio.StatObjectOptions{}); err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" || minio.ToErrorResponse(err).Code == "NoSuchBucket" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	object, err := s3.GetObject(bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close() // nolint: errcheck
+	return ioutil.ReadAll(object)
+}
+
+// Add records an image, replacing any image with the same name
+func (c *Catalog) Add(image Image) {
+	c.Remove(image.Name)
+	c.Images = append(c.Images, image)
+}
+
+// Remove removes an image by name, returning false if it is not in the catalogue
+func (c *Catalog) Remove(name string) bool {
+	for i, image := range c.Images {
+		if image.Name == name {
+			c.Images = append(c.Images[:i], c.Images[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Get returns the image with the given name, or nil
+func (c *Catalog) Get(name string) *Image {
+	for i := range c.Images {
+		if c.Images[i].Name == name {
+			return &c.Images[i]
+		}
+	}
+	return nil
+}
+
+// HashKonfigadm returns a short hash of a konfigadm spec
+func HashKonfigadm(spec []byte) string {
+	sum := sha256.Sum256(spec)
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// Print writes a table of images to w, newest first, with the number of machines using each image
+func Print(w io.Writer, images []Image, inUse map[string]int) {
+	sorted := append([]Image{}, images...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Built.After(sorted[j].Built) })
+	table := tabwriter.NewWriter(w, 3, 2, 3, ' ', 0)
+	fmt.Fprintf(table, "NAME\tKUBERNETES\tOS\tKONFIGADM\tBUILT\tMACHINES\n")
+	for _, image := range sorted {
+		name := image.Name
+		if image.Pending {
+			name += " (not uploaded)"
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%d\n", name, image.Kubernetes, image.OS, image.KonfigadmHash,
+			image.Built.Format("2006-01-02 15:04"), inUse[image.Name])
+	}
+	_ = table.Flush()
+}
@@ -0,0 +1,104 @@
+package machineimages
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/flanksource/karina/pkg/platform"
+	"github.com/flanksource/karina/pkg/types"
+	. "github.com/onsi/gomega"
+)
+
+var built = time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+
+var images = []Image{
+	{Name: "ubuntu-1.19.10-a", Kubernetes: "v1.19.10", OS: "ubuntu20.04", Built: built},
+	{Name: "ubuntu-1.20.7-a", Kubernetes: "v1.20.7", OS: "ubuntu20.04", Built: built.Add(24 * time.Hour)},
+	{Name: "ubuntu-1.20.7-b", Kubernetes: "v1.20.7", OS: "ubuntu20.04", Built: built.Add(48 * time.Hour)},
+	{Name: "centos-1.20.7-a", Kubernetes: "v1.20.7", OS: "centos7", Built: built.Add(72 * time.Hour)},
+}
+
+func TestParseSelector(t *testing.T) {
+	g := NewWithT(t)
+	g.Expect(IsSelector("kube-v1.20.7")).To(BeFalse())
+	g.Expect(IsSelector("k8s=1.20")).To(BeTrue())
+	g.Expect(IsSelector("latest")).To(BeTrue())
+
+	selector, err := ParseSelector("k8s=v1.20, os=Ubuntu, latest")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(selector.Kubernetes).To(Equal("1.20"))
+	g.Expect(selector.OS).To(Equal("ubuntu"))
+	g.Expect(selector.Latest).To(BeTrue())
+
+	_, err = ParseSelector("arch=amd64")
+	g.Expect(err).To(HaveOccurred())
+	_, err = ParseSelector("k8s=")
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestSelect(t *testing.T) {
+	g := NewWithT(t)
+	selector, _ := ParseSelector("k8s=1.20,os=ubuntu,latest")
+	image, err := selector.Select(images)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(image.Name).To(Equal("ubuntu-1.20.7-b"))
+
+	selector, _ = ParseSelector("k8s=1.20,os=ubuntu")
+	_, err = selector.Select(images)
+	g.Expect(err).To(MatchError(ContainSubstring("matches 2 machine images")))
+
+	selector, _ = ParseSelector("k8s=1.2,latest")
+	_, err = selector.Select(images)
+	g.Expect(err).To(MatchError(ContainSubstring("no machine images match")))
+
+	pending := append([]Image{{Name: "ubuntu-1.21.1-a", Kubernetes: "v1.21.1", OS: "ubuntu20.04", Built: built.Add(96 * time.Hour), Pending: true}}, images...)
+	selector, _ = ParseSelector("os=ubuntu,latest")
+	image, err = selector.Select(pending)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(image.Name).To(Equal("ubuntu-1.20.7-b"), "images that are not uploaded are never selected")
+	selector, _ = ParseSelector("k8s=1.21")
+	_, err = selector.Select(pending)
+	g.Expect(err).To(MatchError(ContainSubstring("1 have been built but not uploaded")))
+
+	selector, _ = ParseSelector("k8s=1.19.10")
+	image, err = selector.Select(images)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(image.Name).To(Equal("ubuntu-1.19.10-a"))
+}
+
+func TestUnused(t *testing.T) {
+	g := NewWithT(t)
+	unused := Unused(images, map[string]bool{}, 1)
+	g.Expect(unused).To(HaveLen(1))
+	g.Expect(unused[0].Name).To(Equal("ubuntu-1.20.7-a"))
+
+	g.Expect(Unused(images, map[string]bool{"ubuntu-1.20.7-a": true}, 1)).To(BeEmpty())
+	g.Expect(Unused(images, map[string]bool{}, 2)).To(BeEmpty())
+}
+
+func TestCatalog(t *testing.T) {
+	g := NewWithT(t)
+	p := &platform.Platform{PlatformConfig: types.PlatformConfig{
+		MachineImages: &types.MachineImages{Catalog: filepath.Join(t.TempDir(), "catalog.yaml")},
+	}}
+	catalog, err := Load(p)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(catalog.Images).To(BeEmpty())
+
+	catalog.Add(images[1])
+	catalog.Add(images[0])
+	catalog.Add(Image{Name: images[0].Name, Kubernetes: "v1.19.11", OS: "ubuntu20.04", Built: built})
+	g.Expect(catalog.Images).To(HaveLen(2))
+	g.Expect(catalog.Save(p)).To(Succeed())
+
+	catalog, err = Load(p)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(catalog.Images).To(HaveLen(2))
+	g.Expect(catalog.Get(images[0].Name).Kubernetes).To(Equal("v1.19.11"))
+	g.Expect(catalog.Remove(images[0].Name)).To(BeTrue())
+	g.Expect(catalog.Remove(images[0].Name)).To(BeFalse())
+
+	_, err = Load(&platform.Platform{})
+	g.Expect(err).To(HaveOccurred())
+}
@@ -0,0 +1,135 @@
+package machineimages
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/blang/semver/v4"
+)
+
+// Selector selects an image from the catalogue by Kubernetes version and OS, e.g. k8s=1.20,os=ubuntu,latest
+type Selector struct {
+	// Kubernetes matches images with the same version, or with the same minor version if only major.minor is given
+	Kubernetes string
+	// OS matches images whose OS starts with the value, e.g. ubuntu matches ubuntu20.04
+	OS string
+	// Latest selects the most recently built of the matching images, without it the selector must match a single image
+	Latest bool
+
+	raw string
+}
+
+func (s Selector) String() string {
+	return s.raw
+}
+
+// IsSelector returns true if a VM template is a catalogue selector rather than the name of a template
+func IsSelector(template string) bool {
+	for _, term := range strings.Split(template, ",") {
+		term = strings.TrimSpace(term)
+		if term == "latest" || strings.Contains(term, "=") {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseSelector parses a comma separated list of k8s=<version>, os=<os> and latest
+func ParseSelector(s string) (Selector, error) {
+	selector := Selector{raw: s}
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		if term == "latest" {
+			selector.Latest = true
+			continue
+		}
+		parts := strings.SplitN(term, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return selector, fmt.Errorf("invalid selector term %q in %q, expected k8s=<version>, os=<os> or latest", term, s)
+		}
+		switch parts[0] {
+		case "k8s", "kubernetes":
+			selector.Kubernetes = strings.TrimPrefix(parts[1], "v")
+		case "os":
+			selector.OS = strings.ToLower(parts[1])
+		default:
+			return selector, fmt.Errorf("unknown selector key %q in %q, expected k8s or os", parts[0], s)
+		}
+	}
+	return selector, nil
+}
+
+// Matches returns true if the image is selected, ignoring Latest
+func (s Selector) Matches(image Image) bool {
+	if s.Kubernetes != "" {
+		version := strings.TrimPrefix(image.Kubernetes, "v")
+		if version != s.Kubernetes && !strings.HasPrefix(version, s.Kubernetes+".") {
+			return false
+		}
+	}
+	if s.OS != "" && !strings.HasPrefix(strings.ToLower(image.OS), s.OS) {
+		return false
+	}
+	return true
+}
+
+// Select returns the image selected from images, images that have not been uploaded are never selected
+func (s Selector) Select(images []Image) (*Image, error) {
+	var matches []Image
+	pending := 0
+	for _, image := range images {
+		if !s.Matches(image) {
+			continue
+		}
+		if image.Pending {
+			pending++
+			continue
+		}
+		matches = append(matches, image)
+	}
+	if len(matches) == 0 && pending > 0 {
+		return nil, fmt.Errorf("no uploaded machine images match %s, %d have been built but not uploaded", s, pending)
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no machine images match %s", s)
+	}
+	if len(matches) > 1 && !s.Latest {
+		var names []string
+		for _, image := range matches {
+			names = append(names, image.Name)
+		}
+		return nil, fmt.Errorf("%s matches %d machine images (%s), add latest to select the newest", s, len(matches), strings.Join(names, ", "))
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Built.After(matches[j].Built) })
+	return &matches[0], nil
+}
+
+// Unused returns the images that are neither in use nor among the keep most recently built images of their
+// OS and Kubernetes minor version, in the order they were built
+func Unused(images []Image, inUse map[string]bool, keep int) []Image {
+	sorted := append([]Image{}, images...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Built.After(sorted[j].Built) })
+	kept := map[string]int{}
+	var unused []Image
+	for _, image := range sorted {
+		group := image.OS + "/" + minorVersion(image.Kubernetes)
+		if kept[group] < keep {
+			kept[group]++
+			continue
+		}
+		if !inUse[image.Name] {
+			unused = append(unused, image)
+		}
+	}
+	sort.SliceStable(unused, func(i, j int) bool { return unused[i].Built.Before(unused[j].Built) })
+	return unused
+}
+
+func minorVersion(version string) string {
+	v, err := semver.ParseTolerant(version)
+	if err != nil {
+		return version
+	}
+	return fmt.Sprintf("v%d.%d", v.Major, v.Minor)
+}
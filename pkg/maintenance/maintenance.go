@@ -0,0 +1,180 @@
+package maintenance
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/flanksource/karina/pkg/types"
+	"github.com/pkg/errors"
+)
+
+// horizon is how far ahead Next searches for a maintenance window
+const horizon = 366 * 24 * time.Hour
+
+// Calendar decides when disruptive operations are allowed based on maintenance windows and freezes
+type Calendar struct {
+	location *time.Location
+	windows  []window
+	freezes  []freeze
+}
+
+type window struct {
+	schedule *schedule
+	duration time.Duration
+}
+
+type freeze struct {
+	name       string
+	start, end time.Time
+}
+
+type interval struct {
+	start, end time.Time
+}
+
+// RefusedError is returned when disruptive operations are not allowed
+type RefusedError struct {
+	Reason string
+	// Next is the next time disruptive operations are allowed, or zero if there is none within a year
+	Next time.Time
+}
+
+func (e *RefusedError) Error() string {
+	if e.Next.IsZero() {
+		return e.Reason + ", no maintenance window within the next year"
+	}
+	return fmt.Sprintf("%s, next allowed at %s", e.Reason, e.Next.Format(time.RFC3339))
+}
+
+// New parses a maintenance config, a nil config allows disruptive operations at any time
+func New(config *types.Maintenance) (*Calendar, error) {
+	calendar := &Calendar{location: time.UTC}
+	if config == nil {
+		return calendar, nil
+	}
+	if config.Timezone != "" {
+		location, err := time.LoadLocation(config.Timezone)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid maintenance timezone %s", config.Timezone)
+		}
+		calendar.location = location
+	}
+	for _, w := range config.Windows {
+		schedule, err := parseSchedule(w.Schedule)
+		if err != nil {
+			return nil, err
+		}
+		duration, err := time.ParseDuration(w.Duration)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid duration %q for maintenance window %s", w.Duration, w.Schedule)
+		}
+		calendar.windows = append(calendar.windows, window{schedule: schedule, duration: duration})
+	}
+	for _, f := range config.Freezes {
+		start, err := calendar.parseTime(f.Start, false)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid start of freeze %s", f.Name)
+		}
+		end, err := calendar.parseTime(f.End, true)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid end of freeze %s", f.Name)
+		}
+		if !end.After(start) {
+			return nil, fmt.Errorf("freeze %s ends before it starts", f.Name)
+		}
+		calendar.freezes = append(calendar.freezes, freeze{name: f.Name, start: start, end: end})
+	}
+	return calendar, nil
+}
+
+// parseTime parses an RFC3339 timestamp or a date in the calendar's timezone, dates are
+// inclusive when used as the end of a freeze
+func (c *Calendar) parseTime(s string, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, c.location)
+	if err != nil {
+		return t, fmt.Errorf("%q is not a date (2006-01-02) or RFC3339 timestamp", s)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// Check returns a RefusedError if disruptive operations are not allowed at now
+func (c *Calendar) Check(now time.Time) error {
+	reason := c.refused(now, c.windowsBetween(now, now))
+	if reason == "" {
+		return nil
+	}
+	next, _ := c.Next(now)
+	return &RefusedError{Reason: reason, Next: next}
+}
+
+// Next returns the first time at or after now that disruptive operations are allowed,
+// searching up to a year ahead
+func (c *Calendar) Next(now time.Time) (time.Time, bool) {
+	windows := c.windowsBetween(now, now.Add(horizon))
+	candidates := []time.Time{now}
+	for _, w := range windows {
+		if w.start.After(now) {
+			candidates = append(candidates, w.start)
+		}
+	}
+	for _, f := range c.freezes {
+		if f.end.After(now) {
+			candidates = append(candidates, f.end)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Before(candidates[j]) })
+	for _, t := range candidates {
+		if c.refused(t, windows) == "" {
+			return t.In(c.location), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// refused returns why disruptive operations are not allowed at t, or an empty string if they are
+func (c *Calendar) refused(t time.Time, windows []interval) string {
+	for _, f := range c.freezes {
+		if !t.Before(f.start) && t.Before(f.end) {
+			return fmt.Sprintf("change freeze %s is in effect until %s", f.name, f.end.In(c.location).Format(time.RFC3339))
+		}
+	}
+	if len(c.windows) == 0 {
+		return ""
+	}
+	for _, w := range windows {
+		if !t.Before(w.start) && t.Before(w.end) {
+			return ""
+		}
+	}
+	return "outside of maintenance windows"
+}
+
+// windowsBetween returns the maintenance windows that are open at any time between from and to
+func (c *Calendar) windowsBetween(from, to time.Time) []interval {
+	var longest time.Duration
+	for _, w := range c.windows {
+		if w.duration > longest {
+			longest = w.duration
+		}
+	}
+	var intervals []interval
+	// windows that started up to the longest duration ago may still be open
+	for t := from.In(c.location).Add(-longest).Truncate(time.Minute); !t.After(to); t = t.Add(time.Minute) {
+		for _, w := range c.windows {
+			if !w.schedule.matches(t) {
+				continue
+			}
+			if end := t.Add(w.duration); end.After(from) {
+				intervals = append(intervals, interval{start: t, end: end})
+			}
+		}
+	}
+	return intervals
+}
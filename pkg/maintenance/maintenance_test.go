@@ -0,0 +1,84 @@
+package maintenance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/flanksource/karina/pkg/types"
+	. "github.com/onsi/gomega"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSchedule(t *testing.T) {
+	g := NewWithT(t)
+	s, err := parseSchedule("30 22 * * mon-thu")
+	g.Expect(err).ToNot(HaveOccurred())
+	// 2026-10-19 is a monday
+	g.Expect(s.matches(time.Date(2026, 10, 19, 22, 30, 0, 0, time.UTC))).To(BeTrue())
+	g.Expect(s.matches(time.Date(2026, 10, 19, 22, 31, 0, 0, time.UTC))).To(BeFalse())
+	g.Expect(s.matches(time.Date(2026, 10, 23, 22, 30, 0, 0, time.UTC))).To(BeFalse())
+
+	s, err = parseSchedule("*/15 0-6/2 1,15 * *")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(s.matches(time.Date(2026, 10, 15, 4, 45, 0, 0, time.UTC))).To(BeTrue())
+	g.Expect(s.matches(time.Date(2026, 10, 15, 5, 45, 0, 0, time.UTC))).To(BeFalse())
+	g.Expect(s.matches(time.Date(2026, 10, 16, 4, 45, 0, 0, time.UTC))).To(BeFalse())
+
+	// sunday can be 0 or 7
+	s, err = parseSchedule("0 0 * * 7")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(s.matches(time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC))).To(BeTrue())
+
+	for _, invalid := range []string{"* * * *", "60 * * * *", "* * * * fun", "5-1 * * * *", "*/0 * * * *"} {
+		_, err := parseSchedule(invalid)
+		g.Expect(err).To(HaveOccurred(), invalid)
+	}
+}
+
+func TestCalendar(t *testing.T) {
+	g := NewWithT(t)
+	calendar, err := New(&types.Maintenance{
+		Timezone: "Europe/London",
+		Windows:  []types.MaintenanceWindow{{Schedule: "0 22 * * mon-thu", Duration: "4h"}},
+		Freezes:  []types.Freeze{{Name: "black-friday", Start: "2026-11-26", End: "2026-11-30"}},
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	london, _ := time.LoadLocation("Europe/London")
+
+	// inside the monday window, including after midnight
+	g.Expect(calendar.Check(time.Date(2026, 10, 19, 23, 0, 0, 0, london))).To(Succeed())
+	g.Expect(calendar.Check(time.Date(2026, 10, 20, 1, 59, 0, 0, london))).To(Succeed())
+
+	// outside a window on friday, the next window opens on monday
+	err = calendar.Check(time.Date(2026, 10, 23, 12, 0, 0, 0, london))
+	g.Expect(err).To(MatchError(ContainSubstring("outside of maintenance windows")))
+	g.Expect(err.(*RefusedError).Next).To(BeTemporally("==", time.Date(2026, 10, 26, 22, 0, 0, 0, london)))
+
+	// freeze end dates are inclusive, monday's window is still open when the freeze ends at midnight
+	err = calendar.Check(time.Date(2026, 11, 26, 23, 0, 0, 0, london))
+	g.Expect(err).To(MatchError(ContainSubstring("change freeze black-friday")))
+	g.Expect(err.(*RefusedError).Next).To(BeTemporally("==", time.Date(2026, 12, 1, 0, 0, 0, 0, london)))
+
+	// no config allows everything
+	calendar, err = New(nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(calendar.Check(time.Now())).To(Succeed())
+
+	_, err = New(&types.Maintenance{Windows: []types.MaintenanceWindow{{Schedule: "0 22 * * *", Duration: "forever"}}})
+	g.Expect(err).To(MatchError(ContainSubstring("invalid duration")))
+	_, err = New(&types.Maintenance{Freezes: []types.Freeze{{Name: "xmas", Start: "2026-12-27", End: "2026-12-20"}}})
+	g.Expect(err).To(MatchError(ContainSubstring("ends before it starts")))
+}
+
+func TestRecordOverride(t *testing.T) {
+	g := NewWithT(t)
+	client := fake.NewSimpleClientset()
+	for i := 0; i < maxOverrides+2; i++ {
+		g.Expect(RecordOverride(client, Override{Operation: "rolling update", Reason: "CHG-1234"})).To(Succeed())
+	}
+	overrides, err := GetOverrides(client)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(overrides).To(HaveLen(maxOverrides))
+	g.Expect(overrides[0].Reason).To(Equal("CHG-1234"))
+	g.Expect(overrides[0].User).ToNot(BeEmpty())
+}
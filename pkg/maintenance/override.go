@@ -0,0 +1,106 @@
+package maintenance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	OverridesConfigMap = "karina-maintenance-overrides"
+	OverridesNamespace = "kube-system"
+	overridesKey       = "overrides.json"
+	// maxOverrides is the number of overrides kept in the ConfigMap
+	maxOverrides = 100
+)
+
+// Override records a disruptive operation that was run outside of a maintenance window or during a freeze
+type Override struct {
+	Time      time.Time `json:"time"`
+	User      string    `json:"user"`
+	Operation string    `json:"operation"`
+	Reason    string    `json:"reason"`
+	// Refused is why the operation would otherwise have been refused
+	Refused string `json:"refused"`
+}
+
+// CurrentUser returns user@hostname of the process
+func CurrentUser() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s@%s", name, hostname)
+}
+
+// GetOverrides returns the recorded overrides, oldest first
+func GetOverrides(client kubernetes.Interface) ([]Override, error) {
+	cm, err := client.CoreV1().ConfigMaps(OverridesNamespace).Get(context.TODO(), OverridesConfigMap, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get maintenance overrides")
+	}
+	return decodeOverrides(cm)
+}
+
+func decodeOverrides(cm *v1.ConfigMap) ([]Override, error) {
+	var overrides []Override
+	if data := cm.Data[overridesKey]; data != "" {
+		if err := json.Unmarshal([]byte(data), &overrides); err != nil {
+			return nil, errors.Wrapf(err, "invalid maintenance overrides in %s/%s", OverridesNamespace, OverridesConfigMap)
+		}
+	}
+	return overrides, nil
+}
+
+// RecordOverride appends an override to the overrides ConfigMap, keeping the most recent 100
+func RecordOverride(client kubernetes.Interface, override Override) error {
+	if override.Time.IsZero() {
+		override.Time = time.Now()
+	}
+	if override.User == "" {
+		override.User = CurrentUser()
+	}
+	configMaps := client.CoreV1().ConfigMaps(OverridesNamespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := configMaps.Get(context.TODO(), OverridesConfigMap, metav1.GetOptions{})
+		exists := !kerrors.IsNotFound(err)
+		if !exists {
+			cm = &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: OverridesConfigMap, Namespace: OverridesNamespace}}
+		} else if err != nil {
+			return errors.Wrap(err, "failed to get maintenance overrides")
+		}
+		overrides, err := decodeOverrides(cm)
+		if err != nil {
+			return err
+		}
+		overrides = append(overrides, override)
+		if len(overrides) > maxOverrides {
+			overrides = overrides[len(overrides)-maxOverrides:]
+		}
+		data, err := json.MarshalIndent(overrides, "", "  ")
+		if err != nil {
+			return err
+		}
+		cm.Data = map[string]string{overridesKey: string(data)}
+		if exists {
+			_, err = configMaps.Update(context.TODO(), cm, metav1.UpdateOptions{})
+		} else {
+			_, err = configMaps.Create(context.TODO(), cm, metav1.CreateOptions{})
+		}
+		return err
+	})
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/flanksource/commons/utils"
 	karinav1 "github.com/flanksource/karina/pkg/api/operator/v1"
 	"github.com/flanksource/karina/pkg/constants"
+	"github.com/flanksource/karina/pkg/maintenance"
 	"github.com/flanksource/karina/pkg/phases/harbor"
 	"github.com/flanksource/karina/pkg/platform"
 	"github.com/flanksource/karina/pkg/types"
@@ -81,7 +82,10 @@ func (r *KarinaConfigReconciler) Reconcile(ctx context.Context, req ctrl.Request
 		return ctrl.Result{RequeueAfter: requeuePeriod}, nil
 	}
 
-	yml, err := yaml.Marshal(karinaConfig.Spec)
+	// overrideFreeze is consumed by the deploy it allows, so it is not part of the applied config
+	spec := karinaConfig.Spec
+	spec.OverrideFreeze = ""
+	yml, err := yaml.Marshal(spec)
 	if err != nil {
 		log.Error(err, "failed to marshal spec")
 		return ctrl.Result{}, err
@@ -143,6 +147,15 @@ func (r *KarinaConfigReconciler) Reconcile(ctx context.Context, req ctrl.Request
 		return ctrl.Result{}, err
 	}
 
+	wait, err := r.checkMaintenance(ctx, log, karinaConfig, &platform)
+	if err != nil {
+		log.Error(err, "failed to check maintenance windows")
+		return ctrl.Result{}, err
+	}
+	if wait > 0 {
+		return ctrl.Result{RequeueAfter: wait}, nil
+	}
+
 	if err := r.deploy(ctx, karinaConfig, &platform, secret); err != nil {
 		log.Error(err, "failed to deploy config")
 		return ctrl.Result{}, err
@@ -164,6 +177,48 @@ func (r *KarinaConfigReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		Complete(r)
 }
 
+// checkMaintenance returns how long to wait before deploying when outside of a maintenance window or
+// during a freeze. An overrideFreeze reason allows a single deploy, it is cleared from the spec before the
+// override is recorded so that later deploys are restricted again
+func (r *KarinaConfigReconciler) checkMaintenance(ctx context.Context, log logr.Logger, karinaConfig *karinav1.KarinaConfig, p *platform.Platform) (time.Duration, error) {
+	calendar, err := maintenance.New(p.Maintenance)
+	if err != nil {
+		return 0, err
+	}
+	refused := calendar.Check(time.Now())
+	if refused == nil {
+		return 0, nil
+	}
+	if reason := karinaConfig.Spec.OverrideFreeze; reason != "" {
+		log.Info("Overriding maintenance restrictions", "reason", reason, "refused", refused.Error())
+		client, err := p.GetClientset()
+		if err != nil {
+			return 0, err
+		}
+		// the override is recorded before it is cleared so that it is never used without an audit record
+		if err := maintenance.RecordOverride(client, maintenance.Override{
+			User:      "karina-operator",
+			Operation: fmt.Sprintf("deploy %s/%s", karinaConfig.Namespace, karinaConfig.Name),
+			Reason:    reason,
+			Refused:   refused.Error(),
+		}); err != nil {
+			return 0, err
+		}
+		karinaConfig.Spec.OverrideFreeze = ""
+		if err := r.Update(ctx, karinaConfig); err != nil {
+			return 0, errors.Wrap(err, "failed to clear overrideFreeze")
+		}
+		return 0, nil
+	}
+	wait := time.Hour
+	var refusedErr *maintenance.RefusedError
+	if errors.As(refused, &refusedErr) && !refusedErr.Next.IsZero() {
+		wait = time.Until(refusedErr.Next)
+	}
+	log.Info("Waiting for maintenance window", "reason", refused.Error(), "wait", wait.String())
+	return wait, nil
+}
+
 func (r *KarinaConfigReconciler) deploy(ctx context.Context, karinaConfig *karinav1.KarinaConfig, p *platform.Platform, secret *v1.Secret) error {
 	name := fmt.Sprintf("karina-deploy-%s-%s", karinaConfig.Name, utils.RandomKey(5))
 	configYaml := p.String()
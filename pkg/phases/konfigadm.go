@@ -27,8 +27,34 @@ const (
 	updateHostsFileCmd = "echo $(ifconfig ens160 | grep inet | awk '{print $2}' | head -n1 ) $(hostname) >> /etc/hosts"
 	kubeadmInitCmd     = "kubeadm init --config /etc/kubernetes/kubeadm.conf -v 5 2>&1 | tee -a /var/log/kubeadm.log"
 	kubeadmNodeJoinCmd = "kubeadm join --config /etc/kubernetes/kubeadm.conf -v 5 2>&1 | tee -a /var/log/kubeadm.log"
+	// kubeadmRestoreInitCmd reuses the etcd data directory restored from a snapshot
+	kubeadmRestoreInitCmd = "kubeadm init --config /etc/kubernetes/kubeadm.conf --ignore-preflight-errors=DirAvailable--var-lib-etcd -v 5 2>&1 | tee -a /var/log/kubeadm.log"
+	// etcdImageCmd prints the etcd image kubeadm init will run, which is already present on karina images
+	etcdImageCmd = "kubeadm config images list --config /etc/kubernetes/kubeadm.conf | grep /etcd:"
 )
 
+// etcdctlCmd runs etcdctl from the etcd image using the container runtime, so that restores use the same
+// etcd version and architecture as the cluster and work without internet access
+func etcdctlCmd(runtime string) string {
+	if runtime == "containerd" {
+		return fmt.Sprintf("ETCD_IMAGE=$(%s) && (ctr -n k8s.io images ls -q | grep -qx $ETCD_IMAGE || ctr -n k8s.io images pull $ETCD_IMAGE) && "+
+			"ctr -n k8s.io run --rm --mount type=bind,src=/var/lib,dst=/var/lib,options=rbind:rw --env ETCDCTL_API=3 $ETCD_IMAGE etcd-restore etcdctl", etcdImageCmd)
+	}
+	return fmt.Sprintf("ETCD_IMAGE=$(%s) && docker run --rm -v /var/lib:/var/lib -e ETCDCTL_API=3 $ETCD_IMAGE etcdctl", etcdImageCmd)
+}
+
+// etcdRestoreCmds restore an etcd snapshot into the data directory of a new single member cluster named after
+// the host, the data directory is then reused by kubeadm init
+func etcdRestoreCmds(snapshotURL, runtime string) []string {
+	peerURL := "https://$(hostname -I | awk '{print $1}'):2380"
+	return []string{
+		"mkdir -p /var/lib/etcd-restore",
+		fmt.Sprintf("curl -fsSL -o /var/lib/etcd-restore/snapshot.db '%s'", snapshotURL),
+		fmt.Sprintf("%[1]s snapshot restore /var/lib/etcd-restore/snapshot.db --data-dir /var/lib/etcd --name $(hostname) --initial-cluster $(hostname)=%[2]s --initial-advertise-peer-urls %[2]s", etcdctlCmd(runtime), peerURL),
+		"rm -rf /var/lib/etcd-restore",
+	}
+}
+
 var downloadCustomClusterSigningFiles = []string{
 	// Because the certificate-signing-cert can only contain a single certificate and the ca.crt contains 2 (the root ca, and cluster ca)
 	// ca.{key,crt} are copied removing the 2nd cert and specified as extra arguments to the api server, because of this kubeadm upload/download certs
@@ -58,6 +84,13 @@ func CreatePrimaryMaster(platform *platform.Platform) (*konfigadm.Config, error)
 	for file, content := range files {
 		cfg.Files[file] = content
 	}
+	if platform.RestoreEtcdFrom != "" {
+		for _, cmd := range etcdRestoreCmds(platform.RestoreEtcdFrom, platform.Kubernetes.ContainerRuntime) {
+			cfg.AddCommand(cmd)
+		}
+		cfg.AddCommand(kubeadmRestoreInitCmd)
+		return cfg, nil
+	}
 	cfg.AddCommand(kubeadmInitCmd)
 	return cfg, nil
 }
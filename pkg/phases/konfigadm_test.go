@@ -0,0 +1,51 @@
+package phases
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestEtcdRestoreCmds(t *testing.T) {
+	g := NewWithT(t)
+	url := "https://s3.example.com/backups/etcd.db?X-Amz-Signature=abc&X-Amz-Expires=3600"
+	cmds := etcdRestoreCmds(url, "docker")
+	g.Expect(cmds).To(HaveLen(4))
+
+	// the snapshot is downloaded before etcdctl restores it, and the scratch directory is removed last
+	g.Expect(cmds[0]).To(Equal("mkdir -p /var/lib/etcd-restore"))
+	g.Expect(cmds[1]).To(Equal("curl -fsSL -o /var/lib/etcd-restore/snapshot.db '" + url + "'"))
+	g.Expect(cmds[2]).To(HavePrefix(etcdctlCmd("docker") + " snapshot restore /var/lib/etcd-restore/snapshot.db"))
+	g.Expect(cmds[3]).To(Equal("rm -rf /var/lib/etcd-restore"))
+
+	// the restored member forms a new single member cluster in the data directory kubeadm uses
+	restore := cmds[2]
+	peerURL := "https://$(hostname -I | awk '{print $1}'):2380"
+	g.Expect(restore).To(ContainSubstring("--data-dir /var/lib/etcd "))
+	g.Expect(restore).To(ContainSubstring("--name $(hostname) "))
+	g.Expect(restore).To(ContainSubstring("--initial-cluster $(hostname)=" + peerURL + " "))
+	g.Expect(restore).To(HaveSuffix("--initial-advertise-peer-urls " + peerURL))
+}
+
+func TestEtcdctlCmd(t *testing.T) {
+	g := NewWithT(t)
+	// etcdctl comes from the etcd image kubeadm uses, nothing is downloaded from the internet
+	image := "ETCD_IMAGE=$(kubeadm config images list --config /etc/kubernetes/kubeadm.conf | grep /etcd:) && "
+	g.Expect(etcdctlCmd("docker")).To(Equal(image + "docker run --rm -v /var/lib:/var/lib -e ETCDCTL_API=3 $ETCD_IMAGE etcdctl"))
+	g.Expect(etcdctlCmd("")).To(Equal(etcdctlCmd("docker")))
+	// containerd only pulls the image if it is not already present
+	g.Expect(etcdctlCmd("containerd")).To(Equal(image +
+		"(ctr -n k8s.io images ls -q | grep -qx $ETCD_IMAGE || ctr -n k8s.io images pull $ETCD_IMAGE) && " +
+		"ctr -n k8s.io run --rm --mount type=bind,src=/var/lib,dst=/var/lib,options=rbind:rw --env ETCDCTL_API=3 $ETCD_IMAGE etcd-restore etcdctl"))
+	for _, runtime := range []string{"docker", "containerd"} {
+		g.Expect(strings.Join(etcdRestoreCmds("https://example.com/etcd.db", runtime), "\n")).NotTo(ContainSubstring("github.com"))
+	}
+}
+
+func TestKubeadmRestoreInitCmd(t *testing.T) {
+	g := NewWithT(t)
+	// kubeadm must accept the pre-populated etcd data directory, but otherwise init as normal
+	g.Expect(kubeadmRestoreInitCmd).To(ContainSubstring("--ignore-preflight-errors=DirAvailable--var-lib-etcd"))
+	g.Expect(strings.Replace(kubeadmRestoreInitCmd, " --ignore-preflight-errors=DirAvailable--var-lib-etcd", "", 1)).To(Equal(kubeadmInitCmd))
+}
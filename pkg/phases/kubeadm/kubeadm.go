@@ -10,6 +10,7 @@ import (
 	"github.com/flanksource/commons/certs"
 	"github.com/flanksource/commons/utils"
 	"github.com/flanksource/karina/pkg/api"
+	"github.com/flanksource/karina/pkg/ca"
 	"github.com/flanksource/karina/pkg/constants"
 	"github.com/flanksource/karina/pkg/platform"
 	"github.com/flanksource/karina/pkg/types"
@@ -131,7 +132,7 @@ func GetFilesToMountForPrimary(platform *platform.Platform) (map[string]string,
 	// ca.{key,crt} are copied removing the 2nd cert and specified as extra arguments to the api server, because of this kubeadm upload/download certs
 	// is not aware of these files and they get recreated for each new master, causing node join issues down the line, we therefore split the certs
 	crt := string(clusterCA.EncodedCertificate()) + "\n"
-	crt = crt + string(platform.GetCA().GetPublicChain()[0].EncodedCertificate()) + "\n"
+	crt = crt + string(ca.EncodeChain(platform.GetCA())) + "\n"
 	files[CSRCAPath] = string(clusterCA.EncodedCertificate())
 	files[CSRKeyPath] = string(clusterCA.EncodedPrivateKey())
 	files["/etc/kubernetes/pki/ca.crt"] = crt
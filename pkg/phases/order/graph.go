@@ -0,0 +1,316 @@
+package order
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/flanksource/karina/pkg/platform"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+type Status string
+
+const (
+	Succeeded Status = "succeeded"
+	Failed    Status = "failed"
+	Skipped   Status = "skipped"
+	// Disabled is used for phases that are disabled in the config, they are still run to remove
+	// anything they deployed previously
+	Disabled Status = "disabled"
+)
+
+// Result is the outcome of deploying a single phase
+type Result struct {
+	Name   string
+	Status Status
+	Error  error
+	Start  time.Time
+	End    time.Time
+	// Objects is the number of objects applied by the phase
+	Objects int
+}
+
+// Duration returns how long the phase took to deploy
+func (result Result) Duration() time.Duration {
+	return result.End.Sub(result.Start)
+}
+
+type Results []Result
+
+// HasFailures returns true if any phase failed or was skipped due to a failed dependency
+func (results Results) HasFailures() bool {
+	for _, result := range results {
+		if result.Status == Failed || result.Status == Skipped {
+			return true
+		}
+	}
+	return false
+}
+
+// GetPhase looks up a phase by name from either Phases or PhasesExtra
+func GetPhase(name string) (Phase, bool) {
+	if phase, ok := Phases[name]; ok {
+		return phase, true
+	}
+	phase, ok := PhasesExtra[name]
+	return phase, ok
+}
+
+// Dependencies returns the phases out of names that the phase called name must be deployed after.
+// A dependency is either the name of a phase, or a capability in which case every phase in names
+// that provides it is returned. Dependencies that are not in names are assumed to already be deployed.
+func Dependencies(name string, names []string) []string {
+	phase, _ := GetPhase(name)
+	selected := map[string]bool{}
+	for _, n := range names {
+		selected[n] = true
+	}
+	deps := map[string]bool{}
+	for _, dependency := range phase.DependsOn {
+		// capabilities can share the name of a phase (e.g. crds), so providers are included as well
+		if selected[dependency] && dependency != name {
+			deps[dependency] = true
+		}
+		for _, n := range names {
+			if n == name {
+				continue
+			}
+			other, _ := GetPhase(n)
+			if contains(other.Provides, dependency) {
+				deps[n] = true
+			}
+		}
+	}
+	var list []string
+	for dep := range deps {
+		list = append(list, dep)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Sort returns names ordered so that every phase comes after its dependencies, phases
+// without a dependency between them are ordered alphabetically.
+func Sort(names []string) ([]string, error) {
+	edges := map[string][]string{}
+	for _, name := range names {
+		if _, ok := GetPhase(name); !ok {
+			return nil, fmt.Errorf("unknown phase: %s", name)
+		}
+		edges[name] = Dependencies(name, names)
+	}
+
+	inDegree := map[string]int{}
+	dependents := map[string][]string{}
+	for name, deps := range edges {
+		inDegree[name] = len(deps)
+		for _, dep := range deps {
+			dependents[dep] = append(dependents[dep], name)
+		}
+	}
+
+	var ready []string
+	for name, degree := range inDegree {
+		if degree == 0 {
+			ready = append(ready, name)
+		}
+	}
+
+	var sorted []string
+	for len(ready) > 0 {
+		sort.Strings(ready)
+		name := ready[0]
+		ready = ready[1:]
+		sorted = append(sorted, name)
+		for _, dependent := range dependents[name] {
+			inDegree[dependent]--
+			if inDegree[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	if len(sorted) != len(edges) {
+		return nil, fmt.Errorf("dependency cycle detected: %s", strings.Join(findCycle(edges), " -> "))
+	}
+	return sorted, nil
+}
+
+// findCycle returns the first cycle found in edges, with the first phase repeated at the end
+func findCycle(edges map[string][]string) []string {
+	var names []string
+	for name := range edges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	visited := map[string]bool{}
+	var path []string
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		for i, n := range path {
+			if n == name {
+				return append(append([]string{}, path[i:]...), name)
+			}
+		}
+		if visited[name] {
+			return nil
+		}
+		visited[name] = true
+		path = append(path, name)
+		for _, dep := range edges[name] {
+			if cycle := visit(dep); cycle != nil {
+				return cycle
+			}
+		}
+		path = path[:len(path)-1]
+		return nil
+	}
+
+	for _, name := range names {
+		if cycle := visit(name); cycle != nil {
+			// edges point from a phase to its dependencies, reverse to show deployment order
+			for i, j := 0, len(cycle)-1; i < j; i, j = i+1, j-1 {
+				cycle[i], cycle[j] = cycle[j], cycle[i]
+			}
+			return cycle
+		}
+	}
+	return nil
+}
+
+// Deploy deploys the named phases in dependency order, continuing on failure to allow degraded
+// operations. Phases that depend on a phase that failed or was skipped are skipped.
+// Up to concurrency phases whose dependencies have been deployed are run at the same time,
+// each logging with a phase field. An error is only returned if the phases cannot be ordered.
+func Deploy(p *platform.Platform, names []string, concurrency int) (Results, error) {
+	sorted, err := Sort(names)
+	if err != nil {
+		return nil, err
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	deps := map[string][]string{}
+	for _, name := range sorted {
+		deps[name] = Dependencies(name, sorted)
+	}
+
+	status := map[string]Status{}
+	results := map[string]Result{}
+	completed := make(chan Result)
+	pending := append([]string{}, sorted...)
+	running := 0
+	for len(pending) > 0 || running > 0 {
+		// start pending phases in sorted order as soon as all of their dependencies have finished
+		for i := 0; i < len(pending) && running < concurrency; {
+			name := pending[i]
+			finished := true
+			var failed []string
+			for _, dep := range deps[name] {
+				switch status[dep] {
+				case "":
+					finished = false
+				case Failed, Skipped:
+					failed = append(failed, dep)
+				}
+			}
+			if !finished {
+				i++
+				continue
+			}
+			pending = append(pending[:i], pending[i+1:]...)
+
+			if len(failed) > 0 {
+				err := fmt.Errorf("dependencies did not deploy successfully: %s", strings.Join(failed, ", "))
+				p.Errorf("Skipping %s: %v", name, err)
+				now := time.Now()
+				status[name] = Skipped
+				results[name] = Result{Name: name, Status: Skipped, Error: err, Start: now, End: now}
+				continue
+			}
+
+			running++
+			go func(name string) {
+				completed <- deploy(p.WithField("phase", name), name)
+			}(name)
+		}
+
+		if running == 0 {
+			continue
+		}
+		result := <-completed
+		running--
+		status[result.Name] = result.Status
+		results[result.Name] = result
+	}
+
+	list := Results{}
+	for _, name := range sorted {
+		list = append(list, results[name])
+	}
+	return list, nil
+}
+
+func deploy(p *platform.Platform, name string) Result {
+	phase, _ := GetPhase(name)
+	result := Result{Name: name, Start: time.Now()}
+	hook := p.ApplyHook
+	p.ApplyHook = func(ns string, obj unstructured.Unstructured) {
+		result.Objects++
+		if hook != nil {
+			hook(ns, obj)
+		}
+	}
+	p.Tracef("Deploying %s", name)
+	if err := phase.Fn(p); err != nil {
+		p.Errorf("Failed to deploy %s: %v", name, err)
+		result.Status = Failed
+		result.Error = err
+	} else if phase.IsDisabled != nil && phase.IsDisabled(p) {
+		result.Status = Disabled
+	} else {
+		result.Status = Succeeded
+	}
+	result.End = time.Now()
+	return result
+}
+
+// globalConfig are config keys that are used by most phases
+var globalConfig = []string{"ca", "dockerRegistry", "domain", "ingressCA", "name", "patches", "resources", "s3", "trustedCA", "versions"}
+
+// PhasesForConfig returns the phases that use the config value at path (e.g. monitoring.prometheus.version),
+// global is true if the value is used by most phases
+func PhasesForConfig(path string) (phases []string, global bool) {
+	key := strings.Split(path, ".")[0]
+	if contains(globalConfig, key) {
+		return nil, true
+	}
+	for _, registry := range []map[string]Phase{Phases, PhasesExtra} {
+		for name, phase := range registry {
+			keys := phase.Config
+			if len(keys) == 0 {
+				keys = []string{strings.ReplaceAll(name, "-", "")}
+			}
+			for _, k := range keys {
+				if strings.EqualFold(k, key) {
+					phases = append(phases, name)
+				}
+			}
+		}
+	}
+	sort.Strings(phases)
+	return phases, false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,265 @@
+package order
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/flanksource/commons/logger"
+	"github.com/flanksource/karina/pkg/platform"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// withPhases replaces the phase registry for the duration of a test
+func withPhases(t *testing.T, phases map[string]Phase) {
+	original, originalExtra := Phases, PhasesExtra
+	Phases, PhasesExtra = phases, map[string]Phase{}
+	t.Cleanup(func() { Phases, PhasesExtra = original, originalExtra })
+}
+
+func newTestPlatform() *platform.Platform {
+	return &platform.Platform{Logger: logger.StandardLogger()}
+}
+
+// apply is a phase that applies a single object
+func apply(p *platform.Platform) error {
+	p.ApplyHook("default", unstructured.Unstructured{})
+	return nil
+}
+
+func fail(p *platform.Platform) error {
+	return fmt.Errorf("failed")
+}
+
+func TestSort(t *testing.T) {
+	tests := []struct {
+		name   string
+		phases map[string]Phase
+		names  []string
+		sorted []string
+		err    string
+	}{
+		{
+			name:   "independent phases are sorted alphabetically",
+			phases: map[string]Phase{"c": {}, "a": {}, "b": {}},
+			names:  []string{"c", "a", "b"},
+			sorted: []string{"a", "b", "c"},
+		},
+		{
+			name:   "phases come after their dependencies",
+			phases: map[string]Phase{"a": {DependsOn: []string{"c"}}, "b": {}, "c": {DependsOn: []string{"b"}}},
+			names:  []string{"a", "b", "c"},
+			sorted: []string{"b", "c", "a"},
+		},
+		{
+			name: "phases come after every provider of a capability",
+			phases: map[string]Phase{
+				"app":     {DependsOn: []string{CRDs, Ingress}},
+				"crds":    {Provides: []string{CRDs}},
+				"ingress": {DependsOn: []string{CRDs}, Provides: []string{Ingress}},
+				"zebra":   {Provides: []string{CRDs}},
+			},
+			names:  []string{"app", "crds", "ingress", "zebra"},
+			sorted: []string{"crds", "zebra", "ingress", "app"},
+		},
+		{
+			name:   "dependencies that are not selected are assumed to be deployed",
+			phases: map[string]Phase{"a": {DependsOn: []string{"b", Postgres}}, "b": {}, "c": {Provides: []string{Postgres}}},
+			names:  []string{"a"},
+			sorted: []string{"a"},
+		},
+		{
+			name:   "a phase does not depend on a capability it provides",
+			phases: map[string]Phase{"a": {DependsOn: []string{S3}, Provides: []string{S3}}},
+			names:  []string{"a"},
+			sorted: []string{"a"},
+		},
+		{
+			name:   "unknown phases",
+			phases: map[string]Phase{"a": {}},
+			names:  []string{"a", "b"},
+			err:    "unknown phase: b",
+		},
+		{
+			name:   "direct cycle",
+			phases: map[string]Phase{"a": {DependsOn: []string{"b"}}, "b": {DependsOn: []string{"a"}}},
+			names:  []string{"a", "b"},
+			err:    "dependency cycle detected: a -> b -> a",
+		},
+		{
+			name: "cycle through a capability",
+			phases: map[string]Phase{
+				"a": {DependsOn: []string{"b"}},
+				"b": {DependsOn: []string{Templates}},
+				"c": {DependsOn: []string{"a"}, Provides: []string{Templates}},
+				"d": {DependsOn: []string{"a"}},
+			},
+			names: []string{"a", "b", "c", "d"},
+			err:   "dependency cycle detected: a -> c -> b -> a",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g := NewWithT(t)
+			withPhases(t, test.phases)
+			sorted, err := Sort(test.names)
+			if test.err != "" {
+				g.Expect(err).To(MatchError(test.err))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(sorted).To(Equal(test.sorted))
+		})
+	}
+}
+
+func TestSortRegistry(t *testing.T) {
+	g := NewWithT(t)
+	var names []string
+	for name := range GetAllPhases() {
+		names = append(names, name)
+	}
+	sorted, err := Sort(names)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(sorted).To(HaveLen(len(names)))
+	position := map[string]int{}
+	for i, name := range sorted {
+		position[name] = i
+	}
+	for _, name := range sorted {
+		for _, dep := range Dependencies(name, names) {
+			g.Expect(position[dep]).To(BeNumerically("<", position[name]), "%s depends on %s", name, dep)
+		}
+	}
+}
+
+func TestDeploySkipsDependents(t *testing.T) {
+	tests := []struct {
+		name    string
+		phases  map[string]Phase
+		results map[string]Status
+		errors  map[string]string
+	}{
+		{
+			name: "disabled phases do not fail their dependents",
+			phases: map[string]Phase{
+				"a": {Fn: apply, IsDisabled: func(p *platform.Platform) bool { return true }},
+				"b": {Fn: apply, DependsOn: []string{"a"}},
+			},
+			results: map[string]Status{"a": Disabled, "b": Succeeded},
+		},
+		{
+			name: "enabled phases that apply nothing succeed",
+			phases: map[string]Phase{
+				"a": {Fn: func(p *platform.Platform) error { return nil }},
+				"b": {Fn: func(p *platform.Platform) error { return nil }, IsDisabled: func(p *platform.Platform) bool { return false }},
+			},
+			results: map[string]Status{"a": Succeeded, "b": Succeeded},
+		},
+		{
+			name: "disabled phases that fail to clean up are failed",
+			phases: map[string]Phase{
+				"a": {Fn: fail, IsDisabled: func(p *platform.Platform) bool { return true }},
+			},
+			results: map[string]Status{"a": Failed},
+			errors:  map[string]string{"a": "failed"},
+		},
+		{
+			name: "dependents of a failed phase are skipped transitively",
+			phases: map[string]Phase{
+				"a": {Fn: fail},
+				"b": {Fn: apply, DependsOn: []string{"a"}},
+				"c": {Fn: apply, DependsOn: []string{"b"}},
+				"d": {Fn: apply},
+			},
+			results: map[string]Status{"a": Failed, "b": Skipped, "c": Skipped, "d": Succeeded},
+			errors: map[string]string{
+				"a": "failed",
+				"b": "dependencies did not deploy successfully: a",
+				"c": "dependencies did not deploy successfully: b",
+			},
+		},
+		{
+			name: "dependents of a failed capability provider are skipped",
+			phases: map[string]Phase{
+				"minio":  {Fn: fail, Provides: []string{S3}},
+				"stubs":  {Fn: apply, Provides: []string{S3}},
+				"velero": {Fn: apply, DependsOn: []string{S3}},
+			},
+			results: map[string]Status{"minio": Failed, "stubs": Succeeded, "velero": Skipped},
+			errors:  map[string]string{"minio": "failed", "velero": "dependencies did not deploy successfully: minio"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g := NewWithT(t)
+			withPhases(t, test.phases)
+			var names []string
+			for name := range test.phases {
+				names = append(names, name)
+			}
+			results, err := Deploy(newTestPlatform(), names, 2)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(results).To(HaveLen(len(test.results)))
+			for _, result := range results {
+				g.Expect(result.Status).To(Equal(test.results[result.Name]), result.Name)
+				if msg, ok := test.errors[result.Name]; ok {
+					g.Expect(result.Error).To(MatchError(msg), result.Name)
+				} else {
+					g.Expect(result.Error).ToNot(HaveOccurred(), result.Name)
+				}
+			}
+			g.Expect(results.HasFailures()).To(Equal(len(test.errors) > 0))
+		})
+	}
+}
+
+func TestDeployConcurrency(t *testing.T) {
+	for _, concurrency := range []int{0, 1, 2, 4} {
+		concurrency := concurrency
+		t.Run(fmt.Sprintf("concurrency %d", concurrency), func(t *testing.T) {
+			g := NewWithT(t)
+			var lock sync.Mutex
+			running, max := 0, 0
+			var finished []string
+			phase := func(name string) DeployFn {
+				return func(p *platform.Platform) error {
+					lock.Lock()
+					running++
+					if running > max {
+						max = running
+					}
+					lock.Unlock()
+					time.Sleep(20 * time.Millisecond)
+					lock.Lock()
+					running--
+					finished = append(finished, name)
+					lock.Unlock()
+					return apply(p)
+				}
+			}
+			phases := map[string]Phase{"last": {Fn: phase("last"), DependsOn: []string{Elastic}}}
+			names := []string{"last"}
+			for i := 0; i < 6; i++ {
+				name := fmt.Sprintf("phase-%d", i)
+				phases[name] = Phase{Fn: phase(name), Provides: []string{Elastic}}
+				names = append(names, name)
+			}
+			withPhases(t, phases)
+
+			results, err := Deploy(newTestPlatform(), names, concurrency)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(results.HasFailures()).To(BeFalse())
+			expected := concurrency
+			if expected < 1 {
+				expected = 1
+			}
+			g.Expect(max).To(Equal(expected))
+			// a phase only starts once every phase it depends on has finished
+			g.Expect(finished).To(HaveLen(7))
+			g.Expect(finished[6]).To(Equal("last"))
+		})
+	}
+}
@@ -52,38 +52,69 @@ import (
 
 type DeployFn func(p *platform.Platform) error
 
-var Phases = map[string]DeployFn{
-	"argo-rollouts":     argorollouts.Deploy,
-	"argocd-operator":   argocdoperator.Deploy,
-	"auditbeat":         auditbeat.Deploy,
-	"canary":            canary.Deploy,
-	"eck":               eck.Deploy,
-	"elasticsearch":     elasticsearch.Deploy,
-	"eventrouter":       eventrouter.Deploy,
-	"externaldns":       externaldns.Install,
-	"dashboard":         dashboard.Install,
-	"filebeat":          filebeat.Deploy,
-	"flux":              flux.InstallV2,
-	"git-operator":      gitoperator.Install,
-	"harbor":            harbor.Deploy,
-	"istio-operator":    istiooperator.Install,
-	"journalbeat":       journalbeat.Deploy,
-	"karina-operator":   karinaoperator.Install,
-	"platform":          Platform,
-	"logs-exporter":     logsexporter.Install,
-	"mongodb-operator":  mongodboperator.Deploy,
-	"monitoring":        monitoring.Install,
-	"opa":               opa.Install,
-	"packetbeat":        packetbeat.Deploy,
-	"rabbitmq-operator": rabbitmqoperator.Install,
-	"redis-operator":    redisoperator.Install,
-	"registry-creds":    registrycreds.Install,
-	"sealed-secrets":    sealedsecrets.Install,
-	"velero":            velero.Install,
-	"vault":             vault.Deploy,
+// Capabilities that phases can provide for other phases to depend on, a phase that
+// depends on a capability is ordered after every phase that provides it
+const (
+	CRDs         = "crds"
+	Certificates = "certificates"
+	Ingress      = "ingress"
+	S3           = "s3"
+	Postgres     = "postgres"
+	Templates    = "templates"
+	Elastic      = "elastic"
+)
+
+// Phase is a single deployable unit of the platform
+type Phase struct {
+	Fn DeployFn
+	// DependsOn is a list of phase names or capabilities that must be deployed before this phase
+	DependsOn []string
+	// Provides is a list of capabilities (e.g. CRDs or webhooks) that this phase makes available
+	Provides []string
+	// Config is a list of top-level config keys used by this phase, defaults to the phase name without dashes
+	Config []string
+	// IsDisabled returns true if the phase is disabled in the config, phases without it are always enabled
+	IsDisabled func(p *platform.Platform) bool
+}
+
+// addon returns the dependencies of a phase that is deployed on top of a bootstrapped platform
+func addon(dependsOn ...string) []string {
+	return append([]string{CRDs, Certificates, Ingress}, dependsOn...)
+}
+
+var Phases = map[string]Phase{
+	"argo-rollouts":     {Fn: argorollouts.Deploy, DependsOn: addon(), IsDisabled: func(p *platform.Platform) bool { return p.ArgoRollouts.IsDisabled() }},
+	"argocd-operator":   {Fn: argocdoperator.Deploy, DependsOn: addon(), IsDisabled: func(p *platform.Platform) bool { return p.ArgocdOperator.IsDisabled() }},
+	"auditbeat":         {Fn: auditbeat.Deploy, DependsOn: addon(), IsDisabled: func(p *platform.Platform) bool { return p.Auditbeat.IsDisabled() }},
+	"canary":            {Fn: canary.Deploy, DependsOn: addon(), Config: []string{"canaryChecker"}, IsDisabled: func(p *platform.Platform) bool { return p.CanaryChecker.IsDisabled() }},
+	"eck":               {Fn: eck.Deploy, DependsOn: addon(), Provides: []string{Elastic}, IsDisabled: func(p *platform.Platform) bool { return p.ECK.IsDisabled() }},
+	"elasticsearch":     {Fn: elasticsearch.Deploy, DependsOn: addon(Elastic), IsDisabled: func(p *platform.Platform) bool { return p.Elasticsearch == nil || bool(p.Elasticsearch.Disabled) }},
+	"eventrouter":       {Fn: eventrouter.Deploy, DependsOn: addon(), IsDisabled: func(p *platform.Platform) bool { return p.EventRouter.IsDisabled() }},
+	"externaldns":       {Fn: externaldns.Install, DependsOn: addon(), IsDisabled: func(p *platform.Platform) bool { return p.ExternalDNS.IsDisabled() }},
+	"dashboard":         {Fn: dashboard.Install, DependsOn: addon(), IsDisabled: func(p *platform.Platform) bool { return p.Dashboard.IsDisabled() }},
+	"filebeat":          {Fn: filebeat.Deploy, DependsOn: addon(Elastic), IsDisabled: filebeatDisabled},
+	"flux":              {Fn: flux.InstallV2, DependsOn: addon(), IsDisabled: func(p *platform.Platform) bool { return p.Flux == nil || !p.Flux.Enabled }},
+	"git-operator":      {Fn: gitoperator.Install, DependsOn: addon(), IsDisabled: func(p *platform.Platform) bool { return p.GitOperator.IsDisabled() }},
+	"harbor":            {Fn: harbor.Deploy, DependsOn: addon(Postgres, S3), IsDisabled: func(p *platform.Platform) bool { return p.Harbor.IsDisabled() }},
+	"istio-operator":    {Fn: istiooperator.Install, DependsOn: addon(), IsDisabled: func(p *platform.Platform) bool { return p.IstioOperator.IsDisabled() }},
+	"journalbeat":       {Fn: journalbeat.Deploy, DependsOn: addon(), IsDisabled: func(p *platform.Platform) bool { return p.Journalbeat.IsDisabled() }},
+	"karina-operator":   {Fn: karinaoperator.Install, DependsOn: addon(), IsDisabled: func(p *platform.Platform) bool { return p.KarinaOperator.IsDisabled() }},
+	"platform":          {Fn: Platform, DependsOn: addon()},
+	"logs-exporter":     {Fn: logsexporter.Install, DependsOn: addon(Elastic), IsDisabled: func(p *platform.Platform) bool { return p.LogsExporter.IsDisabled() }},
+	"mongodb-operator":  {Fn: mongodboperator.Deploy, DependsOn: addon(), IsDisabled: func(p *platform.Platform) bool { return p.MongodbOperator.IsDisabled() }},
+	"monitoring":        {Fn: monitoring.Install, DependsOn: addon(S3), Config: []string{"monitoring", "thanos"}, IsDisabled: func(p *platform.Platform) bool { return !p.IsMonitoringEnabled() }},
+	"opa":               {Fn: opa.Install, DependsOn: addon(), Config: []string{"gatekeeper"}, IsDisabled: func(p *platform.Platform) bool { return p.Gatekeeper.IsDisabled() }},
+	"packetbeat":        {Fn: packetbeat.Deploy, DependsOn: addon(), IsDisabled: func(p *platform.Platform) bool { return p.Packetbeat.IsDisabled() }},
+	"rabbitmq-operator": {Fn: rabbitmqoperator.Install, DependsOn: addon(), IsDisabled: func(p *platform.Platform) bool { return p.RabbitmqOperator.IsDisabled() }},
+	"redis-operator":    {Fn: redisoperator.Install, DependsOn: addon(), IsDisabled: func(p *platform.Platform) bool { return p.RedisOperator.IsDisabled() }},
+	"registry-creds": {Fn: registrycreds.Install, DependsOn: addon(), Config: []string{"registryCredentials"}, IsDisabled: func(p *platform.Platform) bool {
+		return p.RegistryCredentials == nil || bool(p.RegistryCredentials.Disabled)
+	}},
+	"sealed-secrets": {Fn: sealedsecrets.Install, DependsOn: addon(), IsDisabled: func(p *platform.Platform) bool { return p.SealedSecrets.IsDisabled() }},
+	"velero":         {Fn: velero.Install, DependsOn: addon(S3), IsDisabled: func(p *platform.Platform) bool { return p.Velero.IsDisabled() }},
+	"vault":          {Fn: vault.Deploy, DependsOn: addon(S3), IsDisabled: func(p *platform.Platform) bool { return p.Vault == nil || bool(p.Vault.Disabled) }},
 }
 
-var PhaseOrder = []string{"bootstrap", "crds", "cni", "csi", "cloud", "platform"}
 var Bootstrap = compose(pre.Install, crds.Install, CNI, CSI, base.Install, Cloud, certmanager.Install, ingress.Install, minio.Install, templateoperator.Install, postgresoperator.Deploy, dex.Install)
 var Minimal = compose(pre.Install, crds.Install, base.Install, certmanager.Install, ingress.Install)
 var BootstrapPhases = []string{"pre", "crds", "cni", "csi", "base", "cloud-controller", "cert-manager", "ingress", "minio", "template-operator", "postgres-operator", "dex"}
@@ -93,6 +124,15 @@ var Cloud = compose(vsphere.Install)
 var Platform = compose(platformoperator.Install, configmapreloader.Deploy)
 var Stubs = compose(minio.Install, apacheds.Install)
 
+func filebeatDisabled(p *platform.Platform) bool {
+	for _, f := range p.Filebeat {
+		if !f.IsDisabled() {
+			return false
+		}
+	}
+	return true
+}
+
 func compose(fns ...DeployFn) DeployFn {
 	return func(p *platform.Platform) error {
 		for _, DeployFn := range fns {
@@ -104,46 +144,50 @@ func compose(fns ...DeployFn) DeployFn {
 	}
 }
 
-var PhasesExtra = map[string]DeployFn{
-	"apacheds":           apacheds.Install,
-	"antrea":             antrea.Install,
-	"base":               base.Install,
-	"bootstrap":          Bootstrap,
-	"calico":             calico.Install,
-	"cert-manager":       certmanager.Install,
-	"cni":                CNI,
-	"configmap-reloader": configmapreloader.Deploy,
-	"crds":               crds.Install,
-	"csi":                CSI,
-	"dex":                dex.Install,
-	"ingress":            ingress.Install,
-	"minimal":            Minimal,
-	"minio":              minio.Install,
-	"node-local-dns":     nodelocaldns.Install,
-	"postgres-operator":  postgresoperator.Deploy,
-	"platform-operator":  platformoperator.Install,
-	"pre":                pre.Install,
-	"template-operator":  templateoperator.Install,
-	"vsphere":            vsphere.Install,
-	"cloud-controller":   Cloud,
-	"stubs":              Stubs,
+// PhasesExtra are phases that are not deployed individually by "deploy all", either because
+// they are part of the bootstrap or because they compose other phases
+var PhasesExtra = map[string]Phase{
+	"apacheds":           {Fn: apacheds.Install, DependsOn: addon(), IsDisabled: func(p *platform.Platform) bool { return p.Ldap == nil || bool(p.Ldap.Disabled) || !p.Ldap.E2E.Mock }},
+	"antrea":             {Fn: antrea.Install, DependsOn: []string{CRDs}, IsDisabled: func(p *platform.Platform) bool { return p.Antrea.IsDisabled() }},
+	"base":               {Fn: base.Install, DependsOn: []string{"csi"}},
+	"bootstrap":          {Fn: Bootstrap, Provides: []string{CRDs, Certificates, Ingress, S3, Templates, Postgres}},
+	"calico":             {Fn: calico.Install, DependsOn: []string{CRDs}, IsDisabled: func(p *platform.Platform) bool { return p.Calico.IsDisabled() }},
+	"cert-manager":       {Fn: certmanager.Install, DependsOn: []string{"cloud-controller"}, Provides: []string{Certificates}, IsDisabled: func(p *platform.Platform) bool { return bool(p.CertManager.Disabled) }},
+	"cni":                {Fn: CNI, DependsOn: []string{CRDs}},
+	"configmap-reloader": {Fn: configmapreloader.Deploy, DependsOn: addon(), IsDisabled: func(p *platform.Platform) bool { return bool(p.ConfigMapReloader.Disabled) }},
+	"crds":               {Fn: crds.Install, DependsOn: []string{"pre"}, Provides: []string{CRDs}},
+	"csi":                {Fn: CSI, DependsOn: []string{"cni"}, Config: []string{"localPath", "nfs"}},
+	"dex":                {Fn: dex.Install, DependsOn: []string{"postgres-operator"}, Config: []string{"dex", "ldap"}, IsDisabled: func(p *platform.Platform) bool { return bool(p.Dex.Disabled) }},
+	"ingress":            {Fn: ingress.Install, DependsOn: []string{Certificates}, Provides: []string{Ingress}, Config: []string{"nginx"}, IsDisabled: func(p *platform.Platform) bool { return p.Nginx != nil && bool(p.Nginx.Disabled) }},
+	"minimal":            {Fn: Minimal, Provides: []string{CRDs, Certificates, Ingress}},
+	"minio":              {Fn: minio.Install, DependsOn: []string{Ingress}, Provides: []string{S3}, IsDisabled: func(p *platform.Platform) bool { return p.Minio.IsDisabled() }},
+	"node-local-dns":     {Fn: nodelocaldns.Install, DependsOn: []string{CRDs}, IsDisabled: func(p *platform.Platform) bool { return bool(p.NodeLocalDNS.Disabled) }},
+	"postgres-operator":  {Fn: postgresoperator.Deploy, DependsOn: []string{Templates}, Provides: []string{Postgres}, IsDisabled: func(p *platform.Platform) bool { return p.PostgresOperator.IsDisabled() }},
+	"platform-operator":  {Fn: platformoperator.Install, DependsOn: addon(), IsDisabled: func(p *platform.Platform) bool { return p.PlatformOperator.IsDisabled() }},
+	"pre":                {Fn: pre.Install},
+	"template-operator":  {Fn: templateoperator.Install, DependsOn: []string{S3}, Provides: []string{Templates}, IsDisabled: func(p *platform.Platform) bool { return p.TemplateOperator.IsDisabled() }},
+	"vsphere":            {Fn: vsphere.Install, DependsOn: []string{CRDs}, IsDisabled: func(p *platform.Platform) bool { return p.Vsphere == nil }},
+	"cloud-controller":   {Fn: Cloud, DependsOn: []string{"base"}},
+	"stubs":              {Fn: Stubs, DependsOn: addon(), Provides: []string{S3}},
 }
 
+// GetAllPhases returns the deploy functions for every phase that can be deployed by name
 func GetAllPhases() map[string]DeployFn {
 	res := map[string]DeployFn{}
 	for k, v := range Phases {
-		res[k] = v
+		res[k] = v.Fn
 	}
 	for k, v := range PhasesExtra {
-		res[k] = v
+		res[k] = v.Fn
 	}
 	return res
 }
 
+// GetPhases returns the deploy functions for the phases deployed after the bootstrap phases
 func GetPhases() map[string]DeployFn {
 	res := map[string]DeployFn{}
 	for k, v := range Phases {
-		res[k] = v
+		res[k] = v.Fn
 	}
 	return res
 }
@@ -0,0 +1,77 @@
+package order
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/flanksource/commons/console"
+)
+
+// PhaseReport is the machine readable form of a Result
+type PhaseReport struct {
+	Name     string    `json:"name"`
+	Status   Status    `json:"status"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Duration string    `json:"duration"`
+	Objects  int       `json:"objects"`
+	Error    string    `json:"error,omitempty"`
+}
+
+type Report struct {
+	Cluster string        `json:"cluster"`
+	Phases  []PhaseReport `json:"phases"`
+}
+
+// NewReport converts the results of a deployment into a report for the named cluster
+func NewReport(cluster string, results Results) Report {
+	report := Report{Cluster: cluster}
+	for _, result := range results {
+		phase := PhaseReport{
+			Name:     result.Name,
+			Status:   result.Status,
+			Start:    result.Start,
+			End:      result.End,
+			Duration: result.Duration().String(),
+			Objects:  result.Objects,
+		}
+		if result.Error != nil {
+			phase.Error = result.Error.Error()
+		}
+		report.Phases = append(report.Phases, phase)
+	}
+	return report
+}
+
+func (report Report) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(report, "", "  ")
+}
+
+// ToJUnit returns the report as a JUnit test suite with a test case per phase,
+// skipped and disabled phases are reported as skipped test cases
+func (report Report) ToJUnit() (string, error) {
+	results := console.TestResults{Name: report.Cluster}
+	for _, phase := range report.Phases {
+		test := console.JUnitTestCase{
+			Classname: "deploy",
+			Name:      phase.Name,
+			Time:      fmt.Sprintf("%.3f", phase.End.Sub(phase.Start).Seconds()),
+		}
+		switch phase.Status {
+		case Failed:
+			test.Failure = &console.JUnitFailure{Message: phase.Error}
+			results.FailCount++
+		case Skipped:
+			test.SkipMessage = &console.JUnitSkipMessage{Message: phase.Error}
+			results.SkipCount++
+		case Disabled:
+			test.SkipMessage = &console.JUnitSkipMessage{Message: "disabled"}
+			results.SkipCount++
+		default:
+			results.PassCount++
+		}
+		results.Tests = append(results.Tests, test)
+	}
+	return results.ToXML()
+}
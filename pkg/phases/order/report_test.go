@@ -0,0 +1,87 @@
+package order
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func testReport() Report {
+	start := time.Date(2021, 6, 1, 2, 0, 0, 0, time.UTC)
+	return NewReport("test", Results{
+		{Name: "crds", Status: Succeeded, Start: start, End: start.Add(1500 * time.Millisecond), Objects: 12},
+		{Name: "minio", Status: Failed, Error: fmt.Errorf("timeout waiting for minio"), Start: start, End: start.Add(2 * time.Minute)},
+		{Name: "velero", Status: Skipped, Error: fmt.Errorf("dependencies did not deploy successfully: minio"), Start: start, End: start},
+		{Name: "vault", Status: Disabled, Start: start, End: start.Add(250 * time.Millisecond)},
+	})
+}
+
+func TestReportToJSON(t *testing.T) {
+	g := NewWithT(t)
+	data, err := testReport().ToJSON()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(string(data)).To(Equal(`{
+  "cluster": "test",
+  "phases": [
+    {
+      "name": "crds",
+      "status": "succeeded",
+      "start": "2021-06-01T02:00:00Z",
+      "end": "2021-06-01T02:00:01.5Z",
+      "duration": "1.5s",
+      "objects": 12
+    },
+    {
+      "name": "minio",
+      "status": "failed",
+      "start": "2021-06-01T02:00:00Z",
+      "end": "2021-06-01T02:02:00Z",
+      "duration": "2m0s",
+      "objects": 0,
+      "error": "timeout waiting for minio"
+    },
+    {
+      "name": "velero",
+      "status": "skipped",
+      "start": "2021-06-01T02:00:00Z",
+      "end": "2021-06-01T02:00:00Z",
+      "duration": "0s",
+      "objects": 0,
+      "error": "dependencies did not deploy successfully: minio"
+    },
+    {
+      "name": "vault",
+      "status": "disabled",
+      "start": "2021-06-01T02:00:00Z",
+      "end": "2021-06-01T02:00:00.25Z",
+      "duration": "250ms",
+      "objects": 0
+    }
+  ]
+}`))
+}
+
+func TestReportToJUnit(t *testing.T) {
+	g := NewWithT(t)
+	xml, err := testReport().ToJUnit()
+	g.Expect(err).ToNot(HaveOccurred())
+	// failed phases are failures, skipped and disabled phases are skipped test cases
+	g.Expect(xml).To(Equal(`<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+	<testsuite tests="4" failures="1" time="" name="test">
+		<properties></properties>
+		<testcase classname="test.deploy" name="crds" time="1.500"></testcase>
+		<testcase classname="test.deploy" name="minio" time="120.000">
+			<failure message="timeout waiting for minio" type=""></failure>
+		</testcase>
+		<testcase classname="test.deploy" name="velero" time="0.000">
+			<skipped message="dependencies did not deploy successfully: minio"></skipped>
+		</testcase>
+		<testcase classname="test.deploy" name="vault" time="0.250">
+			<skipped message="disabled"></skipped>
+		</testcase>
+	</testsuite>
+</testsuites>`))
+}
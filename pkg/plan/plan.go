@@ -0,0 +1,323 @@
+package plan
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/flanksource/karina/pkg/phases/order"
+	"github.com/flanksource/karina/pkg/platform"
+	"github.com/flanksource/kommons"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+type Action string
+
+const (
+	Create    Action = "create"
+	Update    Action = "update"
+	Delete    Action = "delete"
+	Unchanged Action = "unchanged"
+)
+
+const sensitive = "(sensitive)"
+
+// FieldChange is a single field that differs between the live and desired object,
+// Old is nil for fields that are added
+type FieldChange struct {
+	Path string      `json:"path"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// Change is the action a deployment would take on a single object
+type Change struct {
+	Phase     string        `json:"phase"`
+	Action    Action        `json:"action"`
+	Kind      string        `json:"kind"`
+	Namespace string        `json:"namespace,omitempty"`
+	Name      string        `json:"name"`
+	Fields    []FieldChange `json:"fields,omitempty"`
+}
+
+func (change Change) ID() string {
+	if change.Namespace == "" {
+		return fmt.Sprintf("%s/%s", change.Kind, change.Name)
+	}
+	return fmt.Sprintf("%s/%s/%s", change.Kind, change.Namespace, change.Name)
+}
+
+type Plan struct {
+	Changes []Change `json:"changes"`
+	// Errors contains the phases that could not be templated and the reason why
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// Count returns the number of changes with the given action
+func (plan Plan) Count(action Action) int {
+	count := 0
+	for _, change := range plan.Changes {
+		if change.Action == action {
+			count++
+		}
+	}
+	return count
+}
+
+// HasChanges returns true if applying the plan would create, update or delete any objects
+func (plan Plan) HasChanges() bool {
+	return len(plan.Changes) != plan.Count(Unchanged)
+}
+
+// Generate performs a dry-run deployment of the named phases in dependency order, and compares
+// every object that would be applied or pruned with the live object in the cluster.
+func Generate(p *platform.Platform, names []string) (*Plan, error) {
+	sorted, err := order.Sort(names)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{Errors: map[string]string{}}
+	for _, name := range sorted {
+		phase, _ := order.GetPhase(name)
+		var applied, pruned []unstructured.Unstructured
+		dryRun := p.WithField("phase", name)
+		dryRun.DryRun = true
+		dryRun.ApplyDryRun = true
+		dryRun.ApplyHook = func(ns string, obj unstructured.Unstructured) {
+			setNamespace(dryRun, ns, &obj)
+			applied = append(applied, obj)
+		}
+		dryRun.PruneHook = func(ns string, obj unstructured.Unstructured) {
+			setNamespace(dryRun, ns, &obj)
+			pruned = append(pruned, obj)
+		}
+		if err := phase.Fn(dryRun); err != nil {
+			p.Warnf("Error during dry-run of %s: %v", name, err)
+			plan.Errors[name] = err.Error()
+		}
+
+		for _, obj := range applied {
+			change, err := diff(dryRun, name, obj)
+			if err != nil {
+				return nil, err
+			}
+			plan.Changes = append(plan.Changes, *change)
+		}
+		for _, obj := range pruned {
+			live, err := getLive(dryRun, obj)
+			if err != nil {
+				return nil, err
+			}
+			if live == nil {
+				continue
+			}
+			plan.Changes = append(plan.Changes, newChange(name, Delete, obj))
+		}
+	}
+	return plan, nil
+}
+
+func newChange(phase string, action Action, obj unstructured.Unstructured) Change {
+	return Change{
+		Phase:     phase,
+		Action:    action,
+		Kind:      obj.GetKind(),
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+	}
+}
+
+// setNamespace defaults the namespace of namespaced objects to the namespace they are applied to
+func setNamespace(p *platform.Platform, ns string, obj *unstructured.Unstructured) {
+	if obj.GetNamespace() != "" || ns == "" {
+		return
+	}
+	mapping, err := p.WaitForRestMapping(obj, 0)
+	if err != nil || mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		obj.SetNamespace(ns)
+	}
+}
+
+// getLive returns the object currently in the cluster, or nil if it does not exist yet, it is replaced in tests
+var getLive = getLiveObject
+
+func getLiveObject(p *platform.Platform, obj unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	client, _, _, err := p.GetDynamicClientFor(obj.GetNamespace(), &obj)
+	if kommons.IsAPIResourceMissing(err) || meta.IsNoMatchError(err) {
+		// the CRD is created by an earlier phase that has not been applied yet
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client for %s: %v", kommons.GetName(&obj), err)
+	}
+	live, err := client.Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %v", kommons.GetName(&obj), err)
+	}
+	return live, nil
+}
+
+func diff(p *platform.Platform, phase string, desired unstructured.Unstructured) (*Change, error) {
+	live, err := getLive(p, desired)
+	if err != nil {
+		return nil, err
+	}
+	return compare(phase, live, desired)
+}
+
+// compare returns the change needed to update live to desired, masking the data of secrets
+func compare(phase string, live *unstructured.Unstructured, desired unstructured.Unstructured) (*Change, error) {
+	if live == nil {
+		change := newChange(phase, Create, desired)
+		return &change, nil
+	}
+
+	from := live.DeepCopy()
+	to := desired.DeepCopy()
+	kommons.Sanitize(from, to)
+	unstructured.RemoveNestedField(to.Object, "status")
+	if kommons.IsSecret(to) {
+		// stringData is merged into data by the API server
+		stringData, _, _ := unstructured.NestedStringMap(to.Object, "stringData")
+		data, _, _ := unstructured.NestedMap(to.Object, "data")
+		if data == nil {
+			data = map[string]interface{}{}
+		}
+		for k, v := range stringData {
+			data[k] = base64.StdEncoding.EncodeToString([]byte(v))
+		}
+		unstructured.RemoveNestedField(to.Object, "stringData")
+		if len(data) > 0 {
+			to.Object["data"] = data
+		}
+	}
+
+	liveFields, err := normalize(from.Object)
+	if err != nil {
+		return nil, err
+	}
+	desiredFields, err := normalize(to.Object)
+	if err != nil {
+		return nil, err
+	}
+
+	change := newChange(phase, Unchanged, desired)
+	// fields that are only present on the live object are ignored as they are usually defaulted by the API server
+	diffFields("", liveFields, desiredFields, &change.Fields)
+	if kommons.IsSecret(&desired) {
+		for i := range change.Fields {
+			if change.Fields[i].Path == "data" || strings.HasPrefix(change.Fields[i].Path, "data.") {
+				if change.Fields[i].Old != nil {
+					change.Fields[i].Old = sensitive
+				}
+				change.Fields[i].New = sensitive
+			}
+		}
+	}
+	if len(change.Fields) > 0 {
+		change.Action = Update
+	}
+	return &change, nil
+}
+
+// normalize round-trips an object through JSON so that numbers in both the live and desired
+// objects have the same type
+func normalize(object map[string]interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]interface{}{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func diffFields(path string, live, desired interface{}, changes *[]FieldChange) {
+	desiredMap, ok := desired.(map[string]interface{})
+	liveMap, liveOk := live.(map[string]interface{})
+	if !ok || !liveOk {
+		if !reflect.DeepEqual(live, desired) {
+			*changes = append(*changes, FieldChange{Path: path, Old: live, New: desired})
+		}
+		return
+	}
+
+	var keys []string
+	for key := range desiredMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		child := key
+		if path != "" {
+			child = path + "." + key
+		}
+		diffFields(child, liveMap[key], desiredMap[key], changes)
+	}
+}
+
+// Print writes a human readable summary of the plan grouped by phase
+func (plan Plan) Print(w io.Writer) {
+	phase := ""
+	for _, change := range plan.Changes {
+		if change.Action == Unchanged {
+			continue
+		}
+		if change.Phase != phase {
+			phase = change.Phase
+			fmt.Fprintf(w, "\n[%s]\n", phase)
+		}
+		switch change.Action {
+		case Create:
+			fmt.Fprintf(w, "  + %s\n", change.ID())
+		case Delete:
+			fmt.Fprintf(w, "  - %s\n", change.ID())
+		case Update:
+			fmt.Fprintf(w, "  ~ %s\n", change.ID())
+			for _, field := range change.Fields {
+				fmt.Fprintf(w, "      %s: %s => %s\n", field.Path, format(field.Old), format(field.New))
+			}
+		}
+	}
+	var failed []string
+	for phase := range plan.Errors {
+		failed = append(failed, phase)
+	}
+	sort.Strings(failed)
+	for _, phase := range failed {
+		fmt.Fprintf(w, "\n[%s] failed to template: %s\n", phase, plan.Errors[phase])
+	}
+	fmt.Fprintf(w, "\nPlan: %d to create, %d to update, %d to delete, %d unchanged\n",
+		plan.Count(Create), plan.Count(Update), plan.Count(Delete), plan.Count(Unchanged))
+}
+
+func format(value interface{}) string {
+	if value == nil {
+		return "(none)"
+	}
+	if s, ok := value.(string); ok {
+		if s == sensitive {
+			return s
+		}
+		return fmt.Sprintf("%q", s)
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
@@ -0,0 +1,151 @@
+package plan
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"github.com/flanksource/commons/logger"
+	"github.com/flanksource/karina/pkg/phases/order"
+	"github.com/flanksource/karina/pkg/platform"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func object(kind, namespace, name string, fields map[string]interface{}) unstructured.Unstructured {
+	obj := unstructured.Unstructured{Object: map[string]interface{}{"apiVersion": "v1", "kind": kind}}
+	for k, v := range fields {
+		obj.Object[k] = v
+	}
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	return obj
+}
+
+// withLive replaces the cluster lookup with the given objects for the duration of a test
+func withLive(t *testing.T, objects ...unstructured.Unstructured) {
+	live := map[string]unstructured.Unstructured{}
+	for _, obj := range objects {
+		live[obj.GetKind()+"/"+obj.GetNamespace()+"/"+obj.GetName()] = obj
+	}
+	original := getLive
+	getLive = func(p *platform.Platform, obj unstructured.Unstructured) (*unstructured.Unstructured, error) {
+		if existing, ok := live[obj.GetKind()+"/"+obj.GetNamespace()+"/"+obj.GetName()]; ok {
+			return existing.DeepCopy(), nil
+		}
+		return nil, nil
+	}
+	t.Cleanup(func() { getLive = original })
+}
+
+// withPhases replaces the phase registry for the duration of a test
+func withPhases(t *testing.T, phases map[string]order.Phase) {
+	original, originalExtra := order.Phases, order.PhasesExtra
+	order.Phases, order.PhasesExtra = phases, map[string]order.Phase{}
+	t.Cleanup(func() { order.Phases, order.PhasesExtra = original, originalExtra })
+}
+
+func TestGenerate(t *testing.T) {
+	g := NewWithT(t)
+	withLive(t,
+		object("ConfigMap", "default", "same", map[string]interface{}{"data": map[string]interface{}{"key": "value"}}),
+		object("Deployment", "default", "app", map[string]interface{}{"spec": map[string]interface{}{"replicas": int64(1), "paused": false}}),
+		object("ConfigMap", "default", "old", nil),
+	)
+	withPhases(t, map[string]order.Phase{
+		"b": {DependsOn: []string{"a"}, Fn: func(p *platform.Platform) error {
+			p.ApplyHook("default", object("ConfigMap", "default", "partial", nil))
+			return fmt.Errorf("failed to template")
+		}},
+		"a": {Fn: func(p *platform.Platform) error {
+			p.ApplyHook("default", object("ConfigMap", "default", "new", nil))
+			p.ApplyHook("default", object("ConfigMap", "default", "same", map[string]interface{}{"data": map[string]interface{}{"key": "value"}}))
+			p.ApplyHook("default", object("Deployment", "default", "app", map[string]interface{}{"spec": map[string]interface{}{"replicas": int64(3)}}))
+			p.PruneHook("default", object("ConfigMap", "default", "old", nil))
+			p.PruneHook("default", object("ConfigMap", "default", "already-deleted", nil))
+			return nil
+		}},
+	})
+
+	plan, err := Generate(&platform.Platform{Logger: logger.StandardLogger()}, []string{"b", "a"})
+	g.Expect(err).ToNot(HaveOccurred())
+	var changes []string
+	for _, change := range plan.Changes {
+		changes = append(changes, fmt.Sprintf("%s %s %s", change.Phase, change.Action, change.ID()))
+	}
+	// phases are planned in dependency order, and pruned objects that no longer exist are ignored
+	g.Expect(changes).To(Equal([]string{
+		"a create ConfigMap/default/new",
+		"a unchanged ConfigMap/default/same",
+		"a update Deployment/default/app",
+		"a delete ConfigMap/default/old",
+		"b create ConfigMap/default/partial",
+	}))
+	// fields that are only on the live object are ignored
+	g.Expect(plan.Changes[2].Fields).To(Equal([]FieldChange{{Path: "spec.replicas", Old: float64(1), New: float64(3)}}))
+	g.Expect(plan.Errors).To(Equal(map[string]string{"b": "failed to template"}))
+	g.Expect(plan.HasChanges()).To(BeTrue())
+	g.Expect(plan.Count(Unchanged)).To(Equal(1))
+
+	var out bytes.Buffer
+	plan.Print(&out)
+	g.Expect(out.String()).To(Equal(`
+[a]
+  + ConfigMap/default/new
+  ~ Deployment/default/app
+      spec.replicas: 1 => 3
+  - ConfigMap/default/old
+
+[b]
+  + ConfigMap/default/partial
+
+[b] failed to template: failed to template
+
+Plan: 2 to create, 1 to update, 1 to delete, 1 unchanged
+`))
+}
+
+func TestCompareMasksSecrets(t *testing.T) {
+	g := NewWithT(t)
+	encode := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+	live := object("Secret", "default", "credentials", map[string]interface{}{
+		"type": "Opaque",
+		"data": map[string]interface{}{"password": encode("old-password"), "unchanged": encode("same")},
+	})
+	desired := object("Secret", "default", "credentials", map[string]interface{}{
+		"type":       "Opaque",
+		"data":       map[string]interface{}{"unchanged": encode("same")},
+		"stringData": map[string]interface{}{"password": "new-password", "username": "admin"},
+	})
+
+	change, err := compare("vault", &live, desired)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(change.Action).To(Equal(Update))
+	// stringData is compared as the data the API server would store, without revealing either value
+	g.Expect(change.Fields).To(Equal([]FieldChange{
+		{Path: "data.password", Old: sensitive, New: sensitive},
+		{Path: "data.username", New: sensitive},
+	}))
+
+	plan := Plan{Changes: []Change{*change}}
+	var out bytes.Buffer
+	plan.Print(&out)
+	g.Expect(out.String()).To(ContainSubstring("data.password: (sensitive) => (sensitive)"))
+	g.Expect(out.String()).To(ContainSubstring("data.username: (none) => (sensitive)"))
+	for _, value := range []string{"old-password", "new-password", "admin", encode("old-password"), encode("new-password"), encode("admin")} {
+		g.Expect(out.String()).ToNot(ContainSubstring(value))
+	}
+
+	// secrets that have not changed are unchanged
+	same, err := compare("vault", &live, *live.DeepCopy())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(same.Action).To(Equal(Unchanged))
+	g.Expect(same.Fields).To(BeEmpty())
+
+	// new secrets are created without their data
+	created, err := compare("vault", nil, desired)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(created.Action).To(Equal(Create))
+	g.Expect(created.Fields).To(BeEmpty())
+}
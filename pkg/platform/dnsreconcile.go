@@ -0,0 +1,146 @@
+package platform
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	dnsclient "github.com/flanksource/karina/pkg/client/dns"
+	"github.com/flanksource/kommons"
+	"github.com/pkg/errors"
+	"github.com/thoas/go-funk"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DNSChange is the difference between the A records of a round-robin name and the nodes that should serve it
+type DNSChange struct {
+	Domain string
+	Add    []string
+	Remove []string
+	// Claim is true if the name has no ownership record and one is added
+	Claim bool
+	// Skipped is the reason the records of the name are left as is
+	Skipped string
+}
+
+// IsEmpty returns true if the records of the name are up to date
+func (change DNSChange) IsEmpty() bool {
+	return len(change.Add) == 0 && len(change.Remove) == 0 && !change.Claim && change.Skipped == ""
+}
+
+// RoundRobinNodes returns the IPs that each round-robin name of the cluster should resolve to, the ready masters for
+// k8s-api.<domain> and the ready and schedulable workers for *.<domain>, matching the records added by DNSProvider
+func (platform *Platform) RoundRobinNodes(client kubernetes.Interface) (map[string][]string, error) {
+	nodes, err := client.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list nodes")
+	}
+	desired := map[string][]string{
+		roundRobinName(platform, true):  {},
+		roundRobinName(platform, false): {},
+	}
+	for _, node := range nodes.Items {
+		master := kommons.IsMasterNode(node)
+		if kommons.IsDeleted(&node) || !isNodeReady(node) || (!master && node.Spec.Unschedulable) {
+			continue
+		}
+		ip := nodeIP(node)
+		if ip == "" {
+			continue
+		}
+		name := roundRobinName(platform, master)
+		desired[name] = append(desired[name], ip)
+	}
+	for name := range desired {
+		sort.Strings(desired[name])
+	}
+	return desired, nil
+}
+
+// ReconcileDNS adds the ready nodes that are missing from the round-robin records of the cluster and removes the
+// records of nodes that are gone or not ready, only changing names owned by the cluster unless adopt or dns.adopt is true.
+// With --dry-run the changes are returned without being made
+func (platform *Platform) ReconcileDNS(adopt bool) ([]DNSChange, error) {
+	if !platform.DNS.IsEnabled() {
+		return nil, fmt.Errorf("dns is disabled")
+	}
+	client, err := platform.GetClientset()
+	if err != nil {
+		return nil, err
+	}
+	desired, err := platform.RoundRobinNodes(client)
+	if err != nil {
+		return nil, err
+	}
+	registry := dnsclient.Registry{Client: platform.GetDNSClient(), Owner: platform.Name}
+	return reconcileDNS(registry, desired, adopt || platform.DNS.Adopt, platform.DryRun)
+}
+
+func reconcileDNS(registry dnsclient.Registry, desired map[string][]string, adopt, dryRun bool) ([]DNSChange, error) {
+	var names []string
+	for name := range desired {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var changes []DNSChange
+	for _, name := range names {
+		existing, err := registry.Get(name, dnsclient.A)
+		if err != nil {
+			return changes, errors.Wrapf(err, "failed to get %s", name)
+		}
+		owner, err := registry.GetOwner(name)
+		if err != nil {
+			return changes, errors.Wrapf(err, "failed to get the owner of %s", name)
+		}
+		change := DNSChange{
+			Domain: name,
+			Add:    funk.SubtractString(desired[name], existing),
+			Remove: funk.SubtractString(existing, desired[name]),
+			Claim:  owner == "",
+		}
+		sort.Strings(change.Remove)
+		switch {
+		case owner != "" && owner != registry.Owner:
+			change.Skipped = fmt.Sprintf("owned by %s", owner)
+		case owner == "" && len(existing) > 0 && !adopt:
+			change.Skipped = fmt.Sprintf("not owned by %s, use --adopt to take ownership", registry.Owner)
+		case len(desired[name]) == 0:
+			// never remove every record, e.g. when all nodes are briefly not ready
+			change.Skipped = "no ready nodes"
+		}
+		changes = append(changes, change)
+		if dryRun || change.Skipped != "" || change.IsEmpty() {
+			continue
+		}
+		if change.Claim {
+			if err := registry.Claim(name); err != nil {
+				return changes, err
+			}
+		}
+		if err := registry.Update(name, dnsclient.A, desired[name]...); err != nil {
+			return changes, errors.Wrapf(err, "failed to update %s", name)
+		}
+	}
+	return changes, nil
+}
+
+func isNodeReady(node v1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == v1.NodeReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func nodeIP(node v1.Node) string {
+	for _, address := range node.Status.Addresses {
+		if address.Type == v1.NodeInternalIP {
+			return address.Address
+		}
+	}
+	return ""
+}
@@ -0,0 +1,150 @@
+package platform
+
+import (
+	"testing"
+
+	"github.com/flanksource/commons/logger"
+	dnsclient "github.com/flanksource/karina/pkg/client/dns"
+	fakecluster "github.com/flanksource/karina/pkg/provision/fake"
+	"github.com/flanksource/karina/pkg/types"
+	. "github.com/onsi/gomega"
+	"github.com/thoas/go-funk"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// memoryDNS is a dnsclient.Client keeping records in memory
+type memoryDNS map[string][]string
+
+func (m memoryDNS) Append(domain, recordType string, records ...string) error {
+	m[domain+" "+recordType] = funk.UniqString(append(m[domain+" "+recordType], records...))
+	return nil
+}
+
+func (m memoryDNS) Get(domain, recordType string) ([]string, error) {
+	return m[domain+" "+recordType], nil
+}
+
+func (m memoryDNS) Update(domain, recordType string, records ...string) error {
+	m[domain+" "+recordType] = records
+	return nil
+}
+
+func (m memoryDNS) Delete(domain, recordType string, records ...string) error {
+	m[domain+" "+recordType] = funk.SubtractString(m[domain+" "+recordType], records)
+	return nil
+}
+
+func testNode(name, ip string, master, ready, unschedulable bool) *v1.Node {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{}},
+		Spec:       v1.NodeSpec{Unschedulable: unschedulable},
+		Status: v1.NodeStatus{
+			Addresses:  []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: ip}},
+			Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionFalse}},
+		},
+	}
+	if master {
+		node.Labels["node-role.kubernetes.io/master"] = ""
+	}
+	if ready {
+		node.Status.Conditions[0].Status = v1.ConditionTrue
+	}
+	return node
+}
+
+func TestReconcileDNS(t *testing.T) {
+	g := NewWithT(t)
+	platform := &Platform{PlatformConfig: types.PlatformConfig{Name: "test", Domain: "example.com"}}
+	client := fake.NewSimpleClientset(
+		testNode("master-1", "10.0.0.1", true, true, false),
+		testNode("master-2", "10.0.0.2", true, false, false),
+		testNode("worker-1", "10.0.1.1", false, true, false),
+		testNode("worker-2", "10.0.1.2", false, true, true),
+		testNode("worker-3", "10.0.1.3", false, true, false),
+	)
+	desired, err := platform.RoundRobinNodes(client)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(desired).To(Equal(map[string][]string{
+		"k8s-api.example.com": {"10.0.0.1"},
+		"*.example.com":       {"10.0.1.1", "10.0.1.3"},
+	}))
+
+	records := memoryDNS{
+		"k8s-api.example.com A":                 {"10.0.0.1", "10.0.0.2"},
+		"_karina-owner.k8s-api.example.com TXT": {dnsclient.OwnerRecord("test")},
+		"*.example.com A":                       {"10.0.1.1", "10.0.1.9"},
+	}
+	registry := dnsclient.Registry{Client: records, Owner: "test"}
+
+	// a dry run makes no changes, and names without an ownership record are not changed without adopt
+	changes, err := reconcileDNS(registry, desired, false, true)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(changes).To(Equal([]DNSChange{
+		{Domain: "*.example.com", Add: []string{"10.0.1.3"}, Remove: []string{"10.0.1.9"}, Claim: true, Skipped: "not owned by test, use --adopt to take ownership"},
+		{Domain: "k8s-api.example.com", Add: []string{}, Remove: []string{"10.0.0.2"}},
+	}))
+	g.Expect(records["k8s-api.example.com A"]).To(ConsistOf("10.0.0.1", "10.0.0.2"))
+
+	changes, err = reconcileDNS(registry, desired, true, false)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(changes).To(HaveLen(2))
+	g.Expect(records["k8s-api.example.com A"]).To(ConsistOf("10.0.0.1"))
+	g.Expect(records["*.example.com A"]).To(ConsistOf("10.0.1.1", "10.0.1.3"))
+	g.Expect(registry.GetOwner("*.example.com")).To(Equal("test"))
+
+	changes, err = reconcileDNS(registry, desired, false, false)
+	g.Expect(err).ToNot(HaveOccurred())
+	for _, change := range changes {
+		g.Expect(change.IsEmpty()).To(BeTrue(), change.Domain)
+	}
+
+	// names owned by another cluster are never changed
+	other := dnsclient.Registry{Client: records, Owner: "other"}
+	changes, err = reconcileDNS(other, map[string][]string{"k8s-api.example.com": {"10.0.9.1"}}, true, false)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(changes[0].Skipped).To(Equal("owned by test"))
+	g.Expect(records["k8s-api.example.com A"]).To(ConsistOf("10.0.0.1"))
+
+	// the last record is never removed
+	changes, err = reconcileDNS(registry, map[string][]string{"k8s-api.example.com": {}}, false, false)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(changes[0].Skipped).To(Equal("no ready nodes"))
+	g.Expect(records["k8s-api.example.com A"]).To(ConsistOf("10.0.0.1"))
+}
+
+func TestDNSProviderAdopt(t *testing.T) {
+	g := NewWithT(t)
+	platform := &Platform{PlatformConfig: types.PlatformConfig{Name: "test", Domain: "example.com"}}
+	platform.Logger = logger.StandardLogger()
+	worker := fakecluster.NewCluster(platform.PlatformConfig).Add(types.VM{Name: "worker-1"})
+	records := memoryDNS{"*.example.com A": {"10.0.9.1"}}
+	provider := NewDNSProvider(records)
+
+	// records that nobody owns are not adopted when a worker is provisioned
+	g.Expect(provider.AfterProvision(platform, worker)).To(Succeed())
+	g.Expect(records["*.example.com A"]).To(ConsistOf("10.0.9.1"))
+	g.Expect(provider.registry(platform).GetOwner("*.example.com")).To(BeEmpty())
+
+	platform.DNS.Adopt = true
+	g.Expect(provider.AfterProvision(platform, worker)).To(Succeed())
+	g.Expect(records["*.example.com A"]).To(ConsistOf("10.0.9.1", worker.IP()))
+	g.Expect(provider.registry(platform).GetOwner("*.example.com")).To(Equal("test"))
+	g.Expect(records).ToNot(HaveKey("*.example.com TXT"))
+
+	// names without records are claimed
+	platform.DNS.Adopt = false
+	delete(records, "*.example.com A")
+	delete(records, "_karina-owner-wildcard.example.com TXT")
+	g.Expect(provider.AfterProvision(platform, worker)).To(Succeed())
+	g.Expect(records["*.example.com A"]).To(ConsistOf(worker.IP()))
+}
+
+func TestWithFieldKeepsDNSClient(t *testing.T) {
+	g := NewWithT(t)
+	client := &dnsclient.DummyDNSClient{Zone: "example.com"}
+	platform := &Platform{Logger: logger.StandardLogger(), dnsClient: client}
+	// phases log with a field, they must use the same DNS client instead of creating a new one
+	g.Expect(platform.WithField("phase", "ingress").GetDNSClient()).To(BeIdenticalTo(client))
+}
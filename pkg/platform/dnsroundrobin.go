@@ -3,15 +3,15 @@ package platform
 import (
 	"fmt"
 
-	"github.com/flanksource/karina/pkg/client/dns"
+	dnsclient "github.com/flanksource/karina/pkg/client/dns"
 	"github.com/flanksource/karina/pkg/types"
 )
 
 type DNSProvider struct {
-	dns.Client
+	dnsclient.Client
 }
 
-func NewDNSProvider(client dns.Client) DNSProvider {
+func NewDNSProvider(client dnsclient.Client) DNSProvider {
 	provider := DNSProvider{}
 	provider.Client = client
 	return provider
@@ -21,14 +21,47 @@ func (dns DNSProvider) String() string {
 	return fmt.Sprintf("DNS(%s)", dns.Client)
 }
 
+// registry returns the registry of the names owned by the cluster
+func (dns DNSProvider) registry(platform *Platform) dnsclient.Registry {
+	return dnsclient.Registry{Client: dns.Client, Owner: platform.Name}
+}
+
+// roundRobinName returns the name whose A records include every master, or every worker
+func roundRobinName(platform *Platform, master bool) string {
+	if master {
+		return "k8s-api." + platform.Domain
+	}
+	return "*." + platform.Domain
+}
+
+// adoptable returns false if name has A records but no owner, claiming it would take over records that may have
+// been created by someone else
+func (dns DNSProvider) adoptable(registry dnsclient.Registry, name string) (bool, error) {
+	owner, err := registry.GetOwner(name)
+	if err != nil || owner != "" {
+		return true, err
+	}
+	existing, err := registry.Get(name, dnsclient.A)
+	return len(existing) == 0, err
+}
+
 func (dns DNSProvider) BeforeProvision(platform *Platform, machine *types.VM) error { return nil }
 func (dns DNSProvider) AfterProvision(platform *Platform, machine types.Machine) error {
-	zone := "*."
-	if platform.IsMaster(machine) {
-		zone = "k8s-api."
+	name := roundRobinName(platform, platform.IsMaster(machine))
+	registry := dns.registry(platform)
+	if adopt, err := dns.adoptable(registry, name); err != nil {
+		platform.Warnf("Not updating DNS for %s: %v", machine.IP(), err)
+		return nil
+	} else if !adopt && !platform.DNS.Adopt {
+		platform.Warnf("Not updating DNS for %s: %s has records that are not owned by %s, set dns.adopt or use karina dns reconcile --adopt to take ownership", machine.IP(), name, registry.Owner)
+		return nil
+	}
+	if err := registry.Claim(name); err != nil {
+		platform.Warnf("Not updating DNS for %s: %v", machine.IP(), err)
+		return nil
 	}
 
-	if err := dns.Append(zone+platform.Domain, machine.IP()); err != nil {
+	if err := registry.Append(name, dnsclient.A, machine.IP()); err != nil {
 		platform.Warnf("Failed to update DNS for %s", machine.IP())
 	}
 
@@ -36,12 +69,13 @@ func (dns DNSProvider) AfterProvision(platform *Platform, machine types.Machine)
 }
 
 func (dns DNSProvider) BeforeTerminate(platform *Platform, machine types.Machine) error {
-	zone := "*."
-	if platform.IsMaster(machine) {
-		zone = "k8s-api."
+	name := roundRobinName(platform, platform.IsMaster(machine))
+	if owner, err := dns.registry(platform).GetOwner(name); err == nil && owner != "" && owner != platform.Name {
+		platform.Warnf("Not updating DNS for %s: %s is owned by %s", machine.IP(), name, owner)
+		return nil
 	}
 
-	if err := dns.Delete(zone+platform.Domain, machine.IP()); err != nil {
+	if err := dns.Delete(name, dnsclient.A, machine.IP()); err != nil {
 		platform.Warnf("Failed to update DNS for %s", machine.IP())
 	}
 	return nil
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/flanksource/commons/logger"
+	dnsclient "github.com/flanksource/karina/pkg/client/dns"
 	nsxapi "github.com/flanksource/karina/pkg/nsx"
 	"github.com/flanksource/karina/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -177,7 +178,7 @@ func updateDNS(platform *Platform, dns string, ip string) string {
 		// will fail so we substitute it with a random domain
 		lookupDNS = "random-wildcard" + dns[1:]
 	}
-	ips, err := platform.GetDNSClient().Get(lookupDNS)
+	ips, err := platform.GetDNSClient().Get(lookupDNS, dnsclient.A)
 	if err != nil {
 		// try using the system resolver
 		_ips, err := net.LookupIP(lookupDNS)
@@ -195,7 +196,7 @@ func updateDNS(platform *Platform, dns string, ip string) string {
 		platform.Infof("Updating DNS %s: from %s to %s", dns, ips[0], ip)
 	}
 	if len(ips) == 0 || ips[0] != ip {
-		if err := platform.GetDNSClient().Update(dns, ip); err != nil {
+		if err := platform.GetDNSClient().Update(dns, dnsclient.A, ip); err != nil {
 			platform.Warnf("Failed to create DNS entry for %s, failing back to IP: %s: %v", dns, ip, err)
 			return ip
 		}
@@ -54,6 +54,8 @@ type Platform struct {
 	types.PlatformConfig
 	MasterDiscovery MasterDiscovery
 	ProvisionHook   ProvisionHook
+	// PruneHook is called for every object that DeleteSpecs would delete
+	PruneHook kommons.ApplyHook
 	logger.Logger
 	logFields map[string]interface{}
 	kommons.Client
@@ -64,6 +66,7 @@ type Platform struct {
 	ca              certs.CertificateAuthority
 	ingressCA       certs.CertificateAuthority
 	defaultIssuerCA []byte
+	dnsClient       dns.Client
 	// Terminating is true if the cluster is in a terminating state
 	Terminating bool
 }
@@ -158,9 +161,11 @@ func (platform *Platform) clone() *Platform {
 		ctx:             context.TODO(),
 		MasterDiscovery: platform.MasterDiscovery,
 		ProvisionHook:   platform.ProvisionHook,
+		PruneHook:       platform.PruneHook,
 		kubeConfig:      platform.kubeConfig,
 		ca:              platform.ca,
 		ingressCA:       platform.ingressCA,
+		dnsClient:       platform.dnsClient,
 		KubeConfigPath:  platform.KubeConfigPath,
 		Terminating:     platform.Terminating,
 	}
@@ -270,19 +275,27 @@ func (platform *Platform) GetKubeConfigBytes() ([]byte, error) {
 
 // GetCA retrieves the cert.CertificateAuthority
 // for the given platform, initialising it (platform.ca) if it hasn't been read from
-// the specified config (platform.CA) yet.
+// the specified config (platform.CA) yet. When the CA key is held in an HSM or Vault
+// only a signer for it is held in memory.
 func (platform *Platform) GetCA() certs.CertificateAuthority {
 	if platform.ca != nil {
 		return platform.ca
 	}
-	ca, err := ca.ReadCA(platform.CA)
+	ca, err := ca.ReadAuthority(platform.CA)
 	if err != nil {
-		platform.Fatalf("Unable to open %s: %v", platform.CA.PrivateKey, err)
+		platform.Fatalf("Unable to open %s: %v", platform.CA.Cert, err)
 	}
 	platform.ca = ca
 	return ca
 }
 
+// SetCA replaces the CA that signs the kubeconfig karina connects with, e.g. while the CA is being rotated
+func (platform *Platform) SetCA(ca certs.CertificateAuthority) {
+	platform.ca = ca
+	platform.kubeConfig = nil
+	platform.ResetConnection()
+}
+
 func (platform *Platform) ReadIngressCACertString() string {
 	cert := files.SafeRead(platform.IngressCA.Cert)
 	return cert
@@ -324,33 +337,23 @@ func (platform *Platform) WaitForAPIServer() error {
 	}
 }
 
+// GetDNSClient returns the client of the provider selected by dns.type, or a stub if DNS is disabled
 func (platform *Platform) GetDNSClient() dns.Client {
+	if platform.dnsClient != nil {
+		return platform.dnsClient
+	}
 	if !platform.DNS.IsEnabled() {
 		return &dns.DummyDNSClient{
 			Logger: platform.Logger,
 			Zone:   "nip.io",
 		}
 	}
-
-	if platform.DNS.Type == "route53" {
-		dns := &dns.Route53Client{
-			Logger:       platform.Logger,
-			HostedZoneID: platform.DNS.Zone,
-			AccessKey:    platform.DNS.AccessKey,
-			SecretKey:    platform.DNS.SecretKey,
-		}
-		dns.Init()
-		return dns
-	}
-
-	return &dns.DynamicDNSClient{
-		Logger:     platform.Logger,
-		Zone:       platform.DNS.Zone,
-		KeyName:    platform.DNS.KeyName,
-		Nameserver: platform.DNS.Nameserver,
-		Key:        platform.DNS.Key,
-		Algorithm:  platform.DNS.Algorithm,
+	client, err := dns.NewClient(platform.DNS, platform.Logger)
+	if err != nil {
+		platform.Fatalf("Unable to create DNS client: %v", err)
 	}
+	platform.dnsClient = client
+	return client
 }
 
 func (platform *Platform) Clone(vm types.VM, config *konfigadm.Config) (types.Machine, error) {
@@ -665,6 +668,9 @@ func (platform *Platform) DeleteSpecs(namespace string, specs ...string) error {
 		}
 
 		for _, object := range objects {
+			if platform.PruneHook != nil {
+				platform.PruneHook(namespace, *object)
+			}
 			platform.Debugf("Deleting %v", kommons.GetName(object))
 			if err := platform.DeleteUnstructured(namespace, object); err != nil {
 				return errors.Wrapf(err, "error deleting %v", kommons.GetName(object))
@@ -0,0 +1,521 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flanksource/karina/pkg/constants"
+	"github.com/flanksource/karina/pkg/controller/burnin"
+	"github.com/flanksource/karina/pkg/maintenance"
+	"github.com/flanksource/karina/pkg/platform"
+	"github.com/flanksource/kommons"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+type AutoscalerOptions struct {
+	// Interval between checks for unschedulable pods and underutilised nodes
+	Interval time.Duration
+	// ScaleUpCooldown is the minimum time between scaling up the same pool
+	ScaleUpCooldown time.Duration
+	// ScaleDownCooldown is the minimum time after a pool was scaled up or down before it is scaled down
+	ScaleDownCooldown time.Duration
+	// UnneededTime is how long a node needs to be underutilised before it is removed
+	UnneededTime time.Duration
+	// Utilisation is the fraction of allocatable cpu and memory requested below which a node is underutilised
+	Utilisation float64
+	// BurninPeriod is how long new nodes are burnt-in for before workloads are scheduled on them
+	BurninPeriod time.Duration
+	Drain        DrainOptions
+}
+
+// Autoscaler adds and removes workers in pools that have a maxCount, based on unschedulable pods
+// and node utilisation
+type Autoscaler struct {
+	*platform.Platform
+	AutoscalerOptions
+	lastScaleUp   map[string]time.Time
+	lastScaleDown map[string]time.Time
+	unneededSince map[string]time.Time
+	now           func() time.Time
+	// scaleUp and scaleDown can be replaced in tests
+	scaleUp   func(pool string, count int) error
+	scaleDown func(pool string, node v1.Node) error
+}
+
+// scaleDecision is a change to the number of workers in a pool
+type scaleDecision struct {
+	pool   string
+	add    int
+	remove []v1.Node
+	reason string
+}
+
+func NewAutoscaler(platform *platform.Platform, opts AutoscalerOptions) *Autoscaler {
+	a := &Autoscaler{
+		Platform:          platform,
+		AutoscalerOptions: opts,
+		lastScaleUp:       map[string]time.Time{},
+		lastScaleDown:     map[string]time.Time{},
+		unneededSince:     map[string]time.Time{},
+		now:               time.Now,
+	}
+	a.scaleUp = a.addWorkers
+	a.scaleDown = a.removeWorker
+	return a
+}
+
+// Run reconciles autoscaled pools every interval until quit receives a value
+func (a *Autoscaler) Run(quit chan bool) error {
+	if err := WithCluster(a.Platform); err != nil {
+		return err
+	}
+	if err := a.Drain.Validate(); err != nil {
+		return err
+	}
+	var pools []string
+	for _, name := range a.autoscaledPools() {
+		vm := a.Nodes[name]
+		pools = append(pools, fmt.Sprintf("%s (%d-%d)", name, vm.MinCount, vm.MaxCount))
+	}
+	if len(pools) == 0 {
+		return fmt.Errorf("no node pools have a maxCount, nothing to autoscale")
+	}
+	a.Infof("Autoscaling pools %s", strings.Join(pools, ", "))
+
+	// new workers are only ready once the burnin taint is removed
+	burninCancel := make(chan bool)
+	go burnin.Run(a.Platform, a.BurninPeriod, burninCancel)
+	defer func() {
+		burninCancel <- false
+	}()
+
+	for {
+		if err := a.Reconcile(); err != nil {
+			a.Errorf("Error reconciling: %v", err)
+		}
+		select {
+		case <-quit:
+			return nil
+		case <-time.After(a.Interval):
+		}
+	}
+}
+
+// Reconcile scales each autoscaled pool once
+func (a *Autoscaler) Reconcile() error {
+	client, err := a.GetClientset()
+	if err != nil {
+		return err
+	}
+	nodes, err := client.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "failed to list nodes")
+	}
+	pods, err := client.CoreV1().Pods(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "failed to list pods")
+	}
+
+	var errs []string
+	for _, decision := range a.plan(nodes.Items, pods.Items) {
+		if decision.add > 0 {
+			a.Infof("[%s] adding %d workers: %s", decision.pool, decision.add, decision.reason)
+			a.lastScaleUp[decision.pool] = a.now()
+			if err := a.scaleUp(decision.pool, decision.add); err != nil {
+				errs = append(errs, fmt.Sprintf("[%s] %v", decision.pool, err))
+			}
+		}
+		for _, node := range decision.remove {
+			if !a.scaleDownAllowed(decision.pool) {
+				break
+			}
+			a.Infof("[%s] removing %s: %s", decision.pool, node.Name, decision.reason)
+			a.lastScaleDown[decision.pool] = a.now()
+			delete(a.unneededSince, node.Name)
+			if err := a.scaleDown(decision.pool, node); err != nil {
+				errs = append(errs, fmt.Sprintf("[%s] %v", decision.pool, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// scaleDownAllowed returns false during a change freeze or outside of maintenance windows, as
+// removing a worker drains its pods
+func (a *Autoscaler) scaleDownAllowed(pool string) bool {
+	calendar, err := maintenance.New(a.Maintenance)
+	if err != nil {
+		a.Warnf("[%s] not scaling down: %v", pool, err)
+		return false
+	}
+	if err := calendar.Check(a.now()); err != nil {
+		a.Debugf("[%s] not scaling down: %v", pool, err)
+		return false
+	}
+	return true
+}
+
+// autoscaledPools returns the sorted names of pools with a maxCount
+func (a *Autoscaler) autoscaledPools() []string {
+	var pools []string
+	for name, vm := range a.Nodes {
+		if vm.IsAutoscaled() {
+			pools = append(pools, name)
+		}
+	}
+	sort.Strings(pools)
+	return pools
+}
+
+// plan decides how to scale each autoscaled pool:
+//   - pools outside of their min and max bounds are scaled to within them
+//   - pools are scaled up to fit unschedulable pods that would fit on a new worker in the pool
+//   - the oldest worker that has been underutilised for the unneeded time is removed, if its pods fit on
+//     the remaining workers in the pool
+func (a *Autoscaler) plan(nodes []v1.Node, pods []v1.Pod) []scaleDecision {
+	now := a.now()
+	poolNodes := map[string][]v1.Node{}
+	for _, node := range nodes {
+		if pool, ok := node.Labels[constants.NodePoolLabel]; ok && !kommons.IsMasterNode(node) {
+			poolNodes[pool] = append(poolNodes[pool], node)
+		}
+	}
+	for _, list := range poolNodes {
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].CreationTimestamp.Before(&list[j].CreationTimestamp)
+		})
+	}
+	nodePods := map[string][]v1.Pod{}
+	var unschedulable []v1.Pod
+	for _, pod := range pods {
+		if kommons.IsPodFinished(pod) {
+			continue
+		}
+		if pod.Spec.NodeName != "" {
+			nodePods[pod.Spec.NodeName] = append(nodePods[pod.Spec.NodeName], pod)
+		} else if isUnschedulable(pod) {
+			unschedulable = append(unschedulable, pod)
+		}
+	}
+
+	pools := a.autoscaledPools()
+	pending := map[string][]v1.Pod{}
+	for _, pod := range unschedulable {
+		if pool := a.poolFor(pod, pools, poolNodes); pool != "" {
+			pending[pool] = append(pending[pool], pod)
+		}
+	}
+
+	var decisions []scaleDecision
+	unneeded := map[string]bool{}
+	for _, pool := range pools {
+		vm := a.Nodes[pool]
+		current := poolNodes[pool]
+		if desired := vm.DesiredCount(len(current)); desired > len(current) {
+			decisions = append(decisions, scaleDecision{pool: pool, add: desired - len(current), reason: fmt.Sprintf("below minCount of %d", vm.MinCount)})
+			continue
+		} else if desired < len(current) {
+			decisions = append(decisions, scaleDecision{pool: pool, remove: current[:len(current)-desired], reason: fmt.Sprintf("above maxCount of %d", vm.MaxCount)})
+			continue
+		}
+
+		var starting []string
+		for _, node := range current {
+			if !isNodeReady(node) || kommons.HasTaint(node, burnin.Taint) {
+				starting = append(starting, node.Name)
+			}
+		}
+
+		if podsToFit := pending[pool]; len(podsToFit) > 0 {
+			switch {
+			case len(starting) > 0:
+				a.Debugf("[%s] %d unschedulable pods, waiting for %s to become ready", pool, len(podsToFit), strings.Join(starting, ", "))
+			case now.Sub(a.lastScaleUp[pool]) < a.ScaleUpCooldown:
+				a.Debugf("[%s] %d unschedulable pods, scaled up %s ago", pool, len(podsToFit), now.Sub(a.lastScaleUp[pool]))
+			default:
+				add := nodesNeeded(podsToFit, a.capacity(pool, current))
+				if add > vm.MaxCount-len(current) {
+					add = vm.MaxCount - len(current)
+				}
+				decisions = append(decisions, scaleDecision{pool: pool, add: add, reason: fmt.Sprintf("%d unschedulable pods", len(podsToFit))})
+			}
+			continue
+		}
+
+		if len(starting) > 0 || len(current) <= vm.MinCount ||
+			now.Sub(a.lastScaleUp[pool]) < a.ScaleDownCooldown || now.Sub(a.lastScaleDown[pool]) < a.ScaleDownCooldown {
+			continue
+		}
+		for _, node := range current {
+			if !a.isUnneeded(node, nodePods[node.Name]) {
+				continue
+			}
+			unneeded[node.Name] = true
+			if _, ok := a.unneededSince[node.Name]; !ok {
+				a.unneededSince[node.Name] = now
+			}
+			if now.Sub(a.unneededSince[node.Name]) < a.UnneededTime {
+				continue
+			}
+			if !fitsOnOthers(node, current, nodePods) {
+				a.Debugf("[%s] %s is underutilised, but its pods do not fit on the other workers", pool, node.Name)
+				continue
+			}
+			decisions = append(decisions, scaleDecision{pool: pool, remove: []v1.Node{node}, reason: fmt.Sprintf("underutilised for %s", now.Sub(a.unneededSince[node.Name]).Round(time.Second))})
+			break
+		}
+	}
+	for name := range a.unneededSince {
+		if !unneeded[name] {
+			delete(a.unneededSince, name)
+		}
+	}
+	return decisions
+}
+
+// poolFor returns the first pool below its maxCount whose workers would match the pod's node selector and
+// tolerate its taints, based on the labels and taints of an existing worker in the pool
+func (a *Autoscaler) poolFor(pod v1.Pod, pools []string, poolNodes map[string][]v1.Node) string {
+	for _, pool := range pools {
+		if len(poolNodes[pool]) >= a.Nodes[pool].MaxCount {
+			continue
+		}
+		labels := map[string]string{constants.NodePoolLabel: pool, v1.LabelOSStable: "linux"}
+		var taints []v1.Taint
+		if nodes := poolNodes[pool]; len(nodes) > 0 {
+			labels = nodes[0].Labels
+			taints = nodes[0].Spec.Taints
+		}
+		if !matchesNodeSelector(pod, labels) || !toleratesTaints(pod, taints) {
+			continue
+		}
+		cpu, memory := podRequests(pod)
+		capacity := a.capacity(pool, poolNodes[pool])
+		if (capacity.cpu > 0 && cpu > capacity.cpu) || (capacity.memory > 0 && memory > capacity.memory) {
+			a.Debugf("[%s] %s/%s does not fit on a new worker", pool, pod.Namespace, pod.Name)
+			continue
+		}
+		return pool
+	}
+	return ""
+}
+
+type resources struct {
+	// cpu in millicores and memory in bytes
+	cpu, memory int64
+}
+
+// capacity returns the resources of a worker in the pool, from the VM spec or an existing worker
+func (a *Autoscaler) capacity(pool string, nodes []v1.Node) resources {
+	vm := a.Nodes[pool]
+	if vm.CPUs > 0 && vm.MemoryGB > 0 {
+		return resources{cpu: int64(vm.CPUs) * 1000, memory: vm.MemoryGB * 1024 * 1024 * 1024}
+	}
+	if len(nodes) > 0 {
+		return allocatable(nodes[0])
+	}
+	return resources{}
+}
+
+func allocatable(node v1.Node) resources {
+	return resources{cpu: node.Status.Allocatable.Cpu().MilliValue(), memory: node.Status.Allocatable.Memory().Value()}
+}
+
+// nodesNeeded estimates how many workers with capacity are needed to fit pods, at least 1
+func nodesNeeded(pods []v1.Pod, capacity resources) int {
+	var total resources
+	for _, pod := range pods {
+		cpu, memory := podRequests(pod)
+		total.cpu += cpu
+		total.memory += memory
+	}
+	needed := 1.0
+	if capacity.cpu > 0 {
+		needed = math.Max(needed, math.Ceil(float64(total.cpu)/float64(capacity.cpu)))
+	}
+	if capacity.memory > 0 {
+		needed = math.Max(needed, math.Ceil(float64(total.memory)/float64(capacity.memory)))
+	}
+	return int(needed)
+}
+
+// isUnneeded returns true if a schedulable node has requests below the utilisation threshold and all of its
+// pods can be moved to other nodes
+func (a *Autoscaler) isUnneeded(node v1.Node, pods []v1.Pod) bool {
+	if node.Spec.Unschedulable {
+		return false
+	}
+	requested := requests(pods)
+	capacity := allocatable(node)
+	if capacity.cpu == 0 || capacity.memory == 0 {
+		return false
+	}
+	utilisation := math.Max(float64(requested.cpu)/float64(capacity.cpu), float64(requested.memory)/float64(capacity.memory))
+	if utilisation >= a.Utilisation {
+		return false
+	}
+	for _, pod := range pods {
+		if kommons.IsPodDaemonSet(pod) || kommons.IsStaticPod(pod) {
+			continue
+		}
+		// pods without a controller would not be recreated elsewhere
+		if metav1.GetControllerOf(&pod) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// fitsOnOthers returns true if the requests of the pods on node fit into the free capacity of the
+// other schedulable nodes in the pool
+func fitsOnOthers(node v1.Node, pool []v1.Node, nodePods map[string][]v1.Pod) bool {
+	var free resources
+	for _, other := range pool {
+		if other.Name == node.Name || other.Spec.Unschedulable {
+			continue
+		}
+		capacity := allocatable(other)
+		used := requests(nodePods[other.Name])
+		free.cpu += capacity.cpu - used.cpu
+		free.memory += capacity.memory - used.memory
+	}
+	needed := requests(nodePods[node.Name])
+	return needed.cpu <= free.cpu && needed.memory <= free.memory
+}
+
+// requests returns the total requests of pods, excluding daemonsets and static pods which are not moved
+func requests(pods []v1.Pod) resources {
+	var total resources
+	for _, pod := range pods {
+		if kommons.IsPodDaemonSet(pod) || kommons.IsStaticPod(pod) {
+			continue
+		}
+		cpu, memory := podRequests(pod)
+		total.cpu += cpu
+		total.memory += memory
+	}
+	return total
+}
+
+func podRequests(pod v1.Pod) (cpu, memory int64) {
+	for _, container := range pod.Spec.Containers {
+		cpu += container.Resources.Requests.Cpu().MilliValue()
+		memory += container.Resources.Requests.Memory().Value()
+	}
+	return cpu, memory
+}
+
+func isUnschedulable(pod v1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodScheduled && condition.Status == v1.ConditionFalse && condition.Reason == v1.PodReasonUnschedulable {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesNodeSelector(pod v1.Pod, labels map[string]string) bool {
+	for k, v := range pod.Spec.NodeSelector {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func toleratesTaints(pod v1.Pod, taints []v1.Taint) bool {
+	for _, taint := range taints {
+		if taint.Key == burnin.Taint || taint.Effect == v1.TaintEffectPreferNoSchedule {
+			continue
+		}
+		tolerated := false
+		for _, toleration := range pod.Spec.Tolerations {
+			if toleration.ToleratesTaint(&taint) {
+				tolerated = true
+				break
+			}
+		}
+		if !tolerated {
+			return false
+		}
+	}
+	return true
+}
+
+// addWorkers creates count workers in a pool and waits for them to finish burning in
+func (a *Autoscaler) addWorkers(pool string, count int) error {
+	wg := sync.WaitGroup{}
+	var lock sync.Mutex
+	var errs []string
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := func() error {
+				worker, err := createWorker(a.Platform, pool)
+				if err != nil {
+					return err
+				}
+				if err := waitForNode(a.Platform, worker.Name(), a.BurninPeriod+a.Interval*2); err != nil {
+					return err
+				}
+				return addPoolMetadata(a.Platform, worker.Name(), pool)
+			}()
+			if err != nil {
+				lock.Lock()
+				errs = append(errs, err.Error())
+				lock.Unlock()
+			}
+		}()
+		// avoid name collisions as names are based on timestamps
+		time.Sleep(1 * time.Second)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to add workers: %s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// removeWorker drains a worker honouring PodDisruptionBudgets and then terminates it, the worker is uncordoned
+// if it cannot be drained within the drain timeout
+func (a *Autoscaler) removeWorker(pool string, node v1.Node) error {
+	cluster, err := GetCluster(a.Platform)
+	if err != nil {
+		return err
+	}
+	var machine *NodeMachine
+	for i := range cluster.Nodes {
+		if cluster.Nodes[i].Node.Name == node.Name {
+			machine = &cluster.Nodes[i]
+		}
+	}
+	if machine == nil || machine.Machine == nil {
+		return fmt.Errorf("no VM found for %s", node.Name)
+	}
+	return a.drainAndTerminate(cluster.Kubernetes, node.Name, func() error {
+		return cluster.Terminate(machine.Machine)
+	})
+}
+
+// drainAndTerminate only calls terminate once every pod has been evicted from the node
+func (a *Autoscaler) drainAndTerminate(client kubernetes.Interface, nodeName string, terminate func() error) error {
+	if err := Drain(client, a.Logger, nodeName, a.Drain); err != nil {
+		if uncordonErr := uncordon(client, nodeName); uncordonErr != nil {
+			a.Warnf("[%s] failed to uncordon: %v", nodeName, uncordonErr)
+		}
+		return errors.Wrapf(err, "not removing %s", nodeName)
+	}
+	return terminate()
+}
@@ -0,0 +1,208 @@
+package provision
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/flanksource/commons/logger"
+	"github.com/flanksource/karina/pkg/constants"
+	"github.com/flanksource/karina/pkg/controller/burnin"
+	"github.com/flanksource/karina/pkg/types"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestAutoscaler(now *time.Time) *Autoscaler {
+	p := newFakePlatform()
+	p.Logger = logger.StandardLogger()
+	p.Nodes = map[string]types.VM{
+		"general": {Prefix: "g", MinCount: 1, MaxCount: 3, CPUs: 4, MemoryGB: 8},
+		"static":  {Prefix: "s", Count: 2},
+	}
+	a := NewAutoscaler(p, AutoscalerOptions{
+		ScaleUpCooldown:   time.Minute,
+		ScaleDownCooldown: 10 * time.Minute,
+		UnneededTime:      10 * time.Minute,
+		Utilisation:       0.5,
+	})
+	a.now = func() time.Time { return *now }
+	return a
+}
+
+func poolNode(name, pool string, age time.Duration) v1.Node {
+	return v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Labels:            map[string]string{constants.NodePoolLabel: pool},
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-age)),
+		},
+		Status: v1.NodeStatus{
+			Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4"), v1.ResourceMemory: resource.MustParse("8Gi")},
+			Conditions:  []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}},
+		},
+	}
+}
+
+func requestingPod(name, node, cpu string) v1.Pod {
+	controller := true
+	pod := v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       "default",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "web", Controller: &controller}},
+		},
+		Spec: v1.PodSpec{
+			NodeName: node,
+			Containers: []v1.Container{{
+				Name:      "web",
+				Resources: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)}},
+			}},
+		},
+		Status: v1.PodStatus{Phase: v1.PodRunning},
+	}
+	if node == "" {
+		pod.Status.Phase = v1.PodPending
+		pod.Status.Conditions = []v1.PodCondition{{Type: v1.PodScheduled, Status: v1.ConditionFalse, Reason: v1.PodReasonUnschedulable}}
+	}
+	return pod
+}
+
+func TestAutoscalerMinCount(t *testing.T) {
+	g := NewWithT(t)
+	now := time.Now()
+	a := newTestAutoscaler(&now)
+	decisions := a.plan(nil, nil)
+	g.Expect(decisions).To(HaveLen(1))
+	g.Expect(decisions[0].pool).To(Equal("general"))
+	g.Expect(decisions[0].add).To(Equal(1))
+}
+
+func TestAutoscalerScaleUp(t *testing.T) {
+	g := NewWithT(t)
+	now := time.Now()
+	a := newTestAutoscaler(&now)
+	nodes := []v1.Node{poolNode("g-1", "general", time.Hour)}
+	pods := []v1.Pod{
+		requestingPod("running", "g-1", "3"),
+		requestingPod("pending-1", "", "3"),
+		requestingPod("pending-2", "", "3"),
+		requestingPod("pending-3", "", "3"),
+		// too large for any worker
+		requestingPod("huge", "", "16"),
+	}
+
+	decisions := a.plan(nodes, pods)
+	g.Expect(decisions).To(HaveLen(1))
+	g.Expect(decisions[0].pool).To(Equal("general"))
+	// 9 cores requested needs 3 workers of 4 cores, capped by maxCount
+	g.Expect(decisions[0].add).To(Equal(2))
+
+	// in cooldown
+	a.lastScaleUp["general"] = now
+	g.Expect(a.plan(nodes, pods)).To(BeEmpty())
+
+	// new workers are still burning in
+	now = now.Add(2 * time.Minute)
+	starting := poolNode("g-2", "general", time.Minute)
+	starting.Spec.Taints = []v1.Taint{{Key: burnin.Taint, Effect: v1.TaintEffectNoSchedule}}
+	g.Expect(a.plan(append(nodes, starting), pods)).To(BeEmpty())
+
+	// pods that require a taint to be tolerated are not scheduled on the pool
+	tainted := poolNode("g-1", "general", time.Hour)
+	tainted.Spec.Taints = []v1.Taint{{Key: "dedicated", Value: "gpu", Effect: v1.TaintEffectNoSchedule}}
+	g.Expect(a.plan([]v1.Node{tainted}, pods)).To(BeEmpty())
+}
+
+func TestAutoscalerScaleDown(t *testing.T) {
+	g := NewWithT(t)
+	now := time.Now()
+	a := newTestAutoscaler(&now)
+	nodes := []v1.Node{
+		poolNode("g-1", "general", 2*time.Hour),
+		poolNode("g-2", "general", time.Hour),
+		poolNode("s-1", "static", time.Hour),
+	}
+	pods := []v1.Pod{
+		requestingPod("busy", "g-2", "3"),
+		requestingPod("idle", "g-1", "500m"),
+		requestingPod("static", "s-1", "100m"),
+	}
+
+	// g-1 is underutilised, but not for long enough
+	g.Expect(a.plan(nodes, pods)).To(BeEmpty())
+	g.Expect(a.unneededSince).To(HaveKey("g-1"))
+	g.Expect(a.unneededSince).ToNot(HaveKey("g-2"))
+
+	now = now.Add(11 * time.Minute)
+	decisions := a.plan(nodes, pods)
+	g.Expect(decisions).To(HaveLen(1))
+	g.Expect(decisions[0].remove).To(HaveLen(1))
+	g.Expect(decisions[0].remove[0].Name).To(Equal("g-1"))
+
+	// in cooldown after a scale down
+	a.lastScaleDown["general"] = now
+	g.Expect(a.plan(nodes, pods)).To(BeEmpty())
+
+	// pods without a controller are not moved
+	now = now.Add(11 * time.Minute)
+	bare := requestingPod("bare", "g-1", "500m")
+	bare.OwnerReferences = nil
+	g.Expect(a.plan(nodes, append(pods, bare))).To(BeEmpty())
+	g.Expect(a.unneededSince).ToNot(HaveKey("g-1"))
+
+	// pools are never scaled below minCount
+	g.Expect(a.plan(nodes[:1], pods)).To(BeEmpty())
+}
+
+func TestAutoscalerMaxCount(t *testing.T) {
+	g := NewWithT(t)
+	now := time.Now()
+	a := newTestAutoscaler(&now)
+	nodes := []v1.Node{
+		poolNode("g-1", "general", 4*time.Hour),
+		poolNode("g-2", "general", 3*time.Hour),
+		poolNode("g-3", "general", 2*time.Hour),
+		poolNode("g-4", "general", time.Hour),
+	}
+	decisions := a.plan(nodes, nil)
+	g.Expect(decisions).To(HaveLen(1))
+	g.Expect(decisions[0].remove).To(HaveLen(1))
+	g.Expect(decisions[0].remove[0].Name).To(Equal("g-1"))
+}
+
+func TestAutoscalerScaleDownRespectsPDB(t *testing.T) {
+	g := NewWithT(t)
+	now := time.Now()
+	a := newTestAutoscaler(&now)
+	a.Drain = DrainOptions{Timeout: 50 * time.Millisecond, Interval: 10 * time.Millisecond, OnTimeout: DrainSkip}
+	node := poolNode("g-1", "general", 2*time.Hour)
+	client := newDrainClient(
+		&node,
+		newPod("protected", "g-1", map[string]string{"app": "db"}, "StatefulSet"),
+		newPDB("db", map[string]string{"app": "db"}, 0),
+	)
+	terminated := false
+	terminate := func() error {
+		terminated = true
+		return nil
+	}
+
+	err := a.drainAndTerminate(client, "g-1", terminate)
+	g.Expect(err).To(MatchError(ContainSubstring("blocked by PodDisruptionBudgets default/db")))
+	g.Expect(IsDrainSkipped(err)).To(BeTrue())
+	g.Expect(terminated).To(BeFalse())
+	g.Expect(remainingPods(g, client)).To(ConsistOf("protected"))
+	updated, err := client.CoreV1().Nodes().Get(context.TODO(), "g-1", metav1.GetOptions{})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(updated.Spec.Unschedulable).To(BeFalse(), "workers that cannot be drained are uncordoned")
+
+	// once the budget allows it the pod is evicted before the worker is terminated
+	g.Expect(client.Tracker().Update(policy.SchemeGroupVersion.WithResource("poddisruptionbudgets"), newPDB("db", map[string]string{"app": "db"}, 1), "default")).To(Succeed())
+	g.Expect(a.drainAndTerminate(client, "g-1", terminate)).To(Succeed())
+	g.Expect(terminated).To(BeTrue())
+	g.Expect(remainingPods(g, client)).To(BeEmpty())
+}
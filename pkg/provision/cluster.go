@@ -72,7 +72,7 @@ type Cluster struct {
 }
 
 func GetCluster(platform *platform.Platform) (*Cluster, error) {
-	if err := WithVmwareCluster(platform); err != nil {
+	if err := WithCluster(platform); err != nil {
 		return nil, err
 	}
 
@@ -80,7 +80,11 @@ func GetCluster(platform *platform.Platform) (*Cluster, error) {
 	if err != nil {
 		return nil, err
 	}
+	return newCluster(platform, client)
+}
 
+// newCluster matches the nodes in client with the machines of the cluster provider, machines without a node are orphans
+func newCluster(platform *platform.Platform, client kubernetes.Interface) (*Cluster, error) {
 	list, err := client.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
 	if err != nil {
 		return nil, err
@@ -121,8 +125,11 @@ func GetCluster(platform *platform.Platform) (*Cluster, error) {
 	return cluster, nil
 }
 
+// terminateMachine is replaced in tests that do not have a kubernetes cluster to remove nodes from
+var terminateMachine = terminate
+
 func (cluster *Cluster) Terminate(node types.Machine) error {
-	terminate(cluster.Platform, node)
+	terminateMachine(cluster.Platform, node)
 	return nil
 }
 
@@ -0,0 +1,247 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/flanksource/commons/logger"
+	"github.com/flanksource/karina/pkg/constants"
+	"github.com/flanksource/karina/pkg/platform"
+	fakecluster "github.com/flanksource/karina/pkg/provision/fake"
+	"github.com/flanksource/karina/pkg/types"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeMachine is a machine of the fake provider and whether it has joined the cluster as a node
+type fakeMachine struct {
+	name     string
+	template string
+	age      time.Duration
+	joined   bool
+}
+
+// newTestCluster returns a cluster backed by the fake provider and a fake clientset, terminating machines
+// skips removing them from kubernetes
+func newTestCluster(t *testing.T, g *WithT, p *platform.Platform, machines ...fakeMachine) (*Cluster, *fakecluster.Cluster) {
+	p.Logger = logger.StandardLogger()
+	provider := fakecluster.NewCluster(p.PlatformConfig)
+	p.Cluster = provider
+	var nodes []runtime.Object
+	for _, m := range machines {
+		machine := provider.Add(types.VM{Name: m.name, Template: m.template})
+		machine.Attributes["CreatedDate"] = time.Now().Add(-m.age).Format("02Jan06-15:04:05")
+		if !m.joined {
+			continue
+		}
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: m.name, Labels: map[string]string{}}}
+		if m.name[len("k8s-test-")] == 'm' {
+			node.Labels["node-role.kubernetes.io/master"] = ""
+		}
+		nodes = append(nodes, node)
+	}
+	original := terminateMachine
+	terminateMachine = func(platform *platform.Platform, vm types.Machine) {
+		_ = vm.Terminate()
+	}
+	t.Cleanup(func() { terminateMachine = original })
+
+	cluster, err := newCluster(p, fake.NewSimpleClientset(nodes...))
+	g.Expect(err).ToNot(HaveOccurred())
+	return cluster, provider
+}
+
+func machineNames(machines []types.Machine) []string {
+	var names []string
+	for _, machine := range machines {
+		names = append(names, machine.Name())
+	}
+	return names
+}
+
+func TestRolloutOrdering(t *testing.T) {
+	g := NewWithT(t)
+	p := newFakePlatform()
+	p.Master.Template = "kube-v2"
+	p.Nodes["workers"] = types.VM{Prefix: "w", Count: 3, Template: "kube-v2"}
+	p.Nodes["gpu"] = types.VM{Prefix: "g", Count: 1, Template: "kube-v2"}
+	cluster, _ := newTestCluster(t, g, p,
+		fakeMachine{name: "k8s-test-m-1", template: "kube-v1", age: 72 * time.Hour, joined: true},
+		fakeMachine{name: "k8s-test-w-young", template: "kube-v1", age: 30 * time.Hour, joined: true},
+		fakeMachine{name: "k8s-test-w-old", template: "kube-v1", age: 50 * time.Hour, joined: true},
+		fakeMachine{name: "k8s-test-w-current", template: "kube-v2", age: 90 * time.Hour, joined: true},
+		fakeMachine{name: "k8s-test-w-new", template: "kube-v1", age: time.Hour, joined: true},
+		fakeMachine{name: "k8s-test-g-1", template: "kube-v1", age: 40 * time.Hour, joined: true},
+	)
+	opts := RollingOptions{Masters: true, Workers: true, MinAge: 24 * time.Hour, MaxSurge: 2, Max: 10}
+
+	var nodes []RolloutNode
+	for _, nodeMachine := range *selectMachinesToReplace(p, opts, cluster) {
+		node := RolloutNode{Name: nodeMachine.Node.Name, Pool: nodePool(p, nodeMachine.Node)}
+		node.Master = node.Pool == ""
+		nodes = append(nodes, node)
+	}
+	// nodes that are up to date or younger than MinAge are not replaced
+	g.Expect(nodes).To(HaveLen(4))
+	rollout, err := StartRollout(fake.NewSimpleClientset(), opts, nodes)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	masters := rolloutQueue(cluster, rollout, func(node RolloutNode) bool { return node.Master })
+	g.Expect(nodeNames(masters.PopN(1))).To(Equal([]string{"k8s-test-m-1"}))
+
+	// workers are replaced oldest first, in batches of MaxSurge
+	workers := rolloutQueue(cluster, rollout, func(node RolloutNode) bool { return node.Pool == "workers" })
+	g.Expect(nodeNames(workers.PopN(opts.MaxSurge))).To(Equal([]string{"k8s-test-w-old", "k8s-test-w-young"}))
+	g.Expect(workers.Len()).To(Equal(0))
+
+	// nodes removed since the rollout started are failed rather than queued
+	cluster.Nodes = nil
+	gpu := rolloutQueue(cluster, rollout, func(node RolloutNode) bool { return node.Pool == "gpu" })
+	g.Expect(gpu.Len()).To(Equal(0))
+	for _, node := range rollout.Nodes {
+		if node.Name == "k8s-test-g-1" {
+			g.Expect(node.State).To(Equal(NodeFailed))
+		}
+	}
+}
+
+func TestRolloutPoolFilter(t *testing.T) {
+	g := NewWithT(t)
+	p := newFakePlatform()
+	p.Master.Template = "kube-v2"
+	p.Nodes["workers"] = types.VM{Prefix: "w", Count: 1, Template: "kube-v2"}
+	p.Nodes["gpu"] = types.VM{Prefix: "g", Count: 1, Template: "kube-v2"}
+	cluster, _ := newTestCluster(t, g, p,
+		fakeMachine{name: "k8s-test-m-1", template: "kube-v1", age: 72 * time.Hour, joined: true},
+		fakeMachine{name: "k8s-test-w-1", template: "kube-v1", age: 50 * time.Hour, joined: true},
+		fakeMachine{name: "k8s-test-g-1", template: "kube-v1", age: 40 * time.Hour, joined: true},
+	)
+	// masters are skipped when the rollout is restricted to pools
+	toReplace := selectMachinesToReplace(p, RollingOptions{Masters: true, Workers: true, Pools: []string{"gpu"}}, cluster)
+	g.Expect(nodeNames(toReplace.PopN(10))).To(Equal([]string{"k8s-test-g-1"}))
+}
+
+func TestRolloutPoolMaxSurge(t *testing.T) {
+	g := NewWithT(t)
+	p := newFakePlatform()
+	p.Nodes["workers"] = types.VM{Prefix: "w", Count: 3, Template: "kube-v2"}
+	p.Nodes["gpu"] = types.VM{Prefix: "g", Count: 3, Template: "kube-v2"}
+	p.Nodes["db"] = types.VM{Prefix: "d", Count: 3, Template: "kube-v2"}
+	var machines []fakeMachine
+	for _, prefix := range []string{"w", "g", "d"} {
+		for i := 1; i <= 3; i++ {
+			machines = append(machines, fakeMachine{name: fmt.Sprintf("k8s-test-%s-%d", prefix, i), template: "kube-v1", age: time.Duration(i) * time.Hour, joined: true})
+		}
+	}
+	cluster, _ := newTestCluster(t, g, p, machines...)
+	// a surge of 0 is treated as unset and falls back to --max-surge
+	opts := RollingOptions{Workers: true, MaxSurge: 2, PoolMaxSurge: map[string]int{"gpu": 1, "db": 0}, Max: 10}
+
+	var nodes []RolloutNode
+	for _, nodeMachine := range *selectMachinesToReplace(p, opts, cluster) {
+		nodes = append(nodes, RolloutNode{Name: nodeMachine.Node.Name, Pool: nodePool(p, nodeMachine.Node)})
+	}
+	rollout, err := StartRollout(cluster.Kubernetes, opts, nodes)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// each pool is rolled in batches of its own max surge, in the same way as RollingUpdate
+	batches := map[string][]int{}
+	for _, pool := range []string{"workers", "gpu", "db"} {
+		pool := pool
+		queue := rolloutQueue(cluster, rollout, func(node RolloutNode) bool { return node.Pool == pool })
+		for queue.Len() > 0 {
+			batches[pool] = append(batches[pool], len(*queue.PopN(opts.maxSurgeFor(pool))))
+		}
+	}
+	g.Expect(batches).To(Equal(map[string][]int{
+		"workers": {2, 1},
+		"gpu":     {1, 1, 1},
+		"db":      {2, 1},
+	}))
+}
+
+func TestRecoverRolloutPoolMetadata(t *testing.T) {
+	g := NewWithT(t)
+	p := newFakePlatform()
+	p.Nodes["gpu"] = types.VM{Prefix: "g", Count: 1, Annotations: map[string]string{"example.com/gpu": "nvidia"}}
+	cluster, provider := newTestCluster(t, g, p,
+		fakeMachine{name: "k8s-test-g-1", age: 50 * time.Hour, joined: true},
+		fakeMachine{name: "k8s-test-g-2", age: time.Hour, joined: true},
+	)
+	for i := range cluster.Nodes {
+		cluster.Nodes[i].Node.Status.Conditions = []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}}
+	}
+	rollout, err := StartRollout(cluster.Kubernetes, RollingOptions{Workers: true, Timeout: time.Minute}, []RolloutNode{{Name: "k8s-test-g-1", Pool: "gpu"}})
+	g.Expect(err).ToNot(HaveOccurred())
+	rollout.SetNode("k8s-test-g-1", NodeInProgress, "k8s-test-g-2", nil)
+
+	recoverRollout(cluster, rollout)
+	g.Expect(rollout.Pending()).To(BeEmpty())
+	g.Expect(provider.Terminated).To(Equal([]string{"k8s-test-g-1"}))
+
+	// the replacement inherits the label and annotations of the pool of the node it replaced
+	node, err := cluster.Kubernetes.CoreV1().Nodes().Get(context.TODO(), "k8s-test-g-2", metav1.GetOptions{})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(node.Labels).To(HaveKeyWithValue(constants.NodePoolLabel, "gpu"))
+	g.Expect(node.Annotations).To(Equal(map[string]string{"example.com/gpu": "nvidia"}))
+}
+
+func TestDownscaleCandidates(t *testing.T) {
+	g := NewWithT(t)
+	p := newFakePlatform()
+	p.Nodes["workers"] = types.VM{Prefix: "w", Count: 2}
+	p.Nodes["gpu"] = types.VM{Prefix: "g", Count: 0}
+	p.Nodes["auto"] = types.VM{Prefix: "a", MinCount: 1, MaxCount: 3}
+	cluster, _ := newTestCluster(t, g, p,
+		fakeMachine{name: "k8s-test-m-1", age: 96 * time.Hour, joined: true},
+		fakeMachine{name: "k8s-test-w-1", age: 10 * time.Hour, joined: true},
+		fakeMachine{name: "k8s-test-w-2", age: 40 * time.Hour, joined: true},
+		fakeMachine{name: "k8s-test-w-3", age: 20 * time.Hour, joined: true},
+		fakeMachine{name: "k8s-test-w-4", age: 30 * time.Hour, joined: true},
+		// workers that have not joined are orphans and are not counted
+		fakeMachine{name: "k8s-test-w-5", age: 90 * time.Hour},
+		fakeMachine{name: "k8s-test-g-1", age: time.Hour, joined: true},
+		fakeMachine{name: "k8s-test-a-1", age: time.Hour, joined: true},
+		fakeMachine{name: "k8s-test-a-2", age: time.Hour, joined: true},
+	)
+
+	extra, err := downscaleCandidates(cluster)
+	g.Expect(err).ToNot(HaveOccurred())
+	// the oldest workers above the desired count are removed, autoscaled pools within their bounds are left alone
+	g.Expect(machineNames(extra)).To(Equal([]string{"k8s-test-g-1", "k8s-test-w-2", "k8s-test-w-4"}))
+}
+
+func TestTerminateOrphans(t *testing.T) {
+	g := NewWithT(t)
+	p := newFakePlatform()
+	cluster, provider := newTestCluster(t, g, p,
+		fakeMachine{name: "k8s-test-m-1", age: time.Hour, joined: true},
+		fakeMachine{name: "k8s-test-w-1", age: time.Hour, joined: true},
+		fakeMachine{name: "k8s-test-w-2", age: time.Hour},
+		fakeMachine{name: "k8s-test-m-2", age: time.Hour},
+	)
+	g.Expect(machineNames(cluster.Orphans)).To(ConsistOf("k8s-test-w-2", "k8s-test-m-2"))
+
+	terminateOrphans(cluster)
+	g.Expect(provider.Terminated).To(ConsistOf("k8s-test-w-2", "k8s-test-m-2"))
+	var remaining []string
+	for name := range provider.Machines {
+		remaining = append(remaining, name)
+	}
+	sort.Strings(remaining)
+	g.Expect(remaining).To(Equal([]string{"k8s-test-m-1", "k8s-test-w-1"}))
+}
+
+func nodeNames(machines *[]NodeMachine) []string {
+	var names []string
+	for _, nodeMachine := range *machines {
+		names = append(names, nodeMachine.Node.Name)
+	}
+	return names
+}
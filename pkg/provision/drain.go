@@ -0,0 +1,225 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/flanksource/commons/logger"
+	"github.com/flanksource/kommons"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1beta1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DrainPolicy is what to do with pods that could not be evicted from a node within the drain timeout
+type DrainPolicy string
+
+const (
+	// DrainSkip leaves the node cordoned and moves on to the next node
+	DrainSkip DrainPolicy = "skip"
+	// DrainForce deletes the remaining pods, ignoring PodDisruptionBudgets
+	DrainForce DrainPolicy = "force"
+	// DrainAbort stops the rolling operation
+	DrainAbort DrainPolicy = "abort"
+)
+
+type DrainOptions struct {
+	// Timeout is how long evictions blocked by PodDisruptionBudgets are retried for
+	Timeout time.Duration
+	// OnTimeout is applied to the pods that could not be evicted within the timeout
+	OnTimeout DrainPolicy
+	// Interval between eviction attempts, defaults to 5s
+	Interval time.Duration
+}
+
+func (opts DrainOptions) Validate() error {
+	switch opts.OnTimeout {
+	case DrainSkip, DrainForce, DrainAbort:
+		return nil
+	}
+	return fmt.Errorf("invalid drain timeout policy %q, must be one of skip, force or abort", opts.OnTimeout)
+}
+
+// DrainTimeoutError is returned when pods could not be evicted from a node within the timeout
+type DrainTimeoutError struct {
+	Node   string
+	Policy DrainPolicy
+	// Pods that were not evicted, as namespace/name
+	Pods []string
+	// PDBs that blocked the eviction of pods, as namespace/name
+	PDBs []string
+}
+
+func (e *DrainTimeoutError) Error() string {
+	msg := fmt.Sprintf("[%s] timed out evicting %s", e.Node, strings.Join(e.Pods, ", "))
+	if len(e.PDBs) > 0 {
+		msg += fmt.Sprintf(", blocked by PodDisruptionBudgets %s", strings.Join(e.PDBs, ", "))
+	}
+	return msg
+}
+
+// IsDrainSkipped returns true if err is a drain timeout with the skip policy
+func IsDrainSkipped(err error) bool {
+	var timeout *DrainTimeoutError
+	return errors.As(err, &timeout) && timeout.Policy == DrainSkip
+}
+
+// IsDrainAborted returns true if err is a drain timeout with the abort policy
+func IsDrainAborted(err error) bool {
+	var timeout *DrainTimeoutError
+	return errors.As(err, &timeout) && timeout.Policy == DrainAbort
+}
+
+// Drain cordons a node and removes its pods using the Eviction API so that PodDisruptionBudgets are honoured,
+// evictions that are blocked are retried until the timeout, after which the OnTimeout policy is applied
+func Drain(client kubernetes.Interface, log logger.Logger, nodeName string, opts DrainOptions) error {
+	log.Infof("[%s] draining", nodeName)
+	if err := cordon(client, nodeName); err != nil {
+		return errors.Wrapf(err, "error cordoning %s", nodeName)
+	}
+	interval := opts.Interval
+	if interval == 0 {
+		interval = 5 * time.Second
+	}
+	deadline := time.Now().Add(opts.Timeout)
+	for {
+		pods, err := podsToEvict(client, nodeName)
+		if err != nil {
+			return err
+		}
+		if len(pods) == 0 {
+			log.Infof("[%s] drained", nodeName)
+			return nil
+		}
+		var blocked []v1.Pod
+		for _, pod := range pods {
+			if kommons.IsDeleted(&pod) {
+				// already evicted, waiting for it to terminate
+				continue
+			}
+			err := client.CoreV1().Pods(pod.Namespace).Evict(context.TODO(), &policy.Eviction{
+				ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+			})
+			switch {
+			case err == nil:
+				log.Debugf("[%s] evicted %s/%s", nodeName, pod.Namespace, pod.Name)
+			case kerrors.IsNotFound(err):
+			case kerrors.IsTooManyRequests(err):
+				// the eviction would violate a PodDisruptionBudget
+				blocked = append(blocked, pod)
+			default:
+				return errors.Wrapf(err, "failed to evict %s/%s", pod.Namespace, pod.Name)
+			}
+		}
+		if time.Now().After(deadline) {
+			return drainTimeout(client, log, nodeName, pods, blocked, opts.OnTimeout)
+		}
+		if len(blocked) > 0 {
+			pdbs, err := blockingPDBs(client, blocked)
+			if err != nil {
+				return err
+			}
+			log.Infof("[%s] waiting to evict %d pods, blocked by %s", nodeName, len(blocked), strings.Join(pdbs, ", "))
+		}
+		time.Sleep(interval)
+	}
+}
+
+func drainTimeout(client kubernetes.Interface, log logger.Logger, nodeName string, pods, blocked []v1.Pod, onTimeout DrainPolicy) error {
+	pdbs, err := blockingPDBs(client, blocked)
+	if err != nil {
+		return err
+	}
+	timeout := &DrainTimeoutError{Node: nodeName, Policy: onTimeout, PDBs: pdbs}
+	for _, pod := range pods {
+		timeout.Pods = append(timeout.Pods, pod.Namespace+"/"+pod.Name)
+	}
+	if onTimeout != DrainForce {
+		return timeout
+	}
+	log.Warnf("%v, force deleting", timeout)
+	var zero int64
+	for _, pod := range pods {
+		if err := client.CoreV1().Pods(pod.Namespace).Delete(context.TODO(), pod.Name, metav1.DeleteOptions{GracePeriodSeconds: &zero}); err != nil && !kerrors.IsNotFound(err) {
+			return errors.Wrapf(err, "failed to delete %s/%s", pod.Namespace, pod.Name)
+		}
+	}
+	return nil
+}
+
+// podsToEvict returns the pods on a node that need to be evicted, i.e. excluding daemonsets,
+// static pods and pods that have already finished
+func podsToEvict(client kubernetes.Interface, nodeName string) ([]v1.Pod, error) {
+	list, err := client.CoreV1().Pods(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{
+		FieldSelector: fields.SelectorFromSet(fields.Set{"spec.nodeName": nodeName}).String(),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list pods on %s", nodeName)
+	}
+	var pods []v1.Pod
+	for _, pod := range list.Items {
+		if pod.Spec.NodeName != nodeName || kommons.IsPodDaemonSet(pod) || kommons.IsStaticPod(pod) || kommons.IsPodFinished(pod) {
+			continue
+		}
+		pods = append(pods, pod)
+	}
+	return pods, nil
+}
+
+// blockingPDBs returns the names of the PodDisruptionBudgets selecting any of pods
+func blockingPDBs(client kubernetes.Interface, pods []v1.Pod) ([]string, error) {
+	budgets := map[string][]policy.PodDisruptionBudget{}
+	names := map[string]bool{}
+	for _, pod := range pods {
+		if _, ok := budgets[pod.Namespace]; !ok {
+			list, err := client.PolicyV1beta1().PodDisruptionBudgets(pod.Namespace).List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to list PodDisruptionBudgets in %s", pod.Namespace)
+			}
+			budgets[pod.Namespace] = list.Items
+		}
+		for _, pdb := range budgets[pod.Namespace] {
+			selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+			if err != nil || selector.Empty() || !selector.Matches(labels.Set(pod.Labels)) {
+				continue
+			}
+			names[pdb.Namespace+"/"+pdb.Name] = true
+		}
+	}
+	var result []string
+	for name := range names {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func cordon(client kubernetes.Interface, nodeName string) error {
+	return setUnschedulable(client, nodeName, true)
+}
+
+func uncordon(client kubernetes.Interface, nodeName string) error {
+	return setUnschedulable(client, nodeName, false)
+}
+
+func setUnschedulable(client kubernetes.Interface, nodeName string, unschedulable bool) error {
+	nodes := client.CoreV1().Nodes()
+	node, err := nodes.Get(context.TODO(), nodeName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if node.Spec.Unschedulable == unschedulable {
+		return nil
+	}
+	node.Spec.Unschedulable = unschedulable
+	_, err = nodes.Update(context.TODO(), node, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,152 @@
+package provision
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/flanksource/commons/logger"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1beta1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newPod(name, node string, podLabels map[string]string, owner string) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: podLabels},
+		Spec:       v1.PodSpec{NodeName: node},
+		Status:     v1.PodStatus{Phase: v1.PodRunning},
+	}
+	if owner != "" {
+		controller := true
+		pod.OwnerReferences = []metav1.OwnerReference{{Kind: owner, Name: name, Controller: &controller}}
+	}
+	return pod
+}
+
+func newPDB(name string, podLabels map[string]string, allowed int32) *policy.PodDisruptionBudget {
+	return &policy.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       policy.PodDisruptionBudgetSpec{Selector: &metav1.LabelSelector{MatchLabels: podLabels}},
+		Status:     policy.PodDisruptionBudgetStatus{DisruptionsAllowed: allowed},
+	}
+}
+
+// newDrainClient returns a fake clientset that implements the eviction API, refusing evictions
+// that would violate a PodDisruptionBudget
+func newDrainClient(objects ...runtime.Object) *fake.Clientset {
+	client := fake.NewSimpleClientset(objects...)
+	client.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		eviction := action.(k8stesting.CreateAction).GetObject().(*policy.Eviction)
+		tracker := client.Tracker()
+		obj, err := tracker.Get(v1.SchemeGroupVersion.WithResource("pods"), eviction.Namespace, eviction.Name)
+		if err != nil {
+			return true, nil, err
+		}
+		pod := obj.(*v1.Pod)
+		pdbs, _ := tracker.List(policy.SchemeGroupVersion.WithResource("poddisruptionbudgets"), policy.SchemeGroupVersion.WithKind("PodDisruptionBudget"), eviction.Namespace)
+		for _, pdb := range pdbs.(*policy.PodDisruptionBudgetList).Items {
+			selector, _ := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+			if !selector.Matches(labels.Set(pod.Labels)) {
+				continue
+			}
+			if pdb.Status.DisruptionsAllowed <= 0 {
+				return true, nil, kerrors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 0)
+			}
+			pdb.Status.DisruptionsAllowed--
+			if err := tracker.Update(policy.SchemeGroupVersion.WithResource("poddisruptionbudgets"), &pdb, pdb.Namespace); err != nil {
+				return true, nil, err
+			}
+		}
+		return true, nil, tracker.Delete(v1.SchemeGroupVersion.WithResource("pods"), pod.Namespace, pod.Name)
+	})
+	return client
+}
+
+func remainingPods(g *WithT, client *fake.Clientset) []string {
+	list, err := client.CoreV1().Pods("default").List(context.TODO(), metav1.ListOptions{})
+	g.Expect(err).ToNot(HaveOccurred())
+	var names []string
+	for _, pod := range list.Items {
+		names = append(names, pod.Name)
+	}
+	return names
+}
+
+func TestDrainEvictsPods(t *testing.T) {
+	g := NewWithT(t)
+	web := map[string]string{"app": "web"}
+	client := newDrainClient(
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "w-1"}},
+		newPod("web-1", "w-1", web, "ReplicaSet"),
+		newPod("web-2", "w-2", web, "ReplicaSet"),
+		newPod("fluentd", "w-1", nil, "DaemonSet"),
+		newPDB("web", web, 1),
+	)
+
+	err := Drain(client, logger.StandardLogger(), "w-1", DrainOptions{Timeout: time.Second, OnTimeout: DrainAbort, Interval: 10 * time.Millisecond})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(remainingPods(g, client)).To(ConsistOf("web-2", "fluentd"))
+
+	node, err := client.CoreV1().Nodes().Get(context.TODO(), "w-1", metav1.GetOptions{})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(node.Spec.Unschedulable).To(BeTrue())
+}
+
+func TestDrainTimeoutPolicies(t *testing.T) {
+	db := map[string]string{"app": "db"}
+	objects := func() []runtime.Object {
+		return []runtime.Object{
+			&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "w-1"}},
+			newPod("db-0", "w-1", db, "StatefulSet"),
+			newPod("cache", "w-1", nil, "ReplicaSet"),
+			newPDB("db", db, 0),
+		}
+	}
+	opts := DrainOptions{Timeout: 50 * time.Millisecond, Interval: 10 * time.Millisecond}
+
+	t.Run("abort", func(t *testing.T) {
+		g := NewWithT(t)
+		client := newDrainClient(objects()...)
+		opts.OnTimeout = DrainAbort
+		err := Drain(client, logger.StandardLogger(), "w-1", opts)
+		g.Expect(IsDrainAborted(err)).To(BeTrue())
+		g.Expect(IsDrainSkipped(err)).To(BeFalse())
+		g.Expect(err.(*DrainTimeoutError).PDBs).To(Equal([]string{"default/db"}))
+		g.Expect(err.(*DrainTimeoutError).Pods).To(Equal([]string{"default/db-0"}))
+		g.Expect(err).To(MatchError(ContainSubstring("blocked by PodDisruptionBudgets default/db")))
+		g.Expect(remainingPods(g, client)).To(ConsistOf("db-0"))
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		g := NewWithT(t)
+		client := newDrainClient(objects()...)
+		opts.OnTimeout = DrainSkip
+		err := Drain(client, logger.StandardLogger(), "w-1", opts)
+		g.Expect(IsDrainSkipped(err)).To(BeTrue())
+		g.Expect(remainingPods(g, client)).To(ConsistOf("db-0"))
+	})
+
+	t.Run("force", func(t *testing.T) {
+		g := NewWithT(t)
+		client := newDrainClient(objects()...)
+		opts.OnTimeout = DrainForce
+		g.Expect(Drain(client, logger.StandardLogger(), "w-1", opts)).To(Succeed())
+		g.Expect(remainingPods(g, client)).To(BeEmpty())
+	})
+}
+
+func TestDrainOptionsValidate(t *testing.T) {
+	g := NewWithT(t)
+	g.Expect(RollingOptions{}.drainOptions().Validate()).To(Succeed())
+	g.Expect(DrainOptions{OnTimeout: "wait"}.Validate()).To(MatchError(ContainSubstring("invalid drain timeout policy")))
+}
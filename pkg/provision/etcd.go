@@ -0,0 +1,79 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/flanksource/karina/pkg/etcd"
+	"github.com/flanksource/karina/pkg/platform"
+	"github.com/pkg/errors"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DefaultSnapshotURLExpiry is long enough for a new master to be cloned and booted before cloud-init downloads
+// the snapshot, the presigned URL is part of the VM's userdata and grants read access to every secret in the
+// snapshot until it expires
+const DefaultSnapshotURLExpiry = 15 * time.Minute
+
+// RestoreControlPlane rebuilds a control plane that has lost all of its masters by creating a new primary
+// master with etcd restored from a snapshot, secondary masters are then added by provisioning the cluster
+func RestoreControlPlane(platform *platform.Platform, snapshot string, urlExpiry time.Duration) error {
+	if err := checkMaintenance(platform, "restore etcd"); err != nil {
+		return err
+	}
+	if err := WithCluster(platform); err != nil {
+		return err
+	}
+	if platform.PingMaster() {
+		return fmt.Errorf("the control plane is still reachable, restoring etcd replaces the cluster state and is only supported when all masters have been lost: terminate the remaining masters first")
+	}
+	if urlExpiry <= 0 {
+		urlExpiry = DefaultSnapshotURLExpiry
+	}
+	url, err := etcd.SnapshotURL(platform, snapshot, urlExpiry)
+	if err != nil {
+		return err
+	}
+	platform.RestoreEtcdFrom = url
+	defer func() { platform.RestoreEtcdFrom = "" }()
+
+	platform.Infof("Creating a new primary master from etcd snapshot %s", snapshot)
+	master, err := createMaster(platform)
+	if err != nil {
+		return errors.Wrap(err, "failed to create master from snapshot")
+	}
+
+	// kubeadm generated new control plane and etcd certs, remove the copies of the old certs uploaded
+	// for joining masters so that they are uploaded again from the new master
+	client, err := platform.GetClientset()
+	if err != nil {
+		return err
+	}
+	for _, secret := range []string{"kubeadm-certs", "etcd-certs"} {
+		if err := client.CoreV1().Secrets("kube-system").Delete(context.TODO(), secret, metav1.DeleteOptions{}); err != nil && !kerrors.IsNotFound(err) {
+			return errors.Wrapf(err, "failed to delete stale secret %s", secret)
+		}
+	}
+
+	// the restored cluster state still contains the nodes of the lost masters
+	masters, err := platform.GetMasterNodes()
+	if err != nil {
+		return err
+	}
+	for _, name := range masters {
+		if name == master.Name() {
+			continue
+		}
+		platform.Infof("Removing lost master %s", name)
+		if err := terminateConsul(platform, name); err != nil {
+			platform.Warnf("Failed to remove %s from consul: %v", name, err)
+		}
+		if err := platform.DeleteNode(name); err != nil {
+			platform.Warnf("Failed to delete node %s: %v", name, err)
+		}
+	}
+	platform.Infof("Restored control plane on %s, run karina provision to add secondary masters and karina rolling update to replace the workers", master.Name())
+	return nil
+}
@@ -0,0 +1,147 @@
+package fake
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flanksource/karina/pkg/types"
+	konfigadm "github.com/flanksource/konfigadm/pkg/types"
+)
+
+// Cluster is an in-memory types.Cluster for unit tests, machines are "running" as soon
+// as they are cloned and are removed from the cluster when terminated
+type Cluster struct {
+	sync.Mutex
+	prefix     string
+	vmPrefixes map[string]types.VM
+	Machines   map[string]*Machine
+	// Terminated is the names of all machines that have been terminated, in order
+	Terminated []string
+	// DeletedTemplates is the names of all templates that have been deleted, in order
+	DeletedTemplates []string
+	// UploadedTemplates is the names of all templates that have been uploaded, in order
+	UploadedTemplates []string
+	nextIP            int
+}
+
+func NewCluster(platform types.PlatformConfig) *Cluster {
+	cluster := &Cluster{
+		prefix:     platform.HostPrefix + "-" + platform.Name,
+		vmPrefixes: make(map[string]types.VM),
+		Machines:   make(map[string]*Machine),
+	}
+	for _, vm := range platform.Nodes {
+		cluster.vmPrefixes[vm.Prefix] = vm
+	}
+	cluster.vmPrefixes[platform.Master.Prefix] = platform.Master
+	return cluster
+}
+
+// Add creates a running machine without going through Clone, e.g. to seed existing
+// machines before a test
+func (cluster *Cluster) Add(vm types.VM) *Machine {
+	cluster.Lock()
+	defer cluster.Unlock()
+	cluster.nextIP++
+	machine := &Machine{
+		cluster:   cluster,
+		name:      vm.Name,
+		ip:        fmt.Sprintf("10.0.%d.%d", cluster.nextIP/254, cluster.nextIP%254+1),
+		tags:      make(map[string]string),
+		PoweredOn: true,
+		Attributes: map[string]string{
+			"Template":    vm.Template,
+			"CreatedDate": time.Now().Format("02Jan06-15:04:05"),
+		},
+	}
+	for k, v := range vm.Tags {
+		machine.tags[k] = v
+	}
+	cluster.Machines[vm.Name] = machine
+	return machine
+}
+
+func (cluster *Cluster) Clone(template types.VM, config *konfigadm.Config) (types.Machine, error) {
+	cluster.Lock()
+	_, exists := cluster.Machines[template.Name]
+	cluster.Unlock()
+	if exists {
+		return nil, fmt.Errorf("%s already exists", template.Name)
+	}
+	return cluster.Add(template), nil
+}
+
+func (cluster *Cluster) CloneTemplate(template types.VM, config *konfigadm.Config) (types.Machine, error) {
+	return cluster.Clone(template, config)
+}
+
+// GetMachines returns a list of all VM's associated with the cluster
+func (cluster *Cluster) GetMachines() (map[string]types.Machine, error) {
+	machines := map[string]types.Machine{}
+	for _, vm := range cluster.vmPrefixes {
+		vm := vm
+		list, err := cluster.GetMachinesFor(&vm)
+		if err != nil {
+			return nil, err
+		}
+		for name, machine := range list {
+			machines[name] = machine
+		}
+	}
+	return machines, nil
+}
+
+// GetMachinesFor returns a list of all VM's matching the prefix of vm
+func (cluster *Cluster) GetMachinesFor(vm *types.VM) (map[string]types.Machine, error) {
+	cluster.Lock()
+	defer cluster.Unlock()
+	prefix := fmt.Sprintf("%s-%s", cluster.prefix, vm.Prefix)
+	machines := map[string]types.Machine{}
+	for name, machine := range cluster.Machines {
+		if strings.HasPrefix(name, prefix) {
+			machines[name] = machine
+		}
+	}
+	return machines, nil
+}
+
+func (cluster *Cluster) GetMachine(name string) (types.Machine, error) {
+	machines, err := cluster.GetMachines()
+	return machines[name], err
+}
+
+func (cluster *Cluster) SetTags(vm types.Machine, tags map[string]string) error {
+	machine, ok := vm.(*Machine)
+	if !ok {
+		return fmt.Errorf("%s is not a fake machine", vm.Name())
+	}
+	machine.Lock()
+	defer machine.Unlock()
+	for k, v := range tags {
+		machine.tags[k] = v
+	}
+	return nil
+}
+
+func (cluster *Cluster) remove(name string) {
+	cluster.Lock()
+	defer cluster.Unlock()
+	delete(cluster.Machines, name)
+	cluster.Terminated = append(cluster.Terminated, name)
+}
+
+func (cluster *Cluster) UploadTemplate(vm types.VM, name, path string) error {
+	cluster.Lock()
+	defer cluster.Unlock()
+	cluster.UploadedTemplates = append(cluster.UploadedTemplates, name)
+	return nil
+}
+
+func (cluster *Cluster) DeleteTemplate(vm types.VM, name string) error {
+	cluster.Lock()
+	defer cluster.Unlock()
+	cluster.DeletedTemplates = append(cluster.DeletedTemplates, name)
+	return nil
+}
@@ -0,0 +1,117 @@
+package fake
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	vim "github.com/vmware/govmomi/vim25/types"
+)
+
+// Machine is an in-memory types.Machine
+type Machine struct {
+	sync.Mutex
+	cluster    *Cluster
+	name, ip   string
+	tags       map[string]string
+	Attributes map[string]string
+	PoweredOn  bool
+}
+
+func (m *Machine) GetTags() map[string]string {
+	m.Lock()
+	defer m.Unlock()
+	tags := make(map[string]string)
+	for k, v := range m.tags {
+		tags[k] = v
+	}
+	return tags
+}
+
+func (m *Machine) String() string {
+	return m.name
+}
+
+func (m *Machine) Name() string {
+	return m.name
+}
+
+func (m *Machine) IP() string {
+	return m.ip
+}
+
+func (m *Machine) WaitForPoweredOff() error {
+	m.Lock()
+	defer m.Unlock()
+	if m.PoweredOn {
+		return fmt.Errorf("%s is powered on", m.name)
+	}
+	return nil
+}
+
+func (m *Machine) GetIP(timeout time.Duration) (string, error) {
+	m.Lock()
+	defer m.Unlock()
+	if !m.PoweredOn {
+		return "<powered off>", nil
+	}
+	return m.ip, nil
+}
+
+func (m *Machine) WaitForIP() (string, error) {
+	return m.GetIP(0)
+}
+
+func (m *Machine) SetAttributes(attributes map[string]string) error {
+	m.Lock()
+	defer m.Unlock()
+	for k, v := range attributes {
+		m.Attributes[k] = v
+	}
+	return nil
+}
+
+func (m *Machine) GetAttributes() (map[string]string, error) {
+	m.Lock()
+	defer m.Unlock()
+	attributes := make(map[string]string)
+	for k, v := range m.Attributes {
+		attributes[k] = v
+	}
+	return attributes, nil
+}
+
+func (m *Machine) Shutdown() error {
+	return m.PowerOff()
+}
+
+func (m *Machine) PowerOff() error {
+	m.Lock()
+	defer m.Unlock()
+	m.PoweredOn = false
+	return nil
+}
+
+// Terminate powers off the machine and removes it from the cluster
+func (m *Machine) Terminate() error {
+	if err := m.PowerOff(); err != nil {
+		return err
+	}
+	m.cluster.remove(m.name)
+	return nil
+}
+
+func (m *Machine) GetAge() time.Duration {
+	attributes, _ := m.GetAttributes()
+	created, _ := time.ParseInLocation("02Jan06-15:04:05", attributes["CreatedDate"], time.Local)
+	return time.Since(created)
+}
+
+func (m *Machine) GetTemplate() string {
+	attributes, _ := m.GetAttributes()
+	return attributes["Template"]
+}
+
+func (m *Machine) Reference() vim.ManagedObjectReference {
+	return vim.ManagedObjectReference{Type: "FakeMachine", Value: m.name}
+}
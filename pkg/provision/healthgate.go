@@ -0,0 +1,306 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/flanksource/karina/pkg/phases/monitoring"
+	"github.com/flanksource/karina/pkg/platform"
+	"github.com/flanksource/karina/pkg/types"
+	"github.com/flanksource/kommons/etcd"
+	"github.com/pkg/errors"
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	PrometheusGate = "prometheus"
+	CanaryGate     = "canary"
+	AlertsGate     = "alerts"
+	EtcdGate       = "etcd"
+)
+
+// HealthGate is a check that must pass before a rolling operation continues with the next batch
+type HealthGate interface {
+	Name() string
+	// Check returns an error describing why the gate is not passing
+	Check(platform *platform.Platform) error
+}
+
+// NewHealthGate returns the built-in health gate for a gate config
+func NewHealthGate(config types.HealthGate) (HealthGate, error) {
+	name := config.Name
+	if name == "" {
+		name = config.Type
+	}
+	switch config.Type {
+	case PrometheusGate:
+		if config.Query == "" {
+			return nil, fmt.Errorf("health gate %s: query is required", name)
+		}
+		return prometheusGate{name: name, query: config.Query}, nil
+	case CanaryGate:
+		return canaryGate{name: name, namespace: config.Namespace, selector: config.Selector}, nil
+	case AlertsGate:
+		severity := config.Severity
+		if severity == "" {
+			severity = "critical"
+		}
+		if _, ok := severities[severity]; !ok {
+			return nil, fmt.Errorf("health gate %s: unknown severity %s", name, severity)
+		}
+		return alertsGate{name: name, severity: severity}, nil
+	case EtcdGate:
+		return etcdGate{name: name, members: config.Members}, nil
+	}
+	return nil, fmt.Errorf("health gate %s: unknown type %q, must be one of prometheus, canary, alerts or etcd", name, config.Type)
+}
+
+// ParseHealthGate parses a gate from the command line in the form <type>[:<arg>], where arg is the
+// query of prometheus gates, the label selector of canary gates, the severity of alerts gates and
+// the minimum number of members of etcd gates
+func ParseHealthGate(s string) (types.HealthGate, error) {
+	parts := strings.SplitN(s, ":", 2)
+	gate := types.HealthGate{Type: parts[0]}
+	if len(parts) == 1 {
+		return gate, nil
+	}
+	switch gate.Type {
+	case PrometheusGate:
+		gate.Query = parts[1]
+	case CanaryGate:
+		gate.Selector = parts[1]
+	case AlertsGate:
+		gate.Severity = parts[1]
+	case EtcdGate:
+		if _, err := fmt.Sscanf(parts[1], "%d", &gate.Members); err != nil {
+			return gate, fmt.Errorf("invalid etcd member count: %s", parts[1])
+		}
+	}
+	return gate, nil
+}
+
+// healthGates returns the gates in the platform config followed by those in opts
+func (opts RollingOptions) healthGates(platform *platform.Platform) ([]HealthGate, error) {
+	var gates []HealthGate
+	for _, config := range append(append([]types.HealthGate{}, platform.HealthGates...), opts.HealthGates...) {
+		gate, err := NewHealthGate(config)
+		if err != nil {
+			return nil, err
+		}
+		gates = append(gates, gate)
+	}
+	return gates, nil
+}
+
+// checkHealthGates runs and logs every gate, returning true if all of them pass
+func checkHealthGates(platform *platform.Platform, gates []HealthGate) bool {
+	passed := true
+	for _, gate := range gates {
+		if err := gate.Check(platform); err != nil {
+			platform.Infof("[gate:%s] failing: %v", gate.Name(), err)
+			passed = false
+		} else {
+			platform.Infof("[gate:%s] passed", gate.Name())
+		}
+	}
+	return passed
+}
+
+type prometheusGate struct {
+	name, query string
+}
+
+func (gate prometheusGate) Name() string {
+	return gate.name
+}
+
+func (gate prometheusGate) Check(platform *platform.Platform) error {
+	if !platform.IsMonitoringEnabled() {
+		return fmt.Errorf("monitoring is disabled")
+	}
+	promAPI, err := monitoring.GetPrometheusClient(platform, monitoring.Prometheus)
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to prometheus")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	value, _, err := promAPI.Query(ctx, gate.query, time.Now())
+	if err != nil {
+		return errors.Wrapf(err, "failed to query %s", gate.query)
+	}
+	return nonZeroSamples(value)
+}
+
+// nonZeroSamples returns an error listing the samples of a query result that are not zero
+func nonZeroSamples(value model.Value) error {
+	var failing []string
+	switch v := value.(type) {
+	case model.Vector:
+		for _, sample := range v {
+			if sample.Value != 0 {
+				failing = append(failing, fmt.Sprintf("%s=%s", sample.Metric, sample.Value))
+			}
+		}
+	case *model.Scalar:
+		if v.Value != 0 {
+			failing = append(failing, v.Value.String())
+		}
+	case model.Matrix:
+		for _, stream := range v {
+			for _, sample := range stream.Values {
+				if sample.Value != 0 {
+					failing = append(failing, fmt.Sprintf("%s=%s", stream.Metric, sample.Value))
+					break
+				}
+			}
+		}
+	}
+	if len(failing) > 0 {
+		return fmt.Errorf("query returned %s", strings.Join(failing, ", "))
+	}
+	return nil
+}
+
+// severities orders alert severities, alerts without a known severity (e.g. Watchdog) are ignored
+var severities = map[string]int{
+	"info":     1,
+	"warning":  2,
+	"critical": 3,
+}
+
+type alertsGate struct {
+	name, severity string
+}
+
+func (gate alertsGate) Name() string {
+	return gate.name
+}
+
+func (gate alertsGate) Check(platform *platform.Platform) error {
+	if !platform.IsMonitoringEnabled() {
+		return fmt.Errorf("monitoring is disabled")
+	}
+	promAPI, err := monitoring.GetPrometheusClient(platform, monitoring.Prometheus)
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to prometheus")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	result, err := promAPI.Alerts(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to get alerts")
+	}
+	return firingAlerts(result.Alerts, gate.severity)
+}
+
+// firingAlerts returns an error listing the firing alerts at or above severity
+func firingAlerts(alerts []promv1.Alert, severity string) error {
+	var firing []string
+	for _, alert := range alerts {
+		if alert.State != promv1.AlertStateFiring {
+			continue
+		}
+		level, ok := severities[string(alert.Labels["severity"])]
+		if !ok || level < severities[severity] {
+			continue
+		}
+		firing = append(firing, fmt.Sprintf("%s (%s)", alert.Labels[model.AlertNameLabel], alert.Labels["severity"]))
+	}
+	if len(firing) > 0 {
+		return fmt.Errorf("%d alerts firing: %s", len(firing), strings.Join(firing, ", "))
+	}
+	return nil
+}
+
+var canaryResource = schema.GroupVersionResource{Group: "canaries.flanksource.com", Version: "v1", Resource: "canaries"}
+
+type canaryGate struct {
+	name, namespace, selector string
+}
+
+func (gate canaryGate) Name() string {
+	return gate.name
+}
+
+func (gate canaryGate) Check(platform *platform.Platform) error {
+	client, err := platform.GetDynamicClient()
+	if err != nil {
+		return err
+	}
+	list, err := client.Resource(canaryResource).Namespace(gate.namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: gate.selector})
+	if err != nil {
+		return errors.Wrap(err, "failed to list canaries")
+	}
+	var failing []string
+	for _, canary := range list.Items {
+		status, _, _ := unstructured.NestedString(canary.Object, "status", "status")
+		if status != "Passed" {
+			message, _, _ := unstructured.NestedString(canary.Object, "status", "message")
+			failing = append(failing, fmt.Sprintf("%s/%s is %q %s", canary.GetNamespace(), canary.GetName(), status, message))
+		}
+	}
+	if len(failing) > 0 {
+		return fmt.Errorf("%d of %d canaries not passing: %s", len(failing), len(list.Items), strings.Join(failing, ", "))
+	}
+	return nil
+}
+
+type etcdGate struct {
+	name    string
+	members int
+}
+
+func (gate etcdGate) Name() string {
+	return gate.name
+}
+
+func (gate etcdGate) Check(platform *platform.Platform) error {
+	expected := gate.members
+	if expected == 0 {
+		masters, err := platform.GetMasterNodes()
+		if err != nil {
+			return err
+		}
+		expected = len(masters)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	client, err := platform.GetEtcdClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close() // nolint: errcheck
+	members, err := client.Members(ctx)
+	if err != nil {
+		return err
+	}
+	return unhealthyMembers(members, expected)
+}
+
+// unhealthyMembers returns an error if fewer than expected members are started and free of alarms
+func unhealthyMembers(members []*etcd.Member, expected int) error {
+	healthy := 0
+	var problems []string
+	for _, member := range members {
+		switch {
+		case member.Name == "":
+			problems = append(problems, fmt.Sprintf("%x not started", member.ID))
+		case member.IsLearner:
+			problems = append(problems, fmt.Sprintf("%s is a learner", member.Name))
+		case len(member.Alarms) > 0:
+			problems = append(problems, fmt.Sprintf("%s has alarms %v", member.Name, member.Alarms))
+		default:
+			healthy++
+		}
+	}
+	if healthy < expected {
+		return fmt.Errorf("%d of %d expected etcd members healthy %s", healthy, expected, strings.Join(problems, ", "))
+	}
+	return nil
+}
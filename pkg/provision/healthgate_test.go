@@ -0,0 +1,58 @@
+package provision
+
+import (
+	"testing"
+
+	"github.com/flanksource/karina/pkg/types"
+	"github.com/flanksource/kommons/etcd"
+	. "github.com/onsi/gomega"
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+func TestNewHealthGate(t *testing.T) {
+	g := NewWithT(t)
+
+	gate, err := NewHealthGate(types.HealthGate{Type: AlertsGate})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(gate.Name()).To(Equal("alerts"))
+	g.Expect(gate.(alertsGate).severity).To(Equal("critical"))
+
+	_, err = NewHealthGate(types.HealthGate{Type: PrometheusGate, Name: "failed-jobs"})
+	g.Expect(err).To(MatchError(ContainSubstring("query is required")))
+	_, err = NewHealthGate(types.HealthGate{Type: AlertsGate, Severity: "page"})
+	g.Expect(err).To(MatchError(ContainSubstring("unknown severity")))
+	_, err = NewHealthGate(types.HealthGate{Type: "http"})
+	g.Expect(err).To(MatchError(ContainSubstring("unknown type")))
+
+	parsed, err := ParseHealthGate("prometheus:sum(up{job=\"x\"} == 0)")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(parsed).To(Equal(types.HealthGate{Type: PrometheusGate, Query: "sum(up{job=\"x\"} == 0)"}))
+	parsed, err = ParseHealthGate("etcd:3")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(parsed.Members).To(Equal(3))
+	_, err = ParseHealthGate("etcd:three")
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestHealthGateResults(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(nonZeroSamples(model.Vector{})).To(Succeed())
+	g.Expect(nonZeroSamples(model.Vector{{Metric: model.Metric{"job": "a"}, Value: 0}})).To(Succeed())
+	g.Expect(nonZeroSamples(model.Vector{{Metric: model.Metric{"job": "a"}, Value: 2}})).To(MatchError(ContainSubstring("job=\"a\"")))
+
+	alerts := []promv1.Alert{
+		{State: promv1.AlertStateFiring, Labels: model.LabelSet{"alertname": "Watchdog", "severity": "none"}},
+		{State: promv1.AlertStatePending, Labels: model.LabelSet{"alertname": "NodeDown", "severity": "critical"}},
+		{State: promv1.AlertStateFiring, Labels: model.LabelSet{"alertname": "DiskFilling", "severity": "warning"}},
+	}
+	g.Expect(firingAlerts(alerts, "critical")).To(Succeed())
+	g.Expect(firingAlerts(alerts, "warning")).To(MatchError(ContainSubstring("DiskFilling")))
+
+	members := []*etcd.Member{{Name: "m-1"}, {Name: "m-2"}, {ID: 3}}
+	g.Expect(unhealthyMembers(members, 2)).To(Succeed())
+	g.Expect(unhealthyMembers(members, 3)).To(MatchError(ContainSubstring("not started")))
+	members[1].Alarms = []etcd.AlarmType{etcd.AlarmNoSpace}
+	g.Expect(unhealthyMembers(members, 2)).To(MatchError(ContainSubstring("NOSPACE")))
+}
@@ -0,0 +1,272 @@
+package libvirt
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	ptypes "github.com/flanksource/karina/pkg/types"
+	cloudinit "github.com/flanksource/konfigadm/pkg/cloud-init"
+	konfigadm "github.com/flanksource/konfigadm/pkg/types"
+	"github.com/pkg/errors"
+)
+
+type domain struct {
+	XMLName     xml.Name     `xml:"domain"`
+	Type        string       `xml:"type,attr"`
+	Name        string       `xml:"name"`
+	Description string       `xml:"description,omitempty"`
+	Memory      domainMemory `xml:"memory"`
+	VCPU        int32        `xml:"vcpu"`
+	OS          domainOS     `xml:"os"`
+	Features    struct {
+		ACPI *struct{} `xml:"acpi"`
+	} `xml:"features"`
+	CPU     domainCPU     `xml:"cpu"`
+	Devices domainDevices `xml:"devices"`
+}
+
+type domainMemory struct {
+	Unit  string `xml:"unit,attr"`
+	Value int64  `xml:",chardata"`
+}
+
+type domainOS struct {
+	Type domainOSType `xml:"type"`
+}
+
+type domainOSType struct {
+	Arch    string `xml:"arch,attr,omitempty"`
+	Machine string `xml:"machine,attr,omitempty"`
+	Value   string `xml:",chardata"`
+}
+
+type domainCPU struct {
+	Mode string `xml:"mode,attr"`
+}
+
+type domainDevices struct {
+	Disks      []domainDisk      `xml:"disk"`
+	Interfaces []domainInterface `xml:"interface"`
+	Serial     domainSerial      `xml:"serial"`
+	Console    domainSerial      `xml:"console"`
+}
+
+type domainDisk struct {
+	Type     string       `xml:"type,attr"`
+	Device   string       `xml:"device,attr"`
+	Driver   domainDriver `xml:"driver"`
+	Source   domainSource `xml:"source"`
+	Target   domainTarget `xml:"target"`
+	ReadOnly *struct{}    `xml:"readonly"`
+}
+
+type domainDriver struct {
+	Name string `xml:"name,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type domainSource struct {
+	File    string `xml:"file,attr,omitempty"`
+	Network string `xml:"network,attr,omitempty"`
+}
+
+type domainTarget struct {
+	Type string `xml:"type,attr,omitempty"`
+	Dev  string `xml:"dev,attr,omitempty"`
+	Bus  string `xml:"bus,attr,omitempty"`
+	Port *int   `xml:"port,attr"`
+}
+
+type domainInterface struct {
+	Type   string       `xml:"type,attr"`
+	Source domainSource `xml:"source"`
+	Model  domainModel  `xml:"model"`
+}
+
+type domainModel struct {
+	Type string `xml:"type,attr"`
+}
+
+type domainSerial struct {
+	Type   string       `xml:"type,attr"`
+	Target domainTarget `xml:"target"`
+}
+
+func diskName(vm ptypes.VM) string {
+	return vm.Name + ".qcow2"
+}
+
+func isoName(vm ptypes.VM) string {
+	return vm.Name + "-cloud-init.iso"
+}
+
+// createISO returns the path to a NoCloud ISO containing the cloud-init of config, tests replace it
+// to avoid requiring genisoimage
+var createISO = func(hostname string, config *konfigadm.Config) (string, error) {
+	return cloudinit.CreateISO(hostname, config.ToCloudInit().String())
+}
+
+// Clone creates a copy-on-write disk backed by the template volume, attaches the konfigadm
+// cloud-init as a NoCloud ISO and starts a new domain. Anything created is removed again if
+// the clone fails, so that it can be retried with the same name.
+func (v virsh) Clone(vm ptypes.VM, config *konfigadm.Config) (err error) {
+	capacity := int64(vm.DiskGB) * 1024 * 1024 * 1024
+	base, err := v.volumeCapacity(vm.Template)
+	if err != nil {
+		return errors.Wrapf(err, "failed to find base image %s in pool %s", vm.Template, v.pool)
+	}
+	if capacity == 0 {
+		capacity = base
+	} else if capacity < base {
+		return fmt.Errorf("cannot shrink template from %d GB to %d GB", base/1024/1024/1024, vm.DiskGB)
+	}
+
+	v.Infof("Cloning %s to %s", vm.Template, vm.Name)
+	if _, err := v.run("vol-create-as", v.pool, diskName(vm), fmt.Sprintf("%d", capacity),
+		"--format", "qcow2", "--backing-vol", vm.Template, "--backing-vol-format", "qcow2"); err != nil {
+		return errors.Wrapf(err, "failed to create disk for %s", vm.Name)
+	}
+	volumes := []string{diskName(vm)}
+	defined := false
+	defer func() {
+		if err == nil {
+			return
+		}
+		v.Warnf("Removing %s after failed clone", vm.Name)
+		if defined {
+			// the disk and ISO are attached to the domain, so they are removed with it
+			if _, err := v.run("undefine", vm.Name, "--remove-all-storage"); err != nil {
+				v.Warnf("failed to undefine %s: %v", vm.Name, err)
+			}
+			return
+		}
+		for _, volume := range volumes {
+			v.deleteVolume(volume)
+		}
+	}()
+
+	disk, err := v.volumePath(diskName(vm))
+	if err != nil {
+		return err
+	}
+
+	iso, err := v.uploadCloudInit(vm, config)
+	if err != nil {
+		return errors.Wrapf(err, "error getting cdrom")
+	}
+	volumes = append(volumes, isoName(vm))
+
+	data, err := xml.MarshalIndent(newDomain(v.domainType, vm, disk, iso), "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile("", vm.Name+"*.xml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name()) // nolint: errcheck
+	if _, err := file.Write(data); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if _, err := v.run("define", file.Name()); err != nil {
+		return errors.Wrapf(err, "failed to define domain %s", vm.Name)
+	}
+	defined = true
+	if err := v.setMetadata(vm.Name, metadata{Tags: fromMap(vm.Tags)}); err != nil {
+		return err
+	}
+	if _, err := v.run("start", vm.Name); err != nil {
+		return errors.Wrapf(err, "failed to start %s", vm.Name)
+	}
+	return nil
+}
+
+// uploadCloudInit creates a NoCloud ISO containing the konfigadm cloud-init and uploads it to
+// the storage pool, returning the path of the volume on the hypervisor
+func (v virsh) uploadCloudInit(vm ptypes.VM, config *konfigadm.Config) (string, error) {
+	v.Debugf("Creating ISO for %s", vm.Name)
+	iso, err := createISO(vm.Name, config)
+	if err != nil {
+		return "", fmt.Errorf("getCdrom: failed to create ISO: %v", err)
+	}
+	defer os.Remove(iso) // nolint: errcheck
+	info, err := os.Stat(iso)
+	if err != nil {
+		return "", err
+	}
+	if _, err := v.run("vol-create-as", v.pool, isoName(vm), fmt.Sprintf("%d", info.Size()), "--format", "raw"); err != nil {
+		return "", err
+	}
+	v.Debugf("Uploading to [%s] %s", v.pool, isoName(vm))
+	if _, err := v.run("vol-upload", "--pool", v.pool, isoName(vm), iso); err != nil {
+		v.deleteVolume(isoName(vm))
+		return "", err
+	}
+	path, err := v.volumePath(isoName(vm))
+	if err != nil {
+		v.deleteVolume(isoName(vm))
+		return "", err
+	}
+	return path, nil
+}
+
+func newDomain(domainType string, vm ptypes.VM, disk, iso string) domain {
+	memory := vm.MemoryGB
+	if memory == 0 {
+		memory = 2
+	}
+	cpus := vm.CPUs
+	if cpus == 0 {
+		cpus = 2
+	}
+	zero := 0
+	d := domain{
+		Type:        domainType,
+		Name:        vm.Name,
+		Description: "Created by karina from " + vm.Template,
+		Memory:      domainMemory{Unit: "GiB", Value: memory},
+		VCPU:        cpus,
+		OS:          domainOS{Type: domainOSType{Value: "hvm"}},
+		CPU:         domainCPU{Mode: "host-passthrough"},
+		Devices: domainDevices{
+			Disks: []domainDisk{
+				{
+					Type:   "file",
+					Device: "disk",
+					Driver: domainDriver{Name: "qemu", Type: "qcow2"},
+					Source: domainSource{File: disk},
+					Target: domainTarget{Dev: "vda", Bus: "virtio"},
+				},
+				{
+					Type:     "file",
+					Device:   "cdrom",
+					Driver:   domainDriver{Name: "qemu", Type: "raw"},
+					Source:   domainSource{File: iso},
+					Target:   domainTarget{Dev: "sda", Bus: "sata"},
+					ReadOnly: &struct{}{},
+				},
+			},
+			// The serial device is a requirement for Ubuntu image booting, see vmware.getSerial
+			Serial:  domainSerial{Type: "pty", Target: domainTarget{Port: &zero}},
+			Console: domainSerial{Type: "pty", Target: domainTarget{Type: "serial", Port: &zero}},
+		},
+	}
+	d.Features.ACPI = &struct{}{}
+	networks := vm.Network
+	if len(networks) == 0 {
+		networks = []string{defaultNetwork}
+	}
+	for _, network := range networks {
+		d.Devices.Interfaces = append(d.Devices.Interfaces, domainInterface{
+			Type:   "network",
+			Source: domainSource{Network: network},
+			Model:  domainModel{Type: "virtio"},
+		})
+	}
+	return d
+}
@@ -0,0 +1,107 @@
+package libvirt
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/flanksource/karina/pkg/types"
+	konfigadm "github.com/flanksource/konfigadm/pkg/types"
+	. "github.com/onsi/gomega"
+)
+
+// withVirsh replaces virsh with a fake that records each command and fails the first command
+// named failOn, it returns the recorded commands without the connection flags
+func withVirsh(t *testing.T, failOn string) *[]string {
+	var commands []string
+	original, originalISO := execVirsh, createISO
+	execVirsh = func(args ...string) (string, error) {
+		// drop --connect <uri>
+		args = args[2:]
+		commands = append(commands, strings.Join(args, " "))
+		switch {
+		case args[0] == failOn:
+			failOn = ""
+			return "", fmt.Errorf("exit status 1: error: %s failed", args[0])
+		case args[0] == "vol-info":
+			return "Name:           template\nCapacity:       10737418240 bytes\n", nil
+		case args[0] == "vol-path":
+			return "/var/lib/libvirt/images/" + args[len(args)-1] + "\n", nil
+		case args[0] == "domstate":
+			return "shut off\n", nil
+		}
+		return "", nil
+	}
+	createISO = func(hostname string, config *konfigadm.Config) (string, error) {
+		file, err := ioutil.TempFile("", "user-data*.iso")
+		if err != nil {
+			return "", err
+		}
+		defer file.Close() // nolint: errcheck
+		_, err = file.WriteString("#cloud-config\n")
+		return file.Name(), err
+	}
+	t.Cleanup(func() { execVirsh, createISO = original, originalISO })
+	return &commands
+}
+
+func TestClone(t *testing.T) {
+	g := NewWithT(t)
+	commands := withVirsh(t, "")
+	err := newVirsh(&types.Libvirt{Pool: "images"}).Clone(types.VM{Name: "k8s-w-1", Template: "kube-v1.20.7"}, &konfigadm.Config{})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(*commands).To(ContainElement("start k8s-w-1"))
+	for _, command := range *commands {
+		g.Expect(command).ToNot(HavePrefix("vol-delete"))
+		g.Expect(command).ToNot(HavePrefix("undefine"))
+	}
+}
+
+func TestCloneCleanup(t *testing.T) {
+	tests := []struct {
+		failOn  string
+		cleanup []string
+	}{
+		{
+			failOn:  "vol-create-as",
+			cleanup: nil,
+		},
+		{
+			failOn:  "vol-path",
+			cleanup: []string{"vol-delete --pool images k8s-w-1.qcow2"},
+		},
+		{
+			failOn:  "vol-upload",
+			cleanup: []string{"vol-delete --pool images k8s-w-1-cloud-init.iso", "vol-delete --pool images k8s-w-1.qcow2"},
+		},
+		{
+			failOn:  "define",
+			cleanup: []string{"vol-delete --pool images k8s-w-1.qcow2", "vol-delete --pool images k8s-w-1-cloud-init.iso"},
+		},
+		{
+			failOn:  "metadata",
+			cleanup: []string{"undefine k8s-w-1 --remove-all-storage"},
+		},
+		{
+			failOn:  "start",
+			cleanup: []string{"undefine k8s-w-1 --remove-all-storage"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.failOn, func(t *testing.T) {
+			g := NewWithT(t)
+			commands := withVirsh(t, test.failOn)
+			err := newVirsh(&types.Libvirt{Pool: "images"}).Clone(types.VM{Name: "k8s-w-1", Template: "kube-v1.20.7"}, &konfigadm.Config{})
+			g.Expect(err).To(HaveOccurred())
+			g.Expect(err.Error()).To(ContainSubstring("virsh " + test.failOn + " failed"))
+			var cleanup []string
+			for _, command := range *commands {
+				if strings.HasPrefix(command, "vol-delete") || strings.HasPrefix(command, "undefine") {
+					cleanup = append(cleanup, command)
+				}
+			}
+			g.Expect(cleanup).To(Equal(test.cleanup))
+		})
+	}
+}
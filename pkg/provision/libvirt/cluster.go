@@ -0,0 +1,157 @@
+package libvirt
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/flanksource/commons/logger"
+	"github.com/flanksource/karina/pkg/types"
+	konfigadm "github.com/flanksource/konfigadm/pkg/types"
+	"github.com/pkg/errors"
+)
+
+type libvirtCluster struct {
+	prefix     string
+	master     *types.Libvirt
+	vmPrefixes map[string]types.VM
+	DryRun     bool
+}
+
+// NewLibvirtCluster returns a cluster of libvirt domains, each VM connects to the libvirt
+// daemon specified in its libvirt config, or that of the master if not specified
+func NewLibvirtCluster(platform types.PlatformConfig) (types.Cluster, error) {
+	cluster := libvirtCluster{
+		master:     platform.Master.Libvirt,
+		vmPrefixes: make(map[string]types.VM),
+		prefix:     platform.HostPrefix + "-" + platform.Name,
+	}
+	if _, err := newVirsh(cluster.master).run("version"); err != nil {
+		return nil, errors.Wrap(err, "failed to connect to libvirt")
+	}
+	for _, vm := range platform.Nodes {
+		cluster.vmPrefixes[vm.Prefix] = vm
+	}
+	cluster.vmPrefixes[platform.Master.Prefix] = platform.Master
+	return &cluster, nil
+}
+
+func (cluster *libvirtCluster) virshFor(vm types.VM) virsh {
+	if vm.Libvirt != nil {
+		return newVirsh(vm.Libvirt)
+	}
+	return newVirsh(cluster.master)
+}
+
+func (cluster *libvirtCluster) Clone(template types.VM, config *konfigadm.Config) (types.Machine, error) {
+	virsh := cluster.virshFor(template)
+	if err := virsh.Clone(template, config); err != nil {
+		return nil, err
+	}
+	return newVM(virsh, cluster.DryRun, template.Name, &template), nil
+}
+
+// CloneTemplate is the same as Clone as libvirt has no equivalent of content libraries
+func (cluster *libvirtCluster) CloneTemplate(template types.VM, config *konfigadm.Config) (types.Machine, error) {
+	return cluster.Clone(template, config)
+}
+
+// GetMachines returns a list of all VM's associated with the cluster
+func (cluster *libvirtCluster) GetMachines() (map[string]types.Machine, error) {
+	machines := map[string]types.Machine{}
+
+	// To list all machines for a cluster we search by each prefix combination
+	// we cannot search just using the cluster prefix as it may return incorrect startsWith results
+	for _, vm := range cluster.vmPrefixes {
+		vm := vm
+		list, err := cluster.GetMachinesFor(&vm)
+		if err != nil {
+			return nil, err
+		}
+		for name, machine := range list {
+			machines[name] = machine
+		}
+	}
+	return machines, nil
+}
+
+// GetMachinesFor returns a list of all domains matching the prefix of vm
+func (cluster *libvirtCluster) GetMachinesFor(vm *types.VM) (map[string]types.Machine, error) {
+	virsh := cluster.virshFor(*vm)
+	names, err := virsh.listDomains()
+	if err != nil {
+		return nil, err
+	}
+	prefix := fmt.Sprintf("%s-%s", cluster.prefix, vm.Prefix)
+	var vms = make(map[string]types.Machine)
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) {
+			vms[name] = newVM(virsh, cluster.DryRun, name, vm)
+		}
+	}
+	return vms, nil
+}
+
+func (cluster *libvirtCluster) GetMachine(name string) (types.Machine, error) {
+	machines, err := cluster.GetMachines()
+	return machines[name], err
+}
+
+// SetTags merges tags into the karina metadata of the domain
+func (cluster *libvirtCluster) SetTags(machine types.Machine, tags map[string]string) error {
+	message := "Setting tags ["
+	for k, v := range tags {
+		message += fmt.Sprintf("%s=%s ", k, v)
+	}
+	message += fmt.Sprintf("] to virtual machine %s", machine.Name())
+	log.Infof(message)
+
+	domainVM, ok := machine.(*vm)
+	if !ok {
+		return fmt.Errorf("%s is not a libvirt domain", machine.Name())
+	}
+	meta, err := domainVM.virsh.getMetadata(domainVM.name)
+	if err != nil {
+		return err
+	}
+	existing := toMap(meta.Tags)
+	for k, v := range tags {
+		existing[k] = v
+	}
+	meta.Tags = fromMap(existing)
+	return domainVM.virsh.setMetadata(domainVM.name, meta)
+}
+
+// UploadTemplate uploads an image as a base image volume in the storage pool of the VM, images are qcow2 unless
+// they have a .raw extension
+func (cluster *libvirtCluster) UploadTemplate(vm types.VM, name, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	format := "qcow2"
+	if filepath.Ext(path) == ".raw" {
+		format = "raw"
+	}
+	virsh := cluster.virshFor(vm)
+	if _, err := virsh.run("vol-create-as", virsh.pool, name, fmt.Sprintf("%d", info.Size()), "--format", format); err != nil {
+		return errors.Wrapf(err, "failed to create %s in pool %s", name, virsh.pool)
+	}
+	if _, err := virsh.run("vol-upload", "--pool", virsh.pool, name, path); err != nil {
+		if _, deleteErr := virsh.run("vol-delete", "--pool", virsh.pool, name); deleteErr != nil {
+			log.Warnf("failed to delete incomplete upload %s: %v", name, deleteErr)
+		}
+		return errors.Wrapf(err, "failed to upload %s to pool %s", path, virsh.pool)
+	}
+	return nil
+}
+
+// DeleteTemplate deletes a base image volume from the storage pool of the VM
+func (cluster *libvirtCluster) DeleteTemplate(vm types.VM, name string) error {
+	virsh := cluster.virshFor(vm)
+	if _, err := virsh.run("vol-delete", "--pool", virsh.pool, name); err != nil {
+		return errors.Wrapf(err, "failed to delete %s from pool %s", name, virsh.pool)
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package libvirt
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/flanksource/commons/logger"
+	"github.com/flanksource/karina/pkg/types"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultURI        = "qemu:///system"
+	defaultPool       = "default"
+	defaultDomainType = "kvm"
+	defaultNetwork    = "default"
+
+	// metadataURI is the namespace of the karina element in the libvirt domain metadata
+	metadataURI = "https://github.com/flanksource/karina"
+	metadataKey = "karina"
+)
+
+// virsh runs virsh commands against a single libvirt connection, all disks and ISO's
+// are managed as storage pool volumes so that remote (qemu+ssh://) connections work
+type virsh struct {
+	logger.Logger
+	uri        string
+	pool       string
+	domainType string
+}
+
+func newVirsh(config *types.Libvirt) virsh {
+	v := virsh{
+		Logger:     logger.WithValues("libvirt", defaultURI),
+		uri:        defaultURI,
+		pool:       defaultPool,
+		domainType: defaultDomainType,
+	}
+	if config == nil {
+		return v
+	}
+	if config.URI != "" {
+		v.uri = config.URI
+		v.Logger = logger.WithValues("libvirt", config.URI)
+	}
+	if config.Pool != "" {
+		v.pool = config.Pool
+	}
+	if config.DomainType != "" {
+		v.domainType = config.DomainType
+	}
+	return v
+}
+
+// execVirsh runs virsh with args and returns its stdout, tests replace it to avoid a hypervisor
+var execVirsh = func(args ...string) (string, error) {
+	cmd := exec.Command("virsh", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+func (v virsh) run(args ...string) (string, error) {
+	v.Tracef("virsh %s", strings.Join(args, " "))
+	out, err := execVirsh(append([]string{"--connect", v.uri}, args...)...)
+	if err != nil {
+		return "", errors.Errorf("virsh %s failed: %v", args[0], err)
+	}
+	return out, nil
+}
+
+// deleteVolume removes a volume, logging rather than returning errors as it is only used to clean up
+func (v virsh) deleteVolume(volume string) {
+	if _, err := v.run("vol-delete", "--pool", v.pool, volume); err != nil {
+		v.Warnf("failed to delete volume %s: %v", volume, err)
+	}
+}
+
+// listDomains returns the names of all domains, running or not
+func (v virsh) listDomains() ([]string, error) {
+	out, err := v.run("list", "--all", "--name")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, line := range strings.Split(out, "\n") {
+		if name := strings.TrimSpace(line); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func (v virsh) domainState(name string) (string, error) {
+	out, err := v.run("domstate", name)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// volumeCapacity returns the virtual size of a volume in bytes
+func (v virsh) volumeCapacity(volume string) (int64, error) {
+	out, err := v.run("vol-info", "--pool", v.pool, "--bytes", volume)
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "Capacity:" {
+			return strconv.ParseInt(fields[1], 10, 64)
+		}
+	}
+	return 0, errors.Errorf("capacity of %s not found", volume)
+}
+
+func (v virsh) volumePath(volume string) (string, error) {
+	out, err := v.run("vol-path", "--pool", v.pool, volume)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// leaseIP returns the first IPv4 address leased by libvirt's DHCP server to the domain
+func (v virsh) leaseIP(name string) (string, error) {
+	out, err := v.run("domifaddr", name, "--source", "lease")
+	if err != nil {
+		return "", err
+	}
+	return parseDomIfAddr(out), nil
+}
+
+// parseDomIfAddr returns the first ipv4 address from the output of virsh domifaddr, e.g.
+//
+//	vnet0      52:54:00:8a:1f:33    ipv4         192.168.122.42/24
+func parseDomIfAddr(out string) string {
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[2] != "ipv4" {
+			continue
+		}
+		return strings.Split(fields[3], "/")[0]
+	}
+	return ""
+}
+
+// metadata is stored in the domain XML under the karina namespace
+type metadata struct {
+	XMLName    xml.Name   `xml:"machine"`
+	Attributes []keyValue `xml:"attribute"`
+	Tags       []keyValue `xml:"tag"`
+}
+
+type keyValue struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+func toMap(values []keyValue) map[string]string {
+	out := make(map[string]string)
+	for _, kv := range values {
+		out[kv.Name] = kv.Value
+	}
+	return out
+}
+
+func fromMap(values map[string]string) []keyValue {
+	var out []keyValue
+	for k, v := range values {
+		out = append(out, keyValue{Name: k, Value: v})
+	}
+	return out
+}
+
+// getMetadata returns the karina metadata of a domain, or empty metadata if none has been set
+func (v virsh) getMetadata(name string) (metadata, error) {
+	meta := metadata{}
+	out, err := v.run("metadata", name, "--uri", metadataURI)
+	if err != nil {
+		if strings.Contains(err.Error(), "metadata not found") {
+			return meta, nil
+		}
+		return meta, err
+	}
+	if err := xml.Unmarshal([]byte(out), &meta); err != nil {
+		return meta, errors.Wrapf(err, "invalid metadata for %s", name)
+	}
+	return meta, nil
+}
+
+func (v virsh) setMetadata(name string, meta metadata) error {
+	data, err := xml.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	args := []string{"metadata", name, "--uri", metadataURI, "--key", metadataKey, "--set", string(data), "--config"}
+	if state, _ := v.domainState(name); state == "running" {
+		args = append(args, "--live")
+	}
+	if _, err := v.run(args...); err != nil {
+		return fmt.Errorf("setMetadata: %v", err)
+	}
+	return nil
+}
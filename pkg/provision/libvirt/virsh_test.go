@@ -0,0 +1,50 @@
+package libvirt
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestParseDomIfAddr(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		ip   string
+	}{
+		{
+			name: "lease",
+			out: ` Name       MAC address          Protocol     Address
+-------------------------------------------------------------------------------
+ vnet0      52:54:00:8a:1f:33    ipv4         192.168.122.42/24
+`,
+			ip: "192.168.122.42",
+		},
+		{
+			name: "ipv6 addresses are skipped",
+			out: ` Name       MAC address          Protocol     Address
+-------------------------------------------------------------------------------
+ vnet0      52:54:00:8a:1f:33    ipv6         fe80::5054:ff:fe8a:1f33/64
+ vnet1      52:54:00:8a:1f:34    ipv4         10.0.0.5/8
+ vnet2      52:54:00:8a:1f:35    ipv4         10.0.0.6/8
+`,
+			ip: "10.0.0.5",
+		},
+		{
+			name: "no lease yet",
+			out: ` Name       MAC address          Protocol     Address
+-------------------------------------------------------------------------------
+
+`,
+		},
+		{
+			name: "empty",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(parseDomIfAddr(test.out)).To(Equal(test.ip))
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package libvirt
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/flanksource/commons/logger"
+	"github.com/flanksource/karina/pkg/types"
+	"github.com/pkg/errors"
+	vim "github.com/vmware/govmomi/vim25/types"
+)
+
+const (
+	stateRunning = "running"
+	stateShutOff = "shut off"
+)
+
+// vm represents a libvirt domain
+type vm struct {
+	logger.Logger
+	name, ip string
+	dryRun   bool
+	virsh    virsh
+	config   *types.VM
+}
+
+func newVM(virsh virsh, dryRun bool, name string, config *types.VM) types.Machine {
+	return &vm{
+		Logger: logger.WithValues("vm", name),
+		name:   name,
+		dryRun: dryRun,
+		virsh:  virsh,
+		config: config,
+	}
+}
+
+func (vm *vm) GetTags() map[string]string {
+	return vm.config.Tags
+}
+
+func (vm *vm) IP() string {
+	if vm.ip == "" {
+		ip, _ := vm.WaitForIP()
+		vm.ip = ip
+	}
+	return vm.ip
+}
+
+func (vm *vm) Name() string {
+	return vm.name
+}
+
+func (vm *vm) String() string {
+	return vm.name
+}
+
+func (vm *vm) GetAge() time.Duration {
+	attributes, _ := vm.GetAttributes()
+	created, _ := time.ParseInLocation("02Jan06-15:04:05", attributes["CreatedDate"], time.Local)
+	return time.Since(created)
+}
+
+func (vm *vm) GetTemplate() string {
+	attributes, _ := vm.GetAttributes()
+	return attributes["Template"]
+}
+
+// Reference returns the domain name, libvirt domains are not vSphere managed objects
+func (vm *vm) Reference() vim.ManagedObjectReference {
+	return vim.ManagedObjectReference{Type: "LibvirtDomain", Value: vm.name}
+}
+
+func (vm *vm) IsPoweredOn() bool {
+	state, _ := vm.virsh.domainState(vm.name)
+	return state == stateRunning
+}
+
+// WaitForPoweredOff waits until the domain is reported as shut off by libvirt
+func (vm *vm) WaitForPoweredOff() error {
+	deadline := time.Now().Add(5 * time.Minute)
+	for {
+		state, err := vm.virsh.domainState(vm.name)
+		if err != nil {
+			return err
+		}
+		if state == stateShutOff {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timeout waiting for %s to power off, state: %s", vm.name, state)
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// GetIP waits for an IPv4 address to be leased to the domain by the libvirt DHCP server
+func (vm *vm) GetIP(timeout time.Duration) (string, error) {
+	if !vm.IsPoweredOn() {
+		return "<powered off>", nil
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("timeout exceeded")
+		}
+		ip, err := vm.virsh.leaseIP(vm.name)
+		if err != nil {
+			return "", err
+		}
+		if ip != "" {
+			vm.Debugf("Found IP: %s", ip)
+			return ip, nil
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// WaitForIP waits for an IPv4 address to be leased to the domain
+func (vm *vm) WaitForIP() (string, error) {
+	return vm.GetIP(5 * time.Minute)
+}
+
+// SetAttributes merges attributes into the karina metadata of the domain
+func (vm *vm) SetAttributes(attributes map[string]string) error {
+	meta, err := vm.virsh.getMetadata(vm.name)
+	if err != nil {
+		return fmt.Errorf("setAttributes: %v", err)
+	}
+	existing := toMap(meta.Attributes)
+	for k, v := range attributes {
+		existing[k] = v
+	}
+	meta.Attributes = fromMap(existing)
+	return vm.virsh.setMetadata(vm.name, meta)
+}
+
+func (vm *vm) GetAttributes() (map[string]string, error) {
+	meta, err := vm.virsh.getMetadata(vm.name)
+	if err != nil {
+		return nil, fmt.Errorf("getAttributes: %v", err)
+	}
+	return toMap(meta.Attributes), nil
+}
+
+// PowerOff immediately stops a domain
+func (vm *vm) PowerOff() error {
+	vm.Infof("powering off")
+	if _, err := vm.virsh.run("destroy", vm.name); err != nil {
+		return errors.Wrapf(err, "Failed to power off: %s", vm)
+	}
+	vm.Debugf("powered off")
+	return nil
+}
+
+// Shutdown sends an ACPI shutdown to the domain
+func (vm *vm) Shutdown() error {
+	vm.Infof("gracefully shutting down")
+	if _, err := vm.virsh.run("shutdown", vm.name); err != nil {
+		return errors.Wrapf(err, "Failed to shutdown %s", vm.Name())
+	}
+	return nil
+}
+
+// Terminate stops the domain and removes it together with its disk and cloud-init volumes
+func (vm *vm) Terminate() error {
+	vm.Infof("terminating")
+	if vm.dryRun {
+		vm.Infof("Not terminating in dry-run mode")
+		return nil
+	}
+
+	if vm.IsPoweredOn() {
+		if err := vm.Shutdown(); err != nil {
+			vm.Infof("graceful shutdown failed, powering off %s", err)
+			if err := vm.PowerOff(); err != nil {
+				vm.Infof("failed to power off: %s", err)
+			}
+		} else if err := vm.WaitForPoweredOff(); err != nil {
+			vm.Infof("graceful shutdown timed out, powering off")
+			if err := vm.PowerOff(); err != nil {
+				vm.Warnf("failed to power off %v", err)
+			}
+		}
+	}
+	// only volumes attached to the domain are removed, the base image is a backing file
+	if _, err := vm.virsh.run("undefine", vm.name, "--remove-all-storage"); err != nil {
+		return errors.Wrapf(err, "Failed to delete %s", vm)
+	}
+	vm.Debugf("terminated")
+	return nil
+}
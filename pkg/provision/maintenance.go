@@ -0,0 +1,49 @@
+package provision
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/flanksource/karina/pkg/maintenance"
+	"github.com/flanksource/karina/pkg/platform"
+	"github.com/pkg/errors"
+)
+
+// checkMaintenance refuses disruptive operations outside of maintenance windows or during a freeze,
+// waiting for the next window if it opens within --wait-for-window. Operations run with --override-freeze
+// are allowed and the reason is recorded in the cluster
+func checkMaintenance(platform *platform.Platform, operation string) error {
+	calendar, err := maintenance.New(platform.Maintenance)
+	if err != nil {
+		return err
+	}
+	for {
+		err = calendar.Check(time.Now())
+		if err == nil {
+			return nil
+		}
+		if platform.OverrideFreeze != "" {
+			break
+		}
+		var refused *maintenance.RefusedError
+		if !errors.As(err, &refused) || refused.Next.IsZero() || time.Until(refused.Next) > platform.WaitForWindow {
+			return fmt.Errorf("refusing to %s: %v, use --override-freeze <reason> to override", operation, err)
+		}
+		platform.Infof("%s: %v, waiting", operation, err)
+		time.Sleep(time.Until(refused.Next))
+	}
+
+	platform.Warnf("Overriding maintenance restrictions to %s (%v): %s", operation, err, platform.OverrideFreeze)
+	client, clientErr := platform.GetClientset()
+	if clientErr == nil {
+		clientErr = maintenance.RecordOverride(client, maintenance.Override{
+			Operation: operation,
+			Reason:    platform.OverrideFreeze,
+			Refused:   err.Error(),
+		})
+	}
+	if clientErr != nil {
+		platform.Warnf("Failed to record maintenance override: %v", clientErr)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package provision
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/flanksource/karina/pkg/platform"
+)
+
+// packageManager installs the kubernetes packages on a node, commands are run via platform.Executef and
+// must not contain double quotes or $ as they are wrapped in bash -c "..."
+type packageManager string
+
+const (
+	apt packageManager = "apt"
+	dnf packageManager = "dnf"
+	yum packageManager = "yum"
+)
+
+const detectPackageManager = "if command -v apt-get >/dev/null; then echo apt;" +
+	" elif command -v dnf >/dev/null; then echo dnf;" +
+	" elif command -v yum >/dev/null; then echo yum; fi"
+
+// getPackageManager returns the package manager of a node, preferring apt, then dnf and then yum
+func getPackageManager(platform *platform.Platform, node string) (packageManager, error) {
+	out, err := platform.Executef(node, time.Minute, detectPackageManager)
+	if err != nil {
+		return "", fmt.Errorf("failed to detect package manager on %s: %v, %s", node, err, out)
+	}
+	return parsePackageManager(out)
+}
+
+func parsePackageManager(out string) (packageManager, error) {
+	for _, line := range strings.Split(out, "\n") {
+		switch pm := packageManager(strings.TrimSpace(line)); pm {
+		case apt, dnf, yum:
+			return pm, nil
+		}
+	}
+	return "", fmt.Errorf("no supported package manager (apt, dnf or yum) found")
+}
+
+// install returns a command that installs packages at a kubernetes version (e.g. v1.20.4) and holds them
+// at that version, yum and dnf based images exclude the kubernetes repo from updates instead of holding
+func (pm packageManager) install(version string, packages ...string) string {
+	version = strings.TrimPrefix(version, "v")
+	var versioned []string
+	switch pm {
+	case apt:
+		for _, pkg := range packages {
+			versioned = append(versioned, fmt.Sprintf("%s=%s-00", pkg, version))
+		}
+		return fmt.Sprintf("apt-get update -qq; apt-get install -y --allow-change-held-packages %s && apt-mark hold %s",
+			strings.Join(versioned, " "), strings.Join(packages, " "))
+	default:
+		for _, pkg := range packages {
+			versioned = append(versioned, fmt.Sprintf("%s-%s-0", pkg, version))
+		}
+		return fmt.Sprintf("%s install -y --disableexcludes=kubernetes %s", pm, strings.Join(versioned, " "))
+	}
+}
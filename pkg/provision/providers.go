@@ -0,0 +1,97 @@
+package provision
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/flanksource/karina/pkg/platform"
+	"github.com/flanksource/karina/pkg/provision/fake"
+	"github.com/flanksource/karina/pkg/provision/libvirt"
+	"github.com/flanksource/karina/pkg/provision/vmware"
+	"github.com/flanksource/karina/pkg/types"
+)
+
+const (
+	VsphereProvider = "vsphere"
+	LibvirtProvider = "libvirt"
+	FakeProvider    = "fake"
+)
+
+// ClusterProvider builds the types.Cluster used to create and list the VM's of a platform
+type ClusterProvider func(p *platform.Platform) (types.Cluster, error)
+
+var providers = map[string]ClusterProvider{
+	VsphereProvider: newVsphereCluster,
+	LibvirtProvider: func(p *platform.Platform) (types.Cluster, error) {
+		return libvirt.NewLibvirtCluster(p.PlatformConfig)
+	},
+	FakeProvider: func(p *platform.Platform) (types.Cluster, error) {
+		return fake.NewCluster(p.PlatformConfig), nil
+	},
+}
+
+// RegisterProvider adds or replaces the provider that can be selected with master.provider
+func RegisterProvider(name string, provider ClusterProvider) {
+	providers[name] = provider
+}
+
+// Providers returns the names of all registered providers
+func Providers() []string {
+	var names []string
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ProviderName returns the provider of the cluster, defaulting to vsphere
+func ProviderName(p *platform.Platform) string {
+	if p.Master.Provider == "" {
+		return VsphereProvider
+	}
+	return p.Master.Provider
+}
+
+// NewCluster returns the types.Cluster of the provider selected by master.provider
+func NewCluster(p *platform.Platform) (types.Cluster, error) {
+	name := ProviderName(p)
+	provider, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %s, must be one of %s", name, strings.Join(Providers(), ", "))
+	}
+	for group, vm := range p.Nodes {
+		if vm.Provider != "" && vm.Provider != name {
+			return nil, fmt.Errorf("%s uses the %s provider, all VM's must use the provider of the master (%s)", group, vm.Provider, name)
+		}
+	}
+	return provider(p)
+}
+
+func newVsphereCluster(p *platform.Platform) (types.Cluster, error) {
+	return vmware.NewVMwareCluster(p.PlatformConfig)
+}
+
+// applyVsphereVM copies the values from the vsphere sub-block to the top-level VM fields
+// that are used by the vmware package
+func applyVsphereVM(vm *types.VM) {
+	if vm.Vsphere == nil {
+		return
+	}
+	if vm.Vsphere.ContentLibrary != "" {
+		vm.ContentLibrary = vm.Vsphere.ContentLibrary
+	}
+	if vm.Vsphere.Cluster != "" {
+		vm.Cluster = vm.Vsphere.Cluster
+	}
+	if vm.Vsphere.Folder != "" {
+		vm.Folder = vm.Vsphere.Folder
+	}
+	if vm.Vsphere.Datastore != "" {
+		vm.Datastore = vm.Vsphere.Datastore
+	}
+	if vm.Vsphere.ResourcePool != "" {
+		vm.ResourcePool = vm.Vsphere.ResourcePool
+	}
+}
@@ -0,0 +1,115 @@
+package provision
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/flanksource/commons/logger"
+	"github.com/flanksource/karina/pkg/machineimages"
+	"github.com/flanksource/karina/pkg/platform"
+	"github.com/flanksource/karina/pkg/provision/fake"
+	"github.com/flanksource/karina/pkg/types"
+	. "github.com/onsi/gomega"
+)
+
+func newFakePlatform() *platform.Platform {
+	return &platform.Platform{
+		PlatformConfig: types.PlatformConfig{
+			Name:       "test",
+			HostPrefix: "k8s",
+			Master:     types.VM{Provider: FakeProvider, Prefix: "m", Count: 1},
+			Nodes: map[string]types.VM{
+				"workers": {Prefix: "w", Count: 2},
+			},
+		},
+	}
+}
+
+func TestNewClusterFake(t *testing.T) {
+	g := NewWithT(t)
+	p := newFakePlatform()
+	cluster, err := NewCluster(p)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(cluster).To(BeAssignableToTypeOf(&fake.Cluster{}))
+
+	master, err := cluster.Clone(types.VM{Name: "k8s-test-m-1", Template: "kube-v1.20.7"}, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(master.GetTemplate()).To(Equal("kube-v1.20.7"))
+	g.Expect(master.IP()).ToNot(BeEmpty())
+	_, err = cluster.Clone(types.VM{Name: "k8s-test-w-1"}, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	workers, err := cluster.GetMachinesFor(&types.VM{Prefix: "w"})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(workers).To(HaveLen(1))
+	g.Expect(workers).To(HaveKey("k8s-test-w-1"))
+
+	g.Expect(master.Terminate()).To(Succeed())
+	machines, err := cluster.GetMachines()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(machines).To(HaveLen(1))
+	g.Expect(cluster.(*fake.Cluster).Terminated).To(Equal([]string{"k8s-test-m-1"}))
+}
+
+func TestNewClusterUnknownProvider(t *testing.T) {
+	g := NewWithT(t)
+	p := newFakePlatform()
+	p.Master.Provider = "openstack"
+	_, err := NewCluster(p)
+	g.Expect(err).To(MatchError(ContainSubstring("unknown provider openstack")))
+}
+
+func TestNewClusterMixedProviders(t *testing.T) {
+	g := NewWithT(t)
+	p := newFakePlatform()
+	p.Nodes["workers"] = types.VM{Prefix: "w", Provider: LibvirtProvider}
+	_, err := NewCluster(p)
+	g.Expect(err).To(MatchError(ContainSubstring("all VM's must use the provider of the master")))
+}
+
+func TestResolveTemplates(t *testing.T) {
+	g := NewWithT(t)
+	p := newFakePlatform()
+	p.Logger = logger.StandardLogger()
+	p.MachineImages = &types.MachineImages{Catalog: filepath.Join(t.TempDir(), "catalog.yaml")}
+	catalog, err := machineimages.Load(p)
+	g.Expect(err).ToNot(HaveOccurred())
+	catalog.Add(machineimages.Image{Name: "kube-v1.20.7-a", Kubernetes: "v1.20.7", OS: "ubuntu20.04", Built: time.Now().Add(-time.Hour)})
+	catalog.Add(machineimages.Image{Name: "kube-v1.20.7-b", Kubernetes: "v1.20.7", OS: "ubuntu20.04", Built: time.Now()})
+	g.Expect(catalog.Save(p)).To(Succeed())
+
+	p.Master.Template = "k8s=1.20,os=ubuntu,latest"
+	p.Nodes["workers"] = types.VM{Prefix: "w", Template: "kube-v1.20.7-a"}
+	g.Expect(resolveTemplates(p)).To(Succeed())
+	g.Expect(p.Master.Template).To(Equal("kube-v1.20.7-b"))
+	g.Expect(p.Nodes["workers"].Template).To(Equal("kube-v1.20.7-a"))
+
+	p.Master.Template = "k8s=1.21"
+	g.Expect(resolveTemplates(p)).ToNot(Succeed())
+}
+
+func TestUploadTemplate(t *testing.T) {
+	g := NewWithT(t)
+	p := newFakePlatform()
+	p.Logger = logger.StandardLogger()
+	p.MachineImages = &types.MachineImages{Catalog: filepath.Join(t.TempDir(), "catalog.yaml")}
+	catalog, err := machineimages.Load(p)
+	g.Expect(err).ToNot(HaveOccurred())
+	catalog.Add(machineimages.Image{Name: "kube-v1.20.7-a", Kubernetes: "v1.20.7", OS: "ubuntu20.04", Built: time.Now().Add(-time.Hour)})
+	catalog.Add(machineimages.Image{Name: "kube-v1.20.7-b", Kubernetes: "v1.20.7", OS: "ubuntu20.04", Built: time.Now(), Pending: true, Path: "ubuntu.img"})
+	g.Expect(catalog.Save(p)).To(Succeed())
+
+	// images that have been built but not uploaded are never selected
+	p.Master.Template = "k8s=1.20,os=ubuntu,latest"
+	g.Expect(resolveTemplates(p)).To(Succeed())
+	g.Expect(p.Master.Template).To(Equal("kube-v1.20.7-a"))
+
+	g.Expect(UploadTemplate(p, "kube-v1.21.1-a", "")).To(MatchError(ContainSubstring("not in the catalogue")))
+	g.Expect(UploadTemplate(p, "kube-v1.20.7-b", "")).To(Succeed())
+	g.Expect(p.Cluster.(*fake.Cluster).UploadedTemplates).To(Equal([]string{"kube-v1.20.7-b"}))
+
+	p.Master.Template = "k8s=1.20,os=ubuntu,latest"
+	g.Expect(resolveTemplates(p)).To(Succeed())
+	g.Expect(p.Master.Template).To(Equal("kube-v1.20.7-b"))
+}
@@ -3,41 +3,106 @@ package provision
 import (
 	"context"
 	"fmt"
+	"io"
+	"os"
 	"sort"
+	"strings"
 	"sync"
+	"text/tabwriter"
 	"time"
 
 	"github.com/flanksource/commons/timer"
+	"github.com/flanksource/karina/pkg/constants"
 	"github.com/flanksource/karina/pkg/controller/burnin"
 	"github.com/flanksource/karina/pkg/platform"
 	"github.com/flanksource/karina/pkg/types"
 	"github.com/flanksource/kommons"
-	"github.com/jinzhu/copier"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type RollingOptions struct {
-	Timeout                time.Duration
-	MinAge                 time.Duration
-	Max                    int
-	MaxSurge               int
-	HealthTolerance        int
-	BurninPeriod           time.Duration
-	Force                  bool
-	ScaleSingleDeployments bool
-	MigrateLocalVolumes    bool
-	Masters, Workers       bool
+	Timeout          time.Duration
+	MinAge           time.Duration
+	Max              int
+	MaxSurge         int
+	HealthTolerance  int
+	BurninPeriod     time.Duration
+	Force            bool
+	Masters, Workers bool
+	// Pools restricts the rollout to workers in the given pools, masters are skipped if set
+	Pools []string
+	// PoolMaxSurge overrides MaxSurge for individual pools
+	PoolMaxSurge map[string]int
+	// HealthGates are checked after each batch in addition to the gates in the platform config
+	HealthGates []types.HealthGate
+	// Drain controls how long to wait for evictions blocked by PodDisruptionBudgets
+	Drain DrainOptions
+	// KubeletVersion also replaces nodes whose kubelet is not at this version
+	KubeletVersion string `json:",omitempty"`
+	// Resume continues the last rollout using its original options
+	Resume bool `json:"-"`
 }
 
-func replace(platform *platform.Platform, opts RollingOptions, cluster *Cluster, machine NodeMachine) error {
+// includesPool returns true if pool is selected by the --pool filter
+func (opts RollingOptions) includesPool(pool string) bool {
+	if len(opts.Pools) == 0 {
+		return true
+	}
+	for _, p := range opts.Pools {
+		if p == pool {
+			return true
+		}
+	}
+	return false
+}
+
+// drainOptions returns the drain options, defaulting to aborting after Timeout
+func (opts RollingOptions) drainOptions() DrainOptions {
+	drain := opts.Drain
+	if drain.Timeout == 0 {
+		drain.Timeout = opts.Timeout
+	}
+	if drain.OnTimeout == "" {
+		drain.OnTimeout = DrainAbort
+	}
+	return drain
+}
+
+// maxSurgeFor returns the MaxSurge of a pool
+func (opts RollingOptions) maxSurgeFor(pool string) int {
+	if surge, ok := opts.PoolMaxSurge[pool]; ok && surge > 0 {
+		return surge
+	}
+	return opts.MaxSurge
+}
+
+// nodePool returns the pool a worker node belongs to using the pool label, or for nodes created
+// before the label was added, the longest VM prefix of a pool that matches the node name
+func nodePool(platform *platform.Platform, node v1.Node) string {
+	if pool, ok := node.Labels[constants.NodePoolLabel]; ok {
+		return pool
+	}
+	pool, longest := "", ""
+	for name, vm := range platform.Nodes {
+		prefix := fmt.Sprintf("%s-%s-%s-", platform.HostPrefix, platform.Name, vm.Prefix)
+		if strings.HasPrefix(node.Name, prefix) && len(prefix) > len(longest) {
+			pool, longest = name, prefix
+		}
+	}
+	return pool
+}
+
+func replace(platform *platform.Platform, opts RollingOptions, cluster *Cluster, rollout *Rollout, machine NodeMachine) error {
 	node := machine.Node
+	rollout.SetNode(node.Name, NodeInProgress, "", nil)
 	// first we cordon
 	if err := cluster.Cordon(node); err != nil {
 		return err
 	}
 	// then we surge up
 	var replacement types.Machine
+	var pool string
 	var err error
 	if kommons.IsMasterNode(node) {
 		replacement, err = createSecondaryMaster(platform, opts.BurninPeriod)
@@ -45,11 +110,13 @@ func replace(platform *platform.Platform, opts RollingOptions, cluster *Cluster,
 			return fmt.Errorf("failed to create new secondary master: %v", err)
 		}
 	} else {
-		replacement, err = createWorker(platform, "")
+		pool = nodePool(platform, node)
+		replacement, err = createWorker(platform, pool)
 		if err != nil {
-			return fmt.Errorf("failed to create new worker: %v", err)
+			return fmt.Errorf("failed to create new worker in pool %s: %v", pool, err)
 		}
 	}
+	rollout.SetNode(node.Name, NodeInProgress, replacement.Name(), nil)
 
 	if err := waitForNode(platform, replacement.Name(), opts.Timeout); err != nil {
 		platform.Errorf("[%s] terminating node that did not come up healthy", replacement)
@@ -60,7 +127,41 @@ func replace(platform *platform.Platform, opts RollingOptions, cluster *Cluster,
 		}()
 		return err
 	}
-	return nil
+	if pool != "" {
+		if err := setPoolMetadata(cluster.Kubernetes, platform, replacement.Name(), pool); err != nil {
+			platform.Errorf(err.Error())
+		}
+	}
+	// finally we drain the original node now that there is capacity for its pods
+	return Drain(cluster.Kubernetes, platform.Logger, node.Name, opts.drainOptions())
+}
+
+// desiredTemplate returns the template a node should be running and false if the node is not included in the rollout
+func desiredTemplate(platform *platform.Platform, opts RollingOptions, node v1.Node) (string, bool) {
+	if kommons.IsMasterNode(node) {
+		return platform.PlatformConfig.Master.Template, opts.Masters && len(opts.Pools) == 0
+	}
+	pool := nodePool(platform, node)
+	vm, ok := platform.PlatformConfig.Nodes[pool]
+	if !ok {
+		platform.Warnf("Skipping %s, unable to determine its node pool", node.Name)
+		return "", false
+	}
+	return vm.Template, opts.Workers && opts.includesPool(pool)
+}
+
+// replacementReason returns why a node needs to be replaced, or an empty string if it is up to date
+func replacementReason(opts RollingOptions, nodeMachine NodeMachine, newTemplate string) string {
+	if nodeMachine.Machine.GetAge() <= opts.MinAge {
+		return ""
+	}
+	if template := nodeMachine.Machine.GetTemplate(); template != newTemplate {
+		return "template"
+	}
+	if opts.KubeletVersion != "" && nodeMachine.Node.Status.NodeInfo.KubeletVersion != opts.KubeletVersion {
+		return "kubelet " + nodeMachine.Node.Status.NodeInfo.KubeletVersion
+	}
+	return ""
 }
 
 func selectMachinesToReplace(platform *platform.Platform, opts RollingOptions, cluster *Cluster) *NodeMachines {
@@ -69,35 +170,50 @@ func selectMachinesToReplace(platform *platform.Platform, opts RollingOptions, c
 	for _, nodeMachine := range cluster.Nodes {
 		machine := nodeMachine.Machine
 		node := nodeMachine.Node
-		age := machine.GetAge()
-		template := machine.GetTemplate()
-		var newTemplate string
-		if kommons.IsMasterNode(node) {
-			newTemplate = platform.PlatformConfig.Master.Template
-		} else {
-			pool := node.Labels["karina.flanksource.com/pool"]
-			newTemplate = platform.PlatformConfig.Nodes[pool].Template
-		}
-
-		// check if a node is available for update
-		if kommons.IsMasterNode(node) && !opts.Masters {
-			continue
-		} else if !kommons.IsMasterNode(node) && !opts.Workers {
-			continue
-		}
-		if age > opts.MinAge && newTemplate != template {
-			platform.Infof("Queuing for replacement %s, age=%s, template=%s ", machine.Name(), age, template)
-		} else {
+		newTemplate, ok := desiredTemplate(platform, opts, node)
+		if !ok || replacementReason(opts, nodeMachine, newTemplate) == "" {
 			continue
 		}
+		platform.Infof("Queuing for replacement %s, age=%s, template=%s, kubelet=%s", machine.Name(), machine.GetAge(), machine.GetTemplate(), node.Status.NodeInfo.KubeletVersion)
 		toReplace.Push(nodeMachine)
 	}
 	sort.Sort(toReplace)
 	return &toReplace
 }
 
-// Perform a rolling update of nodes
+// printRolloutPlan writes the nodes that would be replaced, in the order they would be replaced
+func printRolloutPlan(w io.Writer, platform *platform.Platform, opts RollingOptions, toReplace *NodeMachines) {
+	table := tabwriter.NewWriter(w, 3, 2, 3, ' ', 0)
+	fmt.Fprintf(table, "NODE\tPOOL\tAGE\tTEMPLATE\tNEW TEMPLATE\tREASON\n")
+	for _, nodeMachine := range *toReplace {
+		pool := "master"
+		if !kommons.IsMasterNode(nodeMachine.Node) {
+			pool = nodePool(platform, nodeMachine.Node)
+		}
+		newTemplate, _ := desiredTemplate(platform, opts, nodeMachine.Node)
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n", nodeMachine.Node.Name, pool, nodeMachine.Machine.GetAge().Round(time.Hour),
+			nodeMachine.Machine.GetTemplate(), newTemplate, replacementReason(opts, nodeMachine, newTemplate))
+	}
+	_ = table.Flush()
+}
+
+// Perform a rolling update of nodes, progress is saved in a ConfigMap so that an interrupted
+// rollout can be resumed or aborted. With --dry-run the nodes that would be replaced are printed instead
 func RollingUpdate(platform *platform.Platform, opts RollingOptions) error {
+	if platform.DryRun {
+		cluster, err := GetCluster(platform)
+		if err != nil {
+			return err
+		}
+		toReplace := selectMachinesToReplace(platform, opts, cluster)
+		if toReplace.Len() == 0 {
+			fmt.Println("All nodes are up to date")
+			return nil
+		}
+		printRolloutPlan(os.Stdout, platform, opts, toReplace)
+		return nil
+	}
+
 	// first we start a burnin controller in the background that checks
 	// new nodes with the burnin taint for health, removing the taint
 	// once they become healthy
@@ -107,53 +223,244 @@ func RollingUpdate(platform *platform.Platform, opts RollingOptions) error {
 		burninCancel <- false
 	}()
 
+	if _, err := opts.healthGates(platform); err != nil {
+		return err
+	}
+	if err := opts.drainOptions().Validate(); err != nil {
+		return err
+	}
+	if err := checkMaintenance(platform, "rolling update"); err != nil {
+		return err
+	}
+
 	// collect all info about cluster and vm's
 	cluster, err := GetCluster(platform)
 	if err != nil {
 		return err
 	}
 
-	total := 0
+	var rollout *Rollout
+	if opts.Resume {
+		rollout, err = ResumeRollout(cluster.Kubernetes)
+		if err != nil {
+			return err
+		}
+		// continue with the options of the original rollout
+		opts = rollout.Options
+		platform.Infof("Resuming rollout started at %s, %d nodes remaining", rollout.Started.Format(time.RFC3339), len(rollout.Pending()))
+		recoverRollout(cluster, rollout)
+	} else {
+		var nodes []RolloutNode
+		for _, nodeMachine := range *selectMachinesToReplace(platform, opts, cluster) {
+			node := RolloutNode{Name: nodeMachine.Node.Name, Master: kommons.IsMasterNode(nodeMachine.Node)}
+			if !node.Master {
+				node.Pool = nodePool(platform, nodeMachine.Node)
+			}
+			nodes = append(nodes, node)
+		}
+		rollout, err = StartRollout(cluster.Kubernetes, opts, nodes)
+		if err != nil {
+			return err
+		}
+	}
 
-	workerOpts := RollingOptions{}
+	total := 0
 	failed := false
 
-	_ = copier.Copy(&workerOpts, &opts)
-	if opts.Masters {
-		opts.Workers = false
-		// first we roll all masters sequentially
-		opts.MaxSurge = 1
-		rolled, err := roll(platform, cluster, opts)
+	// first we roll all masters sequentially
+	masters := rolloutQueue(cluster, rollout, func(node RolloutNode) bool { return node.Master })
+	if masters.Len() > 0 {
+		masterOpts := opts
+		masterOpts.MaxSurge = 1
+		rolled, err := roll(platform, cluster, rollout, masterOpts, masters)
 		total += rolled
 		if err != nil {
 			platform.Errorf(err.Error())
 			failed = true
 		}
 	}
-	if workerOpts.Workers {
-		// then we roll all the workers in batches
-		workerOpts.Masters = false
-		rolled, err := roll(platform, cluster, workerOpts)
+
+	// then we roll the workers in batches, one pool at a time
+	var pools []string
+	for _, node := range rollout.Pending() {
+		if !node.Master && !contains(pools, node.Pool) {
+			pools = append(pools, node.Pool)
+		}
+	}
+	sort.Strings(pools)
+	for _, pool := range pools {
+		if total >= opts.Max || rollout.Err() != nil {
+			break
+		}
+		pool := pool
+		queue := rolloutQueue(cluster, rollout, func(node RolloutNode) bool { return !node.Master && node.Pool == pool })
+		poolOpts := opts
+		poolOpts.MaxSurge = opts.maxSurgeFor(pool)
+		poolOpts.Max = opts.Max - total
+		platform.Infof("Rolling pool %s with max surge %d", pool, poolOpts.MaxSurge)
+		rolled, err := roll(platform, cluster, rollout, poolOpts, queue)
 		total += rolled
 		if err != nil {
-			platform.Errorf(err.Error())
+			platform.Errorf("[%s] %v", pool, err)
 			failed = true
 		}
 	}
 
+	if err := rollout.Err(); err != nil {
+		return err
+	}
+	status := RolloutFinished
+	if failed || len(rollout.Pending()) > 0 {
+		status = RolloutFailed
+	}
+	if err := rollout.Finish(status); err != nil {
+		platform.Errorf("failed to save rollout state: %v", err)
+	}
 	platform.Infof("Rollout finished, rolled %d of %d ", total, cluster.Nodes.Len())
 	if failed {
-		return fmt.Errorf("rolling update unsuccessful")
+		return fmt.Errorf("rolling update unsuccessful, use --resume to retry the remaining nodes")
 	}
 	return nil
 }
 
-func roll(platform *platform.Platform, cluster *Cluster, opts RollingOptions) (int, error) {
+// rolloutQueue returns the pending nodes of the rollout matching filter, in the order they were selected
+func rolloutQueue(cluster *Cluster, rollout *Rollout, filter func(node RolloutNode) bool) *NodeMachines {
+	machines := map[string]NodeMachine{}
+	for _, nodeMachine := range cluster.Nodes {
+		machines[nodeMachine.Node.Name] = nodeMachine
+	}
+	queue := NodeMachines{}
+	for _, node := range rollout.Pending() {
+		// skipped nodes already have a replacement and are drained again by recoverRollout
+		if !filter(node) || node.State == NodeSkipped {
+			continue
+		}
+		nodeMachine, ok := machines[node.Name]
+		if !ok {
+			cluster.Warnf("Skipping %s, node no longer exists", node.Name)
+			rollout.SetNode(node.Name, NodeFailed, "", fmt.Errorf("node no longer exists"))
+			continue
+		}
+		queue.Push(nodeMachine)
+	}
+	return &queue
+}
+
+// recoverRollout cleans up replacements that were in progress when a rollout was interrupted,
+// replacements that joined and are ready are kept, anything else is terminated and the node requeued.
+// Nodes that were skipped because they could not be drained are drained again
+func recoverRollout(cluster *Cluster, rollout *Rollout) {
+	nodes := map[string]NodeMachine{}
+	for _, nodeMachine := range cluster.Nodes {
+		nodes[nodeMachine.Node.Name] = nodeMachine
+	}
+	for _, node := range rollout.Pending() {
+		if node.State == NodeSkipped {
+			if original, ok := nodes[node.Name]; ok {
+				if err := Drain(cluster.Kubernetes, cluster.Logger, node.Name, rollout.Options.drainOptions()); err != nil {
+					cluster.Warnf("[%s] still cannot be drained: %v", node.Name, err)
+					continue
+				}
+				// nolint: errcheck
+				cluster.Terminate(original.Machine)
+			}
+			rollout.SetNode(node.Name, NodeReplaced, "", nil)
+			continue
+		}
+		if node.State != NodeInProgress {
+			continue
+		}
+		if replacement, ok := nodes[node.Replacement]; ok && isNodeReady(replacement.Node) {
+			cluster.Infof("[%s] replacement %s is ready, terminating original node", node.Name, node.Replacement)
+			if node.Pool != "" {
+				if err := setPoolMetadata(cluster.Kubernetes, cluster.Platform, node.Replacement, node.Pool); err != nil {
+					cluster.Errorf(err.Error())
+				}
+			}
+			if original, ok := nodes[node.Name]; ok {
+				if err := Drain(cluster.Kubernetes, cluster.Logger, node.Name, rollout.Options.drainOptions()); err != nil {
+					cluster.Warnf("[%s] cannot be drained: %v", node.Name, err)
+					rollout.SetNode(node.Name, NodeSkipped, node.Replacement, err)
+					continue
+				}
+				// nolint: errcheck
+				cluster.Terminate(original.Machine)
+			}
+			rollout.SetNode(node.Name, NodeReplaced, "", nil)
+			continue
+		}
+		if node.Replacement != "" {
+			if machine, _ := cluster.Platform.Cluster.GetMachine(node.Replacement); machine != nil {
+				cluster.Infof("[%s] terminating incomplete replacement %s", node.Name, node.Replacement)
+				// nolint: errcheck
+				cluster.Terminate(machine)
+			}
+		}
+		rollout.SetNode(node.Name, NodeQueued, "", nil)
+	}
+}
+
+// AbortRollout stops tracking the last rollout, terminating any surge nodes that have not yet replaced a
+// node and uncordoning the nodes that were being replaced
+func AbortRollout(platform *platform.Platform) error {
+	cluster, err := GetCluster(platform)
+	if err != nil {
+		return err
+	}
+	rollout, err := GetRollout(cluster.Kubernetes)
+	if err != nil {
+		return err
+	}
+	if rollout == nil || (rollout.Status != RolloutRunning && rollout.Status != RolloutFailed) {
+		return fmt.Errorf("no running or failed rollout to abort")
+	}
+	for _, node := range rollout.Pending() {
+		if node.State == NodeQueued {
+			continue
+		}
+		if node.Replacement != "" {
+			if machine, _ := platform.Cluster.GetMachine(node.Replacement); machine != nil {
+				platform.Infof("[%s] terminating surge node %s", node.Name, node.Replacement)
+				// nolint: errcheck
+				cluster.Terminate(machine)
+			}
+		}
+		platform.Infof("[%s] uncordoning", node.Name)
+		if err := platform.Uncordon(node.Name); err != nil {
+			platform.Warnf("[%s] failed to uncordon: %v", node.Name, err)
+		}
+	}
+	return rollout.Finish(RolloutAborted)
+}
+
+func isNodeReady(node v1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == v1.NodeReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func roll(platform *platform.Platform, cluster *Cluster, rollout *Rollout, opts RollingOptions, toReplace *NodeMachines) (int, error) {
+	gates, err := opts.healthGates(platform)
+	if err != nil {
+		return 0, err
+	}
 	rolled := 0
-	toReplace := selectMachinesToReplace(platform, opts, cluster)
 	numToReplace := len(*toReplace)
 	var replaced = make(chan NodeMachine, opts.MaxSurge)
 	var replacementError = make(chan NodeMachine, opts.MaxSurge)
+	var drainAborted = make(chan error, opts.MaxSurge)
 	batch := toReplace.PopN(opts.MaxSurge)
 	for len(*batch) > 0 {
 		platform.Infof("Replacing %s, %d remaining", *batch, toReplace.Len())
@@ -166,13 +473,22 @@ func roll(platform *platform.Platform, cluster *Cluster, opts RollingOptions) (i
 			_nodeMachine := nodeMachine
 			wg.Add(1)
 			go func() {
-				if err := replace(platform, opts, cluster, _nodeMachine); err != nil {
-					wg.Done()
+				defer wg.Done()
+				err := replace(platform, opts, cluster, rollout, _nodeMachine)
+				switch {
+				case err == nil:
+					replaced <- _nodeMachine
+				case IsDrainSkipped(err):
+					// the node is left cordoned with its replacement until the rollout is resumed
+					platform.Warnf("Skipping: %v", err)
+					rollout.SetNode(_nodeMachine.Node.Name, NodeSkipped, "", err)
+				case IsDrainAborted(err):
+					rollout.SetNode(_nodeMachine.Node.Name, NodeFailed, "", err)
+					drainAborted <- err
+				default:
 					platform.Errorf(err.Error())
+					rollout.SetNode(_nodeMachine.Node.Name, NodeFailed, "", err)
 					replacementError <- _nodeMachine
-				} else {
-					wg.Done()
-					replaced <- _nodeMachine
 				}
 			}()
 			// force each replacement to have a different timestamp
@@ -191,6 +507,7 @@ func roll(platform *platform.Platform, cluster *Cluster, opts RollingOptions) (i
 				// terminate successful replacements
 				// nolint: errcheck
 				go cluster.Terminate(nodeMachine.Machine)
+				rollout.SetNode(nodeMachine.Node.Name, NodeReplaced, "", nil)
 				rolled++
 			default:
 				platform.Debugf("Batch completed burn-in process ")
@@ -198,8 +515,17 @@ func roll(platform *platform.Platform, cluster *Cluster, opts RollingOptions) (i
 				break outer
 			}
 		}
+		if err := rollout.Err(); err != nil {
+			return rolled, err
+		}
+		select {
+		case err := <-drainAborted:
+			return rolled, err
+		default:
+		}
 
 		// finally we wait until we are the same health level as we were before
+		// and all health gates are passing
 		if succeededWithinTimeout := doUntil(opts.Timeout, func() bool {
 			currentHealth := platform.GetHealth()
 			platform.Infof(currentHealth.String())
@@ -207,9 +533,9 @@ func roll(platform *platform.Platform, cluster *Cluster, opts RollingOptions) (i
 				time.Sleep(5 * time.Second)
 				return false
 			}
-			return true
+			return checkHealthGates(platform, gates)
 		}); !succeededWithinTimeout {
-			return rolled, fmt.Errorf("health degraded after waiting %v", timer)
+			return rolled, fmt.Errorf("health degraded or health gates failing after waiting %v", timer)
 		}
 		if platform.GetHealth().IsDegradedComparedTo(health, opts.HealthTolerance) {
 			return rolled, fmt.Errorf("cluster is not healthy, aborting rollout after %d of %d ", rolled, cluster.Nodes.Len())
@@ -221,7 +547,7 @@ func roll(platform *platform.Platform, cluster *Cluster, opts RollingOptions) (i
 		batch = toReplace.PopN(opts.MaxSurge)
 	}
 
-	err := error(nil)
+	err = nil
 	if rolled < numToReplace {
 		err = fmt.Errorf("rolling update failed to replace all scheduled nodes")
 	}
@@ -239,6 +565,16 @@ func RollingRestart(platform *platform.Platform, opts RollingOptions) error {
 	if err != nil {
 		return err
 	}
+	gates, err := opts.healthGates(platform)
+	if err != nil {
+		return err
+	}
+	if err := opts.drainOptions().Validate(); err != nil {
+		return err
+	}
+	if err := checkMaintenance(platform, "rolling restart"); err != nil {
+		return err
+	}
 	var names sort.StringSlice
 	nodes := make(map[string]v1.Node)
 	for _, node := range list.Items {
@@ -252,13 +588,22 @@ func RollingRestart(platform *platform.Platform, opts RollingOptions) error {
 			platform.Infof("Skipping master %s", node.Name)
 			continue
 		}
+		if !opts.includesPool(nodePool(platform, node)) {
+			continue
+		}
 
 		health := platform.GetHealth()
 
 		platform.Infof("Health Before: %s", health)
 
 		timer := timer.NewTimer()
-		if err := platform.Drain(node.Name, opts.Timeout); err != nil {
+		if err := Drain(client, platform.Logger, node.Name, opts.drainOptions()); IsDrainSkipped(err) {
+			platform.Warnf("Skipping restart: %v", err)
+			if err := platform.Uncordon(node.Name); err != nil {
+				return fmt.Errorf("failed to uncordon %s: %v", node.Name, err)
+			}
+			continue
+		} else if err != nil {
 			if opts.Force {
 				platform.Errorf("failed to drain %s, force restarting: %v", node.Name, err)
 			} else {
@@ -300,6 +645,12 @@ func RollingRestart(platform *platform.Platform, opts RollingOptions) error {
 		}); !succeededWithinTimeout {
 			platform.Warnf("Current health not recovered after timeout %v", opts.Timeout)
 		}
+		if len(gates) > 0 && !doUntil(opts.Timeout, func() bool { return checkHealthGates(platform, gates) }) {
+			if !opts.Force {
+				return fmt.Errorf("health gates failing after restarting %s", node.Name)
+			}
+			platform.Warnf("Health gates still failing after timeout %v", opts.Timeout)
+		}
 	}
 	return nil
 }
@@ -0,0 +1,245 @@
+package provision
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	RolloutConfigMap = "karina-rollout"
+	RolloutNamespace = "kube-system"
+	rolloutKey       = "rollout.json"
+)
+
+type RolloutStatus string
+
+const (
+	RolloutRunning  RolloutStatus = "running"
+	RolloutFinished RolloutStatus = "finished"
+	RolloutFailed   RolloutStatus = "failed"
+	RolloutAborted  RolloutStatus = "aborted"
+)
+
+type NodeState string
+
+const (
+	NodeQueued     NodeState = "queued"
+	NodeInProgress NodeState = "in-progress"
+	NodeReplaced   NodeState = "replaced"
+	NodeFailed     NodeState = "failed"
+	// NodeSkipped nodes could not be drained and are left cordoned alongside their replacement
+	NodeSkipped NodeState = "skipped"
+)
+
+// RolloutNode is the state of a single node being replaced
+type RolloutNode struct {
+	Name   string    `json:"name"`
+	Pool   string    `json:"pool,omitempty"`
+	Master bool      `json:"master,omitempty"`
+	State  NodeState `json:"state"`
+	// Replacement is the name of the surge machine created to replace the node
+	Replacement string    `json:"replacement,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Updated     time.Time `json:"updated"`
+}
+
+// Rollout is the persisted state of a rolling update, only one rollout can be running at a time
+type Rollout struct {
+	Owner   string         `json:"owner"`
+	Status  RolloutStatus  `json:"status"`
+	Started time.Time      `json:"started"`
+	Updated time.Time      `json:"updated"`
+	Options RollingOptions `json:"options"`
+	Nodes   []RolloutNode  `json:"nodes"`
+
+	mu              sync.Mutex
+	client          kubernetes.Interface
+	resourceVersion string
+	// saved is true once the ConfigMap exists
+	saved bool
+	// err is the first error saving the rollout state
+	err error
+}
+
+func rolloutOwner() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s/%d", hostname, os.Getpid())
+}
+
+// GetRollout returns the last rollout, or nil if there has never been one
+func GetRollout(client kubernetes.Interface) (*Rollout, error) {
+	cm, err := client.CoreV1().ConfigMaps(RolloutNamespace).Get(context.TODO(), RolloutConfigMap, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get rollout state")
+	}
+	rollout := &Rollout{client: client, resourceVersion: cm.ResourceVersion, saved: true}
+	if err := json.Unmarshal([]byte(cm.Data[rolloutKey]), rollout); err != nil {
+		return nil, errors.Wrapf(err, "invalid rollout state in %s/%s", RolloutNamespace, RolloutConfigMap)
+	}
+	return rollout, nil
+}
+
+// StartRollout records a new running rollout, failing if another rollout has not finished or been aborted
+// so that the state of a failed rollout is not lost
+func StartRollout(client kubernetes.Interface, opts RollingOptions, nodes []RolloutNode) (*Rollout, error) {
+	existing, err := GetRollout(client)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil && (existing.Status == RolloutRunning || existing.Status == RolloutFailed) {
+		return nil, fmt.Errorf("a rollout started at %s by %s is %s, use --resume to continue it or --abort to cancel it",
+			existing.Started.Format(time.RFC3339), existing.Owner, existing.Status)
+	}
+	now := time.Now()
+	rollout := &Rollout{
+		Owner:   rolloutOwner(),
+		Status:  RolloutRunning,
+		Started: now,
+		Options: opts,
+		Nodes:   nodes,
+		client:  client,
+	}
+	for i := range rollout.Nodes {
+		rollout.Nodes[i].State = NodeQueued
+		rollout.Nodes[i].Updated = now
+	}
+	if existing != nil {
+		rollout.resourceVersion = existing.resourceVersion
+		rollout.saved = true
+	}
+	return rollout, rollout.save()
+}
+
+// ResumeRollout takes over ownership of a running or failed rollout
+func ResumeRollout(client kubernetes.Interface) (*Rollout, error) {
+	rollout, err := GetRollout(client)
+	if err != nil {
+		return nil, err
+	}
+	if rollout == nil || (rollout.Status != RolloutRunning && rollout.Status != RolloutFailed) {
+		return nil, fmt.Errorf("no running or failed rollout to resume")
+	}
+	rollout.Status = RolloutRunning
+	rollout.Owner = rolloutOwner()
+	return rollout, rollout.save()
+}
+
+// save writes the rollout to the ConfigMap, using the resource version to detect another
+// process that has taken over the rollout
+func (r *Rollout) save() error {
+	r.Updated = time.Now()
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	cm := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            RolloutConfigMap,
+			Namespace:       RolloutNamespace,
+			ResourceVersion: r.resourceVersion,
+		},
+		Data: map[string]string{rolloutKey: string(data)},
+	}
+	configMaps := r.client.CoreV1().ConfigMaps(RolloutNamespace)
+	if !r.saved {
+		cm, err = configMaps.Create(context.TODO(), cm, metav1.CreateOptions{})
+		if kerrors.IsAlreadyExists(err) {
+			return fmt.Errorf("another rollout was started concurrently")
+		}
+	} else {
+		cm, err = configMaps.Update(context.TODO(), cm, metav1.UpdateOptions{})
+		if kerrors.IsConflict(err) {
+			return fmt.Errorf("rollout state was modified by another process, it may have been resumed or aborted elsewhere")
+		}
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to save rollout state")
+	}
+	r.resourceVersion = cm.ResourceVersion
+	r.saved = true
+	return nil
+}
+
+// SetNode updates the state of a node and saves the rollout, errors saving the state are returned by Err
+func (r *Rollout) SetNode(name string, state NodeState, replacement string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.Nodes {
+		node := &r.Nodes[i]
+		if node.Name != name {
+			continue
+		}
+		node.State = state
+		node.Updated = time.Now()
+		if replacement != "" {
+			node.Replacement = replacement
+		}
+		node.Error = ""
+		if err != nil {
+			node.Error = err.Error()
+		}
+	}
+	if err := r.save(); err != nil && r.err == nil {
+		r.err = err
+	}
+}
+
+// Err returns the first error saving the rollout state, the rollout must be stopped if the
+// state can no longer be saved
+func (r *Rollout) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Finish marks the rollout as finished, failed or aborted, releasing the lock
+func (r *Rollout) Finish(status RolloutStatus) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Status = status
+	return r.save()
+}
+
+// Pending returns the nodes that have not been replaced yet
+func (r *Rollout) Pending() []RolloutNode {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var pending []RolloutNode
+	for _, node := range r.Nodes {
+		if node.State != NodeReplaced {
+			pending = append(pending, node)
+		}
+	}
+	return pending
+}
+
+func (r *Rollout) Print(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fmt.Fprintf(w, "Rollout %s, started %s by %s, last updated %s\n\n", r.Status, r.Started.Format(time.RFC3339), r.Owner, r.Updated.Format(time.RFC3339))
+	tw := tabwriter.NewWriter(w, 3, 2, 3, ' ', 0)
+	fmt.Fprintf(tw, "NODE\tPOOL\tSTATE\tREPLACEMENT\tUPDATED\tERROR\n")
+	for _, node := range r.Nodes {
+		pool := node.Pool
+		if node.Master {
+			pool = "(master)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", node.Name, pool, node.State, node.Replacement, node.Updated.Format(time.RFC3339), node.Error)
+	}
+	_ = tw.Flush()
+}
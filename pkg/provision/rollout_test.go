@@ -0,0 +1,68 @@
+package provision
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRolloutLock(t *testing.T) {
+	g := NewWithT(t)
+	client := fake.NewSimpleClientset()
+
+	rollout, err := StartRollout(client, RollingOptions{MaxSurge: 2}, []RolloutNode{{Name: "w-1", Pool: "workers"}, {Name: "m-1", Master: true}})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	_, err = StartRollout(client, RollingOptions{}, nil)
+	g.Expect(err).To(MatchError(ContainSubstring("is running, use --resume to continue it or --abort to cancel it")))
+
+	rollout.SetNode("w-1", NodeInProgress, "w-2", nil)
+	g.Expect(rollout.Err()).ToNot(HaveOccurred())
+
+	saved, err := GetRollout(client)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(saved.Status).To(Equal(RolloutRunning))
+	g.Expect(saved.Options.MaxSurge).To(Equal(2))
+	g.Expect(saved.Nodes[0].State).To(Equal(NodeInProgress))
+	g.Expect(saved.Nodes[0].Replacement).To(Equal("w-2"))
+	g.Expect(saved.Nodes[1].State).To(Equal(NodeQueued))
+
+	// a failed rollout must be resumed or aborted before another can start
+	g.Expect(rollout.Finish(RolloutFailed)).To(Succeed())
+	_, err = StartRollout(client, RollingOptions{}, nil)
+	g.Expect(err).To(MatchError(ContainSubstring("is failed, use --resume to continue it or --abort to cancel it")))
+	saved, err = GetRollout(client)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(saved.Status).To(Equal(RolloutFailed))
+	g.Expect(saved.Nodes).To(HaveLen(2))
+
+	for _, status := range []RolloutStatus{RolloutAborted, RolloutFinished} {
+		g.Expect(saved.Finish(status)).To(Succeed())
+		saved, err = StartRollout(client, RollingOptions{}, nil)
+		g.Expect(err).ToNot(HaveOccurred(), string(status))
+	}
+}
+
+func TestRolloutResume(t *testing.T) {
+	g := NewWithT(t)
+	client := fake.NewSimpleClientset()
+
+	_, err := ResumeRollout(client)
+	g.Expect(err).To(MatchError(ContainSubstring("no running or failed rollout")))
+
+	rollout, err := StartRollout(client, RollingOptions{}, []RolloutNode{{Name: "w-1"}, {Name: "w-2"}})
+	g.Expect(err).ToNot(HaveOccurred())
+	rollout.SetNode("w-1", NodeReplaced, "w-3", nil)
+	rollout.SetNode("w-2", NodeFailed, "", fmt.Errorf("did not become ready"))
+	g.Expect(rollout.Finish(RolloutFailed)).To(Succeed())
+
+	resumed, err := ResumeRollout(client)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(resumed.Status).To(Equal(RolloutRunning))
+	pending := resumed.Pending()
+	g.Expect(pending).To(HaveLen(1))
+	g.Expect(pending[0].Name).To(Equal("w-2"))
+	g.Expect(pending[0].Error).To(Equal("did not become ready"))
+}
@@ -0,0 +1,956 @@
+package provision
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/flanksource/commons/certs"
+	"github.com/flanksource/karina/pkg/ca"
+	"github.com/flanksource/karina/pkg/constants"
+	"github.com/flanksource/karina/pkg/phases/certmanager"
+	"github.com/flanksource/karina/pkg/phases/kubeadm"
+	"github.com/flanksource/karina/pkg/platform"
+	"github.com/flanksource/karina/pkg/types"
+	"github.com/pkg/errors"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	clusterCAPath = "/etc/kubernetes/pki/ca.crt"
+	oidcCAPath    = "/etc/ssl/certs/openid-ca.pem"
+	// readFile prints a file, or nothing if it does not exist
+	readFile = "cat %s 2>/dev/null || true"
+	// writeFile replaces a file with base64 encoded content
+	writeFile = "echo %s | base64 -d > %s"
+	// restartStaticPod moves a static pod manifest out of the manifests directory until the kubelet has stopped the pod
+	restartStaticPod = "mv /etc/kubernetes/manifests/%[1]s.yaml /etc/kubernetes/%[1]s.yaml && sleep 20 && mv /etc/kubernetes/%[1]s.yaml /etc/kubernetes/manifests/%[1]s.yaml"
+	// restartedAtAnnotation is added to the pod templates of workloads restarted to load re-issued certificates
+	restartedAtAnnotation = "karina.flanksource.com/restartedAt"
+	// crossSignExpiry is the validity of the kubernetes-ca when it is cross signed by a new ca
+	crossSignExpiry = 10 * 364 * 24 * time.Hour
+)
+
+// caCertKey is the key of the CA bundle in secrets
+const caCertKey = "ca.crt"
+
+// clusterCAComponents are restarted when the cluster CA bundle changes, the controller manager publishes its
+// --root-ca-file in service account token secrets and the kube-root-ca.crt ConfigMap of each namespace
+var clusterCAComponents = []string{"kube-apiserver", "kube-controller-manager", "kube-scheduler"}
+
+var clusterIssuers = schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "clusterissuers"}
+
+type RotateOptions struct {
+	CA CAKind
+	// New is the CA to rotate to, generated with karina ca generate
+	New *types.CA
+	// Until stops the rotation after the given stage, so that the new trust bundle can be distributed before certificates are re-issued
+	Until RotationStage
+	// Bundle is a file that the trusted CA bundle is written to after each stage, for use as trustedCA
+	Bundle string
+	// Timeout for each restart and re-issued certificate
+	Timeout  time.Duration
+	Resume   bool
+	Rollback bool
+}
+
+func (opts RotateOptions) Validate() error {
+	if opts.CA != ClusterCA && opts.CA != IngressCA {
+		return fmt.Errorf("one of --ca or --ingress-ca is required")
+	}
+	if opts.Until != "" && opts.Until != StageTrust && opts.Until != StageReissue && opts.Until != StageFinalize {
+		return fmt.Errorf("invalid stage %s, expected one of %v", opts.Until, RotationStages)
+	}
+	if opts.Resume && opts.Rollback {
+		return fmt.Errorf("--resume and --rollback cannot be used together")
+	}
+	return nil
+}
+
+// rotator holds the CAs of a rotation
+type rotator struct {
+	*platform.Platform
+	opts     RotateOptions
+	rotation *Rotation
+	old      *x509.Certificate
+	new      *x509.Certificate
+	newCA    certs.CertificateAuthority
+}
+
+// RotateCA rotates the cluster CA or ingress CA in stages: first every bundle is updated to trust both CAs,
+// then the certificates signed by the old CA are re-issued and finally the old CA is removed. Progress is
+// saved in a ConfigMap so that a failed rotation can be resumed or rolled back
+func RotateCA(p *platform.Platform, opts RotateOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	if err := checkMaintenance(p, "CA rotation"); err != nil {
+		return err
+	}
+	client, err := p.GetClientset()
+	if err != nil {
+		return err
+	}
+	if opts.Rollback {
+		return rollbackRotation(p, opts)
+	}
+	if opts.New == nil || opts.New.Cert == "" {
+		return fmt.Errorf("the new CA is required")
+	}
+	newCA, err := readNewCA(opts.CA, opts.New)
+	if err != nil {
+		return errors.Wrap(err, "failed to read the new CA")
+	}
+	r := &rotator{Platform: p, opts: opts, new: ca.Certificate(newCA), newCA: newCA}
+	if opts.Resume {
+		if r.rotation, err = ResumeRotation(client); err != nil {
+			return err
+		}
+		if r.rotation.CA != opts.CA {
+			return fmt.Errorf("the rotation in progress is of the %s", r.rotation.CA)
+		}
+		if err := r.load(); err != nil {
+			return err
+		}
+		if !r.new.Equal(ca.Certificate(newCA)) {
+			return fmt.Errorf("the new CA %s is not the CA the rotation was started with", ca.Certificate(newCA).Subject)
+		}
+		p.Infof("Resuming rotation of the %s, completed stages: %v", r.rotation.CA, r.rotation.Completed)
+	} else {
+		if r.old, err = currentCA(p, opts.CA); err != nil {
+			return err
+		}
+		if r.old.Equal(r.new) {
+			return fmt.Errorf("the new CA is the same as the current %s", opts.CA)
+		}
+		if r.rotation, err = StartRotation(client, opts.CA, ca.EncodeCert(r.old), ca.EncodeCert(r.new)); err != nil {
+			return err
+		}
+	}
+
+	// once the new cluster CA is trusted karina connects with a kubeconfig signed by it, so that it can
+	// still connect when the old CA is removed
+	if opts.CA == ClusterCA && r.rotation.Done(StageTrust) {
+		p.SetCA(newCA)
+	}
+	for _, stage := range RotationStages {
+		if !r.rotation.Done(stage) {
+			p.Infof("Rotating the %s: %s", opts.CA, stage)
+			if err := r.run(stage); err != nil {
+				if saveErr := r.rotation.Finish(RotationFailed, err); saveErr != nil {
+					p.Errorf("failed to save rotation state: %v", saveErr)
+				}
+				return fmt.Errorf("%s stage failed, use --resume to retry or --rollback to revert: %v", stage, err)
+			}
+			if err := r.rotation.Complete(stage); err != nil {
+				return err
+			}
+		}
+		if stage == opts.Until {
+			p.Infof("Stopping after the %s stage, use --resume to continue", stage)
+			return nil
+		}
+	}
+	if err := r.rotation.Finish(RotationFinished, nil); err != nil {
+		return err
+	}
+	p.Infof("Rotation of the %s finished, update %s in the karina config to the new CA", opts.CA, opts.CA)
+	return nil
+}
+
+// readNewCA opens the CA being rotated to, the cluster CA can sign with a key in an HSM or Vault
+// while cert-manager needs the key of the ingress CA
+func readNewCA(kind CAKind, config *types.CA) (certs.CertificateAuthority, error) {
+	if kind == ClusterCA {
+		return ca.ReadAuthority(config)
+	}
+	return ca.ReadCA(config)
+}
+
+// currentCA returns the certificate of the configured CA
+func currentCA(p *platform.Platform, kind CAKind) (*x509.Certificate, error) {
+	if kind == ClusterCA {
+		if p.CA == nil {
+			return nil, fmt.Errorf("ca is not configured")
+		}
+		return ca.Certificate(p.GetCA()), nil
+	}
+	if p.IngressCA == nil {
+		return nil, fmt.Errorf("ingressCA is not configured")
+	}
+	return ca.Certificate(p.GetIngressCA()), nil
+}
+
+// load parses the CAs recorded in the rotation
+func (r *rotator) load() error {
+	old, err := ca.ParseCertificates([]byte(r.rotation.OldCA))
+	if err != nil {
+		return errors.Wrap(err, "invalid old CA in rotation state")
+	}
+	new, err := ca.ParseCertificates([]byte(r.rotation.NewCA))
+	if err != nil {
+		return errors.Wrap(err, "invalid new CA in rotation state")
+	}
+	r.old, r.new = old[0], new[0]
+	return nil
+}
+
+func (r *rotator) run(stage RotationStage) error {
+	switch {
+	case stage == StageTrust && r.opts.CA == ClusterCA:
+		if err := r.updateMasters(clusterCAPath, r.trust, clusterCAComponents...); err != nil {
+			return err
+		}
+		r.SetCA(r.newCA)
+		client, err := r.GetClientset()
+		if err != nil {
+			return err
+		}
+		if _, err := client.Discovery().ServerVersion(); err != nil {
+			return errors.Wrap(err, "failed to connect with a kubeconfig signed by the new CA")
+		}
+		return r.updateBundles(r.trust, r.old, r.new)
+	case stage == StageTrust:
+		if err := r.updateMasters(oidcCAPath, r.trust, "kube-apiserver"); err != nil {
+			return err
+		}
+		return r.updateBundles(r.trust, r.old, r.new)
+	case stage == StageReissue && r.opts.CA == ClusterCA:
+		return r.crossSignMasters()
+	case stage == StageReissue:
+		return r.reissue()
+	case stage == StageFinalize && r.opts.CA == ClusterCA:
+		if err := r.updateMasters(clusterCAPath, r.untrust, clusterCAComponents...); err != nil {
+			return err
+		}
+		return r.updateBundles(r.untrust, r.new)
+	default:
+		if err := r.updateMasters(oidcCAPath, r.untrust, "kube-apiserver"); err != nil {
+			return err
+		}
+		return r.updateBundles(r.untrust, r.new)
+	}
+}
+
+// trust adds the new CA to bundles that trust the old CA
+func (r *rotator) trust(bundle []byte) ([]byte, error) {
+	if !ca.Contains(bundle, r.old) {
+		return bundle, nil
+	}
+	return ca.AppendCert(bundle, r.new), nil
+}
+
+// untrust removes the old CA from bundles that also trust the new CA
+func (r *rotator) untrust(bundle []byte) ([]byte, error) {
+	if !ca.Contains(bundle, r.new) {
+		return bundle, nil
+	}
+	return ca.RemoveCert(bundle, r.old), nil
+}
+
+// crossSign replaces the kubernetes-ca at the start of the bundle with a copy signed by the new CA,
+// certificates issued by the kubernetes-ca remain valid as its key does not change
+func (r *rotator) crossSign(bundle []byte) ([]byte, error) {
+	chain, err := ca.ParseCertificates(bundle)
+	if err != nil {
+		return nil, err
+	}
+	if ca.ChainsTo(chain[:1], r.new) || !ca.ChainsTo(chain[:1], r.old) {
+		return bundle, nil
+	}
+	signed, err := ca.CrossSign(r.newCA, chain[0], crossSignExpiry)
+	if err != nil {
+		return nil, err
+	}
+	return ca.ReplaceCert(bundle, signed)
+}
+
+func (r *rotator) masters() ([]string, error) {
+	client, err := r.GetClientset()
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := client.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{LabelSelector: constants.MasterNodeLabel})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list masters")
+	}
+	var names []string
+	for _, node := range nodes.Items {
+		names = append(names, node.Name)
+	}
+	return names, nil
+}
+
+// updateMasters updates a bundle on each master in turn, restarting the components that read it
+func (r *rotator) updateMasters(path string, update func([]byte) ([]byte, error), components ...string) error {
+	return r.eachMaster(func(node string) (bool, error) {
+		return r.updateMasterFile(node, path, update)
+	}, components...)
+}
+
+// crossSignMasters replaces the kubernetes-ca in ca.crt and the signing certificate of the controller manager
+// with a copy cross signed by the new CA
+func (r *rotator) crossSignMasters() error {
+	return r.eachMaster(func(node string) (bool, error) {
+		changed, err := r.updateMasterFile(node, clusterCAPath, r.crossSign)
+		if err != nil {
+			return false, err
+		}
+		bundle, err := r.readMasterFile(node, clusterCAPath)
+		if err != nil {
+			return false, err
+		}
+		chain, err := ca.ParseCertificates(bundle)
+		if err != nil {
+			return false, errors.Wrapf(err, "[%s] invalid %s", node, clusterCAPath)
+		}
+		signing, err := r.updateMasterFile(node, kubeadm.CSRCAPath, func(data []byte) ([]byte, error) {
+			return ca.ReplaceCert(data, chain[0])
+		})
+		return changed || signing, err
+	}, clusterCAComponents...)
+}
+
+// eachMaster runs update on each master in turn, restarting components when it made any changes
+func (r *rotator) eachMaster(update func(node string) (bool, error), components ...string) error {
+	masters, err := r.masters()
+	if err != nil {
+		return err
+	}
+	for _, node := range masters {
+		changed, err := update(node)
+		if err != nil {
+			return errors.Wrapf(err, "[%s] failed to update", node)
+		}
+		if !changed {
+			continue
+		}
+		for _, component := range components {
+			if err := r.restartStaticPod(node, component); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *rotator) readMasterFile(node, path string) ([]byte, error) {
+	out, err := r.Executef(node, r.opts.Timeout, readFile, path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[%s] failed to read %s", node, path)
+	}
+	return []byte(out), nil
+}
+
+func (r *rotator) writeMasterFile(node, path string, data []byte) error {
+	if _, err := r.Executef(node, r.opts.Timeout, writeFile, base64.StdEncoding.EncodeToString(data), path); err != nil {
+		return errors.Wrapf(err, "[%s] failed to write %s", node, path)
+	}
+	return nil
+}
+
+// updateMasterFile applies update to a file on a master, files that do not exist are skipped
+func (r *rotator) updateMasterFile(node, path string, update func([]byte) ([]byte, error)) (bool, error) {
+	data, err := r.readMasterFile(node, path)
+	if err != nil || len(bytes.TrimSpace(data)) == 0 {
+		return false, err
+	}
+	updated, err := update(data)
+	if err != nil || bytes.Equal(updated, data) {
+		return false, err
+	}
+	if err := r.rotation.AddOriginals(map[string][]byte{masterLocation(node, path): data}); err != nil {
+		return false, err
+	}
+	r.Infof("[%s] updating %s", node, path)
+	return true, r.writeMasterFile(node, path, updated)
+}
+
+func masterLocation(node, path string) string {
+	return "master/" + node + ":" + path
+}
+
+// restartStaticPod restarts a control plane component and waits for it to become ready
+func (r *rotator) restartStaticPod(node, component string) error {
+	r.Infof("[%s] restarting %s", node, component)
+	restarted := time.Now()
+	if _, err := r.Executef(node, r.opts.Timeout, restartStaticPod, component); err != nil {
+		return errors.Wrapf(err, "[%s] failed to restart %s", node, component)
+	}
+	name := fmt.Sprintf("%s-%s", component, node)
+	if !doUntil(r.opts.Timeout, func() bool {
+		client, err := r.GetClientset()
+		if err != nil {
+			return false
+		}
+		pod, err := client.CoreV1().Pods("kube-system").Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil || pod.CreationTimestamp.Time.Before(restarted) {
+			return false
+		}
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == v1.PodReady {
+				return condition.Status == v1.ConditionTrue
+			}
+		}
+		return false
+	}) {
+		return fmt.Errorf("[%s] %s did not become ready after %v", node, component, r.opts.Timeout)
+	}
+	return nil
+}
+
+// updateBundles updates the webhook caBundles, ca.crt keys of secrets and the vault issuer caBundle that trust
+// the old CA, and writes the trusted bundle
+func (r *rotator) updateBundles(update func([]byte) ([]byte, error), trusted ...*x509.Certificate) error {
+	client, err := r.GetClientset()
+	if err != nil {
+		return err
+	}
+	if err := updateWebhookBundles(client, r.rotation, update); err != nil {
+		return err
+	}
+	if err := updateSecretBundles(client, r.rotation, update); err != nil {
+		return err
+	}
+	if r.opts.CA == IngressCA {
+		if err := r.updateVaultIssuer(update); err != nil {
+			return err
+		}
+	}
+	if r.opts.Bundle != "" {
+		var bundle []byte
+		for _, cert := range trusted {
+			bundle = append(bundle, ca.EncodeCert(cert)...)
+		}
+		r.Infof("Writing the trusted CA bundle to %s, use it as trustedCA", r.opts.Bundle)
+		return ioutil.WriteFile(r.opts.Bundle, bundle, 0644)
+	}
+	return nil
+}
+
+func webhookLocation(kind, config, webhook string) string {
+	return fmt.Sprintf("webhook/%s/%s/%s", kind, config, webhook)
+}
+
+// updateWebhookBundles applies update to the caBundle of every validating and mutating webhook, the original bundles
+// are recorded together before any webhook is changed
+func updateWebhookBundles(client kubernetes.Interface, rotation *Rotation, update func([]byte) ([]byte, error)) error {
+	validating, err := client.AdmissionregistrationV1().ValidatingWebhookConfigurations().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "failed to list validating webhooks")
+	}
+	mutating, err := client.AdmissionregistrationV1().MutatingWebhookConfigurations().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "failed to list mutating webhooks")
+	}
+	originals := map[string][]byte{}
+	var changedValidating []admissionregistrationv1.ValidatingWebhookConfiguration
+	for _, config := range validating.Items {
+		changed := false
+		for i, webhook := range config.Webhooks {
+			updated, err := update(webhook.ClientConfig.CABundle)
+			if err != nil {
+				return err
+			}
+			if bytes.Equal(updated, webhook.ClientConfig.CABundle) {
+				continue
+			}
+			originals[webhookLocation("validating", config.Name, webhook.Name)] = webhook.ClientConfig.CABundle
+			config.Webhooks[i].ClientConfig.CABundle = updated
+			changed = true
+		}
+		if changed {
+			changedValidating = append(changedValidating, config)
+		}
+	}
+	var changedMutating []admissionregistrationv1.MutatingWebhookConfiguration
+	for _, config := range mutating.Items {
+		changed := false
+		for i, webhook := range config.Webhooks {
+			updated, err := update(webhook.ClientConfig.CABundle)
+			if err != nil {
+				return err
+			}
+			if bytes.Equal(updated, webhook.ClientConfig.CABundle) {
+				continue
+			}
+			originals[webhookLocation("mutating", config.Name, webhook.Name)] = webhook.ClientConfig.CABundle
+			config.Webhooks[i].ClientConfig.CABundle = updated
+			changed = true
+		}
+		if changed {
+			changedMutating = append(changedMutating, config)
+		}
+	}
+	if err := rotation.AddOriginals(originals); err != nil {
+		return err
+	}
+	for _, config := range changedValidating {
+		config := config
+		if _, err := client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Update(context.TODO(), &config, metav1.UpdateOptions{}); err != nil {
+			return errors.Wrapf(err, "failed to update webhook %s", config.Name)
+		}
+	}
+	for _, config := range changedMutating {
+		config := config
+		if _, err := client.AdmissionregistrationV1().MutatingWebhookConfigurations().Update(context.TODO(), &config, metav1.UpdateOptions{}); err != nil {
+			return errors.Wrapf(err, "failed to update webhook %s", config.Name)
+		}
+	}
+	return nil
+}
+
+func secretLocation(namespace, name string) string {
+	return fmt.Sprintf("secret/%s/%s", namespace, name)
+}
+
+// updateSecretBundles applies update to the ca.crt of secrets, secrets issued by cert-manager are skipped as they
+// are re-issued instead. The original bundles are recorded together before any secret is changed
+func updateSecretBundles(client kubernetes.Interface, rotation *Rotation, update func([]byte) ([]byte, error)) error {
+	secrets, err := client.CoreV1().Secrets(v1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "failed to list secrets")
+	}
+	originals := map[string][]byte{}
+	var changed []v1.Secret
+	for _, secret := range secrets.Items {
+		bundle, ok := secret.Data[caCertKey]
+		if !ok || secret.Annotations[ca.CertificateAnnotation] != "" {
+			continue
+		}
+		// the token controller owns service account tokens and reverts any change, it publishes the --root-ca-file
+		// of the controller manager once it is restarted with the new bundle
+		if secret.Type == v1.SecretTypeServiceAccountToken {
+			continue
+		}
+		updated, err := update(bundle)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(updated, bundle) {
+			continue
+		}
+		originals[secretLocation(secret.Namespace, secret.Name)] = bundle
+		secret.Data[caCertKey] = updated
+		changed = append(changed, secret)
+	}
+	if err := rotation.AddOriginals(originals); err != nil {
+		return err
+	}
+	for _, secret := range changed {
+		secret := secret
+		if _, err := client.CoreV1().Secrets(secret.Namespace).Update(context.TODO(), &secret, metav1.UpdateOptions{}); err != nil {
+			return errors.Wrapf(err, "failed to update secret %s/%s", secret.Namespace, secret.Name)
+		}
+	}
+	return nil
+}
+
+// getIssuer returns the ingress-ca ClusterIssuer, or nil if cert-manager is not installed
+func (r *rotator) getIssuer() (*unstructured.Unstructured, error) {
+	dynamicClient, err := r.GetDynamicClient()
+	if err != nil {
+		return nil, err
+	}
+	issuer, err := dynamicClient.Resource(clusterIssuers).Get(context.TODO(), certmanager.IngressCA, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get ClusterIssuer %s", certmanager.IngressCA)
+	}
+	return issuer, nil
+}
+
+// updateVaultIssuer applies update to the caBundle of the ingress-ca ClusterIssuer when it issues from vault
+func (r *rotator) updateVaultIssuer(update func([]byte) ([]byte, error)) error {
+	issuer, err := r.getIssuer()
+	if err != nil || issuer == nil {
+		return err
+	}
+	encoded, found, _ := unstructured.NestedString(issuer.Object, "spec", "vault", "caBundle")
+	if !found {
+		return nil
+	}
+	bundle, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return errors.Wrapf(err, "invalid caBundle in ClusterIssuer %s", certmanager.IngressCA)
+	}
+	updated, err := update(bundle)
+	if err != nil || bytes.Equal(updated, bundle) {
+		return err
+	}
+	if err := r.rotation.AddOriginals(map[string][]byte{"issuer/" + certmanager.IngressCA: bundle}); err != nil {
+		return err
+	}
+	return r.setVaultBundle(issuer, updated)
+}
+
+func (r *rotator) setVaultBundle(issuer *unstructured.Unstructured, bundle []byte) error {
+	if err := unstructured.SetNestedField(issuer.Object, base64.StdEncoding.EncodeToString(bundle), "spec", "vault", "caBundle"); err != nil {
+		return err
+	}
+	dynamicClient, err := r.GetDynamicClient()
+	if err != nil {
+		return err
+	}
+	if _, err := dynamicClient.Resource(clusterIssuers).Update(context.TODO(), issuer, metav1.UpdateOptions{}); err != nil {
+		return errors.Wrapf(err, "failed to update ClusterIssuer %s", certmanager.IngressCA)
+	}
+	return nil
+}
+
+// reissue switches the ingress-ca ClusterIssuer to the new CA and re-issues every certificate it issued
+func (r *rotator) reissue() error {
+	issuer, err := r.getIssuer()
+	if err != nil {
+		return err
+	}
+	if issuer == nil {
+		r.Warnf("ClusterIssuer %s not found, no certificates to re-issue", certmanager.IngressCA)
+		return nil
+	}
+	if _, ok, _ := unstructured.NestedMap(issuer.Object, "spec", "ca"); !ok {
+		r.Warnf("ClusterIssuer %s does not issue from the ingressCA, certificates are not re-issued", certmanager.IngressCA)
+		return nil
+	}
+	r.Infof("Switching ClusterIssuer %s to the new CA", certmanager.IngressCA)
+	issuerCA, ok := r.newCA.(*certs.Certificate)
+	if !ok {
+		return fmt.Errorf("cert-manager needs the private key of the new ingressCA")
+	}
+	if err := r.CreateOrUpdateSecret(certmanager.IngressCA, certmanager.Namespace, issuerCA.AsTLSSecret()); err != nil {
+		return err
+	}
+	if err := r.reissueCertificates(r.new, func(certificate string) error { return r.rotation.AddReissued(certificate) }); err != nil {
+		return err
+	}
+	r.warnUnissued()
+	return nil
+}
+
+// reissueCertificates deletes the secrets of the certificates issued by the ingress-ca ClusterIssuer that do not chain
+// to ca, waits for cert-manager to issue them again and restarts the workloads that mount them
+func (r *rotator) reissueCertificates(root *x509.Certificate, record func(certificate string) error) error {
+	client, err := r.GetClientset()
+	if err != nil {
+		return err
+	}
+	dynamicClient, err := r.GetDynamicClient()
+	if err != nil {
+		return err
+	}
+	list, err := dynamicClient.Resource(ca.Certificates).Namespace(v1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "failed to list certificates")
+	}
+	reissued := map[string]map[string]bool{}
+	for _, item := range list.Items {
+		issuer, _, _ := unstructured.NestedString(item.Object, "spec", "issuerRef", "name")
+		kind, _, _ := unstructured.NestedString(item.Object, "spec", "issuerRef", "kind")
+		secretName, _, _ := unstructured.NestedString(item.Object, "spec", "secretName")
+		if issuer != certmanager.IngressCA || kind != "ClusterIssuer" || secretName == "" {
+			continue
+		}
+		secrets := client.CoreV1().Secrets(item.GetNamespace())
+		secret, err := secrets.Get(context.TODO(), secretName, metav1.GetOptions{})
+		if err != nil && !kerrors.IsNotFound(err) {
+			return err
+		}
+		if err == nil && signedBy(secret, root) {
+			continue
+		}
+		if err := record(item.GetNamespace() + "/" + item.GetName()); err != nil {
+			return err
+		}
+		r.Infof("Re-issuing certificate %s/%s", item.GetNamespace(), item.GetName())
+		if err := secrets.Delete(context.TODO(), secretName, metav1.DeleteOptions{}); err != nil && !kerrors.IsNotFound(err) {
+			return errors.Wrapf(err, "failed to delete secret %s/%s", item.GetNamespace(), secretName)
+		}
+		if reissued[item.GetNamespace()] == nil {
+			reissued[item.GetNamespace()] = map[string]bool{}
+		}
+		reissued[item.GetNamespace()][secretName] = true
+	}
+	for namespace, names := range reissued {
+		for name := range names {
+			if !doUntil(r.opts.Timeout, func() bool {
+				secret, err := client.CoreV1().Secrets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+				return err == nil && signedBy(secret, root)
+			}) {
+				return fmt.Errorf("secret %s/%s was not re-issued after %v", namespace, name, r.opts.Timeout)
+			}
+		}
+		if err := restartWorkloads(client, namespace, names); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// warnUnissued warns about secrets signed by the old CA that karina created directly, they are re-created
+// when the platform is deployed with the new ingressCA configured
+func (r *rotator) warnUnissued() {
+	client, err := r.GetClientset()
+	if err != nil {
+		return
+	}
+	secrets, err := client.CoreV1().Secrets(v1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return
+	}
+	for _, secret := range secrets.Items {
+		secret := secret
+		if secret.Annotations[ca.CertificateAnnotation] == "" && signedBy(&secret, r.old) && !signedBy(&secret, r.new) {
+			r.Warnf("Secret %s/%s is signed by the old ingressCA, run karina deploy with the new ingressCA configured to re-create it", secret.Namespace, secret.Name)
+		}
+	}
+}
+
+// signedBy returns true if the tls.crt of a secret chains to root
+func signedBy(secret *v1.Secret, root *x509.Certificate) bool {
+	chain, err := ca.ParseCertificates(secret.Data[v1.TLSCertKey])
+	return err == nil && ca.ChainsTo(chain, root)
+}
+
+// restartWorkloads restarts the deployments, statefulsets and daemonsets in namespace that mount any of secrets
+func restartWorkloads(client kubernetes.Interface, namespace string, secrets map[string]bool) error {
+	restartedAt := time.Now().Format(time.RFC3339)
+	restart := func(template *v1.PodTemplateSpec) bool {
+		if !mountsSecret(template.Spec, secrets) {
+			return false
+		}
+		if template.Annotations == nil {
+			template.Annotations = map[string]string{}
+		}
+		template.Annotations[restartedAtAnnotation] = restartedAt
+		return true
+	}
+	deployments, err := client.AppsV1().Deployments(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, deployment := range deployments.Items {
+		deployment := deployment
+		if restart(&deployment.Spec.Template) {
+			if _, err := client.AppsV1().Deployments(namespace).Update(context.TODO(), &deployment, metav1.UpdateOptions{}); err != nil {
+				return errors.Wrapf(err, "failed to restart deployment %s/%s", namespace, deployment.Name)
+			}
+		}
+	}
+	statefulSets, err := client.AppsV1().StatefulSets(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, statefulSet := range statefulSets.Items {
+		statefulSet := statefulSet
+		if restart(&statefulSet.Spec.Template) {
+			if _, err := client.AppsV1().StatefulSets(namespace).Update(context.TODO(), &statefulSet, metav1.UpdateOptions{}); err != nil {
+				return errors.Wrapf(err, "failed to restart statefulset %s/%s", namespace, statefulSet.Name)
+			}
+		}
+	}
+	daemonSets, err := client.AppsV1().DaemonSets(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, daemonSet := range daemonSets.Items {
+		daemonSet := daemonSet
+		if restart(&daemonSet.Spec.Template) {
+			if _, err := client.AppsV1().DaemonSets(namespace).Update(context.TODO(), &daemonSet, metav1.UpdateOptions{}); err != nil {
+				return errors.Wrapf(err, "failed to restart daemonset %s/%s", namespace, daemonSet.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func mountsSecret(spec v1.PodSpec, secrets map[string]bool) bool {
+	for _, volume := range spec.Volumes {
+		if volume.Secret != nil && secrets[volume.Secret.SecretName] {
+			return true
+		}
+		if volume.Projected == nil {
+			continue
+		}
+		for _, source := range volume.Projected.Sources {
+			if source.Secret != nil && secrets[source.Secret.Name] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// rollbackRotation restores every bundle changed by the rotation and re-issues certificates from the old CA
+func rollbackRotation(p *platform.Platform, opts RotateOptions) error {
+	client, err := p.GetClientset()
+	if err != nil {
+		return err
+	}
+	rotation, err := GetRotation(client)
+	if err != nil {
+		return err
+	}
+	if rotation == nil || rotation.Status == RotationRolledBack {
+		return fmt.Errorf("no rotation to roll back")
+	}
+	if rotation.CA != opts.CA {
+		return fmt.Errorf("the last rotation is of the %s", rotation.CA)
+	}
+	rotation.Owner = rolloutOwner()
+	r := &rotator{Platform: p, opts: opts, rotation: rotation}
+	if err := r.load(); err != nil {
+		return err
+	}
+
+	if rotation.CA == ClusterCA && rotation.Done(StageTrust) {
+		// connect with the new CA to trust the old CA again on every master, before switching back to the old CA
+		if opts.New == nil || opts.New.Cert == "" {
+			return fmt.Errorf("the new CA is required to roll back once it is trusted")
+		}
+		if r.newCA, err = readNewCA(ClusterCA, opts.New); err != nil {
+			return errors.Wrap(err, "failed to read the new CA")
+		}
+		p.SetCA(r.newCA)
+		if err := r.updateMasters(clusterCAPath, func(bundle []byte) ([]byte, error) {
+			return ca.AppendCert(bundle, r.old), nil
+		}, clusterCAComponents...); err != nil {
+			return err
+		}
+		// the configured CA is read again on the next connection
+		p.SetCA(nil)
+	}
+
+	if rotation.CA == IngressCA && len(rotation.Reissued) > 0 {
+		p.Infof("Switching ClusterIssuer %s back to the old CA", certmanager.IngressCA)
+		old, ok := p.GetIngressCA().(*certs.Certificate)
+		if !ok {
+			return fmt.Errorf("the old ingressCA must be configured to roll back")
+		}
+		if err := p.CreateOrUpdateSecret(certmanager.IngressCA, certmanager.Namespace, old.AsTLSSecret()); err != nil {
+			return err
+		}
+		if err := r.reissueCertificates(r.old, func(string) error { return nil }); err != nil {
+			return err
+		}
+	}
+
+	if err := r.restoreOriginals(); err != nil {
+		if saveErr := rotation.Finish(RotationFailed, err); saveErr != nil {
+			p.Errorf("failed to save rotation state: %v", saveErr)
+		}
+		return err
+	}
+	if opts.Bundle != "" {
+		if err := ioutil.WriteFile(opts.Bundle, ca.EncodeCert(r.old), 0644); err != nil {
+			return err
+		}
+	}
+	p.Infof("Rolled back rotation of the %s", rotation.CA)
+	return rotation.Finish(RotationRolledBack, nil)
+}
+
+// restoreOriginals writes back the original content of every bundle changed by the rotation
+func (r *rotator) restoreOriginals() error {
+	client, err := r.GetClientset()
+	if err != nil {
+		return err
+	}
+	restarts := map[string]bool{}
+	for location := range r.rotation.Originals {
+		data, ok := r.rotation.Original(location)
+		if !ok {
+			return fmt.Errorf("the original content of %s is missing from the rotation state", location)
+		}
+		parts := strings.Split(location, "/")
+		switch parts[0] {
+		case "master":
+			target := strings.SplitN(strings.TrimPrefix(location, "master/"), ":", 2)
+			if err := r.writeMasterFile(target[0], target[1], data); err != nil {
+				return err
+			}
+			restarts[target[0]] = true
+		case "webhook":
+			if err := restoreWebhook(client, parts[1], parts[2], parts[3], data); err != nil {
+				return err
+			}
+		case "secret":
+			secret, err := client.CoreV1().Secrets(parts[1]).Get(context.TODO(), parts[2], metav1.GetOptions{})
+			if kerrors.IsNotFound(err) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			secret.Data[caCertKey] = data
+			if _, err := client.CoreV1().Secrets(parts[1]).Update(context.TODO(), secret, metav1.UpdateOptions{}); err != nil {
+				return errors.Wrapf(err, "failed to restore secret %s/%s", parts[1], parts[2])
+			}
+		case "issuer":
+			issuer, err := r.getIssuer()
+			if err != nil {
+				return err
+			}
+			if issuer != nil {
+				if err := r.setVaultBundle(issuer, data); err != nil {
+					return err
+				}
+			}
+		}
+		r.Infof("Restored %s", location)
+	}
+	for node := range restarts {
+		components := []string{"kube-apiserver"}
+		if r.rotation.CA == ClusterCA {
+			components = clusterCAComponents
+		}
+		for _, component := range components {
+			if err := r.restartStaticPod(node, component); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func restoreWebhook(client kubernetes.Interface, kind, config, name string, bundle []byte) error {
+	if kind == "validating" {
+		webhooks, err := client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.TODO(), config, metav1.GetOptions{})
+		if kerrors.IsNotFound(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		for i := range webhooks.Webhooks {
+			if webhooks.Webhooks[i].Name == name {
+				webhooks.Webhooks[i].ClientConfig.CABundle = bundle
+			}
+		}
+		_, err = client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Update(context.TODO(), webhooks, metav1.UpdateOptions{})
+		return err
+	}
+	webhooks, err := client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.TODO(), config, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for i := range webhooks.Webhooks {
+		if webhooks.Webhooks[i].Name == name {
+			webhooks.Webhooks[i].ClientConfig.CABundle = bundle
+		}
+	}
+	_, err = client.AdmissionregistrationV1().MutatingWebhookConfigurations().Update(context.TODO(), webhooks, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,278 @@
+package provision
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	RotationConfigMap = "karina-ca-rotation"
+	rotationKey       = "rotation.json"
+)
+
+// CAKind is the CA being rotated
+type CAKind string
+
+const (
+	// ClusterCA is the ca that signs the kubernetes-ca of the control plane and admin kubeconfigs
+	ClusterCA CAKind = "ca"
+	// IngressCA is the ingressCA that cert-manager issues certificates from
+	IngressCA CAKind = "ingressCA"
+)
+
+type RotationStage string
+
+const (
+	// StageTrust distributes bundles that trust both the old and new CA
+	StageTrust RotationStage = "trust"
+	// StageReissue re-issues the certificates signed by the old CA and restarts the components using them
+	StageReissue RotationStage = "reissue"
+	// StageFinalize removes the old CA from every bundle
+	StageFinalize RotationStage = "finalize"
+)
+
+// RotationStages are the stages of a rotation, in order
+var RotationStages = []RotationStage{StageTrust, StageReissue, StageFinalize}
+
+type RotationStatus string
+
+const (
+	RotationRunning    RotationStatus = "running"
+	RotationFinished   RotationStatus = "finished"
+	RotationFailed     RotationStatus = "failed"
+	RotationRolledBack RotationStatus = "rolled-back"
+)
+
+// Rotation is the persisted state of a CA rotation, only one rotation can be in progress at a time
+type Rotation struct {
+	CA      CAKind         `json:"ca"`
+	Owner   string         `json:"owner"`
+	Status  RotationStatus `json:"status"`
+	Started time.Time      `json:"started"`
+	Updated time.Time      `json:"updated"`
+	// OldCA and NewCA are the PEM encoded certificates of the CA being replaced and its replacement
+	OldCA string `json:"oldCA"`
+	NewCA string `json:"newCA"`
+	// Completed is the stages that have finished, in order
+	Completed []RotationStage `json:"completed,omitempty"`
+	// Originals is the sha256 of every bundle before the rotation first changed it, keyed by its location
+	Originals map[string]string `json:"originals,omitempty"`
+	// Bundles is the content of the original bundles keyed by their sha256, most bundles are identical so each is
+	// only stored once to keep the state below the size limit of a ConfigMap
+	Bundles map[string]string `json:"bundles,omitempty"`
+	// Reissued is the cert-manager Certificates whose secrets were re-issued, as namespace/name
+	Reissued []string `json:"reissued,omitempty"`
+	Error    string   `json:"error,omitempty"`
+
+	client          kubernetes.Interface
+	resourceVersion string
+	saved           bool
+}
+
+// GetRotation returns the last CA rotation, or nil if there has never been one
+func GetRotation(client kubernetes.Interface) (*Rotation, error) {
+	cm, err := client.CoreV1().ConfigMaps(RolloutNamespace).Get(context.TODO(), RotationConfigMap, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get rotation state")
+	}
+	rotation := &Rotation{client: client, resourceVersion: cm.ResourceVersion, saved: true}
+	if err := json.Unmarshal([]byte(cm.Data[rotationKey]), rotation); err != nil {
+		return nil, errors.Wrapf(err, "invalid rotation state in %s/%s", RolloutNamespace, RotationConfigMap)
+	}
+	return rotation, nil
+}
+
+// StartRotation records a new running rotation, failing if another rotation has not finished or been rolled back
+func StartRotation(client kubernetes.Interface, kind CAKind, oldCA, newCA []byte) (*Rotation, error) {
+	existing, err := GetRotation(client)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil && (existing.Status == RotationRunning || existing.Status == RotationFailed) {
+		return nil, fmt.Errorf("a rotation of the %s started at %s by %s is %s, use --resume to continue it or --rollback to revert it",
+			existing.CA, existing.Started.Format(time.RFC3339), existing.Owner, existing.Status)
+	}
+	rotation := &Rotation{
+		CA:        kind,
+		Owner:     rolloutOwner(),
+		Status:    RotationRunning,
+		Started:   time.Now(),
+		OldCA:     string(oldCA),
+		NewCA:     string(newCA),
+		Originals: map[string]string{},
+		Bundles:   map[string]string{},
+		client:    client,
+	}
+	if existing != nil {
+		rotation.resourceVersion = existing.resourceVersion
+		rotation.saved = true
+	}
+	return rotation, rotation.save()
+}
+
+// ResumeRotation takes over ownership of a running or failed rotation
+func ResumeRotation(client kubernetes.Interface) (*Rotation, error) {
+	rotation, err := GetRotation(client)
+	if err != nil {
+		return nil, err
+	}
+	if rotation == nil || (rotation.Status != RotationRunning && rotation.Status != RotationFailed) {
+		return nil, fmt.Errorf("no running or failed rotation to resume")
+	}
+	rotation.Status = RotationRunning
+	rotation.Owner = rolloutOwner()
+	rotation.Error = ""
+	return rotation, rotation.save()
+}
+
+// save writes the rotation to the ConfigMap, using the resource version to detect another
+// process that has taken over the rotation
+func (r *Rotation) save() error {
+	r.Updated = time.Now()
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	cm := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            RotationConfigMap,
+			Namespace:       RolloutNamespace,
+			ResourceVersion: r.resourceVersion,
+		},
+		Data: map[string]string{rotationKey: string(data)},
+	}
+	configMaps := r.client.CoreV1().ConfigMaps(RolloutNamespace)
+	if !r.saved {
+		cm, err = configMaps.Create(context.TODO(), cm, metav1.CreateOptions{})
+		if kerrors.IsAlreadyExists(err) {
+			return fmt.Errorf("another rotation was started concurrently")
+		}
+	} else {
+		cm, err = configMaps.Update(context.TODO(), cm, metav1.UpdateOptions{})
+		if kerrors.IsConflict(err) {
+			return fmt.Errorf("rotation state was modified by another process, it may have been resumed or rolled back elsewhere")
+		}
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to save rotation state")
+	}
+	r.resourceVersion = cm.ResourceVersion
+	r.saved = true
+	return nil
+}
+
+// Done returns true if stage has completed
+func (r *Rotation) Done(stage RotationStage) bool {
+	for _, completed := range r.Completed {
+		if completed == stage {
+			return true
+		}
+	}
+	return false
+}
+
+// Complete records that stage has finished
+func (r *Rotation) Complete(stage RotationStage) error {
+	if !r.Done(stage) {
+		r.Completed = append(r.Completed, stage)
+	}
+	return r.save()
+}
+
+// AddOriginals records the content of bundles before they are first changed, keyed by their location, so that
+// they can be restored on rollback. The state is saved once for all of them, before any of them are changed
+func (r *Rotation) AddOriginals(originals map[string][]byte) error {
+	if r.Originals == nil {
+		r.Originals = map[string]string{}
+	}
+	if r.Bundles == nil {
+		r.Bundles = map[string]string{}
+	}
+	changed := false
+	for location, content := range originals {
+		if _, ok := r.Originals[location]; ok {
+			continue
+		}
+		sum := fmt.Sprintf("%x", sha256.Sum256(content))
+		r.Originals[location] = sum
+		r.Bundles[sum] = string(content)
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return r.save()
+}
+
+// Original returns the content of the bundle at location before the rotation changed it
+func (r *Rotation) Original(location string) ([]byte, bool) {
+	sum, ok := r.Originals[location]
+	if !ok {
+		return nil, false
+	}
+	content, ok := r.Bundles[sum]
+	return []byte(content), ok
+}
+
+// AddReissued records a certificate whose secret was deleted to be re-issued
+func (r *Rotation) AddReissued(certificate string) error {
+	if contains(r.Reissued, certificate) {
+		return nil
+	}
+	r.Reissued = append(r.Reissued, certificate)
+	return r.save()
+}
+
+// Finish marks the rotation as finished, failed or rolled back, with the error if it failed
+func (r *Rotation) Finish(status RotationStatus, err error) error {
+	r.Status = status
+	r.Error = ""
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r.save()
+}
+
+func (r *Rotation) Print(w io.Writer) {
+	fmt.Fprintf(w, "Rotation of the %s %s, started %s by %s, last updated %s\n", r.CA, r.Status, r.Started.Format(time.RFC3339), r.Owner, r.Updated.Format(time.RFC3339))
+	if r.Error != "" {
+		fmt.Fprintf(w, "Error: %s\n", r.Error)
+	}
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 3, 2, 3, ' ', 0)
+	fmt.Fprintf(tw, "STAGE\tSTATE\n")
+	for _, stage := range RotationStages {
+		state := "pending"
+		if r.Done(stage) {
+			state = "done"
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", stage, state)
+	}
+	_ = tw.Flush()
+	if len(r.Originals) > 0 {
+		var locations []string
+		for location := range r.Originals {
+			locations = append(locations, location)
+		}
+		sort.Strings(locations)
+		fmt.Fprintf(w, "\nChanged bundles:\n")
+		for _, location := range locations {
+			fmt.Fprintf(w, "  %s\n", location)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/flanksource/commons/certs"
+	"github.com/flanksource/karina/pkg/ca"
+	. "github.com/onsi/gomega"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newRotationCA(g *WithT, name string) *certs.Certificate {
+	cert := certs.NewCertificateBuilder(name).CA().Certificate
+	signed, err := cert.SignCertificate(cert, 1)
+	g.Expect(err).ToNot(HaveOccurred())
+	return signed
+}
+
+func TestRotationState(t *testing.T) {
+	g := NewWithT(t)
+	client := fake.NewSimpleClientset()
+
+	_, err := ResumeRotation(client)
+	g.Expect(err).To(MatchError(ContainSubstring("no running or failed rotation")))
+
+	rotation, err := StartRotation(client, IngressCA, []byte("old"), []byte("new"))
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = StartRotation(client, ClusterCA, nil, nil)
+	g.Expect(err).To(MatchError(ContainSubstring("use --resume")))
+
+	g.Expect(rotation.Complete(StageTrust)).To(Succeed())
+	g.Expect(rotation.AddOriginals(map[string][]byte{"secret/default/web": []byte("before")})).To(Succeed())
+	g.Expect(rotation.AddOriginals(map[string][]byte{
+		"secret/default/web": []byte("after"),
+		"secret/default/api": []byte("before"),
+	})).To(Succeed())
+	g.Expect(rotation.AddReissued("default/web")).To(Succeed())
+	g.Expect(rotation.Finish(RotationFailed, fmt.Errorf("timed out"))).To(Succeed())
+
+	resumed, err := ResumeRotation(client)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(resumed.CA).To(Equal(IngressCA))
+	g.Expect(resumed.Status).To(Equal(RotationRunning))
+	g.Expect(resumed.Error).To(BeEmpty())
+	g.Expect(resumed.NewCA).To(Equal("new"))
+	g.Expect(resumed.Done(StageTrust)).To(BeTrue())
+	g.Expect(resumed.Done(StageReissue)).To(BeFalse())
+	g.Expect(resumed.Originals).To(HaveLen(2))
+	g.Expect(resumed.Bundles).To(HaveLen(1), "identical bundles are stored once")
+	for _, location := range []string{"secret/default/web", "secret/default/api"} {
+		original, ok := resumed.Original(location)
+		g.Expect(ok).To(BeTrue())
+		g.Expect(string(original)).To(Equal("before"))
+	}
+	g.Expect(resumed.Reissued).To(Equal([]string{"default/web"}))
+
+	g.Expect(resumed.Finish(RotationFinished, nil)).To(Succeed())
+	_, err = StartRotation(client, ClusterCA, nil, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestRotationBundles(t *testing.T) {
+	g := NewWithT(t)
+	old := newRotationCA(g, "old-ca")
+	new := newRotationCA(g, "new-ca")
+	other := newRotationCA(g, "other-ca")
+
+	client := fake.NewSimpleClientset(
+		&admissionregistrationv1.ValidatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "policies"},
+			Webhooks: []admissionregistrationv1.ValidatingWebhook{
+				{Name: "trusted", ClientConfig: admissionregistrationv1.WebhookClientConfig{CABundle: old.EncodedCertificate()}},
+				{Name: "other", ClientConfig: admissionregistrationv1.WebhookClientConfig{CABundle: other.EncodedCertificate()}},
+			},
+		},
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "monitoring", Name: "thanos-ca"},
+			Data:       map[string][]byte{caCertKey: old.EncodedCertificate()},
+		},
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", Annotations: map[string]string{ca.CertificateAnnotation: "web"}},
+			Data:       map[string][]byte{caCertKey: old.EncodedCertificate()},
+		},
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "default-token-abcde"},
+			Type:       v1.SecretTypeServiceAccountToken,
+			Data:       map[string][]byte{caCertKey: old.EncodedCertificate()},
+		},
+	)
+	rotation, err := StartRotation(client, IngressCA, old.EncodedCertificate(), new.EncodedCertificate())
+	g.Expect(err).ToNot(HaveOccurred())
+	r := &rotator{rotation: rotation, old: old.X509, new: new.X509}
+
+	bundles := func() (trusted, other, secret, issued []byte) {
+		webhooks, err := client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.TODO(), "policies", metav1.GetOptions{})
+		g.Expect(err).ToNot(HaveOccurred())
+		thanos, err := client.CoreV1().Secrets("monitoring").Get(context.TODO(), "thanos-ca", metav1.GetOptions{})
+		g.Expect(err).ToNot(HaveOccurred())
+		web, err := client.CoreV1().Secrets("default").Get(context.TODO(), "web", metav1.GetOptions{})
+		g.Expect(err).ToNot(HaveOccurred())
+		return webhooks.Webhooks[0].ClientConfig.CABundle, webhooks.Webhooks[1].ClientConfig.CABundle, thanos.Data[caCertKey], web.Data[caCertKey]
+	}
+
+	g.Expect(updateWebhookBundles(client, rotation, r.trust)).To(Succeed())
+	client.ClearActions()
+	g.Expect(updateSecretBundles(client, rotation, r.trust)).To(Succeed())
+	saves := 0
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "update" && action.GetResource().Resource == "configmaps" {
+			saves++
+		}
+	}
+	g.Expect(saves).To(Equal(1), "the rotation state is saved once for every secret")
+	trusted, untouched, secret, issued := bundles()
+	g.Expect(ca.Contains(trusted, old.X509) && ca.Contains(trusted, new.X509)).To(BeTrue())
+	g.Expect(ca.Contains(secret, old.X509) && ca.Contains(secret, new.X509)).To(BeTrue())
+	g.Expect(ca.Contains(untouched, new.X509)).To(BeFalse())
+	g.Expect(ca.Contains(issued, new.X509)).To(BeFalse(), "secrets issued by cert-manager are re-issued, not updated")
+	g.Expect(rotation.Originals).To(HaveLen(2))
+	token, err := client.CoreV1().Secrets("default").Get(context.TODO(), "default-token-abcde", metav1.GetOptions{})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ca.Contains(token.Data[caCertKey], new.X509)).To(BeFalse(), "service account tokens are left to the controller manager")
+
+	g.Expect(updateWebhookBundles(client, rotation, r.untrust)).To(Succeed())
+	g.Expect(updateSecretBundles(client, rotation, r.untrust)).To(Succeed())
+	trusted, _, secret, _ = bundles()
+	g.Expect(string(trusted)).To(Equal(string(new.EncodedCertificate())))
+	g.Expect(string(secret)).To(Equal(string(new.EncodedCertificate())))
+
+	g.Expect(rotation.Bundles).To(HaveLen(1))
+	original, _ := rotation.Original(webhookLocation("validating", "policies", "trusted"))
+	g.Expect(restoreWebhook(client, "validating", "policies", "trusted", original)).To(Succeed())
+	trusted, _, _, _ = bundles()
+	g.Expect(string(trusted)).To(Equal(string(old.EncodedCertificate())))
+}
+
+func TestRestartWorkloads(t *testing.T) {
+	g := NewWithT(t)
+	deployment := func(name, secret string) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+			Spec: appsv1.DeploymentSpec{
+				Template: v1.PodTemplateSpec{
+					Spec: v1.PodSpec{
+						Volumes: []v1.Volume{{Name: "tls", VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: secret}}}},
+					},
+				},
+			},
+		}
+	}
+	client := fake.NewSimpleClientset(deployment("web", "web-tls"), deployment("db", "db-tls"))
+
+	g.Expect(restartWorkloads(client, "default", map[string]bool{"web-tls": true})).To(Succeed())
+	web, err := client.AppsV1().Deployments("default").Get(context.TODO(), "web", metav1.GetOptions{})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(web.Spec.Template.Annotations).To(HaveKey(restartedAtAnnotation))
+	db, err := client.AppsV1().Deployments("default").Get(context.TODO(), "db", metav1.GetOptions{})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(db.Spec.Template.Annotations).ToNot(HaveKey(restartedAtAnnotation))
+}
@@ -0,0 +1,160 @@
+package provision
+
+import (
+	"fmt"
+
+	"github.com/flanksource/karina/pkg/machineimages"
+	"github.com/flanksource/karina/pkg/platform"
+	"github.com/flanksource/karina/pkg/types"
+)
+
+// resolveTemplates replaces VM templates that are catalogue selectors (e.g. k8s=1.20,os=ubuntu,latest)
+// with the name of the selected machine image
+func resolveTemplates(p *platform.Platform) error {
+	selectors := machineimages.IsSelector(p.Master.Template)
+	for _, vm := range p.Nodes {
+		selectors = selectors || machineimages.IsSelector(vm.Template)
+	}
+	if !selectors {
+		return nil
+	}
+	catalog, err := machineimages.Load(p)
+	if err != nil {
+		return err
+	}
+	resolve := func(name string, vm *types.VM) error {
+		if !machineimages.IsSelector(vm.Template) {
+			return nil
+		}
+		selector, err := machineimages.ParseSelector(vm.Template)
+		if err != nil {
+			return err
+		}
+		image, err := selector.Select(catalog.Images)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		p.Debugf("[%s] template %s resolved to %s", name, vm.Template, image.Name)
+		vm.Template = image.Name
+		return nil
+	}
+	if err := resolve("master", &p.Master); err != nil {
+		return err
+	}
+	for name, vm := range p.Nodes {
+		if err := resolve(name, &vm); err != nil {
+			return err
+		}
+		p.Nodes[name] = vm
+	}
+	return nil
+}
+
+// TemplateUsage returns the number of machines cloned from each template
+func TemplateUsage(p *platform.Platform) (map[string]int, error) {
+	machines, err := p.Cluster.GetMachines()
+	if err != nil {
+		return nil, err
+	}
+	usage := map[string]int{}
+	for _, machine := range machines {
+		usage[machine.GetTemplate()]++
+	}
+	return usage, nil
+}
+
+// GarbageCollectTemplates deletes the machine images in the catalogue that are not used by any machine or pool,
+// keeping the most recent machineImages.keep images of each OS and kubernetes minor version. With --dry-run
+// the unused images are returned without being deleted
+func GarbageCollectTemplates(p *platform.Platform) ([]machineimages.Image, error) {
+	if err := WithCluster(p); err != nil {
+		return nil, err
+	}
+	catalog, err := machineimages.Load(p)
+	if err != nil {
+		return nil, err
+	}
+	usage, err := TemplateUsage(p)
+	if err != nil {
+		return nil, err
+	}
+	inUse := map[string]bool{p.Master.Template: true}
+	for template := range usage {
+		inUse[template] = true
+	}
+	for _, vm := range p.Nodes {
+		inUse[vm.Template] = true
+	}
+	keep := p.MachineImages.Keep
+	if keep <= 0 {
+		keep = 1
+	}
+	var uploaded []machineimages.Image
+	for _, image := range catalog.Images {
+		if !image.Pending {
+			uploaded = append(uploaded, image)
+		}
+	}
+	unused := machineimages.Unused(uploaded, inUse, keep)
+	if p.DryRun || len(unused) == 0 {
+		return unused, nil
+	}
+	deleter, ok := p.Cluster.(types.TemplateDeleter)
+	if !ok {
+		return nil, fmt.Errorf("deleting templates is not supported by %T", p.Cluster)
+	}
+	var deleted []machineimages.Image
+	for _, image := range unused {
+		p.Infof("Deleting unused template %s (%s, %s)", image.Name, image.Kubernetes, image.OS)
+		if err := deleter.DeleteTemplate(p.Master, image.Name); err != nil {
+			p.Errorf("failed to delete template %s: %v", image.Name, err)
+			continue
+		}
+		catalog.Remove(image.Name)
+		deleted = append(deleted, image)
+	}
+	if err := catalog.Save(p); err != nil {
+		return deleted, err
+	}
+	if len(deleted) < len(unused) {
+		return deleted, fmt.Errorf("failed to delete %d of %d unused templates", len(unused)-len(deleted), len(unused))
+	}
+	return deleted, nil
+}
+
+// UploadTemplate uploads the image at path as the template of a catalogue entry and marks the entry as uploaded,
+// so that selectors can resolve to it. path defaults to the file the image was built into
+func UploadTemplate(p *platform.Platform, name, path string) error {
+	if err := WithCluster(p); err != nil {
+		return err
+	}
+	catalog, err := machineimages.Load(p)
+	if err != nil {
+		return err
+	}
+	image := catalog.Get(name)
+	if image == nil {
+		return fmt.Errorf("%s is not in the catalogue, build or register it first", name)
+	}
+	if path == "" {
+		path = image.Path
+	}
+	if path == "" {
+		return fmt.Errorf("the image file of %s is unknown, specify it with --image", name)
+	}
+	uploader, ok := p.Cluster.(types.TemplateUploader)
+	if !ok {
+		return fmt.Errorf("uploading templates is not supported by %T, upload %s by other means and run karina machine-images register", p.Cluster, path)
+	}
+	if p.DryRun {
+		p.Infof("Would upload %s as %s", path, name)
+		return nil
+	}
+	p.Infof("Uploading %s as %s", path, name)
+	if err := uploader.UploadTemplate(p.Master, name, path); err != nil {
+		return err
+	}
+	image.Pending = false
+	image.Path = ""
+	return catalog.Save(p)
+}
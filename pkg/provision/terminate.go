@@ -18,19 +18,29 @@ func TerminateOrphans(platform *platform.Platform) error {
 	if err != nil {
 		return err
 	}
+	terminateOrphans(cluster)
+	return nil
+}
 
+func terminateOrphans(cluster *Cluster) {
 	for _, orphan := range cluster.Orphans {
 		time.Sleep(1 * time.Second) // sleep to allow for cancellation
-		platform.Infof("Deleting %s", orphan.Name())
+		cluster.Infof("Deleting %s", orphan.Name())
 		if err := cluster.Terminate(orphan); err != nil {
-			platform.Errorf("failed to terminate %s: %v", orphan, err)
+			cluster.Errorf("failed to terminate %s: %v", orphan, err)
 		}
 	}
-	return nil
 }
 
-// TerminateNodes deletes all of the specified nodes stops and deletes all VM's for a cluster;
-func TerminateNodes(platform *platform.Platform, nodes []string) error {
+// TerminateNodes drains the specified nodes honouring PodDisruptionBudgets and then terminates them, nodes that
+// cannot be drained within the drain timeout are left cordoned with the skip policy
+func TerminateNodes(platform *platform.Platform, nodes []string, drain DrainOptions) error {
+	if err := drain.Validate(); err != nil {
+		return err
+	}
+	if err := checkMaintenance(platform, "terminate nodes"); err != nil {
+		return err
+	}
 	cluster, err := GetCluster(platform)
 	if err != nil {
 		return err
@@ -48,7 +58,10 @@ func TerminateNodes(platform *platform.Platform, nodes []string) error {
 		node := nodeMachine.Node
 		platform.Infof("Deleting %s", node.Name)
 
-		if err := cluster.Cordon(node); err != nil {
+		if err := Drain(cluster.Kubernetes, platform.Logger, node.Name, drain); IsDrainSkipped(err) {
+			platform.Warnf("%v, skipping", err)
+			continue
+		} else if err != nil {
 			return err
 		}
 		if err := cluster.Terminate(machine); err != nil {
@@ -64,12 +77,6 @@ func terminate(platform *platform.Platform, vm types.Machine) {
 		return
 	}
 
-	if !platform.Terminating {
-		if err := platform.Drain(vm.Name(), 2*time.Minute); err != nil {
-			platform.Warnf("[%s] failed to drain: %v", vm.Name(), err)
-		}
-	}
-
 	client, err := platform.GetClientset()
 	if err != nil {
 		platform.Warnf("[%s] failed to get client to delete node: %v", vm, err)
@@ -134,8 +141,11 @@ func Terminate(platform *platform.Platform) error {
 	if platform.TerminationProtection {
 		return fmt.Errorf("termination Protection Enabled, use -e terminationProtection=false to disable")
 	}
+	if err := checkMaintenance(platform, "terminate"); err != nil {
+		return err
+	}
 
-	if err := WithVmwareCluster(platform); err != nil {
+	if err := WithCluster(platform); err != nil {
 		return err
 	}
 	platform.Terminating = true
@@ -1,100 +1,406 @@
 package provision
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"sort"
 	"time"
 
+	"github.com/blang/semver/v4"
+	"github.com/flanksource/karina/pkg/deprecations"
 	"github.com/flanksource/karina/pkg/phases/kubeadm"
 	"github.com/flanksource/karina/pkg/platform"
+	"github.com/flanksource/karina/pkg/types"
 	"github.com/flanksource/kommons"
+	"github.com/pkg/errors"
 	"gopkg.in/yaml.v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 const ClusterConfiguration = "ClusterConfiguration"
 
-const upgradePrepCommand =
-// install the correct version kubeadm
-"apt-get install -y --allow-change-held-packages kubeadm=%s-00 ;" +
-	// prevent it from being automatically updated
-	" apt-mark hold kubeadm &&" +
-	// download the most recent kubeadm configuration
-	" (kubectl --kubeconfig /etc/kubernetes/admin.conf get cm kubeadm-config -o json -n kube-system | jq -r '.data.ClusterConfiguration' > /etc/kubernetes/kubeadm.conf)"
-	// perform the upgrade
+// download the most recent kubeadm configuration
+const getKubeadmConfig = "kubectl --kubeconfig /etc/kubernetes/admin.conf get cm kubeadm-config -o json -n kube-system | jq -r '.data.ClusterConfiguration' > /etc/kubernetes/kubeadm.conf"
+
+// perform the upgrade
 const upgradeCluster = "kubeadm upgrade apply -y --allow-experimental-upgrades --allow-release-candidate-upgrades --config /etc/kubernetes/kubeadm.conf %s"
 
-const upgradeNode = "apt-get install -y --allow-change-held-packages kubeadm=%s-00;" +
-	" apt-mark hold kubeadm &&" +
-	" kubeadm upgrade node"
+const upgradeNode = "kubeadm upgrade node"
+
+const restartKubelet = "systemctl daemon-reload && systemctl restart kubelet"
+
+// WorkerUpgradeStrategy is how workers are upgraded once the control plane has been upgraded
+type WorkerUpgradeStrategy string
+
+const (
+	// UpgradeAuto replaces the workers of pools that have a new template and upgrades the rest in-place
+	UpgradeAuto WorkerUpgradeStrategy = "auto"
+	// UpgradeInPlace drains each worker and upgrades kubeadm and the kubelet with the node's package manager
+	UpgradeInPlace WorkerUpgradeStrategy = "in-place"
+	// UpgradeReplace replaces workers with new ones using a rolling update
+	UpgradeReplace WorkerUpgradeStrategy = "replace"
+	// UpgradeNone only upgrades the control plane
+	UpgradeNone WorkerUpgradeStrategy = "none"
+)
+
+type UpgradeOptions struct {
+	Workers WorkerUpgradeStrategy
+	// Pools restricts the worker upgrade to the given pools
+	Pools []string
+	// Timeout is how long to wait for upgraded and replacement nodes to become ready
+	Timeout      time.Duration
+	BurninPeriod time.Duration
+	// MaxSurge is the number of workers replaced at a time in pools that are replaced
+	MaxSurge int
+	// HealthTolerance and HealthGates are checked after each batch of workers in pools that are replaced
+	HealthTolerance int
+	HealthGates     []types.HealthGate
+	Drain           DrainOptions
+	// IgnoreDeprecations continues the upgrade when APIs removed in the new version are still in use
+	IgnoreDeprecations bool
+}
+
+func (opts UpgradeOptions) Validate() error {
+	switch opts.Workers {
+	case UpgradeAuto, UpgradeInPlace, UpgradeReplace, UpgradeNone:
+	default:
+		return fmt.Errorf("invalid worker upgrade strategy %q, must be one of auto, in-place, replace or none", opts.Workers)
+	}
+	return opts.Drain.Validate()
+}
+
+// rollingOptions returns the options of the rolling update that replaces count workers in pool that are not on target
+func (opts UpgradeOptions) rollingOptions(pool, target string, count int) RollingOptions {
+	return RollingOptions{
+		Workers:         true,
+		Pools:           []string{pool},
+		KubeletVersion:  target,
+		Max:             count,
+		MaxSurge:        opts.MaxSurge,
+		HealthTolerance: opts.HealthTolerance,
+		HealthGates:     opts.HealthGates,
+		Timeout:         opts.Timeout,
+		BurninPeriod:    opts.BurninPeriod,
+		Drain:           opts.Drain,
+	}
+}
 
-// Upgrade the kubernetes control plane to the declared version
-func Upgrade(platform *platform.Platform) error {
+// Upgrade the cluster to the declared kubernetes version, the control plane is upgraded one master at a time
+// and then the workers, one pool at a time. Upgrades can be resumed by running them again
+func Upgrade(platform *platform.Platform, opts UpgradeOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	if err := checkMaintenance(platform, "upgrade"); err != nil {
+		return err
+	}
 	cluster, err := GetCluster(platform)
 	if err != nil {
 		return err
 	}
+	target := platform.Kubernetes.Version
 
-	newConfig := kubeadm.NewClusterConfig(platform)
-	newData, err := yaml.Marshal(newConfig)
+	// the lowest API server version is the version of the control plane until all masters are upgraded
+	var masters []string
+	kubelets := map[string]string{}
+	for _, nodeMachine := range cluster.Nodes {
+		node := nodeMachine.Node
+		kubelets[node.Name] = node.Status.NodeInfo.KubeletVersion
+		if kommons.IsMasterNode(node) {
+			masters = append(masters, kubeadm.GetNodeVersion(platform, node))
+		}
+	}
+	current := lowestVersion(masters)
+	if current == "" {
+		return fmt.Errorf("unable to determine the version of the control plane")
+	}
+	if err := checkSkew(current, target, kubelets); err != nil {
+		return err
+	}
+	if current != target {
+		platform.Infof("Starting upgrade from %s to %s", current, target)
+	}
+	if err := checkDeprecations(platform, target, opts.IgnoreDeprecations); err != nil {
+		return err
+	}
+	if err := upgradeControlPlane(platform, cluster, opts); err != nil {
+		return err
+	}
+	if opts.Workers == UpgradeNone {
+		return nil
+	}
+	return upgradeWorkers(platform, cluster, opts)
+}
+
+// checkSkew validates an upgrade of the control plane from current to target against the version skew policy,
+// kubeadm can only upgrade one minor version at a time and kubelets can be up to 2 minor versions older than
+// the API server, but never newer
+func checkSkew(current, target string, kubelets map[string]string) error {
+	from, err := deprecations.ParseVersion(current)
 	if err != nil {
 		return err
 	}
-	kubeadmConfig := (*platform.GetConfigMap("kube-system", "kubeadm-config"))
-	kubeadmConfig[ClusterConfiguration] = string(newData)
+	to, err := deprecations.ParseVersion(target)
+	if err != nil {
+		return err
+	}
+	if to.LT(from) {
+		return fmt.Errorf("cannot downgrade the control plane from %s to %s", current, target)
+	}
+	if to.Major != from.Major || to.Minor > from.Minor+1 {
+		return fmt.Errorf("cannot upgrade from %s to %s, upgrade one minor version at a time: v%d.%d first", current, target, from.Major, from.Minor+1)
+	}
+	var nodes []string
+	for node := range kubelets {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	for _, node := range nodes {
+		kubelet, err := deprecations.ParseVersion(kubelets[node])
+		if err != nil {
+			return errors.Wrapf(err, "invalid kubelet version on %s", node)
+		}
+		if kubelet.GT(to) {
+			return fmt.Errorf("the kubelet on %s (%s) is newer than %s", node, kubelets[node], target)
+		}
+		if kubelet.Major != to.Major || kubelet.Minor+2 < to.Minor {
+			return fmt.Errorf("the kubelet on %s (%s) would be more than 2 minor versions older than the control plane, upgrade it to %s first", node, kubelets[node], current)
+		}
+	}
+	return nil
+}
 
-	// update kubeadm-config with any changes introduced since last provision/update
-	if err := platform.CreateOrUpdateConfigMap("kubeadm-config", "kube-system", kubeadmConfig); err != nil {
+// lowestVersion returns the lowest of versions, ignoring versions that cannot be parsed
+func lowestVersion(versions []string) string {
+	lowest := ""
+	var min semver.Version
+	for _, version := range versions {
+		v, err := deprecations.ParseVersion(version)
+		if err != nil {
+			continue
+		}
+		if lowest == "" || v.LT(min) {
+			lowest, min = version, v
+		}
+	}
+	return lowest
+}
+
+// checkDeprecations reports objects written with APIs removed in the target version and removed APIs
+// that clients are still requesting
+func checkDeprecations(platform *platform.Platform, target string, ignore bool) error {
+	version, err := deprecations.ParseVersion(target)
+	if err != nil {
 		return err
 	}
+	client, err := platform.GetClientset()
+	if err != nil {
+		return err
+	}
+	dynamicClient, err := platform.GetDynamicClient()
+	if err != nil {
+		return err
+	}
+	inUse := false
+	findings, err := deprecations.ScanCluster(client.Discovery(), dynamicClient, version)
+	if err != nil {
+		platform.Warnf("Unable to check for objects using deprecated APIs: %v", err)
+	} else if len(findings) > 0 {
+		platform.Warnf("%d objects use APIs removed in %s:", len(findings), target)
+		deprecations.PrintFindings(os.Stderr, findings)
+		inUse = true
+	}
+	requested, err := deprecations.Requested(client, version)
+	if err != nil {
+		platform.Warnf("Unable to check for deprecated APIs in use: %v", err)
+	} else if len(requested) > 0 {
+		platform.Warnf("%d APIs removed in %s are still being requested:", len(requested), target)
+		deprecations.PrintRequested(os.Stderr, requested)
+		inUse = true
+	}
+	if !inUse {
+		platform.Infof("No APIs removed in %s are in use", target)
+		return nil
+	}
+	if ignore {
+		return nil
+	}
+	return fmt.Errorf("APIs removed in %s are still in use, migrate them or use --ignore-deprecations to continue", target)
+}
 
-	version, err := kubeadm.GetClusterVersion(platform)
+// upgradeControlPlane upgrades the masters one at a time, the first master to be upgraded runs kubeadm upgrade apply
+func upgradeControlPlane(platform *platform.Platform, cluster *Cluster, opts UpgradeOptions) error {
+	newConfig := kubeadm.NewClusterConfig(platform)
+	newData, err := yaml.Marshal(newConfig)
 	if err != nil {
 		return err
 	}
+	kubeadmConfig := (*platform.GetConfigMap("kube-system", "kubeadm-config"))
+	kubeadmConfig[ClusterConfiguration] = string(newData)
 
-	if platform.Kubernetes.Version == version {
-		platform.Infof("At least 1 master has been upgraded to : %s\n", platform.Kubernetes.Version)
-	} else {
-		platform.Infof("Starting upgrade from %s to %s", version, platform.Kubernetes.Version)
+	// update kubeadm-config with any changes introduced since last provision/update
+	if err := platform.CreateOrUpdateConfigMap("kubeadm-config", "kube-system", kubeadmConfig); err != nil {
+		return err
 	}
 
+	target := platform.Kubernetes.Version
 	var toUpgrade = []string{}
 	var upgraded = []string{}
-
+	var masters = NodeMachines{}
 	for _, nodeMachine := range cluster.Nodes {
 		node := nodeMachine.Node
 		if !kommons.IsMasterNode(node) {
 			continue
 		}
-		nodeVersion := kubeadm.GetNodeVersion(platform, node)
-		if nodeVersion != platform.Kubernetes.Version {
+		masters = append(masters, nodeMachine)
+		if kubeadm.GetNodeVersion(platform, node) != target {
 			toUpgrade = append(toUpgrade, node.Name)
 		} else {
 			upgraded = append(upgraded, node.Name)
 		}
 	}
 
-	platform.Infof("Nodes needing upgrade: %s", toUpgrade)
-	platform.Infof("Nodes already upgraded: %s", upgraded)
+	platform.Infof("Masters needing upgrade: %s", toUpgrade)
+	platform.Infof("Masters already upgraded: %s", upgraded)
 
-	if len(upgraded) == 0 {
-		out, err := platform.Executef(toUpgrade[0], 5*time.Minute, upgradePrepCommand, platform.Kubernetes.Version[1:])
+	for _, nodeMachine := range masters {
+		node := nodeMachine.Node
+		pm, err := getPackageManager(platform, node.Name)
 		if err != nil {
-			return fmt.Errorf("failed to prep for upgrade: %s, %s", err, out)
+			return err
 		}
-		out, err = platform.Executef(toUpgrade[0], 5*time.Minute, upgradeCluster, platform.Kubernetes.Version)
-		if err != nil {
-			return fmt.Errorf("failed to upgrade: %s, %s", err, out)
+		if contains(toUpgrade, node.Name) {
+			out, err := platform.Executef(node.Name, 5*time.Minute, "%s && %s", pm.install(target, "kubeadm"), getKubeadmConfig)
+			if err != nil {
+				return fmt.Errorf("failed to prep for upgrade: %s, %s", err, out)
+			}
+			if len(upgraded) == 0 {
+				out, err = platform.Executef(node.Name, 10*time.Minute, upgradeCluster, target)
+				if err != nil {
+					return fmt.Errorf("failed to upgrade: %s, %s", err, out)
+				}
+				platform.Infof("Completed upgrade via %s: %s", node.Name, out)
+			} else {
+				out, err = platform.Executef(node.Name, 5*time.Minute, upgradeNode)
+				if err != nil {
+					return fmt.Errorf("failed to upgrade: %s, %s", err, out)
+				}
+				platform.Infof("Upgraded node via %s: %s", node.Name, out)
+			}
+			upgraded = append(upgraded, node.Name)
+		}
+		if node.Status.NodeInfo.KubeletVersion != target {
+			if err := upgradeKubelet(platform, cluster, node.Name, pm, opts); err != nil {
+				return err
+			}
 		}
-		platform.Infof("Completed upgrade via %s: %s", toUpgrade[0], out)
-		toUpgrade = toUpgrade[1:]
 	}
-	for _, node := range toUpgrade {
-		out, err := platform.Executef(node, 5*time.Minute, upgradeNode, platform.Kubernetes.Version[1:])
-		if err != nil {
-			return fmt.Errorf("failed to upgrade: %s, %s", err, out)
+	return nil
+}
+
+// upgradeWorkers upgrades the workers that are not on the target version, one pool at a time
+func upgradeWorkers(platform *platform.Platform, cluster *Cluster, opts UpgradeOptions) error {
+	target := platform.Kubernetes.Version
+	pools := map[string]NodeMachines{}
+	for _, nodeMachine := range cluster.Nodes {
+		node := nodeMachine.Node
+		if kommons.IsMasterNode(node) || node.Status.NodeInfo.KubeletVersion == target {
+			continue
 		}
-		platform.Infof("Upgraded node via %s: %s", node, out)
+		pool := nodePool(platform, node)
+		if _, ok := platform.Nodes[pool]; !ok {
+			platform.Warnf("Skipping %s, unable to determine its node pool", node.Name)
+			continue
+		}
+		if len(opts.Pools) > 0 && !contains(opts.Pools, pool) {
+			continue
+		}
+		pools[pool] = append(pools[pool], nodeMachine)
 	}
+	var names []string
+	for pool := range pools {
+		names = append(names, pool)
+	}
+	sort.Strings(names)
 
+	skipped := 0
+	for _, pool := range names {
+		strategy := workerStrategy(platform, opts.Workers, pool, pools[pool])
+		platform.Infof("Upgrading %d workers in pool %s (%s)", len(pools[pool]), pool, strategy)
+		if strategy == UpgradeReplace {
+			if err := RollingUpdate(platform, opts.rollingOptions(pool, target, len(pools[pool]))); err != nil {
+				return errors.Wrapf(err, "failed to replace workers in pool %s", pool)
+			}
+			continue
+		}
+		for _, nodeMachine := range pools[pool] {
+			err := upgradeWorker(platform, cluster, nodeMachine.Node.Name, opts)
+			if IsDrainSkipped(err) {
+				platform.Warnf("[%s] skipping upgrade: %v", nodeMachine.Node.Name, err)
+				skipped++
+				continue
+			}
+			if err != nil {
+				return errors.Wrapf(err, "failed to upgrade worker in pool %s", pool)
+			}
+		}
+	}
+	if skipped > 0 {
+		return fmt.Errorf("%d workers could not be drained and were not upgraded, run upgrade again to retry them", skipped)
+	}
 	return nil
 }
+
+// workerStrategy returns how to upgrade a pool, in auto mode pools with workers that are not on the
+// pool's template are replaced
+func workerStrategy(platform *platform.Platform, strategy WorkerUpgradeStrategy, pool string, nodes NodeMachines) WorkerUpgradeStrategy {
+	if strategy != UpgradeAuto {
+		return strategy
+	}
+	for _, nodeMachine := range nodes {
+		if nodeMachine.Machine.GetTemplate() != platform.Nodes[pool].Template {
+			return UpgradeReplace
+		}
+	}
+	return UpgradeInPlace
+}
+
+// upgradeWorker upgrades kubeadm and the kubelet of a worker in-place
+func upgradeWorker(platform *platform.Platform, cluster *Cluster, node string, opts UpgradeOptions) error {
+	pm, err := getPackageManager(platform, node)
+	if err != nil {
+		return err
+	}
+	out, err := platform.Executef(node, 5*time.Minute, "%s && %s", pm.install(platform.Kubernetes.Version, "kubeadm"), upgradeNode)
+	if err != nil {
+		return fmt.Errorf("failed to upgrade %s: %s, %s", node, err, out)
+	}
+	return upgradeKubelet(platform, cluster, node, pm, opts)
+}
+
+// upgradeKubelet drains a node, upgrades the kubelet and waits for it to report the new version before uncordoning
+func upgradeKubelet(platform *platform.Platform, cluster *Cluster, node string, pm packageManager, opts UpgradeOptions) error {
+	target := platform.Kubernetes.Version
+	if err := Drain(cluster.Kubernetes, platform.Logger, node, opts.Drain); err != nil {
+		if !IsDrainSkipped(err) {
+			if uncordonErr := platform.Uncordon(node); uncordonErr != nil {
+				platform.Errorf("[%s] failed to uncordon: %v", node, uncordonErr)
+			}
+		}
+		return err
+	}
+	out, err := platform.Executef(node, 5*time.Minute, "%s && %s", pm.install(target, "kubelet", "kubectl"), restartKubelet)
+	if err != nil {
+		return fmt.Errorf("failed to upgrade kubelet on %s: %s, %s", node, err, out)
+	}
+	upgraded := doUntil(opts.Timeout, func() bool {
+		current, err := cluster.Kubernetes.CoreV1().Nodes().Get(context.TODO(), node, metav1.GetOptions{})
+		return err == nil && isNodeReady(*current) && current.Status.NodeInfo.KubeletVersion == target
+	})
+	if !upgraded {
+		return fmt.Errorf("[%s] kubelet did not become ready on %s within %s", node, target, opts.Timeout)
+	}
+	platform.Infof("[%s] upgraded kubelet to %s", node, target)
+	return platform.Uncordon(node)
+}
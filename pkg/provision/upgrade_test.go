@@ -0,0 +1,91 @@
+package provision
+
+import (
+	"testing"
+	"time"
+
+	"github.com/flanksource/karina/pkg/provision/fake"
+	"github.com/flanksource/karina/pkg/types"
+	. "github.com/onsi/gomega"
+)
+
+func TestCheckSkew(t *testing.T) {
+	g := NewWithT(t)
+	kubelets := map[string]string{"master-1": "v1.19.7", "worker-1": "v1.18.8"}
+
+	g.Expect(checkSkew("v1.19.7", "v1.20.4", kubelets)).To(Succeed())
+	// resuming an upgrade once the control plane is upgraded
+	g.Expect(checkSkew("v1.20.4", "v1.20.4", kubelets)).To(Succeed())
+	g.Expect(checkSkew("v1.19.7", "v1.19.8", kubelets)).To(Succeed())
+
+	g.Expect(checkSkew("v1.19.7", "v1.18.8", kubelets)).To(MatchError(ContainSubstring("cannot downgrade")))
+	g.Expect(checkSkew("v1.19.7", "v1.21.1", kubelets)).To(MatchError(ContainSubstring("v1.20 first")))
+
+	// worker-1 would be 3 minor versions behind
+	kubelets["worker-1"] = "v1.17.3"
+	g.Expect(checkSkew("v1.19.7", "v1.20.4", kubelets)).To(MatchError(ContainSubstring("worker-1")))
+
+	kubelets["worker-1"] = "v1.21.0"
+	g.Expect(checkSkew("v1.20.4", "v1.20.4", kubelets)).To(MatchError(ContainSubstring("newer")))
+
+	g.Expect(checkSkew("<err>", "v1.20.4", kubelets)).ToNot(Succeed())
+}
+
+func TestLowestVersion(t *testing.T) {
+	g := NewWithT(t)
+	g.Expect(lowestVersion([]string{"v1.20.4", "v1.19.7", "<err>", "v1.19.10"})).To(Equal("v1.19.7"))
+	g.Expect(lowestVersion([]string{"<err>", ""})).To(BeEmpty())
+}
+
+func TestWorkerStrategy(t *testing.T) {
+	g := NewWithT(t)
+	p := newFakePlatform()
+	p.Nodes = map[string]types.VM{"general": {Template: "k8s-1.20"}}
+	current := NodeMachines{{Machine: &fake.Machine{Attributes: map[string]string{"Template": "k8s-1.20"}}}}
+	outdated := NodeMachines{{Machine: &fake.Machine{Attributes: map[string]string{"Template": "k8s-1.19"}}}}
+
+	g.Expect(workerStrategy(p, UpgradeAuto, "general", current)).To(Equal(UpgradeInPlace))
+	g.Expect(workerStrategy(p, UpgradeAuto, "general", append(current, outdated...))).To(Equal(UpgradeReplace))
+	g.Expect(workerStrategy(p, UpgradeInPlace, "general", outdated)).To(Equal(UpgradeInPlace))
+}
+
+func TestUpgradeRollingOptions(t *testing.T) {
+	g := NewWithT(t)
+	gate, err := ParseHealthGate("alerts:warning")
+	g.Expect(err).ToNot(HaveOccurred())
+	opts := UpgradeOptions{
+		MaxSurge:        2,
+		HealthTolerance: 1,
+		HealthGates:     []types.HealthGate{gate},
+		Timeout:         5 * time.Minute,
+		BurninPeriod:    3 * time.Minute,
+		Drain:           DrainOptions{Timeout: 10 * time.Minute, OnTimeout: DrainSkip},
+	}
+	// replaced pools are health checked in the same way as a rolling update
+	g.Expect(opts.rollingOptions("general", "v1.20.4", 4)).To(Equal(RollingOptions{
+		Workers:         true,
+		Pools:           []string{"general"},
+		KubeletVersion:  "v1.20.4",
+		Max:             4,
+		MaxSurge:        2,
+		HealthTolerance: 1,
+		HealthGates:     []types.HealthGate{gate},
+		Timeout:         5 * time.Minute,
+		BurninPeriod:    3 * time.Minute,
+		Drain:           DrainOptions{Timeout: 10 * time.Minute, OnTimeout: DrainSkip},
+	}))
+}
+
+func TestPackageManager(t *testing.T) {
+	g := NewWithT(t)
+	pm, err := parsePackageManager("\ndnf\n")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(pm).To(Equal(dnf))
+	_, err = parsePackageManager("")
+	g.Expect(err).To(HaveOccurred())
+
+	g.Expect(apt.install("v1.20.4", "kubelet", "kubectl")).To(Equal(
+		"apt-get update -qq; apt-get install -y --allow-change-held-packages kubelet=1.20.4-00 kubectl=1.20.4-00 && apt-mark hold kubelet kubectl"))
+	g.Expect(yum.install("v1.20.4", "kubeadm")).To(Equal("yum install -y --disableexcludes=kubernetes kubeadm-1.20.4-0"))
+	g.Expect(dnf.install("v1.20.4", "kubeadm")).To(Equal("dnf install -y --disableexcludes=kubernetes kubeadm-1.20.4-0"))
+}
@@ -10,7 +10,7 @@ import (
 
 // VM provisions a new standalone VM
 func VM(platform *platform.Platform, vm *types.VM, konfigs ...string) error {
-	if err := WithVmwareCluster(platform); err != nil {
+	if err := WithCluster(platform); err != nil {
 		return err
 	}
 
@@ -9,6 +9,7 @@ import (
 	"github.com/flanksource/karina/pkg/types"
 	konfigadm "github.com/flanksource/konfigadm/pkg/types"
 	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/vapi/library"
 	"github.com/vmware/govmomi/vapi/rest"
 	vtags "github.com/vmware/govmomi/vapi/tags"
 )
@@ -149,3 +150,31 @@ func (cluster *vmwareCluster) SetTags(vm types.Machine, tags map[string]string)
 
 	return nil
 }
+
+// DeleteTemplate deletes a content library item if the VM is cloned from a content library, or a VM template otherwise
+func (cluster *vmwareCluster) DeleteTemplate(vm types.VM, name string) error {
+	if vm.ContentLibrary == "" {
+		template, err := cluster.session.FindVM(name)
+		if err != nil {
+			return err
+		}
+		task, err := template.Destroy(cluster.ctx)
+		if err != nil {
+			return errors.Wrapf(err, "failed to delete template %s", name)
+		}
+		return task.Wait(cluster.ctx)
+	}
+	item, err := cluster.session.FindTemplate(vm.ContentLibrary, name)
+	if err != nil {
+		return err
+	}
+	restClient := rest.NewClient(cluster.session.Client.Client)
+	user := url.UserPassword(cluster.vsphere.Username, cluster.vsphere.Password)
+	if err := restClient.Login(cluster.ctx, user); err != nil {
+		return errors.Wrap(err, "failed to login")
+	}
+	if err := library.NewManager(restClient).DeleteLibraryItem(cluster.ctx, item); err != nil {
+		return errors.Wrapf(err, "failed to delete %s from content library %s", name, vm.ContentLibrary)
+	}
+	return nil
+}
@@ -17,36 +17,25 @@ import (
 	"github.com/flanksource/karina/pkg/phases/kubeadm"
 	"github.com/flanksource/karina/pkg/phases/vsphere"
 	"github.com/flanksource/karina/pkg/platform"
-	"github.com/flanksource/karina/pkg/provision/vmware"
 	"github.com/flanksource/karina/pkg/types"
 	"github.com/kr/pretty"
 	"github.com/pkg/errors"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
 )
 
-func WithVmwareCluster(p *platform.Platform) error {
-	cluster, err := vmware.NewVMwareCluster(p.PlatformConfig)
-	if err != nil {
-		return err
-	}
-	p.Cluster = cluster
-	if err := p.Init(); err != nil {
-		return err
-	}
-
-	joinEndpoint, err := p.MasterDiscovery.GetControlPlaneEndpoint(p)
-	if err != nil {
-		return err
-	}
-	p.JoinEndpoint = joinEndpoint
-
+// WithCluster initializes the platform with the cluster of the provider selected by master.provider,
+// an existing cluster (e.g. a fake cluster in tests) is reused
+func WithCluster(p *platform.Platform) error {
+	applyVsphereVM(&p.Master)
 	if p.Master.Prefix == "" {
 		p.Master.Prefix = "master"
 	}
 
 	for name, vm := range p.Nodes {
+		applyVsphereVM(&vm)
 		if vm.Prefix == "" {
 			vm.Prefix = name
 		}
@@ -62,6 +51,12 @@ func WithVmwareCluster(p *platform.Platform) error {
 		if vm.ContentLibrary == "" {
 			vm.ContentLibrary = p.Master.ContentLibrary
 		}
+		if vm.Provider == "" {
+			vm.Provider = p.Master.Provider
+		}
+		if vm.Libvirt == nil {
+			vm.Libvirt = p.Master.Libvirt
+		}
 		if len(vm.Annotations) == 0 && len(p.Master.Annotations) > 0 {
 			vm.Annotations = p.Master.Annotations
 		}
@@ -71,12 +66,33 @@ func WithVmwareCluster(p *platform.Platform) error {
 		p.Nodes[name] = vm
 	}
 
+	if err := resolveTemplates(p); err != nil {
+		return err
+	}
+
+	// the cluster is created after defaulting as it indexes VM's by prefix
+	if p.Cluster == nil {
+		cluster, err := NewCluster(p)
+		if err != nil {
+			return err
+		}
+		p.Cluster = cluster
+	}
+	if err := p.Init(); err != nil {
+		return err
+	}
+
+	joinEndpoint, err := p.MasterDiscovery.GetControlPlaneEndpoint(p)
+	if err != nil {
+		return err
+	}
+	p.JoinEndpoint = joinEndpoint
 	return nil
 }
 
-// VsphereCluster provisions or creates a kubernetes cluster
-func VsphereCluster(platform *platform.Platform, burninPeriod time.Duration) error {
-	if err := WithVmwareCluster(platform); err != nil {
+// VMCluster provisions or creates a kubernetes cluster using the VM provider of the platform
+func VMCluster(platform *platform.Platform, burninPeriod time.Duration) error {
+	if err := WithCluster(platform); err != nil {
 		return err
 	}
 
@@ -166,11 +182,10 @@ func VsphereCluster(platform *platform.Platform, burninPeriod time.Duration) err
 			delete(vms, m)
 		}
 
-		for i := 0; i < worker.Count-len(vms); i++ {
+		for i := 0; i < worker.DesiredCount(len(vms))-len(vms); i++ {
 			time.Sleep(1 * time.Second)
 			wg.Add(1)
 			_nodeGroup := nodeGroup
-			annotations := worker.Annotations
 			go func() {
 				defer wg.Done()
 				if w, err := createWorker(platform, _nodeGroup); err != nil {
@@ -178,16 +193,8 @@ func VsphereCluster(platform *platform.Platform, burninPeriod time.Duration) err
 				} else {
 					if err := waitForNode(platform, w.Name(), burninPeriod); err != nil {
 						platform.Errorf("%s did not come up healthy, it may need to be re-provisioned %v", w.Name(), err)
-					} else {
-						if err := addNodeAnnotations(platform, w.Name(), annotations); err != nil {
-							platform.Errorf("failed to add annotations to worker %s: %v", w.Name(), err)
-						}
-						labels := map[string]string{
-							constants.NodePoolLabel: nodeGroup,
-						}
-						if err := addNodeLabels(platform, w.Name(), labels); err != nil {
-							platform.Errorf("failed to add labels to worker: %s: %v", w.Name(), err)
-						}
+					} else if err := addPoolMetadata(platform, w.Name(), _nodeGroup); err != nil {
+						platform.Errorf(err.Error())
 					}
 				}
 			}()
@@ -204,51 +211,87 @@ func VsphereCluster(platform *platform.Platform, burninPeriod time.Duration) err
 	return nil
 }
 
+// downscaleDrain is how long to wait for PodDisruptionBudgets when removing extra workers during provisioning,
+// workers that cannot be drained are kept
+var downscaleDrain = DrainOptions{Timeout: 2 * time.Minute, OnTimeout: DrainSkip}
+
 func downscale(platform *platform.Platform) error {
-	wg := sync.WaitGroup{}
 	cluster, err := GetCluster(platform)
 	if err != nil {
 		return err
 	}
-	existingNodes := platform.GetNodeNames()
-	for _, worker := range platform.Nodes {
-		vms, err := platform.Cluster.GetMachinesFor(&worker)
+	extra, err := downscaleCandidates(cluster)
+	if err != nil {
+		return err
+	}
+	if len(extra) == 0 {
+		return nil
+	}
+	platform.Infof("Downscaling %d extra worker nodes", len(extra))
+	time.Sleep(3 * time.Second)
+	wg := sync.WaitGroup{}
+	for _, vm := range extra {
+		vm := vm
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := Drain(cluster.Kubernetes, platform.Logger, vm.Name(), downscaleDrain); err != nil {
+				platform.Warnf("%v, not terminating %s", err, vm)
+				if err := uncordon(cluster.Kubernetes, vm.Name()); err != nil {
+					platform.Warnf("[%s] failed to uncordon: %v", vm, err)
+				}
+				return
+			}
+			if err := cluster.Terminate(vm); err != nil {
+				platform.Errorf("failed to terminate %s: %v", vm, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// downscaleCandidates returns the workers in each pool above its desired count, oldest first, workers that have
+// not joined the cluster are not counted
+func downscaleCandidates(cluster *Cluster) ([]types.Machine, error) {
+	joined := map[string]bool{}
+	for _, nodeMachine := range cluster.Nodes {
+		joined[nodeMachine.Node.Name] = true
+	}
+	var pools []string
+	for pool := range cluster.Platform.Nodes {
+		pools = append(pools, pool)
+	}
+	sort.Strings(pools)
+
+	var extra []types.Machine
+	for _, pool := range pools {
+		worker := cluster.Platform.Nodes[pool]
+		vms, err := cluster.Platform.Cluster.GetMachinesFor(&worker)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		missing := []string{}
-		for _, vm := range vms {
-			if _, ok := existingNodes[vm.Name()]; !ok {
-				missing = append(missing, vm.Name())
+		var machines []types.Machine
+		age := map[string]time.Duration{}
+		for name, vm := range vms {
+			if joined[name] {
+				machines = append(machines, vm)
+				age[name] = vm.GetAge()
 			}
 		}
-		for _, m := range missing {
-			delete(vms, m)
-		}
-
-		if worker.Count < len(vms) {
-			terminateCount := len(vms) - worker.Count
-			var vmNames []string
-			for k := range vms {
-				vmNames = append(vmNames, k)
-			}
-			sort.Strings(vmNames)
-			platform.Infof("Downscaling %d extra worker nodes", terminateCount)
-			time.Sleep(3 * time.Second)
-			for i := 0; i < terminateCount; i++ {
-				vm := vms[vmNames[worker.Count+i-1]] //terminate oldest first
-				wg.Add(1)
-				go func() {
-					defer wg.Done()
-					if err := cluster.Terminate(vm); err != nil {
-						platform.Errorf("failed to terminate %s: %v", vm, err)
-					}
-				}()
+		sort.Slice(machines, func(i, j int) bool {
+			a, b := machines[i].Name(), machines[j].Name()
+			if age[a] != age[b] {
+				return age[a] > age[b]
 			}
+			return a < b
+		})
+		desired := worker.DesiredCount(len(machines))
+		for i := 0; i < len(machines)-desired; i++ {
+			extra = append(extra, machines[i])
 		}
 	}
-	wg.Wait()
-	return nil
+	return extra, nil
 }
 
 // creating masters needs to be done sequentially due to race conditions in kubeadm
@@ -367,27 +410,38 @@ func waitForNode(platform *platform.Platform, name string, timeout time.Duration
 	return nil
 }
 
-func addNodeAnnotations(platform *platform.Platform, name string, annotations map[string]string) error {
+// addPoolMetadata adds the pool label and the annotations of the pool to a worker node
+func addPoolMetadata(platform *platform.Platform, name, pool string) error {
 	client, err := platform.GetClientset()
 	if err != nil {
 		return errors.Wrap(err, "failed to get clientset")
 	}
+	return setPoolMetadata(client, platform, name, pool)
+}
+
+// setPoolMetadata adds the pool label and the annotations of the pool to a worker node using client
+func setPoolMetadata(client kubernetes.Interface, platform *platform.Platform, name, pool string) error {
 	node, err := client.CoreV1().Nodes().Get(context.TODO(), name, metav1.GetOptions{})
 	if err != nil {
 		return errors.Wrapf(err, "failed to get node %s", name)
 	}
-
-	for k, v := range annotations {
+	if node.Labels == nil {
+		node.Labels = map[string]string{}
+	}
+	if node.Annotations == nil {
+		node.Annotations = map[string]string{}
+	}
+	for k, v := range platform.Nodes[pool].Annotations {
 		node.Annotations[k] = v
 	}
-
+	node.Labels[constants.NodePoolLabel] = pool
 	if _, err := client.CoreV1().Nodes().Update(context.TODO(), node, metav1.UpdateOptions{}); err != nil {
-		return errors.Wrapf(err, "failed to update node %s", name)
+		return errors.Wrapf(err, "failed to add pool metadata to worker %s", name)
 	}
 	return nil
 }
 
-func addNodeLabels(platform *platform.Platform, name string, labels map[string]string) error {
+func addNodeAnnotations(platform *platform.Platform, name string, annotations map[string]string) error {
 	client, err := platform.GetClientset()
 	if err != nil {
 		return errors.Wrap(err, "failed to get clientset")
@@ -397,8 +451,8 @@ func addNodeLabels(platform *platform.Platform, name string, labels map[string]s
 		return errors.Wrapf(err, "failed to get node %s", name)
 	}
 
-	for k, v := range labels {
-		node.Labels[k] = v
+	for k, v := range annotations {
+		node.Annotations[k] = v
 	}
 
 	if _, err := client.CoreV1().Nodes().Update(context.TODO(), node, metav1.UpdateOptions{}); err != nil {
@@ -0,0 +1,16 @@
+// Package s3url parses the s3://bucket/key locations accepted for snapshots and machine image catalogs
+package s3url
+
+import "strings"
+
+// Parse splits s3://bucket/key into the bucket and key
+func Parse(location string) (bucket, key string, ok bool) {
+	if !strings.HasPrefix(location, "s3://") {
+		return "", "", false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(location, "s3://"), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
@@ -0,0 +1,20 @@
+package s3url
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestParse(t *testing.T) {
+	g := NewWithT(t)
+	bucket, key, ok := Parse("s3://backups/etcd/prod/etcd-prod.db")
+	g.Expect(ok).To(BeTrue())
+	g.Expect(bucket).To(Equal("backups"))
+	g.Expect(key).To(Equal("etcd/prod/etcd-prod.db"))
+
+	for _, invalid := range []string{"etcd.db", "s3://backups", "s3://backups/", "s3:///etcd.db", "https://backups/etcd.db"} {
+		_, _, ok := Parse(invalid)
+		g.Expect(ok).To(BeFalse(), invalid)
+	}
+}
@@ -93,3 +93,17 @@ type Cluster interface {
 	GetMachinesFor(vm *VM) (map[string]Machine, error)
 	SetTags(vm Machine, tags map[string]string) error
 }
+
+// TemplateUploader is implemented by clusters that can upload a machine image to clone VM's from
+// +kubebuilder:object:generate=false
+type TemplateUploader interface {
+	// UploadTemplate uploads the image at path as the template name to the content library or storage pool of vm
+	UploadTemplate(vm VM, name, path string) error
+}
+
+// TemplateDeleter is implemented by clusters that can delete the templates VM's are cloned from
+// +kubebuilder:object:generate=false
+type TemplateDeleter interface {
+	// DeleteTemplate deletes the template name from the content library or storage pool of vm
+	DeleteTemplate(vm VM, name string) error
+}
@@ -3,6 +3,7 @@ package types
 
 import (
 	"encoding/json"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
 )
@@ -42,6 +43,8 @@ type PlatformConfig struct {
 	Gatekeeper    Gatekeeper     `yaml:"gatekeeper,omitempty" json:"gatekeeper,omitempty"`
 	GitOperator   GitOperator    `yaml:"gitOperator,omitempty" json:"gitOperator,omitempty"`
 	Harbor        Harbor         `yaml:"harbor,omitempty" json:"harbor,omitempty"`
+	// Additional checks that must pass between each batch of a rolling update or restart
+	HealthGates []HealthGate `yaml:"healthGates,omitempty" json:"healthGates,omitempty"`
 	// A prefix to be added to VM hostnames.
 	HostPrefix string `yaml:"hostPrefix" json:"hostPrefix,omitempty"`
 	// Deprecated, use configFrom instead
@@ -56,6 +59,8 @@ type PlatformConfig struct {
 	Ldap            *Ldap                `yaml:"ldap,omitempty" json:"ldap,omitempty"`
 	LocalPath       LocalPath            `yaml:"localPath" json:"localPath"`
 	LogsExporter    LogsExporter         `yaml:"logsExporter,omitempty" json:"logsExporter,omitempty"`
+	MachineImages   *MachineImages       `yaml:"machineImages,omitempty" json:"machineImages,omitempty"`
+	Maintenance     *Maintenance         `yaml:"maintenance,omitempty" json:"maintenance,omitempty"`
 	Master          VM                   `yaml:"master,omitempty" json:"master,omitempty"`
 	Minio           Minio                `yaml:"minio,omitempty" json:"minio,omitempty"`
 	MongodbOperator MongodbOperator      `yaml:"mongodbOperator,omitempty" json:"mongodbOperator,omitempty"`
@@ -104,6 +109,12 @@ type PlatformConfig struct {
 	JoinEndpoint          string            `yaml:"-" json:"-"`
 	Source                string            `yaml:"-" json:"-"`
 	ControlPlaneEndpoint  string            `yaml:"-" json:"-"`
+	// OverrideFreeze is the reason for running a disruptive operation outside of a maintenance window
+	OverrideFreeze string `yaml:"-" json:"-"`
+	// WaitForWindow is the longest to wait for the next maintenance window before refusing
+	WaitForWindow time.Duration `yaml:"-" json:"-"`
+	// RestoreEtcdFrom is the URL of an etcd snapshot to restore on a new primary master
+	RestoreEtcdFrom string `yaml:"-" json:"-"`
 	// E2E is true if end to end tests are being run
 	E2E bool `yaml:"-" json:"-"`
 	// If the platform should use in cluster config
@@ -55,17 +55,30 @@ type VaultClient struct {
 type VM struct {
 	Name   string `yaml:"name,omitempty" json:"name,omitempty"`
 	Prefix string `yaml:"prefix,omitempty" json:"prefix,omitempty"`
+	// Provider used to create the VM: vsphere, libvirt or fake, defaults to vsphere.
+	// All VM's in a cluster use the provider of the master
+	Provider string `yaml:"provider,omitempty" json:"provider,omitempty"`
 	// Number of VM's to provision
-	Count          int      `yaml:"count" json:"count,omitempty"`
-	ContentLibrary string   `yaml:"contentLibrary" json:"contentLibrary,omitempty"`
-	Template       string   `yaml:"template" json:"template,omitempty"`
-	Cluster        string   `yaml:"cluster,omitempty" json:"cluster,omitempty"`
-	Folder         string   `yaml:"folder,omitempty" json:"folder,omitempty"`
-	Datastore      string   `yaml:"datastore,omitempty" json:"datastore,omitempty"`
-	ResourcePool   string   `yaml:"resourcePool,omitempty" json:"resourcePool,omitempty"`
-	CPUs           int32    `yaml:"cpu" json:"cpu,omitempty"`
-	MemoryGB       int64    `yaml:"memory" json:"memory,omitempty"`
-	Network        []string `yaml:"networks,omitempty" json:"networks,omitempty"`
+	Count int `yaml:"count" json:"count,omitempty"`
+	// Minimum number of workers in the pool when autoscaling
+	MinCount int `yaml:"minCount,omitempty" json:"minCount,omitempty"`
+	// Maximum number of workers in the pool when autoscaling, autoscaling is enabled when set and Count is ignored
+	MaxCount int `yaml:"maxCount,omitempty" json:"maxCount,omitempty"`
+	// vSphere only, use vsphere.contentLibrary instead
+	ContentLibrary string `yaml:"contentLibrary" json:"contentLibrary,omitempty"`
+	// Name of the template to clone, or a selector of an image in the machine image catalogue, e.g. k8s=1.20,os=ubuntu,latest
+	Template string `yaml:"template" json:"template,omitempty"`
+	// vSphere only, use vsphere.cluster instead
+	Cluster string `yaml:"cluster,omitempty" json:"cluster,omitempty"`
+	// vSphere only, use vsphere.folder instead
+	Folder string `yaml:"folder,omitempty" json:"folder,omitempty"`
+	// vSphere only, use vsphere.datastore instead
+	Datastore string `yaml:"datastore,omitempty" json:"datastore,omitempty"`
+	// vSphere only, use vsphere.resourcePool instead
+	ResourcePool string   `yaml:"resourcePool,omitempty" json:"resourcePool,omitempty"`
+	CPUs         int32    `yaml:"cpu" json:"cpu,omitempty"`
+	MemoryGB     int64    `yaml:"memory" json:"memory,omitempty"`
+	Network      []string `yaml:"networks,omitempty" json:"networks,omitempty"`
 	// Size in GB of the VM root volume
 	DiskGB int `yaml:"disk" json:"disk"`
 	// Tags to be applied to the VM
@@ -78,12 +91,108 @@ type VM struct {
 	Annotations        map[string]string  `yaml:"annotations,omitempty" json:"annotations,omitempty"`
 	KubeletExtraArgs   map[string]string  `yaml:"kubeletExtraArgs,omitempty" json:"kubeletExtraArgs,omitempty"`
 	LoadBalancerConfig LoadBalancerConfig `yaml:"loadBalancerConfig,omitempty" json:"loadBalancerConfig,omitempty"`
+	// Libvirt configures VM's provisioned on a libvirt/QEMU hypervisor, Template is the name of
+	// a qcow2 base image volume in the storage pool
+	Libvirt *Libvirt `yaml:"libvirt,omitempty" json:"libvirt,omitempty"`
+	// Vsphere configures VM's provisioned on vCenter, values take precedence over the top-level vSphere fields
+	Vsphere *VsphereVM `yaml:"vsphere,omitempty" json:"vsphere,omitempty"`
+}
+
+type VsphereVM struct {
+	// Content library containing the template, if empty the template is a VM
+	ContentLibrary string `yaml:"contentLibrary,omitempty" json:"contentLibrary,omitempty"`
+	Cluster        string `yaml:"cluster,omitempty" json:"cluster,omitempty"`
+	Folder         string `yaml:"folder,omitempty" json:"folder,omitempty"`
+	Datastore      string `yaml:"datastore,omitempty" json:"datastore,omitempty"`
+	ResourcePool   string `yaml:"resourcePool,omitempty" json:"resourcePool,omitempty"`
+}
+
+type Libvirt struct {
+	// URI of the libvirt daemon, e.g. qemu+ssh://root@hypervisor/system, defaults to qemu:///system
+	URI string `yaml:"uri,omitempty" json:"uri,omitempty"`
+	// Storage pool containing the base images, machine disks and cloud-init ISO's are created in the same pool, defaults to default
+	Pool string `yaml:"pool,omitempty" json:"pool,omitempty"`
+	// Domain type, defaults to kvm
+	DomainType string `yaml:"domainType,omitempty" json:"domainType,omitempty"`
+}
+
+// MachineImages configures the catalogue of machine images that VM templates can be selected from
+type MachineImages struct {
+	// Catalog is a local file or s3://bucket/key that the catalogue is stored in
+	Catalog string `yaml:"catalog,omitempty" json:"catalog,omitempty"`
+	// Keep is the number of unused images of each OS and Kubernetes minor version kept by garbage collection, defaults to 1
+	Keep int `yaml:"keep,omitempty" json:"keep,omitempty"`
+}
+
+// Maintenance restricts disruptive operations (rolling updates and restarts, upgrades, terminations
+// and operator deploys) to maintenance windows outside of change freezes
+type Maintenance struct {
+	// Timezone of the windows and freezes, e.g. Europe/London, defaults to UTC
+	Timezone string `yaml:"timezone,omitempty" json:"timezone,omitempty"`
+	// Windows during which disruptive operations are allowed, if empty they are allowed at any time outside of freezes
+	Windows []MaintenanceWindow `yaml:"windows,omitempty" json:"windows,omitempty"`
+	// Freezes during which disruptive operations are refused, even inside a window
+	Freezes []Freeze `yaml:"freezes,omitempty" json:"freezes,omitempty"`
+}
+
+type MaintenanceWindow struct {
+	// Schedule is a cron expression (minute hour day-of-month month day-of-week) for the start of the window, e.g. "0 22 * * mon-thu"
+	Schedule string `yaml:"schedule" json:"schedule"`
+	// Duration of the window, e.g. 4h
+	Duration string `yaml:"duration" json:"duration"`
+}
+
+type Freeze struct {
+	Name string `yaml:"name,omitempty" json:"name,omitempty"`
+	// Start of the freeze, either a date (2006-01-02) or an RFC3339 timestamp
+	Start string `yaml:"start" json:"start"`
+	// End of the freeze, either an inclusive date (2006-01-02) or an RFC3339 timestamp
+	End string `yaml:"end" json:"end"`
+}
+
+// HealthGate is an additional check that must pass before a rolling update or restart
+// continues with the next batch of nodes
+type HealthGate struct {
+	// Type is one of prometheus, canary, alerts or etcd
+	Type string `yaml:"type" json:"type"`
+	// Name is used when logging the result of the gate, defaults to the type
+	Name string `yaml:"name,omitempty" json:"name,omitempty"`
+	// Query is a PromQL query for prometheus gates, the gate passes when the query returns no samples or only zero values
+	Query string `yaml:"query,omitempty" json:"query,omitempty"`
+	// Namespace restricts the canaries checked by canary gates, defaults to all namespaces
+	Namespace string `yaml:"namespace,omitempty" json:"namespace,omitempty"`
+	// Selector is a label selector that restricts the canaries checked by canary gates
+	Selector string `yaml:"selector,omitempty" json:"selector,omitempty"`
+	// Severity is the lowest severity of firing alerts that fails an alerts gate, defaults to critical
+	Severity string `yaml:"severity,omitempty" json:"severity,omitempty"`
+	// Members is the minimum number of healthy etcd members for etcd gates, defaults to the number of masters
+	Members int `yaml:"members,omitempty" json:"members,omitempty"`
 }
 
 func (vm VM) GetTags() map[string]string {
 	return vm.Tags
 }
 
+// IsAutoscaled returns true if the number of VM's is managed by the autoscaler
+func (vm VM) IsAutoscaled() bool {
+	return vm.MaxCount > 0
+}
+
+// DesiredCount returns the number of VM's that should exist given the current number, for autoscaled
+// pools this is the current number within the min and max bounds
+func (vm VM) DesiredCount(current int) int {
+	if !vm.IsAutoscaled() {
+		return vm.Count
+	}
+	if current < vm.MinCount {
+		return vm.MinCount
+	}
+	if current > vm.MaxCount {
+		return vm.MaxCount
+	}
+	return current
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (vm *VM) DeepCopyInto(out *VM) {
 	*out = *vm
@@ -496,9 +605,34 @@ type DynamicDNS struct {
 	Region    string `yaml:"region,omitempty" json:"region,omitempty"`
 	AccessKey string `yaml:"accessKey,omitempty" json:"accessKey,omitempty"`
 	SecretKey string `yaml:"secretKey,omitempty" json:"secretKey,omitempty"`
-	// Type of DNS provider. Defaults to RFC 2136 Dynamic DNS. If using "route53" you
-	// must specify accessKey, secretKey and zone
+	// Type of DNS provider, one of rfc2136, route53, powerdns or coredns-etcd. Defaults to RFC 2136 Dynamic DNS.
+	// If using "route53" you must specify accessKey, secretKey and zone
 	Type string `yaml:"type,omitempty" json:"type,omitempty"`
+	// PowerDNS HTTP API settings, required if type is "powerdns"
+	PowerDNS *PowerDNS `yaml:"powerdns,omitempty" json:"powerdns,omitempty"`
+	// etcd settings, required if type is "coredns-etcd"
+	Etcd *DNSEtcd `yaml:"etcd,omitempty" json:"etcd,omitempty"`
+	// Adopt takes ownership of k8s-api and "*" when they already have records but no ownership record, e.g. records
+	// created before ownership was tracked. Otherwise they are left as is
+	Adopt bool `yaml:"adopt,omitempty" json:"adopt,omitempty"`
+}
+
+type PowerDNS struct {
+	// URL of the PowerDNS API, e.g. http://powerdns:8081
+	URL string `yaml:"url" json:"url"`
+	// API key sent in the X-API-Key header
+	APIKey string `yaml:"apiKey,omitempty" json:"apiKey,omitempty"`
+	// ID of the server, defaults to localhost
+	Server string `yaml:"server,omitempty" json:"server,omitempty"`
+}
+
+// DNSEtcd is the etcd backend of the CoreDNS etcd plugin
+type DNSEtcd struct {
+	Endpoints []string `yaml:"endpoints" json:"endpoints"`
+	// Path that CoreDNS reads records from, defaults to /skydns
+	Prefix   string `yaml:"prefix,omitempty" json:"prefix,omitempty"`
+	Username string `yaml:"username,omitempty" json:"username,omitempty"`
+	Password string `yaml:"password,omitempty" json:"password,omitempty"`
 }
 
 func (dns DynamicDNS) IsEnabled() bool {
@@ -648,9 +782,40 @@ type Velero struct {
 }
 
 type CA struct {
+	// The CA certificate, followed by the certificates of its issuers when it is an intermediate
 	Cert       string `yaml:"cert,omitempty" json:"cert,omitempty"`
 	PrivateKey string `yaml:"privateKey,omitempty" json:"privateKey,omitempty"`
 	Password   string `yaml:"password,omitempty" json:"password,omitempty"`
+	// Sign using a key held in an HSM instead of privateKey
+	PKCS11 *PKCS11 `yaml:"pkcs11,omitempty" json:"pkcs11,omitempty"`
+	// Sign using a key held in Vault instead of privateKey
+	Vault *VaultSigner `yaml:"vault,omitempty" json:"vault,omitempty"`
+}
+
+// PKCS11 identifies a private key in an HSM, signing requires pkcs11-tool from OpenSC
+type PKCS11 struct {
+	// Path to the PKCS#11 module of the HSM, e.g. /usr/lib/softhsm/libsofthsm2.so
+	Module string `yaml:"module" json:"module"`
+	// Label of the token holding the key, defaults to the first token
+	TokenLabel string `yaml:"tokenLabel,omitempty" json:"tokenLabel,omitempty"`
+	// Label of the private key
+	KeyLabel string `yaml:"keyLabel,omitempty" json:"keyLabel,omitempty"`
+	// Hex encoded ID of the private key, used instead of keyLabel
+	KeyID string `yaml:"keyID,omitempty" json:"keyID,omitempty"`
+	// PIN of the token user
+	Pin string `yaml:"pin,omitempty" json:"pin,omitempty"`
+}
+
+// VaultSigner identifies a key held by the Vault transit secrets engine
+type VaultSigner struct {
+	// The address of the Vault server, defaults to VAULT_ADDR
+	Address string `yaml:"address,omitempty" json:"address,omitempty"`
+	// A VAULT_TOKEN to use when authenticating with Vault
+	Token string `yaml:"token,omitempty" json:"token,omitempty"`
+	// The path the transit secrets engine is mounted at, defaults to transit
+	Mount string `yaml:"mount,omitempty" json:"mount,omitempty"`
+	// The name of the transit key
+	Key string `yaml:"key" json:"key"`
 }
 
 type Thanos struct {
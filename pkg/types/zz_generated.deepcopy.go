@@ -164,6 +164,16 @@ func (in *Brand) DeepCopy() *Brand {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *CA) DeepCopyInto(out *CA) {
 	*out = *in
+	if in.PKCS11 != nil {
+		in, out := &in.PKCS11, &out.PKCS11
+		*out = new(PKCS11)
+		**out = **in
+	}
+	if in.Vault != nil {
+		in, out := &in.Vault, &out.Vault
+		*out = new(VaultSigner)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CA.
@@ -326,6 +336,26 @@ func (in *DB) DeepCopy() *DB {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DNSEtcd) DeepCopyInto(out *DNSEtcd) {
+	*out = *in
+	if in.Endpoints != nil {
+		in, out := &in.Endpoints, &out.Endpoints
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DNSEtcd.
+func (in *DNSEtcd) DeepCopy() *DNSEtcd {
+	if in == nil {
+		return nil
+	}
+	out := new(DNSEtcd)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Dashboard) DeepCopyInto(out *Dashboard) {
 	*out = *in
@@ -379,6 +409,16 @@ func (in *Dex) DeepCopy() *Dex {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DynamicDNS) DeepCopyInto(out *DynamicDNS) {
 	*out = *in
+	if in.PowerDNS != nil {
+		in, out := &in.PowerDNS, &out.PowerDNS
+		*out = new(PowerDNS)
+		**out = **in
+	}
+	if in.Etcd != nil {
+		in, out := &in.Etcd, &out.Etcd
+		*out = new(DNSEtcd)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DynamicDNS.
@@ -560,6 +600,21 @@ func (in *Flux) DeepCopy() *Flux {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Freeze) DeepCopyInto(out *Freeze) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Freeze.
+func (in *Freeze) DeepCopy() *Freeze {
+	if in == nil {
+		return nil
+	}
+	out := new(Freeze)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *GCP) DeepCopyInto(out *GCP) {
 	*out = *in
@@ -819,6 +874,21 @@ func (in *HarborSettings) DeepCopy() *HarborSettings {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HealthGate) DeepCopyInto(out *HealthGate) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HealthGate.
+func (in *HealthGate) DeepCopy() *HealthGate {
+	if in == nil {
+		return nil
+	}
+	out := new(HealthGate)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *IstioOperator) DeepCopyInto(out *IstioOperator) {
 	*out = *in
@@ -1020,6 +1090,21 @@ func (in *LetsencryptIssuer) DeepCopy() *LetsencryptIssuer {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Libvirt) DeepCopyInto(out *Libvirt) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Libvirt.
+func (in *Libvirt) DeepCopy() *Libvirt {
+	if in == nil {
+		return nil
+	}
+	out := new(Libvirt)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *LoadBalancerConfig) DeepCopyInto(out *LoadBalancerConfig) {
 	*out = *in
@@ -1092,6 +1177,61 @@ func (in *Memory) DeepCopy() *Memory {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MachineImages) DeepCopyInto(out *MachineImages) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MachineImages.
+func (in *MachineImages) DeepCopy() *MachineImages {
+	if in == nil {
+		return nil
+	}
+	out := new(MachineImages)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Maintenance) DeepCopyInto(out *Maintenance) {
+	*out = *in
+	if in.Windows != nil {
+		in, out := &in.Windows, &out.Windows
+		*out = make([]MaintenanceWindow, len(*in))
+		copy(*out, *in)
+	}
+	if in.Freezes != nil {
+		in, out := &in.Freezes, &out.Freezes
+		*out = make([]Freeze, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Maintenance.
+func (in *Maintenance) DeepCopy() *Maintenance {
+	if in == nil {
+		return nil
+	}
+	out := new(Maintenance)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MaintenanceWindow) DeepCopyInto(out *MaintenanceWindow) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MaintenanceWindow.
+func (in *MaintenanceWindow) DeepCopy() *MaintenanceWindow {
+	if in == nil {
+		return nil
+	}
+	out := new(MaintenanceWindow)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Minio) DeepCopyInto(out *Minio) {
 	*out = *in
@@ -1613,6 +1753,21 @@ func (in *OAuth2Proxy) DeepCopy() *OAuth2Proxy {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PKCS11) DeepCopyInto(out *PKCS11) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PKCS11.
+func (in *PKCS11) DeepCopy() *PKCS11 {
+	if in == nil {
+		return nil
+	}
+	out := new(PKCS11)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Packetbeat) DeepCopyInto(out *Packetbeat) {
 	*out = *in
@@ -1694,7 +1849,7 @@ func (in *PlatformConfig) DeepCopyInto(out *PlatformConfig) {
 		}
 	}
 	in.Dex.DeepCopyInto(&out.Dex)
-	out.DNS = in.DNS
+	in.DNS.DeepCopyInto(&out.DNS)
 	out.ECK = in.ECK
 	if in.Elasticsearch != nil {
 		in, out := &in.Elasticsearch, &out.Elasticsearch
@@ -1719,6 +1874,11 @@ func (in *PlatformConfig) DeepCopyInto(out *PlatformConfig) {
 	in.Gatekeeper.DeepCopyInto(&out.Gatekeeper)
 	out.GitOperator = in.GitOperator
 	in.Harbor.DeepCopyInto(&out.Harbor)
+	if in.HealthGates != nil {
+		in, out := &in.HealthGates, &out.HealthGates
+		*out = make([]HealthGate, len(*in))
+		copy(*out, *in)
+	}
 	if in.ImportConfigs != nil {
 		in, out := &in.ImportConfigs, &out.ImportConfigs
 		*out = make([]string, len(*in))
@@ -1746,6 +1906,16 @@ func (in *PlatformConfig) DeepCopyInto(out *PlatformConfig) {
 	}
 	out.LocalPath = in.LocalPath
 	out.LogsExporter = in.LogsExporter
+	if in.MachineImages != nil {
+		in, out := &in.MachineImages, &out.MachineImages
+		*out = new(MachineImages)
+		**out = **in
+	}
+	if in.Maintenance != nil {
+		in, out := &in.Maintenance, &out.Maintenance
+		*out = new(Maintenance)
+		(*in).DeepCopyInto(*out)
+	}
 	in.Master.DeepCopyInto(&out.Master)
 	out.Minio = in.Minio
 	out.MongodbOperator = in.MongodbOperator
@@ -1901,6 +2071,21 @@ func (in *PostgresOperator) DeepCopy() *PostgresOperator {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PowerDNS) DeepCopyInto(out *PowerDNS) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PowerDNS.
+func (in *PowerDNS) DeepCopy() *PowerDNS {
+	if in == nil {
+		return nil
+	}
+	out := new(PowerDNS)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Prometheus) DeepCopyInto(out *Prometheus) {
 	*out = *in
@@ -2365,6 +2550,21 @@ func (in *VaultPolicyPath) DeepCopy() *VaultPolicyPath {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VaultSigner) DeepCopyInto(out *VaultSigner) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VaultSigner.
+func (in *VaultSigner) DeepCopy() *VaultSigner {
+	if in == nil {
+		return nil
+	}
+	out := new(VaultSigner)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Velero) DeepCopyInto(out *Velero) {
 	*out = *in
@@ -2403,6 +2603,21 @@ func (in *Vsphere) DeepCopy() *Vsphere {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VsphereVM) DeepCopyInto(out *VsphereVM) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VsphereVM.
+func (in *VsphereVM) DeepCopy() *VsphereVM {
+	if in == nil {
+		return nil
+	}
+	out := new(VsphereVM)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *XDisabled) DeepCopyInto(out *XDisabled) {
 	*out = *in